@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+)
+
+// GenerateAPIKeyCommand is the sub-command which prints a new API key and its
+// hash for `apikeys`
+const GenerateAPIKeyCommand = "generate-api-key"
+
+// HashAPIKey returns hex-encoded SHA-256 hash of the API key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random API key and its hash
+func GenerateAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	key := hex.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// isValidAPIKey returns true if hash of the key is listed in `apikeys`
+func isValidAPIKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	hash := []byte(HashAPIKey(key))
+	valid := false
+	for _, allowed := range Config.APIKeys {
+		// Every hash is compared to not leak the position of the key
+		if subtle.ConstantTimeCompare(hash, []byte(allowed)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// verifyAPIKeys verifies that `apikeys` contains SHA-256 hashes, not keys
+func verifyAPIKeys(hashes []string) error {
+	for _, hash := range hashes {
+		decoded, err := hex.DecodeString(hash)
+		if err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("apikeys has to contain SHA-256 hashes of keys, see %s", GenerateAPIKeyCommand)
+		}
+	}
+	return nil
+}
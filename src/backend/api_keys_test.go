@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAPIKeys(t *testing.T) {
+	setupTestEnv(t)
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HashAPIKey(key) != hash || verifyAPIKeys([]string{hash}) != nil {
+		t.Fatalf("Unexpected hash %s of key %s", hash, key)
+	}
+	if verifyAPIKeys([]string{key[:10]}) == nil {
+		t.Error("Expected error for a value which is not a hash")
+	}
+	Config.APIKeys = []string{hash}
+
+	router := chi.NewRouter()
+	router.With(AuthMi).Get("/api/queue", HandleQueueStatus)
+	cases := []struct {
+		auth     string
+		expected int
+	}{
+		{"Bearer " + key, http.StatusOK},
+		{"Bearer " + hash, http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/api/queue", nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf("%q: expected %d, got %d", c.auth, c.expected, w.Code)
+		}
+	}
+}
+
+func TestPublicMonitoring(t *testing.T) {
+	setupTestEnv(t)
+	for _, public := range []bool{false, true} {
+		Config.PublicMonitoring = public
+		router := chi.NewRouter()
+		internalRoutes(router, AuthMi)
+		expected := map[string]int{
+			"/healthz":      http.StatusForbidden,
+			"/debug/pprof/": http.StatusForbidden,
+		}
+		if public {
+			expected["/healthz"] = http.StatusOK
+		}
+		for path, code := range expected {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != code {
+				t.Errorf("public %v, %s: expected %d, got %d", public, path, code, w.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ArtifactChecksumsFile is written to the artifacts directory after
+// collection, in the format of `sha256sum`
+const ArtifactChecksumsFile = "checksums.sha256"
+
+// fileSHA256 returns the hex-encoded SHA-256 hash of the file content
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// formatChecksums returns the checksums in the format of `sha256sum`, sorted
+// by name
+func formatChecksums(checksums map[string]string) string {
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s  %s\n", checksums[name], name)
+	}
+	return sb.String()
+}
+
+// writeArtifactChecksums hashes the collected artifacts as they are stored,
+// compressed artifacts are hashed compressed, and writes ArtifactChecksumsFile
+func (b *Build) writeArtifactChecksums() {
+	if len(b.BuildArtifacts) == 0 {
+		return
+	}
+	checksums := map[string]string{}
+	for _, artifact := range b.BuildArtifacts {
+		sum, err := fileSHA256(b.GetArtifactsDir() + artifact.Filename)
+		if err != nil {
+			b.addFinalizationError("artifacts", fmt.Errorf("unable to hash %s: %w", artifact.Filename, err))
+			continue
+		}
+		checksums[artifact.Filename] = sum
+	}
+	err := os.WriteFile(b.GetArtifactsDir()+ArtifactChecksumsFile, []byte(formatChecksums(checksums)), 0644)
+	if err != nil {
+		b.addFinalizationError("artifacts", fmt.Errorf("unable to write checksums: %w", err))
+		return
+	}
+	b.ArtifactChecksums = checksums
+}
+
+// artifactModified returns true if the artifact differs from the one hashed
+// on collection. Artifacts collected without checksums are not verified
+func artifactModified(data *BuildUpdateData, name string, path string) (bool, error) {
+	expected, ok := data.ArtifactChecksums[name]
+	if !ok {
+		return false, nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return false, err
+	}
+	return sum != expected, nil
+}
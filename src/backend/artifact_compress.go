@@ -0,0 +1,61 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// CompressedArtifactExt is appended to names of artifacts collected with
+// Job.CompressArtifacts
+const CompressedArtifactExt = ".gz"
+
+// gzipFile writes the gzip-compressed content of src to dst
+func gzipFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// acceptsGzip returns true if the client accepts gzip-encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding, _, _ = strings.Cut(strings.TrimSpace(encoding), ";")
+			if encoding == "gzip" || encoding == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compressedArtifacts returns names of compressed artifacts of the build
+func compressedArtifacts(data *BuildUpdateData) map[string]bool {
+	compressed := map[string]bool{}
+	for _, artifact := range data.BuildArtifacts {
+		if artifact.Compressed {
+			compressed[artifact.Filename] = true
+		}
+	}
+	return compressed
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ArtifactPattern is a glob of files in the workspace collected as artifacts.
+// In the job file it is either a string or an object with `pattern` and
+// `required` keys
+type ArtifactPattern struct {
+	Pattern string `yaml:"pattern" json:"pattern"`
+	// The build fails if the pattern matches no files
+	Required bool `yaml:"required" json:"required"`
+}
+
+// UnmarshalYAML accepts a plain string as the pattern
+func (p *ArtifactPattern) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var pattern string
+	if err := unmarshal(&pattern); err == nil {
+		p.Pattern = pattern
+		return nil
+	}
+	type plain ArtifactPattern
+	return unmarshal((*plain)(p))
+}
+
+// noMatchWarning returns the warning reported when the pattern matches no files
+func (p *ArtifactPattern) noMatchWarning() string {
+	return fmt.Sprintf("artifact pattern '%s' matched no files", p.Pattern)
+}
+
+// validateArtifactPattern reports mistakes which doublestar silently accepts
+func validateArtifactPattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("artifact pattern can't be empty")
+	}
+	if strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("artifact pattern '%s' has to be relative to the workspace", pattern)
+	}
+	brackets, braces := 0, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			brackets++
+		case ']':
+			brackets--
+		case '{':
+			braces++
+		case '}':
+			braces--
+		}
+		if brackets < 0 || braces < 0 {
+			break
+		}
+	}
+	if brackets != 0 {
+		return fmt.Errorf("artifact pattern '%s' has unbalanced []", pattern)
+	}
+	if braces != 0 {
+		return fmt.Errorf("artifact pattern '%s' has unbalanced {}", pattern)
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment != "**" && strings.Contains(segment, "**") {
+			return fmt.Errorf("'**' has to be a separate path element in artifact pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// Used to verify artifact patterns before saving after editing
+func (j *Job) verifyArtifacts() error {
+	for _, artPattern := range j.Artifacts {
+		err := validateArtifactPattern(artPattern.Pattern)
+		if err != nil {
+			return err
+		}
+	}
+	for _, pattern := range j.ArtifactsExclude {
+		err := validateArtifactPattern(pattern)
+		if err != nil {
+			return fmt.Errorf("artifacts_exclude: %w", err)
+		}
+	}
+	return nil
+}
+
+// isArtifactExcluded returns true if the file matches one of
+// Job.ArtifactsExclude. The path is relative to the workspace
+func (j *Job) isArtifactExcluded(relPath string) bool {
+	for _, pattern := range j.ArtifactsExclude {
+		matched, err := doublestar.Match(pattern, relPath)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequiredArtifacts returns false if a required artifact pattern matches
+// no files in the workspace
+func (b *Build) checkRequiredArtifacts() bool {
+	passed := true
+	for _, artPattern := range b.Job.Artifacts {
+		if !artPattern.Required {
+			continue
+		}
+		files, err := doublestar.Glob(b.GetWorkspaceDir() + artPattern.Pattern)
+		if err != nil {
+			b.Logger.Println(err)
+		}
+		if len(files) == 0 {
+			b.Logger.Printf("Required %s\n", artPattern.noMatchWarning())
+			b.addWarning(artPattern.noMatchWarning())
+			passed = false
+		}
+	}
+	return passed
+}
+
+// truncateArtifacts records that the artifact and the remaining ones are not
+// collected because of the limit, see reserveArtifactStorage
+func (b *Build) truncateArtifacts(relPath string, limitName string, limit int64) {
+	msg := fmt.Sprintf(
+		"Artifacts exceed the limit of %s (%d bytes), %s and the remaining artifacts are not collected",
+		limitName, limit, relPath,
+	)
+	b.Logger.Println(msg)
+	b.mutex.Lock()
+	b.ArtifactsTruncated = true
+	b.mutex.Unlock()
+	b.addWarning(msg)
+}
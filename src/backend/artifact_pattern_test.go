@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestArtifactPattern_UnmarshalYAML(t *testing.T) {
+	job := Job{}
+	err := yaml.Unmarshal([]byte("artifacts:\n  - \"*.tar.gz\"\n  - pattern: dist/**/*.whl\n    required: true\n"), &job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ArtifactPattern{{Pattern: "*.tar.gz"}, {Pattern: "dist/**/*.whl", Required: true}}
+	if len(job.Artifacts) != len(expected) {
+		t.Fatalf("Expected %d patterns, got %d", len(expected), len(job.Artifacts))
+	}
+	for i := range expected {
+		if *job.Artifacts[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *job.Artifacts[i])
+		}
+	}
+}
+
+func TestValidateArtifactPattern(t *testing.T) {
+	valid := []string{"*.tar.gz", "dist/**/*.whl", "{a,b}/[0-9]*.log", `\[literal\]`}
+	for _, pattern := range valid {
+		if err := validateArtifactPattern(pattern); err != nil {
+			t.Errorf("Expected %q to be valid, got %s", pattern, err)
+		}
+	}
+	invalid := []string{"", "/abs/*.log", "dist/[a-", "dist/{a,b", "dist/a}", "dist/**.whl"}
+	for _, pattern := range invalid {
+		if err := validateArtifactPattern(pattern); err == nil {
+			t.Errorf("Expected %q to be invalid", pattern)
+		}
+	}
+}
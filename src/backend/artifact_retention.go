@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultArtifactRetentionInterval is a period to look for expired artifacts
+// if ArtifactRetentionInterval is not configured
+const DefaultArtifactRetentionInterval = time.Hour
+
+// ArtifactRetention defines which artifacts are kept on disk. Either MaxAge or
+// Keep is set
+type ArtifactRetention struct {
+	// Artifacts of builds completed earlier are removed
+	MaxAge time.Duration
+	// Number of the latest completed builds of each job which keep artifacts
+	Keep int
+}
+
+// ParseArtifactRetention parses ArtifactRetention from a duration, e.g. 720h,
+// or from a number of builds, e.g. 20. Returns nil if the value is empty
+func ParseArtifactRetention(value string) (*ArtifactRetention, error) {
+	if value == "" {
+		return nil, nil
+	}
+	keep, err := strconv.Atoi(value)
+	if err == nil {
+		if keep <= 0 {
+			return nil, fmt.Errorf("artifact retention has to be positive: %s", value)
+		}
+		return &ArtifactRetention{Keep: keep}, nil
+	}
+	maxAge, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact retention %q: expected a duration or a number of builds", value)
+	}
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("artifact retention has to be positive: %s", value)
+	}
+	return &ArtifactRetention{MaxAge: maxAge}, nil
+}
+
+// CleanExpiredArtifacts removes artifacts of completed builds according to
+// ArtifactRetention. Build records are kept and marked with ArtifactsPurged
+func (cl *Cleaner) CleanExpiredArtifacts() {
+	retention, err := ParseArtifactRetention(Config.ArtifactRetention)
+	if err != nil {
+		cl.Logger.Println(err)
+		return
+	}
+	if retention == nil {
+		return
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		hb := tx.Bucket(HistoryBucket)
+		toPurge := []*BuildUpdateData{}
+		// Number of completed builds of each job seen so far, the latest first
+		completed := map[string]int{}
+		c := hb.Cursor()
+		for key, v := c.Last(); key != nil; key, v = c.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				cl.Logger.Println(err)
+				continue
+			}
+			if !isTerminalStatus(msg.Status) {
+				continue
+			}
+			completed[msg.JobName()]++
+			if msg.ArtifactsPurged || len(msg.BuildArtifacts) == 0 {
+				continue
+			}
+			if retention.Keep != 0 && completed[msg.JobName()] <= retention.Keep {
+				continue
+			}
+			if retention.MaxAge != 0 && time.Since(msg.StartedAt.Add(msg.Duration)) < retention.MaxAge {
+				continue
+			}
+			toPurge = append(toPurge, &msg)
+		}
+		for _, msg := range toPurge {
+			cl.Logger.Printf(
+				"Removing %d artifacts (%d bytes) of build %d of job %s...\n",
+				len(msg.BuildArtifacts), artifactsSize(msg), msg.ID, msg.JobName(),
+			)
+			err := purgeArtifacts(hb, msg)
+			if err != nil {
+				cl.Logger.Println(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cl.Logger.Println(err)
+	}
+}
+
+// artifactsSize returns total size of the build artifacts
+func artifactsSize(msg *BuildUpdateData) int64 {
+	var size int64
+	for _, artifact := range msg.BuildArtifacts {
+		size += artifact.Size
+	}
+	return size
+}
+
+// purgeArtifacts removes the artifacts directory of the build and updates the
+// build record
+func purgeArtifacts(hb *bolt.Bucket, msg *BuildUpdateData) error {
+	err := os.RemoveAll(filepath.Join(Config.WorkDir, "wakespace/", fmt.Sprintf("%d", msg.ID), "artifacts"))
+	if err != nil {
+		return err
+	}
+	err = addArtifactStorage(hb.Tx(), -artifactsSize(msg))
+	if err != nil {
+		return err
+	}
+	msg.Artifacts = nil
+	msg.BuildArtifacts = nil
+	msg.ArtifactChecksums = nil
+	msg.ArtifactsPurged = true
+	dataB, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return hb.Put(Itob(msg.ID), dataB)
+}
+
+// CleanupExpiredArtifacts periodically removes artifacts according to
+// ArtifactRetention
+func CleanupExpiredArtifacts(d time.Duration) {
+	if Config.ArtifactRetention == "" {
+		return
+	}
+	ticker := time.NewTicker(d)
+	c := Cleaner{
+		Logger: log.New(os.Stdout, "[artifacts janitor] ", log.Lmicroseconds|log.Lshortfile),
+	}
+	c.Logger.Printf("Artifact retention is %s, checking every %s\n", Config.ArtifactRetention, d)
+	go func() {
+		for range ticker.C {
+			c.CleanExpiredArtifacts()
+		}
+	}()
+}
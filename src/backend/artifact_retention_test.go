@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestParseArtifactRetention(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected ArtifactRetention
+	}{
+		{"720h", ArtifactRetention{MaxAge: 720 * time.Hour}},
+		{"20", ArtifactRetention{Keep: 20}},
+	}
+	for _, c := range cases {
+		result, err := ParseArtifactRetention(c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *result != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", c.value, c.expected, *result)
+		}
+	}
+	for _, value := range []string{"0", "-1h", "month"} {
+		if _, err := ParseArtifactRetention(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+	if result, err := ParseArtifactRetention(""); result != nil || err != nil {
+		t.Errorf("Expected no retention, got %+v, %v", result, err)
+	}
+}
+
+// putTestBuildWithArtifact saves a completed build with an artifact
+func putTestBuildWithArtifact(t *testing.T, id int, name string, completedAt time.Time) {
+	dir := Config.WorkDir + "wakespace/" + strconv.Itoa(id) + "/artifacts/"
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dir+"out.txt", []byte("ok"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataB, err := json.Marshal(&BuildUpdateData{
+		ID:             id,
+		Name:           name,
+		Status:         StatusFinished,
+		StartedAt:      completedAt.Add(-time.Minute),
+		Duration:       time.Minute,
+		BuildArtifacts: []*ArtifactInfo{{Filename: "out.txt", Size: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(HistoryBucket).Put(Itob(id), dataB)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanExpiredArtifacts(t *testing.T) {
+	cases := []struct {
+		retention string
+		purged    map[int]bool
+	}{
+		// Builds 1 and 3 are old
+		{"24h", map[int]bool{1: true, 3: true}},
+		// Builds 1 and 2 are older builds of job a
+		{"1", map[int]bool{1: true, 2: true}},
+	}
+	for _, c := range cases {
+		setupTestEnv(t)
+		Config.ArtifactRetention = c.retention
+		putTestBuildWithArtifact(t, 1, "a", time.Now().Add(-48*time.Hour))
+		putTestBuildWithArtifact(t, 2, "a", time.Now())
+		putTestBuildWithArtifact(t, 3, "b", time.Now().Add(-48*time.Hour))
+		putTestBuildWithArtifact(t, 4, "a", time.Now())
+
+		cl := Cleaner{Logger: log.New(io.Discard, "", 0)}
+		cl.CleanExpiredArtifacts()
+
+		for id := 1; id <= 4; id++ {
+			data, err := getBuildStatusData(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, statErr := os.Stat(Config.WorkDir + "wakespace/" + strconv.Itoa(id) + "/artifacts/out.txt")
+			if c.purged[id] {
+				if !data.ArtifactsPurged || len(data.BuildArtifacts) != 0 || !os.IsNotExist(statErr) {
+					t.Errorf("%s: expected artifacts of build %d to be removed", c.retention, id)
+				}
+			} else if data.ArtifactsPurged || len(data.BuildArtifacts) != 1 || statErr != nil {
+				t.Errorf("%s: expected artifacts of build %d to be kept", c.retention, id)
+			}
+		}
+	}
+}
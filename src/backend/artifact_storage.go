@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ArtifactStorageKey of GlobalBucket holds the total size of artifacts stored
+// by all builds in bytes
+var ArtifactStorageKey = []byte("artifactStorage")
+
+// getMaxArtifactStorage returns the maximum total size of artifacts of all
+// builds in bytes, 0 if unlimited
+func (c *WakeConfig) getMaxArtifactStorage() int64 {
+	if c.MaxArtifactStorage == "" {
+		return 0
+	}
+	size, err := ParseSize(c.MaxArtifactStorage)
+	if err != nil {
+		Logger.Println(err)
+		return 0
+	}
+	return size
+}
+
+// getMaxArtifactSize returns the maximum total size of artifacts of the build
+// in bytes and the value it is parsed from. Job configuration takes precedence
+// over the global one. 0 means unlimited
+func (b *Build) getMaxArtifactSize() (int64, string) {
+	if b.Job.MaxArtifactSize == "" {
+		return Config.getMaxArtifactSize(), Config.MaxArtifactSize
+	}
+	size, err := ParseSize(b.Job.MaxArtifactSize)
+	if err != nil {
+		b.Logger.Println(err)
+		return 0, ""
+	}
+	return size, b.Job.MaxArtifactSize
+}
+
+// getArtifactStorage returns the total size of stored artifacts in bytes
+func getArtifactStorage(tx *bolt.Tx) int64 {
+	size, err := ByteToInt(tx.Bucket(GlobalBucket).Get(ArtifactStorageKey))
+	if err != nil {
+		return 0
+	}
+	return int64(size)
+}
+
+// addArtifactStorage changes the total size of stored artifacts by delta
+func addArtifactStorage(tx *bolt.Tx, delta int64) error {
+	size := getArtifactStorage(tx) + delta
+	if size < 0 {
+		size = 0
+	}
+	return tx.Bucket(GlobalBucket).Put(ArtifactStorageKey, IntToByte(int(size)))
+}
+
+// RecountArtifactStorage sets the total size of stored artifacts from build
+// records, so the counter doesn't drift if artifacts were removed while the
+// server was stopped
+func RecountArtifactStorage(tx *bolt.Tx) error {
+	var size int64
+	err := tx.Bucket(HistoryBucket).ForEach(func(k, v []byte) error {
+		var msg BuildUpdateData
+		if json.Unmarshal(v, &msg) != nil || msg.ArtifactsPurged {
+			return nil
+		}
+		size += artifactsSize(&msg)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(GlobalBucket).Put(ArtifactStorageKey, IntToByte(int(size)))
+}
+
+// reserveArtifactStorage returns the limit of the size of artifacts which can
+// be collected by the build and its description, 0 if unlimited. Returns an
+// error if artifact storage is full
+func (b *Build) reserveArtifactStorage() (int64, string, error) {
+	limit, limitName := b.getMaxArtifactSize()
+	maxStorage := Config.getMaxArtifactStorage()
+	if maxStorage == 0 {
+		return limit, limitName, nil
+	}
+	var used int64
+	err := DB.View(func(tx *bolt.Tx) error {
+		used = getArtifactStorage(tx)
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	remaining := maxStorage - used
+	if remaining <= 0 {
+		return 0, "", fmt.Errorf(
+			"artifact storage is full: %d bytes are used of %s (maxartifactstorage), artifacts are not collected",
+			used, Config.MaxArtifactStorage,
+		)
+	}
+	if limit == 0 || remaining < limit {
+		return remaining, fmt.Sprintf("maxartifactstorage %s", Config.MaxArtifactStorage), nil
+	}
+	return limit, limitName, nil
+}
+
+// recordArtifactStorage adds the size of the collected artifacts to the total
+func (b *Build) recordArtifactStorage() {
+	var size int64
+	for _, artifact := range b.BuildArtifacts {
+		size += artifact.Size
+	}
+	if size == 0 {
+		return
+	}
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return addArtifactStorage(tx, size)
+	})
+	if err != nil {
+		b.Logger.Println(err)
+	}
+}
+
+// HandleStats returns the usage of server resources
+// @Summary      Return server statistics
+// @Description  `artifact_storage` is the total size of artifacts of all builds in bytes, `max_artifact_storage` is the limit (`maxartifactstorage`), 0 if unlimited
+// @Tags         stats
+// @Produce      json
+// @Success      200      {object}   StatsData
+// @Failure      500      {string}   string
+// @Router       /stats [get]
+func HandleStats(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	payload := StatsData{
+		MaxArtifactStorage: Config.getMaxArtifactStorage(),
+	}
+	err := DB.View(func(tx *bolt.Tx) error {
+		payload.ArtifactStorage = getArtifactStorage(tx)
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
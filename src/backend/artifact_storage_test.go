@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// setArtifactStorage overrides the total size of stored artifacts
+func setArtifactStorage(t *testing.T, size int) {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(GlobalBucket).Put(ArtifactStorageKey, IntToByte(size))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readArtifactStorage returns the total size of stored artifacts
+func readArtifactStorage(t *testing.T) int64 {
+	var size int64
+	err := DB.View(func(tx *bolt.Tx) error {
+		size = getArtifactStorage(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func TestMaxArtifactStorage(t *testing.T) {
+	cases := []struct {
+		used      int
+		collected []string
+		warning   string
+	}{
+		// The job limit is lower than the free storage
+		{0, []string{"a.bin"}, "10KB"},
+		// Free storage is lower than the job limit
+		{6000, []string{"a.bin"}, "maxartifactstorage 12KB"},
+		{12288, nil, "artifact storage is full"},
+	}
+	for _, c := range cases {
+		setupTestEnv(t)
+		Config.MaxArtifactSize = "1MB"
+		Config.MaxArtifactStorage = "12KB"
+		setArtifactStorage(t, c.used)
+		job := &Job{
+			Name:            "max_artifact_storage",
+			Artifacts:       []*ArtifactPattern{{Pattern: "a.bin"}, {Pattern: "b.bin"}},
+			MaxArtifactSize: "10KB",
+			Tasks: []*Task{{
+				Name:    "build",
+				Command: "head -c 4000 /dev/zero > a.bin && head -c 8000 /dev/zero > b.bin",
+				Kind:    KindMain,
+			}},
+		}
+		build := createTestBuild(t, job)
+
+		waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+		data := build.GenerateBuildUpdateData()
+		collected := []string{}
+		var size int64
+		for _, artifact := range data.BuildArtifacts {
+			collected = append(collected, artifact.Filename)
+			size += artifact.Size
+		}
+		if strings.Join(collected, ",") != strings.Join(c.collected, ",") {
+			t.Errorf("used %d: expected %v to be collected, got %v", c.used, c.collected, collected)
+		}
+		if len(data.Warnings) != 1 || !strings.Contains(data.Warnings[0], c.warning) {
+			t.Errorf("used %d: expected a warning about %q, got %v", c.used, c.warning, data.Warnings)
+		}
+		if used := readArtifactStorage(t); used != int64(c.used)+size {
+			t.Errorf("used %d: expected artifact storage of %d, got %d", c.used, int64(c.used)+size, used)
+		}
+	}
+}
+
+func TestArtifactStorageAccounting(t *testing.T) {
+	setupTestEnv(t)
+	Config.ArtifactRetention = "1"
+	Config.MaxArtifactStorage = "1MB"
+	for id := 1; id <= 3; id++ {
+		putTestBuildWithArtifact(t, id, "a", time.Now())
+	}
+	err := DB.Update(RecountArtifactStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := readArtifactStorage(t); used != 6 {
+		t.Fatalf("Expected artifacts of 3 builds to be counted, got %d", used)
+	}
+
+	cl := Cleaner{Logger: log.New(io.Discard, "", 0)}
+	cl.CleanExpiredArtifacts()
+	if used := readArtifactStorage(t); used != 2 {
+		t.Errorf("Expected purged artifacts to be subtracted, got %d", used)
+	}
+
+	w := httptest.NewRecorder()
+	HandleStats(w, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	var stats StatsData
+	err = json.Unmarshal(w.Body.Bytes(), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || stats.ArtifactStorage != 2 || stats.MaxArtifactStorage != 1<<20 {
+		t.Errorf("Unexpected stats %d %+v", w.Code, stats)
+	}
+}
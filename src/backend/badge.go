@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// BadgeStyleFlat is the default badge style with rounded corners and gradient
+const BadgeStyleFlat = "flat"
+
+// BadgeStyleFlatSquare is a badge style without rounded corners and gradient
+const BadgeStyleFlatSquare = "flat-square"
+
+// badgeColors maps build status to badge message and color
+var badgeColors = map[ItemStatus][2]string{
+	StatusFinished:          {"passing", "#4c1"},
+	StatusFailed:            {"failing", "#e05d44"},
+	StatusRunning:           {"running", "#dfb317"},
+	StatusPending:           {"pending", "#9f9f9f"},
+	StatusAborted:           {"aborted", "#9f9f9f"},
+	StatusTimedOut:          {"timed out", "#fe7d37"},
+	StatusDiskQuotaExceeded: {"disk quota exceeded", "#fe7d37"},
+	// The job has no builds in the history
+	"": {"no builds", "#9f9f9f"},
+	// The build has finished, but its artifacts or record may be incomplete
+	badgeFinalizationErrors: {"finished with errors", "#dfb317"},
+}
+
+// badgeFinalizationErrors is shown instead of StatusFinished if the build has
+// FinalizationErrors
+const badgeFinalizationErrors ItemStatus = "finalization errors"
+
+// getBadgeMessage returns text and color of the badge for the build status
+func getBadgeMessage(status ItemStatus) (string, string) {
+	item, ok := badgeColors[status]
+	if !ok {
+		return "unknown", "#9f9f9f"
+	}
+	return item[0], item[1]
+}
+
+// badgeTextWidth estimates width of the text in Verdana 11px
+func badgeTextWidth(text string) int {
+	return len([]rune(text))*7 + 10
+}
+
+// RenderBadge returns shields.io-like SVG badge
+func RenderBadge(label string, message string, color string, style string) string {
+	labelWidth := badgeTextWidth(label)
+	messageWidth := badgeTextWidth(message)
+	width := labelWidth + messageWidth
+	title := html.EscapeString(label + ": " + message)
+	label = html.EscapeString(label)
+	message = html.EscapeString(message)
+
+	radius := 3
+	gradient := `<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`
+	gradientRect := fmt.Sprintf(`<rect width="%d" height="20" fill="url(#s)"/>`, width)
+	if style == BadgeStyleFlatSquare {
+		radius = 0
+		gradient = ""
+		gradientRect = ""
+	}
+
+	return fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[2]s">`+
+			`<title>%[2]s</title>%[3]s`+
+			`<clipPath id="r"><rect width="%[1]d" height="20" rx="%[4]d" fill="#fff"/></clipPath>`+
+			`<g clip-path="url(#r)"><rect width="%[5]d" height="20" fill="#555"/><rect x="%[5]d" width="%[6]d" height="20" fill="%[7]s"/>%[8]s</g>`+
+			`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+			`<text x="%[9]d" y="14">%[10]s</text><text x="%[11]d" y="14">%[12]s</text></g></svg>`,
+		width, title, gradient, radius,
+		labelWidth, messageWidth, color, gradientRect,
+		labelWidth/2, label, labelWidth+messageWidth/2, message,
+	)
+}
+
+// badgeRoutes mounts status badges of jobs. Badges are embedded in README
+// files, so they skip authentication if `publicbadges` is enabled
+func badgeRoutes(router chi.Router) {
+	badges := router.With(AuthMi)
+	if Config.PublicBadges {
+		badges = router
+	}
+	badges.Get("/job/{name}/badge.svg", HandleJobBadge)
+	badges.Get("/badge/{name}", HandleJobBadge)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRenderBadge(t *testing.T) {
+	badge := RenderBadge("<build>", "passing", "#4c1", BadgeStyleFlat)
+	for _, expected := range []string{`<title>&lt;build&gt;: passing</title>`, `fill="#4c1"`, `rx="3"`, "linearGradient"} {
+		if !strings.Contains(badge, expected) {
+			t.Errorf("Expected %q in %s", expected, badge)
+		}
+	}
+	badge = RenderBadge("build", "passing", "#4c1", BadgeStyleFlatSquare)
+	if !strings.Contains(badge, `rx="0"`) || strings.Contains(badge, "linearGradient") {
+		t.Errorf("Expected a square badge without gradient: %s", badge)
+	}
+}
+
+func TestHandleJobBadge(t *testing.T) {
+	setupTestEnv(t)
+	router := chi.NewRouter()
+	router.Get("/job/{name}/badge.svg", HandleJobBadge)
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for key := range header {
+			r.Header.Set(key, header.Get(key))
+		}
+		router.ServeHTTP(w, r)
+		return w
+	}
+	message := func(name string) string {
+		w := get("/job/"+name+"/badge.svg", nil)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" {
+			t.Fatalf("Unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		title := strings.SplitN(strings.SplitN(w.Body.String(), "<title>", 2)[1], "</title>", 2)[0]
+		return strings.TrimPrefix(title, "build: ")
+	}
+
+	putTestBuildStatus(t, 1, "lint", StatusFinished, time.Now())
+	putTestBuildStatus(t, 2, "test", StatusFinished, time.Now())
+	putTestBuildStatus(t, 3, "test", StatusFailed, time.Now())
+	// Not in the queue, e.g. the server was restarted
+	putTestBuildStatus(t, 4, "deploy", StatusRunning, time.Now())
+	dataB, err := json.Marshal(&BuildUpdateData{
+		ID:                 5,
+		Name:               "deploy-api",
+		Template:           "package",
+		Status:             StatusFinished,
+		FinalizationErrors: []string{"artifacts are not collected"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(HistoryBucket).Put(Itob(5), dataB)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"lint":       "passing",
+		"test":       "failing",
+		"deploy":     "aborted",
+		"package":    "finished with errors",
+		"deploy-api": "finished with errors",
+	} {
+		if result := message(name); result != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, result)
+		}
+	}
+
+	w := get("/job/lint/badge.svg?label=ci&style=flat-square", nil)
+	if !strings.Contains(w.Body.String(), "<title>ci: passing</title>") || !strings.Contains(w.Body.String(), `rx="0"`) {
+		t.Errorf("Expected square badge with label ci: %s", w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Cache-Control") != "max-age=30" {
+		t.Errorf("Expected caching headers, got %v", w.Header())
+	}
+	if w := get("/job/lint/badge.svg?label=ci&style=flat-square", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for the same badge, got %d", w.Code)
+	}
+	if w := get("/job/lint/badge.svg", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another badge, got %d", w.Code)
+	}
+}
+
+func TestBadgeRoutes(t *testing.T) {
+	for _, public := range []bool{true, false} {
+		setupTestEnv(t)
+		Config.PublicBadges = public
+		putTestBuildStatus(t, 1, "lint", StatusFinished, time.Now())
+		router := chi.NewRouter()
+		badgeRoutes(router)
+
+		for path, expected := range map[string]string{
+			"/badge/lint":          "passing",
+			"/job/lint/badge.svg":  "passing",
+			"/badge/unknown":       "no builds",
+			"/badge/lint?label=ci": "passing",
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if !public {
+				if w.Code != http.StatusForbidden {
+					t.Errorf("%s: expected 403 without credentials, got %d", path, w.Code)
+				}
+				continue
+			}
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), ": "+expected+"</title>") {
+				t.Errorf("%s: expected %q, got %d %s", path, expected, w.Code, w.Body.String())
+			}
+		}
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -47,6 +47,10 @@ const StatusAborted = "aborted"
 // `timeout` value was reached
 const StatusTimedOut = "timed out"
 
+// StatusDiskQuotaExceeded indicates that a build was automatically aborted
+// because its workspace has exceeded `disk_quota`
+const StatusDiskQuotaExceeded = "disk quota exceeded"
+
 // StatusSkipped indicates that `when` condition is false and the task won't be
 // executed
 const StatusSkipped = "skipped"
@@ -54,7 +58,32 @@ const StatusSkipped = "skipped"
 // FinalTask is the task that is executed no matter what is the result of the build
 const FinalTask = "finally"
 
-// WHEN_EVAL_TIMEOUT is the timeout for evaluating `when` condition in tasks, s
+// WarningAnnotation is a prefix of task output lines which are collected as
+// warnings of the build, e.g. `echo "##wake:warning API v1 is deprecated"`
+const WarningAnnotation = "##wake:warning"
+
+// MaxBuildWarnings limits number of collected warnings
+const MaxBuildWarnings = 50
+
+// TriggerManual indicates that a build was started via API or UI
+const TriggerManual = "manual"
+
+// TriggerCron indicates that a build was started by cron (`interval`)
+const TriggerCron = "cron"
+
+// TriggerRetry indicates that a build was started again after the previous
+// attempt failed (`retry`)
+const TriggerRetry = "retry"
+
+// TriggerWebhook indicates that a build was started via POST /webhook/{name}
+const TriggerWebhook = "webhook"
+
+// OnStatusTaskTimeout is the hard limit for duration of on-status tasks
+// (`on_*` and `finally`), so a stuck notification doesn't block the build
+var OnStatusTaskTimeout = 10 * time.Minute
+
+// WHEN_EVAL_TIMEOUT is the timeout for evaluating `if` and bash `when`
+// conditions in tasks, s
 const WHEN_EVAL_TIMEOUT = 3
 
 // ABORT_TIMEOUT is the timeout for aborting the task, s
@@ -67,9 +96,9 @@ type Build struct {
 	Status         ItemStatus
 	Logger         *log.Logger
 	abortedChannel chan string
+	killChannel    chan bool // Instructs to kill the running task immediately
 	flushChannel   chan bool // Instructs to flush bw
 	pendingTasksWG sync.WaitGroup
-	abortedReason  string
 	Params         []map[string]string
 	Artifacts      []string // Deprecate
 	BuildArtifacts []*ArtifactInfo
@@ -77,38 +106,188 @@ type Build struct {
 	Duration       time.Duration // ns
 	ETA            int           // seconds
 	timer          *time.Timer   // A timer for Job.Timeout
+	quotaDone      chan bool     // Stops disk quota watcher
+	Trigger        *TriggerInfo
+	// Number of received abort requests, see RequestAbort
+	abortRequests int
+	// Main tasks are done and on-status tasks of the final status are running
+	onStatusPhase bool
+	// Remaining on-status tasks are skipped after a hard abort
+	skipOnStatusTasks bool
+	InstanceName      string // Name of the job instance if the job is a template
+	Prerequisites     *PrerequisitesCheck
+	PendingReason     string // Why the build is still in the queue
+	Labels            map[string]string
+	Warnings          []string // Collected from WarningAnnotation lines
+	token             string   // See BuildTokenRegistry
+	currentTask       int      // ID of the last started task
+	ArtifactFetches   []*ArtifactFetch
+	JobBuildNumber    int // Sequential number within the job, see Job.JobBuildNumbers
+	RetryOf           int // ID of the previous attempt, see Job.Retry
+	RetryAttempt      int // 0 for the first attempt
+	// Abort channels of main tasks running in parallel, see Job.Parallel
+	parallelTasks map[int]*taskChannels
+	// Reason of the abort request received while tasks run in parallel
+	parallelAbortReason string
+	// One of the tasks running in parallel has failed
+	parallelFailed bool
+	// The build waits in the queue for the end of a maintenance window
+	heldByMaintenance bool
+	// Files changed by main tasks, see Job.AuditWorkspace
+	WorkspaceAudit *WorkspaceAuditResult
+	// Builds created from the same run of Job.Matrix share the ID of the first one
+	MatrixBuildID int
+	Matrix        map[string]string // Values of the matrix combination
+	// Position among queued builds, see PriorityHigh
+	Priority string
+	// Failures of stages after main tasks, see addFinalizationError
+	FinalizationErrors []string
+	// Set if the build failed not because of the job, see FailureCauseInfra
+	FailureCause string
+	// Number of retries after infrastructure failures, see
+	// Job.AutoRetryInfraFailures
+	InfraRetryAttempt int
+	// Number of duplicate triggers merged into the pending build, see
+	// Job.Dedupe
+	Deduplicated int
+	// Not all artifacts were collected because of MaxArtifactSize
+	ArtifactsTruncated bool
+	// SHA-256 of the collected artifacts, see ArtifactChecksumsFile
+	ArtifactChecksums map[string]string
+	// Builds started by Job.Triggers
+	DownstreamBuilds []int
+	// Set by DirectiveSetDescription
+	Description string
+	// Notes added by DirectiveAnnotation
+	Annotations []string
+	// Revision of the latest saved record, see nextRevision
+	revision int
+	// Number of directives printed by each task, see MaxTaskDirectives
+	taskDirectives map[string]int
 	mutex          deadlock.Mutex
 }
 
 // Start starts execution of tasks in job
 func (b *Build) Start() {
 	b.SetBuildStatus(StatusRunning)
-	for _, task := range b.Job.Tasks {
-		if task.Kind != KindMain {
-			continue
+	if err := b.checkWorkspace(); err != nil {
+		b.markInfraFailure(err)
+		b.SetBuildStatus(StatusFailed)
+		return
+	}
+	// Fail fast instead of failing every task with exit code 127
+	if err := b.checkShell(); err != nil {
+		b.markInfraFailure(err)
+		b.SetBuildStatus(StatusFailed)
+		return
+	}
+	manifest := b.startWorkspaceAudit()
+	status := b.runMainTasks()
+	b.finishWorkspaceAudit(manifest)
+	if status == StatusFinished && !b.checkRequiredArtifacts() {
+		status = StatusFailed
+	}
+	b.SetBuildStatus(status)
+}
+
+// runMainTasks runs main tasks and returns the status of the build
+func (b *Build) runMainTasks() ItemStatus {
+	if b.Job.hasTaskDependencies() {
+		// Without the limit all tasks with completed dependencies start
+		workers := len(b.Job.Tasks)
+		if b.Job.Parallel > 1 {
+			workers = b.Job.Parallel
+		} else if !b.Job.hasDependsOn() {
+			// Tasks of a workflow stage run concurrently only with `parallel`
+			workers = 1
 		}
-		task.Status = StatusRunning
-		task.startedAt = time.Now()
-		b.BroadcastUpdate()
+		return b.runTaskGraph(workers)
+	}
+	if b.Job.Parallel > 1 {
+		return b.runMainTasksParallel(b.Job.Parallel)
+	}
+	for _, step := range b.Job.mainTaskSteps() {
+		// Abort request might be received while no task was running
+		select {
+		case reason := <-b.abortedChannel:
+			return ItemStatus(reason)
+		default:
+		}
+
+		var status ItemStatus
+		if task := step[0]; task.Group == "" {
+			if !b.startTask(task) {
+				continue
+			}
+			b.BroadcastUpdate()
 
-		status := b.runTask(task)
+			status = b.runTaskWithRetries(task)
 
-		task.Status = status
-		task.duration = time.Since(task.startedAt)
+			b.finishTask(task, status)
+			if b.isFailureAllowed(task, status) {
+				status = StatusFinished
+			}
+			status = b.checkTaskAborted(task, status)
+		} else {
+			// Tasks of the group run concurrently as a single step
+			status = b.runTasksParallel(step, len(step))
+		}
 		switch status {
-		case StatusFailed:
-			b.SetBuildStatus(StatusFailed)
-			return
-		case StatusAborted:
-			b.SetBuildStatus(StatusAborted)
-			return
-		case StatusTimedOut:
-			b.SetBuildStatus(StatusTimedOut)
-			return
+		case StatusFailed, StatusAborted, StatusTimedOut, StatusDiskQuotaExceeded:
+			return status
 		}
 		b.BroadcastUpdate()
 	}
-	b.SetBuildStatus(StatusFinished)
+	return StatusFinished
+}
+
+// startTask marks the task as running. Returns false if the task is skipped
+// by RequestTaskSkip and mustn't run
+func (b *Build) startTask(task *Task) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if task.skipRequested {
+		b.Logger.Printf("Skipping task %d on request\n", task.ID)
+		return false
+	}
+	task.Status = StatusRunning
+	task.startedAt = time.Now()
+	if task.abortChannel == nil {
+		task.abortChannel = make(chan bool, 1)
+	}
+	return true
+}
+
+// getTaskStatus returns the current status of the task
+func (b *Build) getTaskStatus(task *Task) ItemStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return task.Status
+}
+
+// finishTask sets the final status of the task
+func (b *Build) finishTask(task *Task, status ItemStatus) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if status == StatusTaskAborted {
+		status = StatusAborted
+	}
+	task.Status = status
+	task.duration = time.Since(task.startedAt)
+	if status == StatusSkipped {
+		task.duration = 0
+	}
+}
+
+// isFailureAllowed returns true if the task failed, but the build continues
+// because of Task.AllowFailure
+func (b *Build) isFailureAllowed(task *Task, status ItemStatus) bool {
+	if status != StatusFailed || !task.AllowFailure {
+		return false
+	}
+	b.Logger.Printf("Task %d failed, failure is allowed\n", task.ID)
+	b.addWarning(fmt.Sprintf("Task %s failed (allowed)", task.Name))
+	return true
 }
 
 // runOnStatusTasks runs tasks on status change
@@ -119,14 +298,24 @@ func (b *Build) runOnStatusTasks(status ItemStatus) {
 	}
 	for _, task := range b.Job.Tasks {
 		if task.Kind == string(status) {
-			task.Status = StatusRunning
-			task.startedAt = time.Now()
+			b.mutex.Lock()
+			skip := b.skipOnStatusTasks
+			b.mutex.Unlock()
+			if skip {
+				b.Logger.Printf("Skipping task %d after abort request\n", task.ID)
+				b.mutex.Lock()
+				task.Status = StatusSkipped
+				b.mutex.Unlock()
+				b.BroadcastUpdate()
+				continue
+			}
+			b.startTask(task)
 			b.BroadcastUpdate()
 
 			status := b.runTask(task)
 
-			task.Status = status
-			task.duration = time.Since(task.startedAt)
+			b.finishTask(task, status)
+			b.checkOnStatusTask(task, status)
 			b.BroadcastUpdate()
 		}
 	}
@@ -135,18 +324,35 @@ func (b *Build) runOnStatusTasks(status ItemStatus) {
 // runTask is responsible for running one task and return it's status
 func (b *Build) runTask(task *Task) ItemStatus {
 	b.Logger.Printf("Task %d has been started\n", task.ID)
+	b.mutex.Lock()
+	b.currentTask = task.ID
+	b.mutex.Unlock()
 	defer b.Logger.Printf("Task %d is completed\n", task.ID)
 	// Disable output buffering, enable streaming
-	// Modify default streaming buffer size (thanks, webpack)
+	// Lines longer than the limit are truncated before they reach the go-cmd
+	// buffer, which has room for the truncation marker
+	lineLimit := b.getLineBufferSize(task)
 	cmdOptions := cmd.Options{
 		Buffered:       false,
 		Streaming:      true,
-		LineBufferSize: 491520,
+		LineBufferSize: uint(lineLimit + 128),
+		BeforeExec:     []func(*exec.Cmd){limitLines(lineLimit)},
 	}
-	taskCmd := cmd.NewCmdOptions(cmdOptions, "bash", "-c", injectSecrets(task.Command))
+	shell, shellArgs := b.Job.shellCommand()
+	taskCmd := cmd.NewCmdOptions(cmdOptions, shell, append(shellArgs, injectSecrets(task.Command))...)
 
-	// Configure task logs
-	file, err := os.Create(b.GetWakespaceDir() + fmt.Sprintf("task_%d.log", task.ID))
+	// Configure task logs. Retries are appended to the log of the first attempt
+	b.mutex.Lock()
+	attempt := task.attempts
+	b.mutex.Unlock()
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if attempt > 1 {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(b.GetWakespaceDir()+task.LogFileName(), flags, 0666)
+	if err == nil {
+		metricsOpenTaskLogs.Inc()
+	}
 	bw := bufio.NewWriter(file)
 	defer func() {
 		err = bw.Flush()
@@ -156,12 +362,20 @@ func (b *Build) runTask(task *Task) ItemStatus {
 		err = file.Close()
 		if err != nil {
 			b.Logger.Println(err)
+		} else {
+			metricsOpenTaskLogs.Dec()
 		}
 	}()
 	if err != nil {
-		b.Logger.Println(err)
+		if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+			err = fmt.Errorf("too many open files — check server limits: %w", err)
+		}
+		b.markInfraFailure(fmt.Errorf("unable to open log of task %d: %w", task.ID, err))
 		return StatusFailed
 	}
+	if attempt > 1 {
+		b.ProcessLogEntry(fmt.Sprintf("> Retry %d/%d", attempt-1, task.Retries), bw, task, task.startedAt)
+	}
 
 	// Construct environment for the task
 	taskCmd.Env = os.Environ()
@@ -181,7 +395,7 @@ func (b *Build) runTask(task *Task) ItemStatus {
 	buidEnv, err := godotenv.Read(envFile)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			b.ProcessLogEntry("> Error in build.env file: "+err.Error(), bw, task.ID, task.startedAt)
+			b.ProcessLogEntry("> Error in build.env file: "+err.Error(), bw, task, task.startedAt)
 			return StatusFailed
 		}
 	} else {
@@ -192,66 +406,42 @@ func (b *Build) runTask(task *Task) ItemStatus {
 
 	// Checking condition in `when`
 	if task.When != "" {
-		condCmd := exec.Command("bash", "-c", fmt.Sprintf("[[ %s ]]", task.When))
-		condCmd.Env = taskCmd.Env
-		condCmd.Dir = taskCmd.Dir
-		b.ProcessLogEntry("> Checking `when` condition: "+task.When, bw, task.ID, task.startedAt)
-		expandedCondCmd := os.Expand(task.When, getEnvMapper(condCmd.Env))
-		if expandedCondCmd != task.When {
-			b.ProcessLogEntry(
-				"> Expanded condition: "+os.Expand(task.When, getEnvMapper(condCmd.Env)), bw, task.ID, task.startedAt,
-			)
-		}
-		condErr := condCmd.Start()
+		expandedCond := os.Expand(task.When, getEnvMapper(taskCmd.Env))
+		condResult, condErr := evaluateTaskCondition(task.When, taskCmd.Env, taskCmd.Dir, b.getParamNames())
 		if condErr != nil {
 			b.ProcessLogEntry(
-				fmt.Sprintf("> Unable to evaluate the condition: %s", condErr.Error()),
-				bw, task.ID, task.startedAt,
+				fmt.Sprintf("> Unable to evaluate `when` condition %s: %s", expandedCond, condErr.Error()),
+				bw, task, task.startedAt,
 			)
 			return StatusFailed
 		}
-		condKilled := false
-		condTimer := time.AfterFunc(WHEN_EVAL_TIMEOUT*time.Second, func() {
-			condKilled = true
-			condCmd.Process.Kill()
-		})
-		condErr = condCmd.Wait()
-		condTimer.Stop()
-		if condKilled {
-			b.ProcessLogEntry(
-				fmt.Sprintf("> Condition timeouted: %s", condErr.Error()),
-				bw, task.ID, task.startedAt,
-			)
-			return StatusFailed
-		}
-		if condErr != nil {
+		if !condResult {
 			b.ProcessLogEntry(
-				fmt.Sprintf("> Condition is false: %s. Skipping the task", condErr.Error()),
-				bw, task.ID, task.startedAt,
+				fmt.Sprintf("> Skipping the task, `when` condition is false: %s", expandedCond),
+				bw, task, task.startedAt,
 			)
 			return StatusSkipped
-		} else {
-			b.ProcessLogEntry("> Condition is true", bw, task.ID, task.startedAt)
 		}
+		b.ProcessLogEntry(fmt.Sprintf("> `when` condition is true: %s", expandedCond), bw, task, task.startedAt)
 	}
 
 	// Checking condition in `if`
 	if task.If != "" {
-		condCmd := exec.Command("bash", "-c", task.If)
+		condCmd := exec.Command(shell, append(shellArgs, task.If)...)
 		condCmd.Env = taskCmd.Env
 		condCmd.Dir = taskCmd.Dir
-		b.ProcessLogEntry("> Checking `if` condition: "+task.If, bw, task.ID, task.startedAt)
+		b.ProcessLogEntry("> Checking `if` condition: "+task.If, bw, task, task.startedAt)
 		expandedCondCmd := os.Expand(task.If, getEnvMapper(condCmd.Env))
 		if expandedCondCmd != task.If {
 			b.ProcessLogEntry(
-				"> Expanded condition: "+os.Expand(task.If, getEnvMapper(condCmd.Env)), bw, task.ID, task.startedAt,
+				"> Expanded condition: "+os.Expand(task.If, getEnvMapper(condCmd.Env)), bw, task, task.startedAt,
 			)
 		}
 		condErr := condCmd.Start()
 		if condErr != nil {
 			b.ProcessLogEntry(
 				fmt.Sprintf("> Unable to evaluate the condition: %s", condErr.Error()),
-				bw, task.ID, task.startedAt,
+				bw, task, task.startedAt,
 			)
 			return StatusFailed
 		}
@@ -265,30 +455,72 @@ func (b *Build) runTask(task *Task) ItemStatus {
 		if condKilled {
 			b.ProcessLogEntry(
 				fmt.Sprintf("> Condition timeouted: %s", condErr.Error()),
-				bw, task.ID, task.startedAt,
+				bw, task, task.startedAt,
 			)
 			return StatusFailed
 		}
 		if condErr != nil {
 			b.ProcessLogEntry(
 				fmt.Sprintf("> Condition is false: %s. Skipping the task", condErr.Error()),
-				bw, task.ID, task.startedAt,
+				bw, task, task.startedAt,
 			)
 			return StatusSkipped
 		} else {
-			b.ProcessLogEntry("> Condition is true", bw, task.ID, task.startedAt)
+			b.ProcessLogEntry("> Condition is true", bw, task, task.startedAt)
 		}
 	}
 
 	// Add executed command to logs
-	b.ProcessLogEntry("> Running command: "+task.Command, bw, task.ID, task.startedAt)
+	b.ProcessLogEntry("> Running command: "+task.Command, bw, task, task.startedAt)
 	expandedTaskCmd := os.Expand(task.Command, getEnvMapper(taskCmd.Env))
+	resolvedCommand := b.redactSecrets(b.maskSensitiveParams(injectSecrets(expandedTaskCmd)))
+	b.mutex.Lock()
+	task.resolvedCommand = resolvedCommand
+	b.mutex.Unlock()
 	if expandedTaskCmd != task.Command {
 		b.ProcessLogEntry(
-			"> Expanded command: "+injectSecrets(expandedTaskCmd), bw, task.ID, task.startedAt,
+			"> Expanded command: "+injectSecrets(expandedTaskCmd), bw, task, task.startedAt,
 		)
 	}
 
+	// Only main tasks can be aborted gracefully. On-status tasks are limited by
+	// OnStatusTaskTimeout and are killed immediately on abort request
+	abortedChannel := b.abortedChannel
+	killChannel := b.killChannel
+	if channels := b.getTaskChannels(task); channels != nil {
+		abortedChannel = channels.aborted
+		killChannel = channels.kill
+	}
+	var capChannel <-chan time.Time
+	if task.Kind != KindMain {
+		abortedChannel = nil
+		capTimer := time.NewTimer(OnStatusTaskTimeout)
+		defer capTimer.Stop()
+		capChannel = capTimer.C
+	}
+	var killedReason string
+	var abortedReason string
+	// Only main tasks can be aborted alone
+	taskAbortChannel := b.getTaskAbortChannel(task)
+	if task.Kind != KindMain {
+		taskAbortChannel = nil
+	}
+	taskAborted := false
+
+	// Task timeout fails only this task, Job.Timeout is handled separately
+	var taskTimeoutChannel <-chan time.Time
+	taskTimedOut := false
+	if task.Timeout != "" {
+		d, err := time.ParseDuration(task.Timeout)
+		if err != nil {
+			b.Logger.Println(err)
+		} else {
+			taskTimer := time.NewTimer(d)
+			defer taskTimer.Stop()
+			taskTimeoutChannel = taskTimer.C
+		}
+	}
+
 	// Print STDOUT and STDERR lines streaming from Cmd
 	// See example https://github.com/go-cmd/cmd/blob/master/examples/blocking-streaming/main.go
 	doneChan := make(chan struct{})
@@ -301,33 +533,41 @@ func (b *Build) runTask(task *Task) ItemStatus {
 					taskCmd.Stdout = nil
 					continue
 				}
-				b.ProcessLogEntry(line, bw, task.ID, task.startedAt)
+				b.ProcessLogEntry(line, bw, task, task.startedAt)
 			case line, open := <-taskCmd.Stderr:
 				if !open {
 					taskCmd.Stderr = nil
 					continue
 				}
-				b.ProcessLogEntry(line, bw, task.ID, task.startedAt)
-			case abortedDetails := <-b.abortedChannel:
-				b.abortedReason = abortedDetails
+				b.ProcessLogEntry(line, bw, task, task.startedAt)
+			case abortedDetails := <-abortedChannel:
+				abortedReason = abortedDetails
 				b.Logger.Printf("Aborting via abortedChannel: %s\n", abortedDetails)
 				switch abortedDetails {
 				case StatusTimedOut:
-					b.ProcessLogEntry("> Timed out.", bw, task.ID, task.startedAt)
+					b.ProcessLogEntry("> Timed out.", bw, task, task.startedAt)
 				case StatusAborted:
-					b.ProcessLogEntry("> Aborted by a user.", bw, task.ID, task.startedAt)
+					b.ProcessLogEntry("> Aborted by a user.", bw, task, task.startedAt)
+				case StatusDiskQuotaExceeded:
+					b.ProcessLogEntry("> Disk quota exceeded.", bw, task, task.startedAt)
 				default:
 					b.Logger.Printf("Unhandled abort method: %s\n", abortedDetails)
 				}
 				// taskCmd.Stop() send SIGTERM signal to the command. Most of the time it works just fine, however
 				// there are applications which will just ignore it or are in busy state and can't handle the signal.
 				// Here we start a timer for SIGTERM to succeed and if it doesn't, SIGKILL is sent
+				b.startTaskStop(task, abortedDetails, "SIGTERM")
 				abortTimer := time.AfterFunc(ABORT_TIMEOUT*time.Second, func() {
-					b.ProcessLogEntry("> Killing the command...", bw, task.ID, task.startedAt)
-					err = syscall.Kill(taskCmd.Status().PID, syscall.SIGKILL)
+					b.ProcessLogEntry(
+						fmt.Sprintf("> The command didn't exit %ds after SIGTERM. Killing the command...", ABORT_TIMEOUT),
+						bw, task, task.startedAt,
+					)
+					err := syscall.Kill(taskCmd.Status().PID, syscall.SIGKILL)
 					if err != nil {
 						b.Logger.Printf("Unable to kill aborted task %d: %s\n", task.ID, err.Error())
+						return
 					}
+					b.markTaskForceKilled(task)
 				})
 				taskCmd.Stop()
 				go func() {
@@ -335,6 +575,67 @@ func (b *Build) runTask(task *Task) ItemStatus {
 					<-taskCmd.Done()
 					abortTimer.Stop()
 				}()
+			case <-killChannel:
+				b.Logger.Printf("Killing task %d on abort request\n", task.ID)
+				b.ProcessLogEntry("> Aborted by a user. Killing the command...", bw, task, task.startedAt)
+				killedReason = StatusAborted
+				if task.Kind == KindMain && abortedReason == "" {
+					abortedReason = StatusAborted
+				}
+				b.startTaskStop(task, StatusAborted, "SIGKILL")
+				b.markTaskForceKilled(task)
+				err := killTaskCmd(taskCmd)
+				if err != nil {
+					b.Logger.Printf("Unable to kill task %d: %s\n", task.ID, err.Error())
+				}
+			case <-capChannel:
+				b.Logger.Printf("Task %d has reached the limit of %s\n", task.ID, OnStatusTaskTimeout)
+				b.ProcessLogEntry(
+					fmt.Sprintf("> Timed out after %s. Killing the command...", OnStatusTaskTimeout), bw, task, task.startedAt,
+				)
+				killedReason = StatusTimedOut
+				b.startTaskStop(task, StatusTimedOut, "SIGKILL")
+				b.markTaskForceKilled(task)
+				err := killTaskCmd(taskCmd)
+				if err != nil {
+					b.Logger.Printf("Unable to kill task %d: %s\n", task.ID, err.Error())
+				}
+			case <-taskTimeoutChannel:
+				b.Logger.Printf("Task %d has reached its timeout %s\n", task.ID, task.Timeout)
+				b.ProcessLogEntry(fmt.Sprintf("> Task timed out after %s.", task.Timeout), bw, task, task.startedAt)
+				taskTimedOut = true
+				b.startTaskStop(task, StatusTimedOut, "SIGTERM")
+				abortTimer := time.AfterFunc(ABORT_TIMEOUT*time.Second, func() {
+					err := killTaskCmd(taskCmd)
+					if err != nil {
+						b.Logger.Printf("Unable to kill timed out task %d: %s\n", task.ID, err.Error())
+						return
+					}
+					b.markTaskForceKilled(task)
+				})
+				taskCmd.Stop()
+				go func() {
+					<-taskCmd.Done()
+					abortTimer.Stop()
+				}()
+			case <-taskAbortChannel:
+				b.Logger.Printf("Aborting task %d\n", task.ID)
+				b.ProcessLogEntry("> The task is aborted by a user.", bw, task, task.startedAt)
+				taskAborted = true
+				b.startTaskStop(task, StatusAborted, "SIGTERM")
+				abortTimer := time.AfterFunc(ABORT_TIMEOUT*time.Second, func() {
+					err := killTaskCmd(taskCmd)
+					if err != nil {
+						b.Logger.Printf("Unable to kill aborted task %d: %s\n", task.ID, err.Error())
+						return
+					}
+					b.markTaskForceKilled(task)
+				})
+				taskCmd.Stop()
+				go func() {
+					<-taskCmd.Done()
+					abortTimer.Stop()
+				}()
 			case <-b.flushChannel:
 				b.Logger.Println("Flushing log file...")
 				bw.Flush()
@@ -343,7 +644,9 @@ func (b *Build) runTask(task *Task) ItemStatus {
 	}()
 
 	// Run and wait for Cmd to return
+	metricsTaskProcesses.Inc()
 	status := <-taskCmd.Start()
+	metricsTaskProcesses.Dec()
 	b.Logger.Printf(
 		"Task %d result: Completed: %v, Exit code %d, Error %s",
 		task.ID, status.Complete, status.Exit, status.Error,
@@ -352,19 +655,49 @@ func (b *Build) runTask(task *Task) ItemStatus {
 	// Cmd has finished but wait for goroutine to print all lines
 	<-doneChan
 
+	// The process was never started, e.g. fork failed
+	if status.PID == 0 && status.Error != nil {
+		err := explainSpawnFailure(status.Error)
+		b.ProcessLogEntry("> "+err.Error(), bw, task, task.startedAt)
+		b.markInfraFailure(fmt.Errorf("task %s: %w", task.Name, err))
+		return StatusFailed
+	}
+
+	// Background processes of the command might be still running
+	b.reapChildren(task, status.PID, bw)
+
+	if stop := b.finishTaskStop(task); stop != nil {
+		msg := fmt.Sprintf("> The command exited %s after %s", stop.ExitedIn.Truncate(time.Millisecond), stop.Signal)
+		if stop.ForceKilled && stop.Signal != "SIGKILL" {
+			msg += ", SIGKILL was required"
+		}
+		b.Logger.Printf("Task %d stop sequence: %s\n", task.ID, msg[2:])
+		b.ProcessLogEntry(msg, bw, task, task.startedAt)
+	}
+
+	// On-status task was killed
+	if task.Kind != KindMain && killedReason != "" {
+		return ItemStatus(killedReason)
+	}
+
 	// Abort message was recieved via channel
-	if b.abortedReason != "" {
-		reason := b.abortedReason
-		// Toggle status back for OnStatus tasks
-		b.abortedReason = ""
-		return ItemStatus(reason)
+	if abortedReason != "" {
+		return ItemStatus(abortedReason)
 	}
 
-	b.ProcessLogEntry(fmt.Sprintf("> Exit code: %d", status.Exit), bw, task.ID, task.startedAt)
+	if taskAborted {
+		return StatusTaskAborted
+	}
+
+	if taskTimedOut {
+		return StatusFailed
+	}
+
+	b.ProcessLogEntry(fmt.Sprintf("> Exit code: %d", status.Exit), bw, task, task.startedAt)
 
 	if !status.Complete || status.Exit != 0 || status.Error != nil {
 		if task.IgnoreErrors {
-			b.ProcessLogEntry("> Ignorring exit code", bw, task.ID, task.startedAt)
+			b.ProcessLogEntry("> Ignorring exit code", bw, task, task.startedAt)
 			return StatusFinished
 		}
 		return StatusFailed
@@ -373,6 +706,110 @@ func (b *Build) runTask(task *Task) ItemStatus {
 	return StatusFinished
 }
 
+// startTaskStop records the first signal sent to the command of the task
+func (b *Build) startTaskStop(task *Task, reason string, signal string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if task.stop != nil {
+		return
+	}
+	task.stop = &TaskStopInfo{
+		Reason:    reason,
+		Signal:    signal,
+		startedAt: time.Now(),
+	}
+}
+
+// markTaskForceKilled records that SIGKILL was sent to the command of the task
+func (b *Build) markTaskForceKilled(task *Task) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if task.stop != nil {
+		task.stop.ForceKilled = true
+	}
+}
+
+// finishTaskStop records how long it took the command to exit. Returns nil if
+// the command wasn't stopped
+func (b *Build) finishTaskStop(task *Task) *TaskStopInfo {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if task.stop == nil {
+		return nil
+	}
+	task.stop.ExitedIn = time.Since(task.stop.startedAt)
+	stop := *task.stop
+	return &stop
+}
+
+// killTaskCmd immediately kills the process group of the task
+func killTaskCmd(taskCmd *cmd.Cmd) error {
+	// The command might be still starting
+	for i := 0; i < 50; i++ {
+		s := taskCmd.Status()
+		if s.PID > 0 {
+			return syscall.Kill(-s.PID, syscall.SIGKILL)
+		}
+		if s.StopTs > 0 {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("the command hasn't started")
+}
+
+// RequestAbort asks the build to abort. The first request aborts the running
+// main task gracefully (SIGTERM, then SIGKILL after ABORT_TIMEOUT). Any
+// following request kills the running main task immediately. A request received
+// while on-status tasks of the final build status are running kills the running
+// task immediately and skips all remaining on-status tasks. Returns true if it
+// is the first request
+func (b *Build) RequestAbort(reason string) bool {
+	b.mutex.Lock()
+	b.abortRequests++
+	first := b.abortRequests == 1
+	onStatusPhase := b.onStatusPhase
+	if onStatusPhase {
+		b.skipOnStatusTasks = true
+	}
+	b.mutex.Unlock()
+
+	if first && !onStatusPhase {
+		b.Logger.Printf("Abort requested: %s\n", reason)
+		select {
+		case b.abortedChannel <- reason:
+		default:
+		}
+		return true
+	}
+	b.Logger.Printf("Abort requested again: %s\n", reason)
+	select {
+	case b.killChannel <- true:
+	default:
+	}
+	return first
+}
+
+// isAbortRequested returns true if the build has received an abort request
+func (b *Build) isAbortRequested() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.abortRequests > 0
+}
+
+// enterOnStatusPhase marks that the build has reached its final status and
+// only on-status tasks are going to be executed
+func (b *Build) enterOnStatusPhase() {
+	b.mutex.Lock()
+	b.onStatusPhase = true
+	b.mutex.Unlock()
+	// Drop kill requests which were addressed to main tasks
+	select {
+	case <-b.killChannel:
+	default:
+	}
+}
+
 // Generate default set of environmental variables that are injected before
 // running a task, for example WAKE_BUILD_ID
 func (b *Build) generateDefaultEnvVariables() []string {
@@ -382,37 +819,115 @@ func (b *Build) generateDefaultEnvVariables() []string {
 			params.Set(pkey, pval)
 		}
 	}
+	b.mutex.Lock()
+	warnings := strings.Join(b.Warnings, "\n")
+	b.mutex.Unlock()
+	token := b.getToken()
 	var evs = []string{
 		fmt.Sprintf("WAKE_BUILD_ID=%d", b.ID),
 		fmt.Sprintf("WAKE_BUILD_WORKSPACE=%s", b.GetWorkspaceDir()),
-		fmt.Sprintf("WAKE_JOB_NAME=%s", b.Job.Name),
+		fmt.Sprintf("WAKE_JOB_NAME=%s", b.GetJobName()),
+		fmt.Sprintf("WAKE_JOB_TEMPLATE=%s", b.Job.Name),
 		fmt.Sprintf("WAKE_JOB_PARAMS=%s", params.Encode()),
 		fmt.Sprintf("WAKE_CONFIG_DIR=%s", Config.JobDir),
+		fmt.Sprintf("WAKE_BUILD_WARNINGS=%s", warnings),
+		fmt.Sprintf("WAKE_BUILD_TOKEN=%s", token),
 	}
-	if Config.Port == "443" {
-		evs = append(evs, fmt.Sprintf("WAKE_URL=https://%s/", Config.Hostname))
-	} else {
-		evs = append(evs, fmt.Sprintf("WAKE_URL=http://localhost:%s/", Config.Port))
+	if b.Trigger != nil {
+		evs = append(evs, fmt.Sprintf("WAKE_TRIGGER=%s", b.Trigger.Kind))
+		if b.Trigger.UpstreamBuildID != 0 {
+			evs = append(evs, fmt.Sprintf("WAKE_UPSTREAM_BUILD_ID=%d", b.Trigger.UpstreamBuildID))
+		}
 	}
+	if b.JobBuildNumber != 0 {
+		evs = append(evs, fmt.Sprintf("WAKE_JOB_BUILD_NUMBER=%d", b.JobBuildNumber))
+	}
+	evs = append(evs, fmt.Sprintf("WAKE_URL=%s", getWakeURL()))
 	return evs
 }
 
+// GetJobName returns name of the job instance if the job is a template or
+// name of the job otherwise
+func (b *Build) GetJobName() string {
+	if b.InstanceName != "" {
+		return b.InstanceName
+	}
+	return b.Job.Name
+}
+
+// getParamsMapper is used to expand params in strings
+func (b *Build) getParamsMapper() func(string) string {
+	return func(pkey string) string {
+		if pkey == "WAKE_JOB_BUILD_NUMBER" && b.JobBuildNumber != 0 {
+			return strconv.Itoa(b.JobBuildNumber)
+		}
+		// Iterate backwards as the last value will be the actual value
+		for i := len(b.Params) - 1; i >= 0; i-- {
+			value, ok := b.Params[i][pkey]
+			if ok {
+				return value
+			}
+		}
+		return ""
+	}
+}
+
+// getParamNames returns names of params of the build
+func (b *Build) getParamNames() map[string]bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	names := make(map[string]bool)
+	for _, param := range b.Params {
+		for pkey := range param {
+			names[pkey] = true
+		}
+	}
+	return names
+}
+
 // Cleanup is called when a job finished, failed or aborted
 func (b *Build) Cleanup() {
 	if b.timer != nil {
 		b.timer.Stop()
 	}
+	b.stopDiskQuotaWatcher()
+	b.revokeToken()
 	GlobalQueue.Remove(b.ID)
 	GlobalQueue.Take()
+	GlobalQueue.track(func() {
+		err := IndexBuildLogs(b.ID, b.Job.Name)
+		if err != nil {
+			b.Logger.Println(err)
+		}
+	})
 }
 
-// CollectArtifacts copies artifacts from workspace to wakespace
+// CollectArtifacts copies artifacts from workspace to wakespace. Collecting
+// stops when the total size exceeds MaxArtifactSize or the artifact storage
+// is full
 func (b *Build) CollectArtifacts() {
+	if len(b.Job.Artifacts) == 0 {
+		return
+	}
+	limit, limitName, err := b.reserveArtifactStorage()
+	if err != nil {
+		b.Logger.Println(err)
+		b.addWarning(err.Error())
+		return
+	}
+	defer b.recordArtifactStorage()
+	var total int64
+Patterns:
 	for _, artPattern := range b.Job.Artifacts {
-		pattern := b.GetWorkspaceDir() + artPattern
+		pattern := b.GetWorkspaceDir() + artPattern.Pattern
 		files, err := doublestar.Glob(pattern)
 		if err != nil {
-			b.Logger.Println(err)
+			b.addFinalizationError("artifacts", err)
+			continue
+		}
+		if len(files) == 0 {
+			b.Logger.Println(artPattern.noMatchWarning())
+			b.addWarning(artPattern.noMatchWarning())
 			continue
 		}
 
@@ -420,58 +935,117 @@ func (b *Build) CollectArtifacts() {
 			// Skip directories
 			fi, err := os.Stat(f)
 			if err != nil {
-				b.Logger.Println(err)
+				b.addFinalizationError("artifacts", err)
 				continue
 			}
 			if fi.IsDir() {
 				continue
 			}
 			relPath := strings.TrimPrefix(f, b.GetWorkspaceDir())
+			// Exclude patterns win over include patterns
+			if b.Job.isArtifactExcluded(relPath) {
+				b.Logger.Printf("Skipping excluded artifact %s\n", relPath)
+				continue
+			}
+			if relPath == ArtifactChecksumsFile {
+				b.addWarning(fmt.Sprintf("Artifact %s is skipped, the name is reserved for checksums of artifacts", relPath))
+				continue
+			}
 			relDir, _ := filepath.Split(relPath)
 
 			// Recreate folder structure relative to artifacts directory
 			err = os.MkdirAll(b.GetArtifactsDir()+relDir, os.ModePerm)
 			if err != nil {
-				b.Logger.Println(err)
+				b.addFinalizationError("artifacts", err)
 				continue
 			}
-			b.Logger.Printf("Copying artifact %s...\n", relPath)
-			c := cmd.NewCmd("cp", f, b.GetArtifactsDir()+relPath)
-			s := <-c.Start()
-			if s.Exit != 0 {
-				b.Logger.Printf("Unable to copy %s, code %d\n", f, s.Exit)
-			} else {
+			if b.Job.CompressArtifacts {
+				b.Logger.Printf("Compressing artifact %s...\n", relPath)
+				relPath += CompressedArtifactExt
+				err = gzipFile(f, b.GetArtifactsDir()+relPath)
+				if err != nil {
+					b.addFinalizationError("artifacts", fmt.Errorf("unable to compress %s: %w", relPath, err))
+					continue
+				}
+				// Size on disk
+				fi, err = os.Stat(b.GetArtifactsDir() + relPath)
+				if err != nil {
+					b.addFinalizationError("artifacts", err)
+					continue
+				}
+				if limit > 0 && total+fi.Size() > limit {
+					err = os.Remove(b.GetArtifactsDir() + relPath)
+					if err != nil {
+						b.Logger.Println(err)
+					}
+					b.truncateArtifacts(relPath, limitName, limit)
+					break Patterns
+				}
+				total += fi.Size()
 				b.BuildArtifacts = append(b.BuildArtifacts, &ArtifactInfo{
-					Size:     fi.Size(),
-					Filename: relPath,
+					Size:       fi.Size(),
+					Filename:   relPath,
+					Compressed: true,
 				})
 				b.Artifacts = append(b.Artifacts, relPath) // Deprecate
+				continue
+			}
+			if limit > 0 && total+fi.Size() > limit {
+				b.truncateArtifacts(relPath, limitName, limit)
+				break Patterns
+			}
+			total += fi.Size()
+			b.Logger.Printf("Copying artifact %s...\n", relPath)
+			err = copyFile(f, b.GetArtifactsDir()+relPath, fi.Mode())
+			if err != nil {
+				b.addFinalizationError("artifacts", fmt.Errorf("unable to copy %s: %w", relPath, err))
+				continue
 			}
+			b.BuildArtifacts = append(b.BuildArtifacts, &ArtifactInfo{
+				Size:     fi.Size(),
+				Filename: relPath,
+			})
+			b.Artifacts = append(b.Artifacts, relPath) // Deprecate
 		}
 	}
+	b.writeArtifactChecksums()
 }
 
 // BroadcastUpdate sends update to all subscribed clients. Contains general
 // information about the build
 func (b *Build) BroadcastUpdate() {
-	data := b.GenerateBuildUpdateData()
+	data := b.nextRevision()
 	msg := MsgBroadcast{
 		Type: "build:update:" + strconv.Itoa(b.ID),
 		Data: data,
 	}
 	WSHub.broadcast <- &msg
 
-	err := DB.Update(func(tx *bolt.Tx) error {
-		var err error
-		hb := tx.Bucket([]byte(HistoryBucket))
-		dataB, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-		return hb.Put(Itob(data.ID), dataB)
-	})
+	// The final record is retried, the build is completed only once
+	attempts := 1
+	if isTerminalStatus(data.Status) {
+		attempts = FinalizationRetries
+	}
+	err := saveBuildUpdate(data, attempts)
+	if errors.Is(err, errStaleBuildUpdate) {
+		// The newer revision is saved by a concurrent update
+		b.Logger.Println(err)
+		return
+	}
 	if err != nil {
 		b.Logger.Println(err)
+		if isTerminalStatus(data.Status) {
+			// Clients still see that the record is not saved
+			b.addFinalizationError("history", err)
+			WSHub.broadcast <- &MsgBroadcast{
+				Type: msg.Type,
+				Data: b.GenerateBuildUpdateData(),
+			}
+		}
+		return
+	}
+	if isTerminalStatus(data.Status) {
+		GlobalExporter.Enqueue(data.ID)
 	}
 }
 
@@ -479,31 +1053,118 @@ func (b *Build) BroadcastUpdate() {
 func (b *Build) GenerateBuildUpdateData() *BuildUpdateData {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
+	return b.buildUpdateData()
+}
+
+// nextRevision generates BuildUpdateData with the next revision of the build
+// record. Records of older revisions are never saved over it
+func (b *Build) nextRevision() *BuildUpdateData {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.revision++
+	return b.buildUpdateData()
+}
+
+// copyParams copies params, so the update isn't changed while it is sent
+func copyParams(params []map[string]string) []map[string]string {
+	if params == nil {
+		return nil
+	}
+	copied := make([]map[string]string, len(params))
+	for i, param := range params {
+		copied[i] = make(map[string]string, len(param))
+		for k, v := range param {
+			copied[i][k] = v
+		}
+	}
+	return copied
+}
+
+// buildUpdateData generates BuildUpdateData. The caller has to hold the mutex
+func (b *Build) buildUpdateData() *BuildUpdateData {
+	var template string
+	if b.InstanceName != "" {
+		template = b.Job.Name
+	}
+	tasks := b.GetTasksStatus()
+	allowedFailures := false
+	for _, task := range tasks {
+		allowedFailures = allowedFailures || task.AllowedFailure
+	}
 	return &BuildUpdateData{
-		ID:             b.ID,
-		Name:           b.Job.Name,
-		Status:         b.Status,
-		Tasks:          b.GetTasksStatus(),
-		Params:         b.Params,
-		Artifacts:      b.Artifacts, // Deprecate
-		BuildArtifacts: b.BuildArtifacts,
-		StartedAt:      b.StartedAt,
-		Duration:       b.Duration,
-		ETA:            b.ETA,
+		ID:                 b.ID,
+		Name:               b.GetJobName(),
+		Template:           template,
+		Status:             b.Status,
+		Revision:           b.revision,
+		Tasks:              tasks,
+		Params:             copyParams(b.Params),
+		Artifacts:          b.Artifacts, // Deprecate
+		BuildArtifacts:     b.BuildArtifacts,
+		StartedAt:          b.StartedAt,
+		Duration:           b.Duration,
+		ETA:                b.ETA,
+		Trigger:            b.Trigger,
+		Prerequisites:      b.Prerequisites,
+		PendingReason:      b.PendingReason,
+		Labels:             b.Labels,
+		Warnings:           b.Warnings,
+		ArtifactFetches:    b.ArtifactFetches,
+		JobBuildNumber:     b.JobBuildNumber,
+		RetryOf:            b.RetryOf,
+		RetryAttempt:       b.RetryAttempt,
+		AllowedFailures:    allowedFailures,
+		WorkspaceAudit:     b.WorkspaceAudit,
+		MatrixBuildID:      b.MatrixBuildID,
+		Matrix:             b.Matrix,
+		Priority:           b.Priority,
+		FailureCause:       b.FailureCause,
+		InfraRetryAttempt:  b.InfraRetryAttempt,
+		Deduplicated:       b.Deduplicated,
+		ArtifactsTruncated: b.ArtifactsTruncated,
+		ArtifactChecksums:  b.ArtifactChecksums,
+		DownstreamBuilds:   b.DownstreamBuilds,
+		Description:        b.Description,
+		Annotations:        b.Annotations,
+		// Copied, the list grows while the record is being saved
+		FinalizationErrors: append([]string(nil), b.FinalizationErrors...),
 	}
 }
 
+// redactSecrets hides values of secrets and the build token
+func (b *Build) redactSecrets(str string) string {
+	b.mutex.Lock()
+	token := b.token
+	b.mutex.Unlock()
+	if token != "" {
+		str = strings.ReplaceAll(str, token, redactedSecret)
+	}
+	return redactSecrets(str)
+}
+
 // ProcessLogEntry handles log messages from tasks
-func (b *Build) ProcessLogEntry(line string, buffer *bufio.Writer, taskID int, startedAt time.Time) {
+func (b *Build) ProcessLogEntry(line string, buffer *bufio.Writer, task *Task, startedAt time.Time) {
 	// Format and clean up the log line:
 	// - add duration and a new line to the log entry
 	// - stip out color info
 	// - redact servers from the log
+	// - mask values of sensitive params
 	//
 	// Note: Internal logs start with `>`
-	pline := fmt.Sprintf("[%10s] ", time.Since(startedAt).Truncate(time.Millisecond).String()) + StripColor(redactSecrets(line)) + "\n"
+	prefix := fmt.Sprintf("[%10s] ", time.Since(startedAt).Truncate(time.Millisecond).String())
+	cleanLine := StripColor(b.redactSecrets(b.maskSensitiveParams(line)))
+	// Directives are not logged
+	if strings.HasPrefix(strings.TrimSpace(cleanLine), DirectivePrefix) {
+		b.handleDirective(strings.TrimSpace(cleanLine), task)
+		return
+	}
+	pline := prefix + SanitizeLogLine(cleanLine) + "\n"
 	// Write to the task's log file
-	_, err := buffer.WriteString(pline)
+	fline := pline
+	if b.getLogOutput(task) == LogOutputRaw {
+		fline = prefix + cleanLine + "\n"
+	}
+	_, err := buffer.WriteString(fline)
 	if err != nil {
 		b.Logger.Println(err)
 	}
@@ -512,11 +1173,43 @@ func (b *Build) ProcessLogEntry(line string, buffer *bufio.Writer, taskID int, s
 	msg := MsgBroadcast{
 		Type: "build:log:" + strconv.Itoa(b.ID),
 		Data: &CommandLogData{
-			TaskID: taskID,
+			TaskID: task.ID,
+			Key:    task.LogKey(),
 			Data:   pline,
 		},
 	}
 	WSHub.broadcast <- &msg
+
+	cleanLine = strings.TrimSpace(cleanLine)
+	if strings.HasPrefix(cleanLine, WarningAnnotation) {
+		b.addWarning(strings.TrimSpace(strings.TrimPrefix(cleanLine, WarningAnnotation)))
+	}
+	// Values are taken after redaction, so secrets don't appear in params
+	if b.exportParam(cleanLine) {
+		b.BroadcastUpdate()
+	}
+}
+
+// addWarning adds a new warning to the build. Duplicates and warnings above
+// MaxBuildWarnings are ignored
+func (b *Build) addWarning(warning string) {
+	if warning == "" {
+		return
+	}
+	b.mutex.Lock()
+	if len(b.Warnings) >= MaxBuildWarnings {
+		b.mutex.Unlock()
+		return
+	}
+	for _, w := range b.Warnings {
+		if w == warning {
+			b.mutex.Unlock()
+			return
+		}
+	}
+	b.Warnings = append(b.Warnings, warning)
+	b.mutex.Unlock()
+	b.BroadcastUpdate()
 }
 
 // GetWorkspaceDir returns path to the workspace, where all user created files
@@ -545,24 +1238,42 @@ func (b *Build) GetBuildConfigFilename() string {
 func (b *Build) GetTasksStatus() []*TaskStatus {
 	info := make([]*TaskStatus, 0)
 	for _, t := range b.Job.Tasks {
-		info = append(info, &TaskStatus{
-			ID:        t.ID,
-			Status:    t.Status,
-			StartedAt: t.startedAt,
-			Duration:  t.duration,
-			Kind:      t.Kind,
-		})
+		status := &TaskStatus{
+			ID:             t.ID,
+			Status:         t.Status,
+			StartedAt:      t.startedAt,
+			Duration:       t.duration,
+			Kind:           t.Kind,
+			Command:        t.resolvedCommand,
+			Attempts:       t.attempts,
+			AllowedFailure: t.AllowFailure && t.Status == StatusFailed,
+		}
+		if t.stop != nil {
+			stop := *t.stop
+			status.Stop = &stop
+			status.ForceKilled = stop.ForceKilled
+		}
+		info = append(info, status)
 	}
 	return info
 }
 
+// setDuration sets the duration of the completed build
+func (b *Build) setDuration() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.Duration = time.Since(b.StartedAt)
+}
+
 // SetBuildStatus sets the status of the builds
 func (b *Build) SetBuildStatus(status ItemStatus) {
 	b.Logger.Printf("Status: %s\n", status)
+	b.mutex.Lock()
 	b.Status = status
 	if status == StatusRunning {
 		b.StartedAt = time.Now()
 	}
+	b.mutex.Unlock()
 	// Wait for pending task to finish before running anything else
 	b.pendingTasksWG.Wait()
 	switch status {
@@ -591,39 +1302,63 @@ func (b *Build) SetBuildStatus(status ItemStatus) {
 				}()
 			}
 		}
+		b.startDiskQuotaWatcher()
 		b.runOnStatusTasks(status)
-	case StatusAborted, StatusTimedOut:
-		// We run on_aborted handlers for builds aborted by a user or timed out
+	case StatusAborted, StatusTimedOut, StatusDiskQuotaExceeded:
+		b.enterOnStatusPhase()
+		// We run on_aborted handlers for builds aborted by a user, timed out
+		// or exceeded disk quota
 		b.runOnStatusTasks(StatusAborted)
 		b.runOnStatusTasks(FinalTask)
-		b.Duration = time.Since(b.StartedAt)
+		b.setDuration()
 		b.Cleanup()
 		b.BroadcastUpdate()
 	case StatusFailed:
+		b.enterOnStatusPhase()
 		b.runOnStatusTasks(status)
 		b.CollectArtifacts()
 		b.runOnStatusTasks(FinalTask)
-		b.Duration = time.Since(b.StartedAt)
+		b.setDuration()
 		b.Cleanup()
 		b.BroadcastUpdate()
 	case StatusFinished:
+		b.enterOnStatusPhase()
 		b.runOnStatusTasks(status)
 		b.CollectArtifacts()
 		b.runOnStatusTasks(FinalTask)
-		b.Duration = time.Since(b.StartedAt)
+		b.setDuration()
 		b.Cleanup()
 		err := RecordBuildDuration(b.Job.Name, int(b.Duration))
 		if err != nil {
 			b.Logger.Println(err)
 		}
+		b.triggerDownstreamJobs()
 		b.BroadcastUpdate()
 	}
 
+	if isTerminalStatus(status) {
+		b.recordMetrics(status)
+		// Only the result of the retry is reported
+		if b.shouldRetryInfraFailure() {
+			b.Logger.Println("Status webhook and notifications are skipped, the build is retried after infrastructure failure")
+		} else {
+			GlobalStatusWebhooks.Push(b)
+			b.notifySlack(status)
+			b.sendEmail(status)
+		}
+		b.sendBuildWebhook(status)
+	}
+	if status == StatusFailed || status == StatusAborted {
+		_, err := b.retryBuild()
+		if err != nil {
+			b.Logger.Println(err)
+		}
+	}
 }
 
 // CreateBuild creates Build instance and all necessary files and folders in wakespace
 func CreateBuild(job *Job, jobPath string) (*Build, error) {
-	var counti int
+	var counti, number int
 	err := DB.Update(func(tx *bolt.Tx) error {
 		var err error
 		gb := tx.Bucket([]byte(GlobalBucket))
@@ -638,7 +1373,10 @@ func CreateBuild(job *Job, jobPath string) (*Build, error) {
 			counti++
 		}
 		gb.Put([]byte("count"), []byte(strconv.Itoa(counti)))
-		return nil
+		if job.JobBuildNumbers {
+			number, err = nextJobBuildNumber(tx, job.Name, counti)
+		}
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -647,7 +1385,9 @@ func CreateBuild(job *Job, jobPath string) (*Build, error) {
 	build := Build{
 		Job:            job,
 		ID:             counti,
-		abortedChannel: make(chan string),
+		JobBuildNumber: number,
+		abortedChannel: make(chan string, 1),
+		killChannel:    make(chan bool, 1),
 		flushChannel:   make(chan bool),
 		Params:         job.DefaultParams,
 		ETA:            GetJobETA(job.Name),
@@ -698,6 +1438,8 @@ func CreateBuild(job *Job, jobPath string) (*Build, error) {
 type ArtifactInfo struct {
 	Filename string `json:"filename"`
 	Size     int64  `json:"size"`
+	// The file is gzip-compressed, Filename has CompressedArtifactExt
+	Compressed bool `json:"compressed,omitempty"`
 }
 
 // Used to expand env variables in commands
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TaskNode is a main task of the build in the task dependency graph
+type TaskNode struct {
+	ID        int
+	Duration  time.Duration
+	DependsOn []int
+}
+
+// taskDependencies returns dependency graph for main tasks of the job. Tasks
+// with `depends_on` or in workflow stages depend on the same tasks as in
+// runTaskGraph. Otherwise main tasks are executed one after another, so every
+// task depends on the previous one. Tasks of the same group run concurrently,
+// so each of them depends on the step before the group. Tasks of jobs with
+// `parallel` are independent
+func taskDependencies(job *Job) map[int][]int {
+	deps := make(map[int][]int)
+	if job.hasTaskDependencies() {
+		tasks, dependencies := job.mainTaskDependencies()
+		for _, t := range tasks {
+			deps[t.ID] = []int{}
+			for _, dep := range dependencies[t] {
+				deps[t.ID] = append(deps[t.ID], dep.ID)
+			}
+		}
+		return deps
+	}
+	previous := []int{}
+	for _, step := range job.mainTaskSteps() {
+		ids := make([]int, 0, len(step))
+		for _, t := range step {
+			if job.Parallel > 1 {
+				deps[t.ID] = []int{}
+			} else {
+				deps[t.ID] = previous
+			}
+			ids = append(ids, t.ID)
+		}
+		previous = ids
+	}
+	return deps
+}
+
+// taskSchedule is the result of the critical path method: the earliest and the
+// latest start of every task when all tasks start as soon as possible
+type taskSchedule struct {
+	byID           map[int]*TaskNode
+	order          []int // Topological order
+	successors     map[int][]int
+	earliestStart  map[int]time.Duration
+	earliestFinish map[int]time.Duration
+	latestStart    map[int]time.Duration
+	total          time.Duration
+}
+
+// scheduleTasks runs forward and backward passes over the dependency graph
+func scheduleTasks(nodes []*TaskNode) (*taskSchedule, error) {
+	s := &taskSchedule{
+		byID:           make(map[int]*TaskNode, len(nodes)),
+		order:          make([]int, 0, len(nodes)),
+		successors:     make(map[int][]int),
+		earliestStart:  make(map[int]time.Duration),
+		earliestFinish: make(map[int]time.Duration),
+		latestStart:    make(map[int]time.Duration),
+	}
+	for _, n := range nodes {
+		s.byID[n.ID] = n
+	}
+
+	// Topological sort (Kahn's algorithm), preserving the original order of
+	// independent tasks
+	inDegree := make(map[int]int)
+	for _, n := range nodes {
+		inDegree[n.ID] += 0
+		for _, dep := range n.DependsOn {
+			if _, ok := s.byID[dep]; !ok {
+				continue
+			}
+			s.successors[dep] = append(s.successors[dep], n.ID)
+			inDegree[n.ID]++
+		}
+	}
+	for len(s.order) < len(nodes) {
+		found := false
+		for _, n := range nodes {
+			if inDegree[n.ID] == 0 {
+				inDegree[n.ID] = -1
+				s.order = append(s.order, n.ID)
+				for _, succ := range s.successors[n.ID] {
+					inDegree[succ]--
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("task dependency graph contains a cycle")
+		}
+	}
+
+	// Forward pass: the earliest start and finish of every task
+	for _, id := range s.order {
+		var start time.Duration
+		for _, dep := range s.byID[id].DependsOn {
+			if finish, ok := s.earliestFinish[dep]; ok && finish > start {
+				start = finish
+			}
+		}
+		s.earliestStart[id] = start
+		s.earliestFinish[id] = start + s.byID[id].Duration
+		if s.earliestFinish[id] > s.total {
+			s.total = s.earliestFinish[id]
+		}
+	}
+
+	// Backward pass: the latest start of every task
+	for i := len(s.order) - 1; i >= 0; i-- {
+		id := s.order[i]
+		s.latestStart[id] = s.latestFinish(id) - s.byID[id].Duration
+	}
+	return s, nil
+}
+
+// latestFinish is the latest start of the earliest successor or the end of
+// the build
+func (s *taskSchedule) latestFinish(id int) time.Duration {
+	finish := s.total
+	for _, succ := range s.successors[id] {
+		if s.latestStart[succ] < finish {
+			finish = s.latestStart[succ]
+		}
+	}
+	return finish
+}
+
+// totalSlack is how much the task can be delayed without delaying the build
+func (s *taskSchedule) totalSlack(id int) time.Duration {
+	return s.latestStart[id] - s.earliestStart[id]
+}
+
+// freeSlack is how much the task can be delayed without delaying any of its
+// successors
+func (s *taskSchedule) freeSlack(id int) time.Duration {
+	next := s.total
+	for _, succ := range s.successors[id] {
+		if s.earliestStart[succ] < next {
+			next = s.earliestStart[succ]
+		}
+	}
+	return next - s.earliestFinish[id]
+}
+
+// path returns IDs of the tasks on the critical path, walking back from the
+// task which finishes last
+func (s *taskSchedule) path() []int {
+	path := []int{}
+	current := -1
+	for _, id := range s.order {
+		if s.earliestFinish[id] == s.total {
+			current = id
+			break
+		}
+	}
+	for current >= 0 {
+		path = append([]int{current}, path...)
+		next := -1
+		for _, dep := range s.byID[current].DependsOn {
+			if finish, ok := s.earliestFinish[dep]; ok && finish == s.earliestStart[current] && s.totalSlack(dep) == 0 {
+				next = dep
+				break
+			}
+		}
+		current = next
+	}
+	return path
+}
+
+// CriticalPath returns IDs of the tasks on the longest path through the
+// dependency graph and slack of every task - how much the task could be
+// delayed without delaying the whole build
+func CriticalPath(nodes []*TaskNode) ([]int, map[int]time.Duration, error) {
+	s, err := scheduleTasks(nodes)
+	if err != nil {
+		return nil, nil, err
+	}
+	slack := make(map[int]time.Duration, len(nodes))
+	for _, id := range s.order {
+		slack[id] = s.totalSlack(id)
+	}
+	return s.path(), slack, nil
+}
+
+// CriticalPathTask is a task of the build analyzed with the critical path
+// method
+type CriticalPathTask struct {
+	TaskID           int    `json:"task_id"`
+	Name             string `json:"name"`
+	Duration         int64  `json:"duration_ms"`
+	IsOnCriticalPath bool   `json:"is_on_critical_path"`
+	FreeSlack        int64  `json:"free_slack_ms"`
+	TotalSlack       int64  `json:"total_slack_ms"`
+}
+
+// AnalyzeCriticalPath returns every task of the graph in the topological
+// order with its slack
+func AnalyzeCriticalPath(nodes []*TaskNode, names map[int]string) ([]*CriticalPathTask, error) {
+	s, err := scheduleTasks(nodes)
+	if err != nil {
+		return nil, err
+	}
+	onPath := make(map[int]bool)
+	for _, id := range s.path() {
+		onPath[id] = true
+	}
+	result := make([]*CriticalPathTask, 0, len(s.order))
+	for _, id := range s.order {
+		result = append(result, &CriticalPathTask{
+			TaskID:           id,
+			Name:             names[id],
+			Duration:         s.byID[id].Duration.Milliseconds(),
+			IsOnCriticalPath: onPath[id],
+			FreeSlack:        s.freeSlack(id).Milliseconds(),
+			TotalSlack:       s.totalSlack(id).Milliseconds(),
+		})
+	}
+	return result, nil
+}
+
+// ParallelismLevel returns the maximum number of tasks that were running at
+// the same time
+func ParallelismLevel(tasks []*TaskStatus) int {
+	level := 0
+	for _, t := range tasks {
+		if t.StartedAt.IsZero() {
+			continue
+		}
+		running := 0
+		for _, o := range tasks {
+			if o.StartedAt.IsZero() {
+				continue
+			}
+			if !o.StartedAt.After(t.StartedAt) && o.StartedAt.Add(o.Duration).After(t.StartedAt) {
+				running++
+			}
+		}
+		if running > level {
+			level = running
+		}
+	}
+	if level == 0 {
+		level = 1
+	}
+	return level
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCriticalPath_Chain(t *testing.T) {
+	nodes := []*TaskNode{
+		{ID: 0, Duration: 2 * time.Second, DependsOn: []int{}},
+		{ID: 1, Duration: 3 * time.Second, DependsOn: []int{0}},
+		{ID: 2, Duration: 1 * time.Second, DependsOn: []int{1}},
+	}
+	path, slack, err := CriticalPath(nodes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(path) != 3 || path[0] != 0 || path[1] != 1 || path[2] != 2 {
+		t.Errorf("Unexpected critical path: %v", path)
+		return
+	}
+	for id, s := range slack {
+		if s != 0 {
+			t.Errorf("Unexpected slack for task %d: %s", id, s)
+		}
+	}
+}
+
+func TestCriticalPath_Parallel(t *testing.T) {
+	nodes := []*TaskNode{
+		{ID: 0, Duration: 1 * time.Second, DependsOn: []int{}},
+		{ID: 1, Duration: 5 * time.Second, DependsOn: []int{0}},
+		{ID: 2, Duration: 2 * time.Second, DependsOn: []int{0}},
+		{ID: 3, Duration: 1 * time.Second, DependsOn: []int{1, 2}},
+	}
+	path, slack, err := CriticalPath(nodes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(path) != 3 || path[0] != 0 || path[1] != 1 || path[2] != 3 {
+		t.Errorf("Unexpected critical path: %v", path)
+		return
+	}
+	if slack[2] != 3*time.Second {
+		t.Errorf("Unexpected slack for task 2: %s", slack[2])
+		return
+	}
+}
+
+func TestCriticalPath_Cycle(t *testing.T) {
+	nodes := []*TaskNode{
+		{ID: 0, Duration: 1 * time.Second, DependsOn: []int{1}},
+		{ID: 1, Duration: 1 * time.Second, DependsOn: []int{0}},
+	}
+	_, _, err := CriticalPath(nodes)
+	if err == nil {
+		t.Errorf("Expected an error")
+		return
+	}
+}
+
+func TestParallelismLevel(t *testing.T) {
+	now := time.Now()
+	tasks := []*TaskStatus{
+		{ID: 0, StartedAt: now, Duration: 3 * time.Second},
+		{ID: 1, StartedAt: now.Add(1 * time.Second), Duration: 3 * time.Second},
+		{ID: 2, StartedAt: now.Add(5 * time.Second), Duration: 1 * time.Second},
+	}
+	level := ParallelismLevel(tasks)
+	if level != 2 {
+		t.Errorf("Expected %d, got %d", 2, level)
+		return
+	}
+}
+
+func TestAnalyzeCriticalPath(t *testing.T) {
+	nodes := []*TaskNode{
+		{ID: 0, Duration: 1 * time.Second, DependsOn: []int{}},
+		{ID: 1, Duration: 5 * time.Second, DependsOn: []int{0}},
+		{ID: 2, Duration: 1 * time.Second, DependsOn: []int{0}},
+		{ID: 3, Duration: 1 * time.Second, DependsOn: []int{2}},
+		{ID: 4, Duration: 1 * time.Second, DependsOn: []int{1, 3}},
+	}
+	result, err := AnalyzeCriticalPath(nodes, map[int]string{1: "compile"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []CriticalPathTask{
+		{TaskID: 0, Duration: 1000, IsOnCriticalPath: true},
+		{TaskID: 1, Name: "compile", Duration: 5000, IsOnCriticalPath: true},
+		// Delaying task 2 delays task 3, so it has no free slack
+		{TaskID: 2, Duration: 1000, TotalSlack: 3000},
+		{TaskID: 3, Duration: 1000, FreeSlack: 3000, TotalSlack: 3000},
+		{TaskID: 4, Duration: 1000, IsOnCriticalPath: true},
+	}
+	if len(result) != len(expected) {
+		t.Errorf("Expected %d tasks, got %d", len(expected), len(result))
+		return
+	}
+	for i, task := range result {
+		if *task != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *task)
+		}
+	}
+}
+
+func TestTaskDependencies_DependsOn(t *testing.T) {
+	// Diamond: checkout -> (build, lint) -> deploy
+	job := &Job{
+		Parallel: 2,
+		Tasks: []*Task{
+			{ID: 0, Name: "checkout", Kind: KindMain},
+			{ID: 1, Name: "build", Kind: KindMain, DependsOn: []string{"checkout"}},
+			{ID: 2, Name: "lint", Kind: KindMain, DependsOn: []string{"checkout"}},
+			{ID: 3, Name: "deploy", Kind: KindMain, DependsOn: []string{"build", "lint"}},
+			{ID: 4, Name: "notify", Kind: "finished"},
+		},
+	}
+	deps := taskDependencies(job)
+	expected := map[int][]int{0: {}, 1: {0}, 2: {0}, 3: {1, 2}}
+	if len(deps) != len(expected) {
+		t.Fatalf("Unexpected dependencies %v", deps)
+	}
+	for id, ids := range expected {
+		if len(deps[id]) != len(ids) {
+			t.Fatalf("Unexpected dependencies of task %d: %v", id, deps[id])
+		}
+		for idx := range ids {
+			if deps[id][idx] != ids[idx] {
+				t.Errorf("Unexpected dependencies of task %d: %v", id, deps[id])
+			}
+		}
+	}
+
+	durations := map[int]time.Duration{0: time.Second, 1: 4 * time.Second, 2: 2 * time.Second, 3: time.Second}
+	nodes := []*TaskNode{}
+	for id := 0; id < 4; id++ {
+		nodes = append(nodes, &TaskNode{ID: id, Duration: durations[id], DependsOn: deps[id]})
+	}
+	path, slack, err := CriticalPath(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 3 || path[0] != 0 || path[1] != 1 || path[2] != 3 {
+		t.Errorf("Unexpected critical path: %v", path)
+	}
+	if slack[2] != 2*time.Second {
+		t.Errorf("Unexpected slack for task lint: %s", slack[2])
+	}
+}
+
+func TestTaskDependencies_Groups(t *testing.T) {
+	// checkout -> (unit, e2e in group tests) -> deploy
+	job := &Job{
+		Tasks: []*Task{
+			{ID: 0, Name: "checkout", Kind: KindMain},
+			{ID: 1, Name: "unit", Kind: KindMain, Group: "tests"},
+			{ID: 2, Name: "e2e", Kind: KindMain, Group: "tests"},
+			{ID: 3, Name: "deploy", Kind: KindMain},
+			{ID: 4, Name: "notify", Kind: "finished"},
+		},
+	}
+	deps := taskDependencies(job)
+	expected := map[int][]int{0: {}, 1: {0}, 2: {0}, 3: {1, 2}}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Fatalf("Expected dependencies %v, got %v", expected, deps)
+	}
+
+	durations := map[int]time.Duration{0: time.Second, 1: 4 * time.Second, 2: 2 * time.Second, 3: time.Second}
+	nodes := []*TaskNode{}
+	for id := 0; id < 4; id++ {
+		nodes = append(nodes, &TaskNode{ID: id, Duration: durations[id], DependsOn: deps[id]})
+	}
+	path, slack, err := CriticalPath(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(path, []int{0, 1, 3}) {
+		t.Errorf("Unexpected critical path: %v", path)
+	}
+	if slack[2] != 2*time.Second {
+		t.Errorf("Unexpected slack for task e2e: %s", slack[2])
+	}
+
+	// Groups are ignored with `parallel`
+	job.Parallel = 2
+	for id, ids := range taskDependencies(job) {
+		if len(ids) != 0 {
+			t.Errorf("Expected independent task %d, got %v", id, ids)
+		}
+	}
+}
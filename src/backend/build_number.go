@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// JobBuildNumberKey is the key of the counter in a sub-bucket of
+// JobCountersBucket
+var JobBuildNumberKey = []byte("count")
+
+// nextJobBuildNumber increments the counter of the job and maps the new number
+// to the id of the build. Has to be called in the transaction which assigns
+// the id
+func nextJobBuildNumber(tx *bolt.Tx, jobName string, buildID int) (int, error) {
+	jb, err := tx.Bucket(JobCountersBucket).CreateBucketIfNotExists([]byte(jobName))
+	if err != nil {
+		return 0, err
+	}
+	number := 1
+	count := jb.Get(JobBuildNumberKey)
+	if count != nil {
+		number, err = ByteToInt(count)
+		if err != nil {
+			return 0, err
+		}
+		number++
+	}
+	err = jb.Put(JobBuildNumberKey, IntToByte(number))
+	if err != nil {
+		return 0, err
+	}
+	return number, jb.Put(Itob(number), Itob(buildID))
+}
+
+// removeJobBuildNumber removes the mapping of the build which is cleaned up.
+// The counter is kept, so numbers are never reused
+func removeJobBuildNumber(tx *bolt.Tx, data *BuildUpdateData) error {
+	if data.JobBuildNumber == 0 {
+		return nil
+	}
+	name := data.Name
+	if data.Template != "" {
+		name = data.Template
+	}
+	jb := tx.Bucket(JobCountersBucket).Bucket([]byte(name))
+	if jb == nil {
+		return nil
+	}
+	return jb.Delete(Itob(data.JobBuildNumber))
+}
+
+// GetBuildIDByJobBuildNumber returns the id of the build with the job scoped
+// number
+func GetBuildIDByJobBuildNumber(jobName string, number int) (int, error) {
+	var buildID int
+	err := DB.View(func(tx *bolt.Tx) error {
+		jb := tx.Bucket(JobCountersBucket).Bucket([]byte(jobName))
+		if jb == nil {
+			return fmt.Errorf("build %d of job %s not found", number, jobName)
+		}
+		v := jb.Get(Itob(number))
+		if v == nil {
+			return fmt.Errorf("build %d of job %s not found", number, jobName)
+		}
+		buildID = int(binary.BigEndian.Uint64(v))
+		return nil
+	})
+	return buildID, err
+}
+
+// HandleGetJobBuild resolves the job scoped build number to the build
+// @Summary      Return status of the build by its job scoped number
+// @Description  Builds are numbered per job if `job_build_numbers` is enabled
+// @Tags         job
+// @Produce      json
+// @Param        name     path    string    true  "Name of the job"
+// @Param        number   path    integer   true  "Build number in the job"
+// @Success      200      {object}   BuildUpdateData
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /job/{name}/builds/{number} [get]
+func HandleGetJobBuild(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	name := chi.URLParam(r, "name")
+	number, err := strconv.Atoi(chi.URLParam(r, "number"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	buildID, err := GetBuildIDByJobBuildNumber(name, number)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	data, err := getBuildStatusData(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
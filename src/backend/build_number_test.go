@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobBuildNumbers(t *testing.T) {
+	setupTestEnv(t)
+	numbered := &Job{
+		Name:            "release",
+		JobBuildNumbers: true,
+		Tasks:           []*Task{{Name: "main", Command: "true", Kind: KindMain}},
+	}
+	other := &Job{
+		Name:  "other",
+		Tasks: []*Task{{Name: "main", Command: "true", Kind: KindMain}},
+	}
+
+	first := createTestBuild(t, numbered)
+	unrelated := createTestBuild(t, other)
+	second := createTestBuild(t, numbered)
+	for _, build := range []*Build{first, unrelated, second} {
+		waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	}
+
+	if first.JobBuildNumber != 1 || second.JobBuildNumber != 2 || unrelated.JobBuildNumber != 0 {
+		t.Errorf("Unexpected numbers %d, %d, %d", first.JobBuildNumber, second.JobBuildNumber, unrelated.JobBuildNumber)
+	}
+	id, err := GetBuildIDByJobBuildNumber("release", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != second.ID {
+		t.Errorf("Expected build %d, got %d", second.ID, id)
+	}
+	_, err = GetBuildIDByJobBuildNumber("other", 1)
+	if err == nil {
+		t.Errorf("Builds of jobs without numbering shouldn't be found")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Priorities of builds in the queue. Queued builds with higher priority are
+// taken first, builds with the same priority are taken in FIFO order
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+var buildPriorityRanks = map[string]int{
+	PriorityLow:    -1,
+	PriorityNormal: 0,
+	PriorityHigh:   1,
+}
+
+// parseBuildPriority verifies the priority provided when the build is
+// triggered. Empty value means PriorityNormal
+func parseBuildPriority(value string) (string, error) {
+	if value == "" {
+		return PriorityNormal, nil
+	}
+	if _, ok := buildPriorityRanks[value]; !ok {
+		return "", fmt.Errorf("invalid priority %q: expected %s, %s or %s", value, PriorityLow, PriorityNormal, PriorityHigh)
+	}
+	return value, nil
+}
+
+// priorityRank returns rank of the build priority, builds without priority
+// have normal priority
+func (b *Build) priorityRank() int {
+	return buildPriorityRanks[b.Priority]
+}
+
+// takeOrder returns indexes of queued builds in the order they should be
+// inspected by Take. Must be called with the queue mutex held
+func (q *Queue) takeOrder() []int {
+	order := make([]int, len(q.queued))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return q.queued[order[i]].priorityRank() > q.queued[order[j]].priorityRank()
+	})
+	return order
+}
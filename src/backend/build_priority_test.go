@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseBuildPriority(t *testing.T) {
+	for value, expected := range map[string]string{"": PriorityNormal, "low": PriorityLow, "high": PriorityHigh} {
+		priority, err := parseBuildPriority(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if priority != expected {
+			t.Errorf("%q: expected %s, got %s", value, expected, priority)
+		}
+	}
+	if _, err := parseBuildPriority("urgent"); err == nil {
+		t.Error("Expected error for unknown priority")
+	}
+}
+
+func TestQueueTakeOrder(t *testing.T) {
+	q := &Queue{
+		queued: []*Build{
+			{ID: 1, Priority: PriorityLow},
+			{ID: 2, Priority: PriorityNormal},
+			{ID: 3, Priority: PriorityHigh},
+			{ID: 4},
+			{ID: 5, Priority: PriorityHigh},
+		},
+	}
+	expected := []int{3, 5, 2, 4, 1}
+	for i, id := range q.takeOrder() {
+		if q.queued[id].ID != expected[i] {
+			t.Fatalf("Expected build %d at position %d, got %d", expected[i], i, q.queued[id].ID)
+		}
+	}
+}
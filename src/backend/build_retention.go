@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MsgTypeBuildsRemoved is sent when builds are removed by the retention
+// policy. Data contains BuildRetentionSummary
+const MsgTypeBuildsRemoved = "builds:removed"
+
+// BuildRetention defines which completed builds of a job are kept. Builds
+// are removed if they match any of the limits, 0 disables a limit
+type BuildRetention struct {
+	// Builds completed more than this number of days ago are removed
+	Days int
+	// Number of the latest completed builds of the job which are kept
+	Count int
+}
+
+// verify validates the retention before saving
+func (r BuildRetention) verify() error {
+	if r.Days < 0 {
+		return fmt.Errorf("number of days to keep builds can't be negative: %d", r.Days)
+	}
+	if r.Count < 0 {
+		return fmt.Errorf("number of builds to keep can't be negative: %d", r.Count)
+	}
+	return nil
+}
+
+// expired returns the reason to remove the build, empty if the build is kept.
+// completed is the number of completed builds of the job which are not older
+// than the build. Builds with unknown completion time are not removed by age
+func (r BuildRetention) expired(completedAt time.Time, completed int) string {
+	if r.Count != 0 && completed > r.Count {
+		return fmt.Sprintf("not one of the latest %d builds", r.Count)
+	}
+	maxAge := time.Duration(r.Days) * 24 * time.Hour
+	if r.Days != 0 && !completedAt.IsZero() && time.Since(completedAt) > maxAge {
+		return fmt.Sprintf("completed more than %d days ago", r.Days)
+	}
+	return ""
+}
+
+// Used to verify the build retention before saving after editing
+func (j *Job) verifyBuildRetention() error {
+	return BuildRetention{Days: j.KeepBuildsDays, Count: j.KeepBuildsCount}.verify()
+}
+
+// getBuildRetention returns the retention of builds of the job. Values of the
+// job file take precedence over the server config. The server config is used
+// if the job file can't be read, e.g. the job was deleted
+func getBuildRetention(name string) BuildRetention {
+	retention := BuildRetention{Days: Config.KeepBuildsDays, Count: Config.KeepBuildsCount}
+	job, err := CreateJobFromFile(Config.JobDir + name + Config.jobsExt)
+	if err != nil {
+		return retention
+	}
+	if job.KeepBuildsDays != 0 {
+		retention.Days = job.KeepBuildsDays
+	}
+	if job.KeepBuildsCount != 0 {
+		retention.Count = job.KeepBuildsCount
+	}
+	return retention
+}
+
+// RemovedBuildData describes a build removed by the retention policy
+type RemovedBuildData struct {
+	ID     int    `json:"id"`
+	Job    string `json:"job"`
+	Reason string `json:"reason"`
+}
+
+// BuildRetentionSummary lists builds removed by the retention policy
+type BuildRetentionSummary struct {
+	// Builds are only listed, nothing is removed
+	DryRun bool                `json:"dry_run"`
+	Builds []*RemovedBuildData `json:"builds"`
+}
+
+// expiredBuilds returns completed builds which have to be removed according
+// to BuildRetention of their jobs, the latest first. Pending and running
+// builds are never removed
+func expiredBuilds(tx *bolt.Tx, logger *log.Logger) []*RemovedBuildData {
+	expired := []*RemovedBuildData{}
+	retentions := map[string]BuildRetention{}
+	// Number of completed builds of each job seen so far, the latest first
+	completed := map[string]int{}
+	c := tx.Bucket(HistoryBucket).Cursor()
+	for key, v := c.Last(); key != nil; key, v = c.Prev() {
+		var msg BuildUpdateData
+		err := json.Unmarshal(v, &msg)
+		if err != nil {
+			logger.Println(err)
+			continue
+		}
+		if !isTerminalStatus(msg.Status) {
+			continue
+		}
+		name := msg.JobName()
+		completed[name]++
+		retention, ok := retentions[name]
+		if !ok {
+			retention = getBuildRetention(name)
+			retentions[name] = retention
+		}
+		reason := retention.expired(buildCompletedAt(tx, &msg), completed[name])
+		if reason == "" {
+			continue
+		}
+		expired = append(expired, &RemovedBuildData{ID: msg.ID, Job: name, Reason: reason})
+	}
+	return expired
+}
+
+// ApplyBuildRetention removes workspaces, logs, artifacts and records of
+// builds according to BuildRetention. With dryRun the builds are only listed
+func (cl *Cleaner) ApplyBuildRetention(dryRun bool) (*BuildRetentionSummary, error) {
+	summary := &BuildRetentionSummary{DryRun: dryRun}
+	if dryRun {
+		err := DB.View(func(tx *bolt.Tx) error {
+			summary.Builds = expiredBuilds(tx, cl.Logger)
+			return nil
+		})
+		return summary, err
+	}
+	err := DB.Update(func(tx *bolt.Tx) error {
+		summary.Builds = expiredBuilds(tx, cl.Logger)
+		for _, build := range summary.Builds {
+			cl.Logger.Printf("Removing build %d of job %s, %s...\n", build.ID, build.Job, build.Reason)
+			var msg BuildUpdateData
+			err := json.Unmarshal(tx.Bucket(HistoryBucket).Get(Itob(build.ID)), &msg)
+			if err != nil {
+				return err
+			}
+			removeBuild(tx, build.ID, &msg, cl.Logger)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(summary.Builds) != 0 {
+		cl.Logger.Printf("%d builds were removed by the retention policy\n", len(summary.Builds))
+		WSHub.broadcast <- &MsgBroadcast{
+			Type: MsgTypeBuildsRemoved,
+			Data: summary,
+		}
+	}
+	return summary, nil
+}
+
+// HandleApplyBuildRetention removes builds according to the retention policy
+// @Summary      Remove old builds
+// @Description  Removes workspaces, logs, artifacts and records of completed builds according to `keepbuildsdays` and `keepbuildscount` of the server config or `keep_builds_days` and `keep_builds_count` of the job. With `dry_run` the builds are only listed, so the policy can be verified before enabling it
+// @Tags         admin
+// @Produce      json
+// @Param        dry_run  query   boolean   false  "List builds without removing them"
+// @Success      200      {object}   BuildRetentionSummary
+// @Failure      400      {string}   http.StatusBadRequest
+// @Failure      500      {string}   string
+// @Router       /admin/retention [post]
+func HandleApplyBuildRetention(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+	writeError := func(status int, err error) {
+		logger.Println(err)
+		w.WriteHeader(status)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(http.StatusBadRequest, fmt.Errorf("invalid dry_run: %q", value))
+			return
+		}
+	}
+	cl := Cleaner{Logger: logger}
+	summary, err := cl.ApplyBuildRetention(dryRun)
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+	payloadB, err := json.Marshal(summary)
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
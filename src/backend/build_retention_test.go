@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestApplyBuildRetention(t *testing.T) {
+	setupTestEnv(t)
+	Config.KeepBuildsDays = 30
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	// Job b keeps builds longer, job c keeps only the latest build
+	for name, content := range map[string]string{
+		"b": "desc: b\nkeep_builds_days: 100\n",
+		"c": "desc: c\nkeep_builds_count: 1\n",
+	} {
+		err := os.WriteFile(Config.JobDir+name+Config.jobsExt, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	putTestBuildWithArtifact(t, 1, "a", old)
+	putTestBuildWithArtifact(t, 2, "a", time.Now())
+	putTestBuildWithArtifact(t, 3, "b", old)
+	putTestBuildWithArtifact(t, 4, "a", old)
+	putTestBuildWithArtifact(t, 5, "c", time.Now())
+	putTestBuildWithArtifact(t, 6, "c", time.Now())
+	// Build 4 is still running
+	err := DB.Update(func(tx *bolt.Tx) error {
+		dataB, err := json.Marshal(&BuildUpdateData{ID: 4, Name: "a", Status: StatusRunning, StartedAt: old})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(HistoryBucket).Put(Itob(4), dataB)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(query string) (*httptest.ResponseRecorder, *BuildRetentionSummary) {
+		w := httptest.NewRecorder()
+		HandleApplyBuildRetention(w, httptest.NewRequest(http.MethodPost, "/api/admin/retention"+query, nil))
+		var summary BuildRetentionSummary
+		if w.Code == http.StatusOK {
+			err := json.Unmarshal(w.Body.Bytes(), &summary)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, &summary
+	}
+	ids := func(summary *BuildRetentionSummary) []int {
+		ids := []int{}
+		for _, build := range summary.Builds {
+			ids = append(ids, build.ID)
+		}
+		return ids
+	}
+	exists := func(id int) bool {
+		found := false
+		DB.View(func(tx *bolt.Tx) error {
+			found = tx.Bucket(HistoryBucket).Get(Itob(id)) != nil
+			return nil
+		})
+		_, err := os.Stat(Config.WorkDir + "wakespace/" + strconv.Itoa(id))
+		return found && err == nil
+	}
+
+	w, summary := post("?dry_run=true")
+	if w.Code != http.StatusOK || !summary.DryRun || len(summary.Builds) != 2 || ids(summary)[0] != 5 || ids(summary)[1] != 1 {
+		t.Fatalf("Expected builds 5 and 1 to be listed, got %d %v", w.Code, ids(summary))
+	}
+	for id := 1; id <= 6; id++ {
+		if id != 4 && !exists(id) {
+			t.Errorf("Build %d was removed by the dry run", id)
+		}
+	}
+
+	w, summary = post("")
+	if w.Code != http.StatusOK || summary.DryRun || len(summary.Builds) != 2 {
+		t.Fatalf("Expected builds 5 and 1 to be removed, got %d %v", w.Code, ids(summary))
+	}
+	for id, removed := range map[int]bool{1: true, 2: false, 3: false, 5: true, 6: false} {
+		if exists(id) == removed {
+			t.Errorf("Build %d: expected removed %t", id, removed)
+		}
+	}
+	DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(HistoryBucket).Get(Itob(4)) == nil {
+			t.Error("Running build was removed")
+		}
+		return nil
+	})
+
+	if w, _ := post("?dry_run=maybe"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid dry_run, got %d", w.Code)
+	}
+}
+
+func TestApplyBuildRetention_AbortedWhilePending(t *testing.T) {
+	setupTestEnv(t)
+	Config.KeepBuildsDays = 30
+	putTestJob(t, "a", "tasks:\n  - run: \"true\"\n")
+	err := GlobalQueue.SetPaused(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := RunJob("a", nil, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GlobalQueue.Abort(build.ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+	var data *BuildUpdateData
+	waitFor(t, 5*time.Second, "the build is recorded as aborted", func() bool {
+		data, err = getBuildStatusData(build.ID)
+		return err == nil && data.Status == StatusAborted
+	})
+	if !data.StartedAt.IsZero() {
+		t.Fatalf("Expected the build not to be started, got %s", data.StartedAt)
+	}
+	// Saved before transitions were tracked
+	err = DB.Update(func(tx *bolt.Tx) error {
+		dataB, err := json.Marshal(&BuildUpdateData{ID: 100, Name: "a", Status: StatusAborted})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(HistoryBucket).Put(Itob(100), dataB)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	HandleApplyBuildRetention(w, httptest.NewRequest(http.MethodPost, "/api/admin/retention?dry_run=true", nil))
+	var summary BuildRetentionSummary
+	err = json.Unmarshal(w.Body.Bytes(), &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(summary.Builds) != 0 {
+		t.Errorf("Expected builds aborted while pending to be kept, got %d %+v", w.Code, summary.Builds)
+	}
+}
+
+func TestVerifyBuildRetention(t *testing.T) {
+	for _, job := range []*Job{{KeepBuildsDays: -1}, {KeepBuildsCount: -5}} {
+		if job.verifyBuildRetention() == nil {
+			t.Errorf("Expected an error for %+v", job)
+		}
+	}
+	if err := (&Job{KeepBuildsDays: 7, KeepBuildsCount: 10}).verifyBuildRetention(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+)
+
+// retryBuild creates a new build of the job with the same params if the
+// failed or aborted build has attempts left, see Job.Retry and
+// Job.AutoRetryInfraFailures. The job is read from the file again, so changes
+// of the job apply to the next attempt
+func (b *Build) retryBuild() (*Build, error) {
+	infra := b.shouldRetryInfraFailure()
+	if !infra && b.RetryAttempt >= b.Job.Retry {
+		return nil, nil
+	}
+	if GlobalQueue.isDraining() {
+		return nil, fmt.Errorf("build %d is not retried: %w", b.ID, ErrServerShuttingDown)
+	}
+	jobFile := Config.JobDir + b.Job.Name + Config.jobsExt
+	job, err := CreateJobFromFile(jobFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retry build %d: %w", b.ID, err)
+	}
+
+	build, err := CreateBuild(job, jobFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retry build %d: %w", b.ID, err)
+	}
+	b.mutex.Lock()
+	params := make([]map[string]string, len(b.Params))
+	for idx := range b.Params {
+		params[idx] = make(map[string]string, len(b.Params[idx]))
+		for pkey, pval := range b.Params[idx] {
+			params[idx][pkey] = pval
+		}
+	}
+	trigger := &TriggerInfo{Kind: TriggerRetry}
+	if b.Trigger != nil {
+		trigger.Preset = b.Trigger.Preset
+	}
+	build.Params = params
+	build.Trigger = trigger
+	build.Labels = b.Labels
+	build.InstanceName = b.InstanceName
+	build.MatrixBuildID = b.MatrixBuildID
+	build.Matrix = b.Matrix
+	build.Priority = b.Priority
+	build.RetryOf = b.ID
+	build.RetryAttempt = b.RetryAttempt
+	build.InfraRetryAttempt = b.InfraRetryAttempt
+	if infra {
+		build.InfraRetryAttempt++
+	} else {
+		build.RetryAttempt++
+	}
+	b.mutex.Unlock()
+	if infra {
+		build.Logger.Printf(
+			"Retry %d/%d of build %d after infrastructure failure\n",
+			build.InfraRetryAttempt, job.autoRetryInfraFailures(), b.ID,
+		)
+	} else {
+		build.Logger.Printf("Retry %d/%d of build %d\n", build.RetryAttempt, job.Retry, b.ID)
+	}
+
+	GlobalQueue.Add(build)
+	GlobalQueue.Take()
+	build.BroadcastUpdate()
+	return build, nil
+}
+
+// Used to verify the number of build retries before saving after editing
+func (j *Job) verifyRetry() error {
+	if j.Retry < 0 {
+		return fmt.Errorf("retry can't be negative: %d", j.Retry)
+	}
+	if j.AutoRetryInfraFailures < 0 {
+		return fmt.Errorf("auto_retry_infra_failures can't be negative: %d", j.AutoRetryInfraFailures)
+	}
+	return nil
+}
@@ -0,0 +1,1218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var testHubOnce sync.Once
+
+// setupTestEnv creates configuration, database and queue in a temporary
+// directory
+func setupTestEnv(t *testing.T) {
+	// Some tests set up the environment several times
+	if GlobalQueue != nil {
+		stopTestBuilds(t, GlobalQueue)
+	}
+	Logger = log.New(io.Discard, "", 0)
+	testHubOnce.Do(func() {
+		WSHub = newHub()
+		go WSHub.run()
+	})
+
+	dir := t.TempDir()
+	Config = &WakeConfig{
+		WorkDir: dir + "/",
+		JobDir:  dir + "/",
+		jobsExt: ".yaml",
+	}
+
+	var err error
+	DB, err = bolt.Open(dir+"/wakeci.db", 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		DB.Close()
+	})
+	err = DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{JobsBucket, GlobalBucket, HistoryBucket, UsageBucket, SecretsBucket, LogIndexBucket, JobCountersBucket, ParamSuggestionsBucket, MaintenanceBucket, TransitionsBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{LogIndexTermsKey, LogIndexBuildsKey} {
+			_, err := tx.Bucket(LogIndexBucket).CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(GlobalBucket).Put([]byte("concurrentBuilds"), IntToByte(2))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	GlobalQueue, err = CreateQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cleanups run in reverse order, so builds are stopped before DB.Close
+	queue := GlobalQueue
+	t.Cleanup(func() {
+		stopTestBuilds(t, queue)
+	})
+}
+
+// stopTestBuilds aborts builds of the queue and waits until they are
+// completed, so they don't use config and database of the next test
+func stopTestBuilds(t *testing.T, queue *Queue) {
+	queue.mutex.Lock()
+	ids := []int{}
+	for _, b := range append(queue.running, queue.queued...) {
+		ids = append(ids, b.ID)
+	}
+	queue.mutex.Unlock()
+	for _, id := range ids {
+		queue.Abort(id, StatusAborted)
+	}
+	if !queue.waitIdle(2 * ABORT_TIMEOUT * time.Second) {
+		t.Error("Builds haven't completed after the test")
+	}
+}
+
+// createTestBuild creates a build from the job and puts it in the queue
+func createTestBuild(t *testing.T, job *Job) *Build {
+	for i, task := range job.Tasks {
+		task.ID = i
+		task.Status = StatusPending
+	}
+	build, err := CreateBuild(job, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	build.Logger = log.New(io.Discard, "", 0)
+	GlobalQueue.Add(build)
+	GlobalQueue.Take()
+	return build
+}
+
+// waitFor waits until condition is true
+func waitFor(t *testing.T, timeout time.Duration, msg string, condition func() bool) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for: %s", msg)
+}
+
+// waitForTerminalState waits until the build is removed from the queue and its
+// final status is saved in the history
+func waitForTerminalState(t *testing.T, build *Build, timeout time.Duration, expected ItemStatus) {
+	waitFor(t, timeout, "queue slot is released", func() bool {
+		return !GlobalQueue.Verify(build.ID)
+	})
+	waitFor(t, timeout, "status is saved", func() bool {
+		data, err := getBuildStatusData(build.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data.Status == expected
+	})
+}
+
+func TestAbort_DuringOnAbortedTask(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "abort_during_on_aborted",
+		Tasks: []*Task{
+			{Name: "main", Command: "sleep 30", Kind: KindMain},
+			{Name: "notify", Command: "sleep 30", Kind: StatusAborted},
+			{Name: "cleanup", Command: "true", Kind: FinalTask},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitFor(t, 5*time.Second, "main task is running", func() bool {
+		return build.getTaskStatus(job.Tasks[0]) == StatusRunning
+	})
+	err := GlobalQueue.Abort(build.ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, 5*time.Second, "on_aborted task is running", func() bool {
+		return build.getTaskStatus(job.Tasks[1]) == StatusRunning
+	})
+	started := time.Now()
+	err = GlobalQueue.Abort(build.ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+	if time.Since(started) > ABORT_TIMEOUT*time.Second {
+		t.Errorf("on_aborted task wasn't killed immediately, took %s", time.Since(started))
+	}
+	if status := build.getTaskStatus(job.Tasks[1]); status != StatusAborted {
+		t.Errorf("Expected on_aborted task status %q, got %q", StatusAborted, status)
+	}
+	if status := build.getTaskStatus(job.Tasks[2]); status != StatusSkipped {
+		t.Errorf("Expected finally task status %q, got %q", StatusSkipped, status)
+	}
+	tasks := build.GetTasksStatus()
+	if tasks[0].ForceKilled || tasks[0].Stop == nil {
+		t.Errorf("Expected main task to exit on SIGTERM, got %+v", tasks[0].Stop)
+	}
+	if !tasks[1].ForceKilled {
+		t.Errorf("Expected on_aborted task to be force killed")
+	}
+}
+
+func TestAbort_Double(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "double_abort",
+		Tasks: []*Task{
+			// Ignores SIGTERM, so the first abort request waits ABORT_TIMEOUT
+			{Name: "main", Command: "trap '' TERM; sleep 30", Kind: KindMain},
+			{Name: "notify", Command: "true", Kind: StatusAborted},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitFor(t, 5*time.Second, "main task is running", func() bool {
+		return build.getTaskStatus(job.Tasks[0]) == StatusRunning
+	})
+	// Give bash time to install the trap
+	time.Sleep(200 * time.Millisecond)
+	started := time.Now()
+	for i := 0; i < 2; i++ {
+		err := GlobalQueue.Abort(build.ID, StatusAborted)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+	if time.Since(started) > ABORT_TIMEOUT*time.Second {
+		t.Errorf("Second abort request didn't kill the task immediately, took %s", time.Since(started))
+	}
+	if status := build.getTaskStatus(job.Tasks[1]); status != StatusFinished {
+		t.Errorf("Expected on_aborted task status %q, got %q", StatusFinished, status)
+	}
+	tasks := build.GetTasksStatus()
+	if !tasks[0].ForceKilled || tasks[0].Stop == nil || tasks[0].Stop.Signal != "SIGTERM" {
+		t.Errorf("Expected main task to be force killed after SIGTERM, got %+v", tasks[0].Stop)
+	}
+}
+
+func TestAbort_OnStatusTaskTimeout(t *testing.T) {
+	setupTestEnv(t)
+	defer func(d time.Duration) {
+		OnStatusTaskTimeout = d
+	}(OnStatusTaskTimeout)
+	OnStatusTaskTimeout = 500 * time.Millisecond
+
+	job := &Job{
+		Name: "on_status_timeout",
+		Tasks: []*Task{
+			{Name: "main", Command: "true", Kind: KindMain},
+			{Name: "notify", Command: "sleep 30", Kind: StatusFinished},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	if job.Tasks[1].Status != StatusTimedOut {
+		t.Errorf("Expected on_finished task status %q, got %q", StatusTimedOut, job.Tasks[1].Status)
+	}
+}
+
+func TestWarningAnnotations(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "warnings",
+		Tasks: []*Task{
+			{Name: "main", Command: "echo '##wake:warning deprecated'; echo '##wake:warning deprecated'; echo '##wake:warning skipped step'", Kind: KindMain},
+			{Name: "notify", Command: "[[ \"$WAKE_BUILD_WARNINGS\" == *'skipped step'* ]]", Kind: StatusFinished},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"deprecated", "skipped step"}
+	if len(data.Warnings) != len(expected) || data.Warnings[0] != expected[0] || data.Warnings[1] != expected[1] {
+		t.Errorf("Expected warnings %v, got %v", expected, data.Warnings)
+	}
+	if job.Tasks[1].Status != StatusFinished {
+		t.Errorf("Expected on_finished task status %q, got %q", StatusFinished, job.Tasks[1].Status)
+	}
+}
+
+func TestExportParams(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:          "export_params",
+		DefaultParams: []map[string]string{{"TARGET": "dev"}},
+		Tasks: []*Task{
+			{Name: "version", Command: "echo 'WAKE_EXPORT VERSION=1.2.3'; echo 'WAKE_EXPORT TARGET=prod'; echo 'WAKE_EXPORT 1INVALID=x'", Kind: KindMain},
+			{Name: "tag", Command: "[ \"$VERSION/$TARGET\" = 1.2.3/prod ]", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]string{{"TARGET": "prod"}, {"VERSION": "1.2.3"}}
+	if !reflect.DeepEqual(data.Params, expected) {
+		t.Errorf("Expected params %v, got %v", expected, data.Params)
+	}
+}
+
+func TestLongLineTruncated(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "long_line",
+		Tasks: []*Task{
+			{
+				Name:           "main",
+				Command:        "head -c 3000000 /dev/zero | tr '\\0' a; echo; echo after",
+				Kind:           KindMain,
+				LineBufferSize: "64KB",
+			},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 10*time.Second, StatusFinished)
+	data, err := os.ReadFile(build.GetWakespaceDir() + job.Tasks[0].LogFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var truncated string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "aaaa") {
+			truncated = line
+		}
+	}
+	marker := fmt.Sprintf(LineTruncatedMarker, 3000000-64*1024)
+	if !strings.HasSuffix(truncated, strings.Repeat("a", 10)+marker) {
+		t.Errorf("Expected truncated line with marker %q", marker)
+	}
+	if strings.Count(truncated, "a") != 64*1024+strings.Count(marker, "a") {
+		t.Errorf("Expected %d bytes of the line, got %d", 64*1024, strings.Count(truncated, "a"))
+	}
+	if !strings.Contains(string(data), "] after\n") {
+		t.Errorf("Output after the long line is lost")
+	}
+}
+
+func TestLogOutputSanitized(t *testing.T) {
+	setupTestEnv(t)
+	command := "printf 'crlf\\r\\nbinary \\xff\\n'"
+	job := &Job{
+		Name: "log_output",
+		Tasks: []*Task{
+			{Name: "sanitized", Command: command, Kind: KindMain},
+			{Name: "raw", Command: command, Kind: KindMain, LogOutput: LogOutputRaw},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	expected := [][]string{
+		{"] crlf\n", "] binary \\xff\n"},
+		{"] crlf\r\n", "] binary \xff\n"},
+	}
+	for i, task := range job.Tasks {
+		data, err := os.ReadFile(build.GetWakespaceDir() + task.LogFileName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range expected[i] {
+			if !strings.Contains(string(data), line) {
+				t.Errorf("Expected %q in the log of task %s, got %q", line, task.Name, data)
+			}
+		}
+	}
+}
+
+// readBackgroundPID returns the pid of the background process started by a task
+func readBackgroundPID(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+// isProcessRunning reports whether the process exists and isn't a zombie
+func isProcessRunning(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return fields[0] != "Z"
+}
+
+func TestReapChildren(t *testing.T) {
+	for _, mode := range []string{ReapChildrenWarn, ReapChildrenKill, ReapChildrenIgnore} {
+		t.Run(mode, func(t *testing.T) {
+			setupTestEnv(t)
+			job := &Job{
+				Name: "reap_children_" + mode,
+				Tasks: []*Task{
+					{
+						Name:         "daemon",
+						Command:      "sleep 30 >/dev/null 2>&1 & echo $! > daemon.pid",
+						Kind:         KindMain,
+						ReapChildren: mode,
+					},
+				},
+			}
+			build := createTestBuild(t, job)
+
+			waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+			pid := readBackgroundPID(t, build.GetWorkspaceDir()+"daemon.pid")
+			t.Cleanup(func() {
+				syscall.Kill(pid, syscall.SIGKILL)
+			})
+			data, err := getBuildStatusData(build.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reported := len(data.Warnings) == 1 && strings.Contains(data.Warnings[0], strconv.Itoa(pid)+" (")
+			if reported != (mode == ReapChildrenWarn) {
+				t.Errorf("Unexpected warnings %v", data.Warnings)
+			}
+			if isProcessRunning(pid) != (mode != ReapChildrenKill) {
+				t.Errorf("Unexpected state of the background process, running: %v", isProcessRunning(pid))
+			}
+		})
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "task_timeout",
+		Tasks: []*Task{
+			{Name: "fetch", Command: "sleep 30", Kind: KindMain, Timeout: "200ms"},
+			{Name: "notify", Command: "true", Kind: StatusFailed},
+		},
+	}
+	build := createTestBuild(t, job)
+	started := time.Now()
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	if time.Since(started) > ABORT_TIMEOUT*time.Second {
+		t.Errorf("Task wasn't stopped on timeout, took %s", time.Since(started))
+	}
+	if job.Tasks[0].Status != StatusFailed {
+		t.Errorf("Expected task status %q, got %q", StatusFailed, job.Tasks[0].Status)
+	}
+	if job.Tasks[1].Status != StatusFinished {
+		t.Errorf("Expected on_failed task status %q, got %q", StatusFinished, job.Tasks[1].Status)
+	}
+}
+
+func TestResourceReservations(t *testing.T) {
+	setupTestEnv(t)
+	Config.Capacity = &Resources{CPU: 3, Memory: "4GB"}
+	newJob := func(name string, command string) *Job {
+		return &Job{
+			Name:      name,
+			Resources: &Resources{CPU: 2, Memory: "1GB"},
+			Tasks:     []*Task{{Name: "main", Command: command, Kind: KindMain}},
+		}
+	}
+	first := createTestBuild(t, newJob("first", "sleep 0.5"))
+	second := createTestBuild(t, newJob("second", "true"))
+
+	waitFor(t, 5*time.Second, "the second build waits for resources", func() bool {
+		return second.GenerateBuildUpdateData().PendingReason != ""
+	})
+	status := GlobalQueue.Status()
+	if status.Running != 1 || status.Queued != 1 || status.Reserved.CPU != 2 || status.Reserved.Memory != 1<<30 {
+		t.Errorf("Unexpected queue status %+v", status)
+	}
+
+	waitForTerminalState(t, first, 5*time.Second, StatusFinished)
+	waitForTerminalState(t, second, 5*time.Second, StatusFinished)
+	if second.StartedAt.Before(first.StartedAt.Add(first.Duration)) {
+		t.Errorf("The second build started before the first one released resources")
+	}
+}
+
+func TestParallelTasks(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:     "parallel",
+		Parallel: 3,
+		Tasks: []*Task{
+			{Name: "lint", Command: "sleep 0.5", Kind: KindMain},
+			{Name: "test", Command: "sleep 0.5", Kind: KindMain},
+			{Name: "build", Command: "sleep 0.5", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+	started := time.Now()
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	if time.Since(started) > 1200*time.Millisecond {
+		t.Errorf("Tasks weren't executed in parallel, took %s", time.Since(started))
+	}
+}
+
+func TestParallelTasks_Failed(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:     "parallel_failed",
+		Parallel: 2,
+		Tasks: []*Task{
+			{Name: "fail", Command: "false", Kind: KindMain},
+			{Name: "slow", Command: "sleep 0.5", Kind: KindMain},
+			{Name: "next", Command: "true", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	expected := []ItemStatus{StatusFailed, StatusFinished, StatusPending}
+	for i, task := range build.GenerateBuildUpdateData().Tasks {
+		if task.Status != expected[i] {
+			t.Errorf("Expected task %d status %q, got %q", i, expected[i], task.Status)
+		}
+	}
+}
+
+func TestTaskGroups(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "task_groups",
+		Tasks: []*Task{
+			{Name: "lint", Command: "sleep 0.5", Kind: KindMain, Group: "checks"},
+			{Name: "test", Command: "sleep 0.5", Kind: KindMain, Group: "checks"},
+			{Name: "fail", Command: "false", Kind: KindMain, Group: "deploy"},
+			{Name: "slow", Command: "sleep 0.2", Kind: KindMain, Group: "deploy"},
+			{Name: "next", Command: "true", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+	started := time.Now()
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	if time.Since(started) > 1200*time.Millisecond {
+		t.Errorf("Tasks of the group weren't executed concurrently, took %s", time.Since(started))
+	}
+	expected := []ItemStatus{StatusFinished, StatusFinished, StatusFailed, StatusFinished, StatusPending}
+	for i, task := range build.GenerateBuildUpdateData().Tasks {
+		if task.Status != expected[i] {
+			t.Errorf("Expected task %d status %q, got %q", i, expected[i], task.Status)
+		}
+	}
+
+	job.Tasks = append(job.Tasks, &Task{Name: "lint again", Command: "true", Kind: KindMain, Group: "checks"})
+	if job.verifyTaskGroups() == nil {
+		t.Error("Expected error for tasks of the group which don't follow each other")
+	}
+}
+
+func TestWhenCondition(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:          "when_condition",
+		DefaultParams: []map[string]string{{"BRANCH": "dev"}},
+		Tasks: []*Task{
+			{Name: "deploy", Command: "true", Kind: KindMain, When: `BRANCH == "main"`},
+			{Name: "test", Command: "true", Kind: KindMain, When: `$BRANCH != main`},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	tasks := build.GenerateBuildUpdateData().Tasks
+	if tasks[0].Status != StatusSkipped || tasks[0].Duration != 0 {
+		t.Errorf("Expected skipped task with zero duration, got %q %s", tasks[0].Status, tasks[0].Duration)
+	}
+	if tasks[1].Status != StatusFinished {
+		t.Errorf("Expected finished task, got %q", tasks[1].Status)
+	}
+}
+
+func TestTaskDependencies(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "task_dependencies",
+		Tasks: []*Task{
+			{Name: "build", Command: "sleep 0.3", Kind: KindMain},
+			{Name: "unit", Command: "sleep 0.5", Kind: KindMain, DependsOn: []string{"build"}},
+			{Name: "e2e", Command: "sleep 0.5", Kind: KindMain, DependsOn: []string{"build"}},
+			{Name: "lint", Command: "false", Kind: KindMain},
+			{Name: "deploy", Command: "true", Kind: KindMain, DependsOn: []string{"unit", "e2e", "lint"}},
+			{Name: "notify", Command: "true", Kind: KindMain, DependsOn: []string{"deploy"}},
+		},
+	}
+	if err := job.verifyTaskDependencies(); err != nil {
+		t.Fatal(err)
+	}
+	build := createTestBuild(t, job)
+	started := time.Now()
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	if time.Since(started) > 1500*time.Millisecond {
+		t.Errorf("Independent tasks weren't executed concurrently, took %s", time.Since(started))
+	}
+	expected := []ItemStatus{
+		StatusFinished, StatusFinished, StatusFinished, StatusFailed, StatusSkipped, StatusSkipped,
+	}
+	for i, task := range build.GenerateBuildUpdateData().Tasks {
+		if task.Status != expected[i] {
+			t.Errorf("Expected task %d status %q, got %q", i, expected[i], task.Status)
+		}
+	}
+
+	job.Tasks[0].DependsOn = []string{"notify"}
+	err := job.verifyTaskDependencies()
+	if err == nil || !strings.Contains(err.Error(), "build -> notify -> deploy -> unit -> build") {
+		t.Errorf("Expected error for the dependency cycle, got %v", err)
+	}
+	job.Tasks[0].DependsOn = []string{"package"}
+	if job.verifyTaskDependencies() == nil {
+		t.Error("Expected error for the dependency on unknown task")
+	}
+}
+
+func TestWorkflowStages(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:     "workflow_stages",
+		Parallel: 2,
+		Tasks: []*Task{
+			{Name: "compile", Command: "sleep 0.5 && touch compiled", Kind: KindMain},
+			{Name: "lint", Command: "sleep 0.5 && touch linted", Kind: KindMain},
+			{Name: "unit", Command: "[ -f compiled ] && [ -f linted ]", Kind: KindMain},
+			{Name: "e2e", Command: "false", Kind: KindMain},
+			{Name: "deploy", Command: "true", Kind: KindMain},
+		},
+		Workflow: []*WorkflowStage{
+			{Name: "build", Tasks: []int{0, 1}},
+			{Name: "test", Tasks: []int{2, 3}},
+			{Name: "release", Tasks: []int{4}},
+		},
+	}
+	if err := job.verifyWorkflow(); err != nil {
+		t.Fatal(err)
+	}
+	build := createTestBuild(t, job)
+	started := time.Now()
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	if time.Since(started) > 900*time.Millisecond {
+		t.Errorf("Tasks of the stage weren't executed concurrently, took %s", time.Since(started))
+	}
+	// The next stage starts after all tasks of the previous one
+	expected := []ItemStatus{StatusFinished, StatusFinished, StatusFinished, StatusFailed, StatusSkipped}
+	for i, task := range build.GenerateBuildUpdateData().Tasks {
+		if task.Status != expected[i] {
+			t.Errorf("Expected task %d status %q, got %q", i, expected[i], task.Status)
+		}
+	}
+}
+
+func TestVerifyWorkflow(t *testing.T) {
+	tasks := []*Task{
+		{ID: 0, Name: "build", Kind: KindMain},
+		{ID: 1, Name: "test", Kind: KindMain},
+		{ID: 2, Name: "notify", Kind: StatusFinished},
+	}
+	for _, workflow := range [][]*WorkflowStage{
+		{{Name: "build", Tasks: []int{0}}, {Name: "test", Tasks: []int{3}}},
+		{{Name: "build", Tasks: []int{0}}, {Name: "notify", Tasks: []int{2}}},
+		{{Name: "build", Tasks: []int{0, 1}}, {Name: "test", Tasks: []int{1}}},
+		{{Name: "build", Tasks: []int{0, 0}}},
+	} {
+		if (&Job{Tasks: tasks, Workflow: workflow}).verifyWorkflow() == nil {
+			t.Errorf("Expected error for stages %+v %+v", workflow[0], workflow[len(workflow)-1])
+		}
+	}
+	grouped := []*Task{{ID: 0, Name: "build", Kind: KindMain, Group: "checks"}}
+	if (&Job{Tasks: grouped, Workflow: []*WorkflowStage{{Name: "build", Tasks: []int{0}}}}).verifyWorkflow() == nil {
+		t.Error("Expected error for workflow with groups")
+	}
+	if err := (&Job{Tasks: tasks, Workflow: []*WorkflowStage{{Name: "build", Tasks: []int{0}}, {Name: "test", Tasks: []int{1}}}}).verifyWorkflow(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParallelTasks_Abort(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:     "parallel_abort",
+		Parallel: 2,
+		Tasks: []*Task{
+			{Name: "first", Command: "sleep 30", Kind: KindMain},
+			{Name: "second", Command: "sleep 30", Kind: KindMain},
+			{Name: "third", Command: "true", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitFor(t, 5*time.Second, "tasks are running", func() bool {
+		tasks := build.GenerateBuildUpdateData().Tasks
+		return tasks[0].Status == StatusRunning && tasks[1].Status == StatusRunning
+	})
+	started := time.Now()
+	err := GlobalQueue.Abort(build.ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+	if time.Since(started) > ABORT_TIMEOUT*time.Second {
+		t.Errorf("Running tasks weren't stopped, took %s", time.Since(started))
+	}
+	expected := []ItemStatus{StatusAborted, StatusAborted, StatusPending}
+	for i, task := range build.GenerateBuildUpdateData().Tasks {
+		if task.Status != expected[i] {
+			t.Errorf("Expected task %d status %q, got %q", i, expected[i], task.Status)
+		}
+	}
+}
+
+func TestResolvedCommand(t *testing.T) {
+	setupTestEnv(t)
+	Config.secrets = map[string]string{"TOKEN": "s3cr3t"}
+	job := &Job{
+		Name:          "resolved_command",
+		DefaultParams: []map[string]string{{"TARGET": "prod"}},
+		Tasks: []*Task{
+			{Name: "deploy", Command: "echo deploy ${TARGET} {{ secrets.TOKEN }}", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "echo deploy prod " + redactedSecret
+	if data.Tasks[0].Command != expected {
+		t.Errorf("Expected command %q, got %q", expected, data.Tasks[0].Command)
+	}
+}
+
+func TestSensitiveParams(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "sensitive_params",
+		DefaultParams: []map[string]string{
+			{"TOKEN": "t0ken", SensitiveParamFlag: "true"},
+			{"TARGET": "prod"},
+		},
+		Tasks: []*Task{
+			{Name: "deploy", Command: "echo deploy ${TARGET} ${TOKEN}; [ \"$TOKEN\" = t0ken ]", Kind: KindMain},
+		},
+	}
+	job.extractSensitiveParams()
+	if len(job.DefaultParams[0]) != 1 {
+		t.Fatalf("Expected the flag to be removed from params, got %v", job.DefaultParams[0])
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := os.ReadFile(build.GetWakespaceDir() + job.Tasks[0].LogFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "t0ken") || !strings.Contains(string(data), "deploy prod "+maskedParam) {
+		t.Errorf("Expected the token to be masked, got %q", string(data))
+	}
+}
+
+func TestTaskRetries(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "task_retries",
+		Tasks: []*Task{
+			{
+				Name:    "flaky",
+				Command: "n=$(( $(cat attempts 2>/dev/null || echo 0) + 1 )); echo $n > attempts; echo attempt $n; [ $n -ge 3 ]",
+				Kind:    KindMain,
+				Retries: 3,
+			},
+			{Name: "broken", Command: "false", Kind: KindMain, Retries: 1},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	tasks := build.GenerateBuildUpdateData().Tasks
+	if tasks[0].Status != StatusFinished || tasks[0].Attempts != 3 {
+		t.Errorf("Expected flaky task to pass on the 3rd attempt, got %s after %d", tasks[0].Status, tasks[0].Attempts)
+	}
+	if tasks[1].Status != StatusFailed || tasks[1].Attempts != 2 {
+		t.Errorf("Expected broken task to fail after 2 attempts, got %s after %d", tasks[1].Status, tasks[1].Attempts)
+	}
+	data, err := os.ReadFile(build.GetWakespaceDir() + job.Tasks[0].LogFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"attempt 1", "> Retry 1/3", "attempt 2", "> Retry 2/3", "attempt 3"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected %q in the task log:\n%s", expected, data)
+		}
+	}
+}
+
+func TestTaskRetries_Abort(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "task_retries_abort",
+		Tasks: []*Task{
+			{Name: "sleep", Command: "sleep 30", Kind: KindMain, Retries: 3},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitFor(t, 5*time.Second, "the task is started", func() bool {
+		return build.GenerateBuildUpdateData().Tasks[0].Status == StatusRunning
+	})
+	err := GlobalQueue.Abort(build.ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+	if attempts := build.GenerateBuildUpdateData().Tasks[0].Attempts; attempts != 1 {
+		t.Errorf("Expected aborted task not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestBuildRetry(t *testing.T) {
+	setupTestEnv(t)
+	jobFile := Config.JobDir + "retry" + Config.jobsExt
+	err := os.WriteFile(jobFile, []byte("retry: 2\ntasks:\n  - name: fail\n    run: exit 1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := CreateJobFromFile(jobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := createTestBuild(t, job)
+	first.mutex.Lock()
+	first.Params = []map[string]string{{"TARGET": "prod"}}
+	first.mutex.Unlock()
+
+	waitFor(t, 5*time.Second, "the last retry fails", func() bool {
+		data, err := getBuildStatusData(first.ID + 2)
+		return err == nil && data.Status == StatusFailed
+	})
+	for attempt := 1; attempt <= 2; attempt++ {
+		data, err := getBuildStatusData(first.ID + attempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.RetryOf != first.ID+attempt-1 || data.RetryAttempt != attempt || data.Trigger.Kind != TriggerRetry {
+			t.Errorf("Unexpected retry %d: retry_of %d, attempt %d, trigger %s", attempt, data.RetryOf, data.RetryAttempt, data.Trigger.Kind)
+		}
+		if data.Params[0]["TARGET"] != "prod" {
+			t.Errorf("Expected params of the first build in retry %d, got %v", attempt, data.Params)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := getBuildStatusData(first.ID + 3); err == nil {
+		t.Error("Expected no more than 2 retries")
+	}
+}
+
+func TestAllowFailure(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "allow_failure",
+		Tasks: []*Task{
+			{Name: "coverage", Command: "false", Kind: KindMain, AllowFailure: true},
+			{Name: "build", Command: "true", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data := build.GenerateBuildUpdateData()
+	if data.Tasks[0].Status != StatusFailed || !data.Tasks[0].AllowedFailure {
+		t.Errorf("Expected allowed failure of the first task, got %+v", data.Tasks[0])
+	}
+	if data.Tasks[1].Status != StatusFinished || data.Tasks[1].AllowedFailure {
+		t.Errorf("Expected the second task to finish, got %+v", data.Tasks[1])
+	}
+	if !data.AllowedFailures {
+		t.Error("Expected the build to be marked with allowed failures")
+	}
+	if len(data.Warnings) != 1 || data.Warnings[0] != "Task coverage failed (allowed)" {
+		t.Errorf("Unexpected warnings %v", data.Warnings)
+	}
+}
+
+func TestArtifactPatternWarnings(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:      "artifact_warnings",
+		Artifacts: []*ArtifactPattern{{Pattern: "*.txt"}, {Pattern: "dist/**/*.whl"}},
+		Tasks:     []*Task{{Name: "build", Command: "echo ok > out.txt", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data := build.GenerateBuildUpdateData()
+	expected := "artifact pattern 'dist/**/*.whl' matched no files"
+	if len(data.Warnings) != 1 || data.Warnings[0] != expected {
+		t.Errorf("Expected warning %q, got %v", expected, data.Warnings)
+	}
+	if len(data.BuildArtifacts) != 1 {
+		t.Errorf("Expected 1 artifact, got %d", len(data.BuildArtifacts))
+	}
+}
+
+func TestArtifactsExclude(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:             "artifacts_exclude",
+		Artifacts:        []*ArtifactPattern{{Pattern: "dist/**"}},
+		ArtifactsExclude: []string{"dist/node_modules/**", "**/*.map"},
+		Tasks: []*Task{{
+			Name:    "build",
+			Command: "mkdir -p dist/js dist/node_modules/left-pad && touch dist/app.js dist/js/app.js.map dist/node_modules/left-pad/index.js",
+			Kind:    KindMain,
+		}},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data := build.GenerateBuildUpdateData()
+	if len(data.BuildArtifacts) != 1 || data.BuildArtifacts[0].Filename != "dist/app.js" {
+		t.Errorf("Expected only dist/app.js, got %+v", data.BuildArtifacts)
+	}
+}
+
+func TestMaxArtifactSize(t *testing.T) {
+	setupTestEnv(t)
+	Config.MaxArtifactSize = "10KB"
+	job := &Job{
+		Name:      "max_artifact_size",
+		Artifacts: []*ArtifactPattern{{Pattern: "a.bin"}, {Pattern: "b.bin"}, {Pattern: "c.bin"}},
+		Tasks: []*Task{{
+			Name:    "build",
+			Command: "head -c 4000 /dev/zero > a.bin && head -c 8000 /dev/zero > b.bin && head -c 10 /dev/zero > c.bin",
+			Kind:    KindMain,
+		}},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data := build.GenerateBuildUpdateData()
+	if len(data.BuildArtifacts) != 1 || data.BuildArtifacts[0].Filename != "a.bin" {
+		t.Errorf("Expected only a.bin to be collected, got %+v", data.BuildArtifacts)
+	}
+	if !data.ArtifactsTruncated || len(data.Warnings) != 1 || !strings.Contains(data.Warnings[0], "b.bin") {
+		t.Errorf("Expected artifacts to be truncated at b.bin, got %v", data.Warnings)
+	}
+	if _, err := os.Stat(build.GetArtifactsDir() + "a.bin"); err != nil {
+		t.Errorf("Expected the collected artifact to be kept: %s", err)
+	}
+	if _, err := os.Stat(build.GetArtifactsDir() + "b.bin"); !os.IsNotExist(err) {
+		t.Errorf("Expected b.bin not to be copied: %v", err)
+	}
+}
+
+func TestArtifactPatternRequired(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:      "artifact_required",
+		Artifacts: []*ArtifactPattern{{Pattern: "dist/*.whl", Required: true}},
+		Tasks:     []*Task{{Name: "build", Command: "true", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	data := build.GenerateBuildUpdateData()
+	expected := "artifact pattern 'dist/*.whl' matched no files"
+	if len(data.Warnings) != 1 || data.Warnings[0] != expected {
+		t.Errorf("Expected warning %q, got %v", expected, data.Warnings)
+	}
+}
+
+func TestJobShell(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:  "job_shell",
+		Shell: "sh",
+		Tasks: []*Task{{Name: "print shell", Command: "echo shell=$0", If: "true", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := os.ReadFile(build.GetWakespaceDir() + job.Tasks[0].LogFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "shell=sh") {
+		t.Errorf("Expected the command to run with sh:\n%s", data)
+	}
+}
+
+func TestJobShell_NotFound(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:  "job_shell_not_found",
+		Shell: "no-such-shell",
+		Tasks: []*Task{{Name: "main", Command: "true", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+	data := build.GenerateBuildUpdateData()
+	if data.Tasks[0].Status != StatusPending {
+		t.Errorf("Expected the task not to run, got %s", data.Tasks[0].Status)
+	}
+	if len(data.Warnings) != 1 || !strings.HasPrefix(data.Warnings[0], "shell no-such-shell is not found") {
+		t.Errorf("Unexpected warnings %v", data.Warnings)
+	}
+}
+
+func TestTaskGroups_Abort(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "task_groups_abort",
+		Tasks: []*Task{
+			{Name: "unit", Command: "sleep 30", Kind: KindMain, Group: "test"},
+			{Name: "integration", Command: "sleep 30", Kind: KindMain, Group: "test"},
+			{Name: "deploy", Command: "true", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitFor(t, 5*time.Second, "tasks of the group are running", func() bool {
+		tasks := build.GenerateBuildUpdateData().Tasks
+		return tasks[0].Status == StatusRunning && tasks[1].Status == StatusRunning
+	})
+	err := GlobalQueue.Abort(build.ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+	expected := []ItemStatus{StatusAborted, StatusAborted, StatusPending}
+	for i, task := range build.GenerateBuildUpdateData().Tasks {
+		if task.Status != expected[i] {
+			t.Errorf("Expected task %d status %q, got %q", i, expected[i], task.Status)
+		}
+	}
+}
+
+func TestMaintenanceWindowHoldsBuilds(t *testing.T) {
+	setupTestEnv(t)
+	err := SaveMaintenanceWindow(&MaintenanceWindow{
+		Name:  "upgrade",
+		Start: time.Now().Add(-time.Minute),
+		End:   time.Now().Add(300 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paused := createTestBuild(t, &Job{
+		Name:     "pausable",
+		Pausable: true,
+		Tasks:    []*Task{{Name: "main", Command: "true", Kind: KindMain}},
+	})
+	other := createTestBuild(t, &Job{
+		Name:  "not_pausable",
+		Tasks: []*Task{{Name: "main", Command: "true", Kind: KindMain}},
+	})
+
+	waitForTerminalState(t, other, 5*time.Second, StatusFinished)
+	data := paused.GenerateBuildUpdateData()
+	if data.Status != StatusPending || !strings.HasPrefix(data.PendingReason, "Paused by maintenance window upgrade") {
+		t.Errorf("Expected the build to be held, got %s: %q", data.Status, data.PendingReason)
+	}
+	if !GlobalQueue.HasHeldByMaintenance() {
+		t.Error("Expected the queue to report held builds")
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	GlobalQueue.Take()
+	waitForTerminalState(t, paused, 5*time.Second, StatusFinished)
+	if reason := paused.GenerateBuildUpdateData().PendingReason; reason != "" {
+		t.Errorf("Expected pending reason to be cleared, got %q", reason)
+	}
+}
+
+func TestJobConcurrency(t *testing.T) {
+	setupTestEnv(t)
+	newJob := func() *Job {
+		return &Job{
+			Name:        "deploy",
+			Concurrency: 1,
+			Tasks:       []*Task{{Name: "deploy", Command: "sleep 0.3", Kind: KindMain}},
+		}
+	}
+	first := createTestBuild(t, newJob())
+	second := createTestBuild(t, newJob())
+	third := createTestBuild(t, newJob())
+	other := createTestBuild(t, &Job{Name: "other", Tasks: []*Task{{Name: "ok", Command: "true", Kind: KindMain}}})
+
+	// Other jobs aren't limited
+	waitForTerminalState(t, other, 5*time.Second, StatusFinished)
+	if second.GenerateBuildUpdateData().Status != StatusPending {
+		t.Fatal("Expected the second build to wait for the first one")
+	}
+	if reason := second.GenerateBuildUpdateData().PendingReason; !strings.HasPrefix(reason, concurrencyPendingReason) {
+		t.Errorf("Unexpected pending reason %q", reason)
+	}
+
+	// Started now ignoring the limit
+	err := GlobalQueue.TakeNow(third.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the third build is running", func() bool {
+		return third.GenerateBuildUpdateData().Status == StatusRunning
+	})
+
+	waitForTerminalState(t, first, 5*time.Second, StatusFinished)
+	waitForTerminalState(t, second, 5*time.Second, StatusFinished)
+	if reason := second.GenerateBuildUpdateData().PendingReason; reason != "" {
+		t.Errorf("Expected the pending reason to be cleared, got %q", reason)
+	}
+	waitForTerminalState(t, third, 5*time.Second, StatusFinished)
+}
+
+func TestJobConcurrency_Sequential(t *testing.T) {
+	cases := []struct {
+		concurrency      int
+		concurrentBuilds int
+	}{
+		// Limited by the job
+		{1, 2},
+		// The global limit applies on top of the job limit
+		{3, 1},
+	}
+	for _, c := range cases {
+		setupTestEnv(t)
+		GlobalQueue.SetConcurrency(c.concurrentBuilds)
+		builds := []*Build{}
+		for i := 0; i < 3; i++ {
+			builds = append(builds, createTestBuild(t, &Job{
+				Name:        "sequential",
+				Concurrency: c.concurrency,
+				Tasks:       []*Task{{Name: "sleep", Command: "sleep 0.1", Kind: KindMain}},
+			}))
+		}
+		waitFor(t, 5*time.Second, "all builds are completed", func() bool {
+			running, queued := GlobalQueue.Count()
+			if running > 1 {
+				t.Fatalf("concurrency %d of %d: expected one build at a time, got %d", c.concurrency, c.concurrentBuilds, running)
+			}
+			return running == 0 && queued == 0
+		})
+		for _, b := range builds {
+			waitForTerminalState(t, b, time.Second, StatusFinished)
+		}
+	}
+}
+
+func TestQueuePause(t *testing.T) {
+	setupTestEnv(t)
+	running := createTestBuild(t, &Job{Name: "running", Tasks: []*Task{{Name: "sleep", Command: "sleep 0.3", Kind: KindMain}}})
+	waitFor(t, 5*time.Second, "the build is running", func() bool {
+		return running.GenerateBuildUpdateData().Status == StatusRunning
+	})
+
+	err := GlobalQueue.SetPaused(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := createTestBuild(t, &Job{Name: "queued", Tasks: []*Task{{Name: "ok", Command: "true", Kind: KindMain}}})
+	// Running builds continue
+	waitForTerminalState(t, running, 5*time.Second, StatusFinished)
+	if status := queued.GenerateBuildUpdateData().Status; status != StatusPending {
+		t.Fatalf("Expected the build to wait while the queue is paused, got %s", status)
+	}
+
+	// The state is persisted
+	q, err := CreateQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.Status().Paused {
+		t.Error("Expected the queue to stay paused after restart")
+	}
+
+	err = GlobalQueue.SetPaused(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, queued, 5*time.Second, StatusFinished)
+}
+
+func TestAbortPendingJob(t *testing.T) {
+	setupTestEnv(t)
+	newJob := func() *Job {
+		return &Job{
+			Name:        "backlog",
+			Concurrency: 1,
+			Tasks: []*Task{
+				{Name: "sleep", Command: "sleep 0.3", Kind: KindMain},
+				{Name: "notify", Command: "true", Kind: StatusAborted},
+			},
+		}
+	}
+	running := createTestBuild(t, newJob())
+	waitFor(t, 5*time.Second, "the build is running", func() bool {
+		return running.GenerateBuildUpdateData().Status == StatusRunning
+	})
+	first := createTestBuild(t, newJob())
+	second := createTestBuild(t, newJob())
+	other := createTestBuild(t, &Job{Name: "other", Tasks: []*Task{{Name: "sleep", Command: "sleep 0.3", Kind: KindMain}}})
+
+	ids := GlobalQueue.AbortPendingJob("backlog", StatusAborted)
+	if !reflect.DeepEqual(ids, []int{first.ID, second.ID}) {
+		t.Errorf("Expected pending builds to be aborted, got %v", ids)
+	}
+	for _, build := range []*Build{first, second} {
+		waitForTerminalState(t, build, 5*time.Second, StatusAborted)
+		if status := build.GenerateBuildUpdateData().Tasks[1].Status; status != StatusFinished {
+			t.Errorf("Expected on_aborted task of build %d to run, got %s", build.ID, status)
+		}
+	}
+	waitForTerminalState(t, running, 5*time.Second, StatusFinished)
+	waitForTerminalState(t, other, 5*time.Second, StatusFinished)
+}
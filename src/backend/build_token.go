@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sasha-s/go-deadlock"
+)
+
+// BuildTokenRegistry maps tokens of running builds to the builds. Tokens are
+// kept only in memory and are valid until the build is completed
+type BuildTokenRegistry struct {
+	builds map[string]*Build // Key is sha256 of the token
+	mu     deadlock.Mutex
+}
+
+// GlobalBuildTokens contains tokens of all running builds
+var GlobalBuildTokens = &BuildTokenRegistry{builds: make(map[string]*Build)}
+
+// ArtifactFetch is a record of an artifact of another build fetched by the
+// build with its token
+type ArtifactFetch struct {
+	TaskID  int    `json:"task_id"`
+	BuildID int    `json:"build_id"`
+	Job     string `json:"job"`
+	// Empty if all artifacts were downloaded as the archive
+	Path      string    `json:"path"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func hashBuildToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue generates a new token for the build
+func (r *BuildTokenRegistry) Issue(b *Build) (string, error) {
+	data := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, data)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(data)
+	r.mu.Lock()
+	r.builds[hashBuildToken(token)] = b
+	r.mu.Unlock()
+	return token, nil
+}
+
+// Lookup returns the build which owns the token or nil
+func (r *BuildTokenRegistry) Lookup(token string) *Build {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.builds[hashBuildToken(token)]
+}
+
+// Revoke invalidates the token
+func (r *BuildTokenRegistry) Revoke(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.builds, hashBuildToken(token))
+}
+
+// getToken returns the token of the build, the token is issued on the first
+// call
+func (b *Build) getToken() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.token == "" {
+		token, err := GlobalBuildTokens.Issue(b)
+		if err != nil {
+			b.Logger.Println(err)
+			return ""
+		}
+		b.token = token
+	}
+	return b.token
+}
+
+// revokeToken invalidates the token of the build
+func (b *Build) revokeToken() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.token != "" {
+		GlobalBuildTokens.Revoke(b.token)
+	}
+}
+
+// canReadArtifactsFrom reports whether tasks of the build are allowed to read
+// artifacts of the job
+func (b *Build) canReadArtifactsFrom(jobName string) bool {
+	for _, name := range b.Job.CanReadArtifactsFrom {
+		if name == jobName {
+			return true
+		}
+	}
+	return false
+}
+
+// recordArtifactFetch saves provenance of the artifact fetched by the build
+func (b *Build) recordArtifactFetch(fetch *ArtifactFetch) {
+	b.mutex.Lock()
+	fetch.TaskID = b.currentTask
+	b.ArtifactFetches = append(b.ArtifactFetches, fetch)
+	b.mutex.Unlock()
+	b.Logger.Printf("Task %d fetched artifact %s of build %d (job %s)\n", fetch.TaskID, fetch.Path, fetch.BuildID, fetch.Job)
+	b.BroadcastUpdate()
+}
+
+// authorizeBuildToken verifies that the build which owns the token can read
+// the artifact of the build. Empty artifact stands for the archive with all
+// artifacts of the build. Paths with `..` are rejected, so a token can't
+// escape the artifacts directory before the path is cleaned
+func authorizeBuildToken(token string, buildID string, artifact string, logger *log.Logger) (int, error) {
+	source := GlobalBuildTokens.Lookup(token)
+	if source == nil {
+		return http.StatusForbidden, fmt.Errorf("invalid build token")
+	}
+	for _, segment := range strings.Split(artifact, "/") {
+		if segment == ".." {
+			return http.StatusForbidden, fmt.Errorf("build tokens can't be used with relative paths")
+		}
+	}
+	targetID, err := strconv.Atoi(buildID)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	if targetID == source.ID {
+		return http.StatusOK, nil
+	}
+	target, err := getBuildStatusData(targetID)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	if !source.canReadArtifactsFrom(target.JobName()) {
+		logger.Printf(
+			"Build %d of job %s is not allowed to read artifacts of build %d of job %s\n",
+			source.ID, source.Job.Name, targetID, target.JobName(),
+		)
+		return http.StatusForbidden, fmt.Errorf("job %s is not allowed to read artifacts of job %s", source.Job.Name, target.JobName())
+	}
+	source.recordArtifactFetch(&ArtifactFetch{
+		BuildID:   targetID,
+		Job:       target.JobName(),
+		Path:      artifact,
+		FetchedAt: time.Now(),
+	})
+	return http.StatusOK, nil
+}
+
+// storageArtifact returns the build and the artifact requested from the
+// storage. Resource is relative to /storage/build/, only files in the
+// artifacts directory are allowed
+func storageArtifact(resource string) (string, string, error) {
+	for _, segment := range strings.Split(resource, "/") {
+		if segment == ".." {
+			return "", "", fmt.Errorf("build tokens can't be used with relative paths")
+		}
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path.Clean("/"+resource), "/"), "/", 3)
+	if len(parts) != 3 || parts[1] != "artifacts" || parts[2] == "" {
+		return "", "", fmt.Errorf("build tokens can be used only to fetch artifacts")
+	}
+	return parts[0], parts[2], nil
+}
+
+// buildTokenMi authorizes requests with a build token (`Authorization: Bearer
+// $WAKE_BUILD_TOKEN`) to fetch the artifact returned by requestedArtifact.
+// Other requests, including ones with API keys, are checked by AuthMi
+func buildTokenMi(next http.Handler, requestedArtifact func(r *http.Request) (string, string, error)) http.Handler {
+	authNext := AuthMi(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger, ok := r.Context().Value(HL).(*log.Logger)
+		if !ok {
+			logger = Logger
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || isValidAPIKey(token) {
+			authNext.ServeHTTP(w, r)
+			return
+		}
+		status := http.StatusForbidden
+		buildID, artifact, err := requestedArtifact(r)
+		if err == nil {
+			status, err = authorizeBuildToken(token, buildID, artifact, logger)
+		}
+		if err != nil {
+			logger.Println(err)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(status)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StorageAuthMi authorizes build tokens to fetch artifacts from /storage
+func StorageAuthMi(next http.Handler) http.Handler {
+	return buildTokenMi(next, func(r *http.Request) (string, string, error) {
+		return storageArtifact(strings.TrimPrefix(r.URL.Path, "/storage/build/"))
+	})
+}
+
+// ArtifactAuthMi authorizes build tokens to download artifacts with the API.
+// It has to be mounted on routes with the `id` of the build, the route without
+// the `*` parameter downloads all artifacts
+func ArtifactAuthMi(next http.Handler) http.Handler {
+	return buildTokenMi(next, func(r *http.Request) (string, string, error) {
+		return chi.URLParam(r, "id"), chi.URLParam(r, "*"), nil
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestStorageAuthMi_BuildToken(t *testing.T) {
+	setupTestEnv(t)
+	source, err := CreateBuild(&Job{Name: "consumer", CanReadArtifactsFrom: []string{"producer"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Logger = log.New(io.Discard, "", 0)
+	token := source.getToken()
+	defer source.revokeToken()
+	putTestBuildWithArtifact(t, 100, "producer", time.Now())
+	putTestBuildWithArtifact(t, 101, "private", time.Now())
+
+	handler := StorageAuthMi(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cases := []struct {
+		path     string
+		expected int
+	}{
+		// Own build
+		{fmt.Sprintf("%d/artifacts/out.txt", source.ID), http.StatusOK},
+		// Allowed by can_read_artifacts_from
+		{"100/artifacts/out.txt", http.StatusOK},
+		{"100/artifacts/./dir//out.txt", http.StatusOK},
+		// Not allowed
+		{"101/artifacts/out.txt", http.StatusForbidden},
+		{fmt.Sprintf("%d/task_0.log", source.ID), http.StatusForbidden},
+		{"100/task_0.log", http.StatusForbidden},
+		{"100/artifacts/", http.StatusForbidden},
+		// Path traversal
+		{fmt.Sprintf("%d/artifacts/../../101/artifacts/out.txt", source.ID), http.StatusForbidden},
+		{fmt.Sprintf("%d/artifacts/../task_0.log", source.ID), http.StatusForbidden},
+		{"100/artifacts/../../101/artifacts/out.txt", http.StatusForbidden},
+		{fmt.Sprintf("%d/./artifacts/x/../../../101/artifacts/out.txt", source.ID), http.StatusForbidden},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/storage/build/"+c.path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf("%s: expected %d, got %d %q", c.path, c.expected, w.Code, w.Body.String())
+		}
+	}
+
+	source.mutex.Lock()
+	fetches := source.ArtifactFetches
+	source.mutex.Unlock()
+	if len(fetches) != 2 || fetches[0].BuildID != 100 || fetches[0].Job != "producer" {
+		t.Errorf("Expected fetches of build 100 to be recorded, got %+v", fetches)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/storage/build/100/artifacts/out.txt", nil)
+	r.Header.Set("Authorization", "Bearer invalid")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an invalid token, got %d", w.Code)
+	}
+}
+
+func TestArtifactAuthMi_BuildToken(t *testing.T) {
+	setupTestEnv(t)
+	source, err := CreateBuild(&Job{Name: "consumer", CanReadArtifactsFrom: []string{"producer"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Logger = log.New(io.Discard, "", 0)
+	token := source.getToken()
+	defer source.revokeToken()
+	putTestBuildWithArtifact(t, 100, "producer", time.Now())
+	putTestBuildWithArtifact(t, 101, "private", time.Now())
+
+	router := chi.NewRouter()
+	router.With(ArtifactAuthMi).Get("/api/build/{id}/artifacts.zip", HandleDownloadArtifacts)
+	router.With(ArtifactAuthMi).Get("/api/build/{id}/artifacts/*", HandleDownloadArtifact)
+	cases := []struct {
+		path     string
+		expected int
+	}{
+		{"100/artifacts/out.txt", http.StatusOK},
+		{"100/artifacts.zip", http.StatusOK},
+		{"101/artifacts/out.txt", http.StatusForbidden},
+		{"101/artifacts.zip", http.StatusForbidden},
+		{"100/artifacts/../../101/artifacts/out.txt", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/api/build/"+c.path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf("%s: expected %d, got %d %q", c.path, c.expected, w.Code, w.Body.String())
+		}
+	}
+
+	source.mutex.Lock()
+	fetches := source.ArtifactFetches
+	source.mutex.Unlock()
+	if len(fetches) != 2 || fetches[0].Path != "out.txt" || fetches[1].Path != "" {
+		t.Errorf("Expected the artifact and the archive of build 100 to be recorded, got %+v", fetches)
+	}
+
+	// Requests without the token are checked by AuthMi
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/build/100/artifacts/out.txt", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without authorization, got %d", w.Code)
+	}
+}
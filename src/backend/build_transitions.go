@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// errStaleBuildUpdate is returned if a newer revision of the build record is
+// already saved
+var errStaleBuildUpdate = errors.New("a newer revision of the build is saved")
+
+// BuildTransition is a change of the status of the build
+type BuildTransition struct {
+	Status ItemStatus `json:"status"`
+	// Revision of the build record which changed the status
+	Revision  int       `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	// Reconstructed from a record saved before transitions were tracked
+	Synthetic bool `json:"synthetic,omitempty"`
+}
+
+// putBuildRecord saves the record of the build unless a newer revision is
+// saved already. A transition is appended if the status has changed
+func putBuildRecord(tx *bolt.Tx, data *BuildUpdateData) error {
+	hb := tx.Bucket(HistoryBucket)
+	var prevStatus ItemStatus
+	if prevB := hb.Get(Itob(data.ID)); prevB != nil {
+		var prev BuildUpdateData
+		err := json.Unmarshal(prevB, &prev)
+		if err != nil {
+			return err
+		}
+		if prev.Revision > data.Revision {
+			return fmt.Errorf("revision %d of build %d is rejected: %w", data.Revision, data.ID, errStaleBuildUpdate)
+		}
+		prevStatus = prev.Status
+	}
+	dataB, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	err = hb.Put(Itob(data.ID), dataB)
+	if err != nil {
+		return err
+	}
+	if prevStatus == data.Status {
+		return nil
+	}
+	return appendBuildTransition(tx, data.ID, &BuildTransition{
+		Status:    data.Status,
+		Revision:  data.Revision,
+		Timestamp: time.Now(),
+	})
+}
+
+func appendBuildTransition(tx *bolt.Tx, id int, transition *BuildTransition) error {
+	bb, err := tx.Bucket(TransitionsBucket).CreateBucketIfNotExists(Itob(id))
+	if err != nil {
+		return err
+	}
+	seq, err := bb.NextSequence()
+	if err != nil {
+		return err
+	}
+	transitionB, err := json.Marshal(transition)
+	if err != nil {
+		return err
+	}
+	return bb.Put(Itob(int(seq)), transitionB)
+}
+
+// BackfillBuildTransitions adds a synthetic transition to the current status
+// for builds saved before transitions were tracked
+func BackfillBuildTransitions(tx *bolt.Tx) error {
+	tb := tx.Bucket(TransitionsBucket)
+	count := 0
+	err := tx.Bucket(HistoryBucket).ForEach(func(k, v []byte) error {
+		if tb.Bucket(k) != nil {
+			return nil
+		}
+		var msg BuildUpdateData
+		err := json.Unmarshal(v, &msg)
+		if err != nil {
+			Logger.Println(err)
+			return nil
+		}
+		timestamp := msg.StartedAt
+		if isTerminalStatus(msg.Status) {
+			timestamp = msg.StartedAt.Add(msg.Duration)
+		}
+		count++
+		return appendBuildTransition(tx, msg.ID, &BuildTransition{
+			Status:    msg.Status,
+			Revision:  msg.Revision,
+			Timestamp: timestamp,
+			Synthetic: true,
+		})
+	})
+	if count > 0 {
+		Logger.Printf("Status transitions of %d builds are backfilled\n", count)
+	}
+	return err
+}
+
+// removeBuildTransitions removes transitions of the deleted build
+func removeBuildTransitions(tx *bolt.Tx, id int) error {
+	err := tx.Bucket(TransitionsBucket).DeleteBucket(Itob(id))
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return nil
+	}
+	return err
+}
+
+// buildCompletedAt returns the time of the transition to the terminal status
+// of the build. Records without it fall back to the start of the build plus
+// its duration. Zero time is returned for builds which have never started,
+// e.g. aborted while pending, if the transition is not tracked
+func buildCompletedAt(tx *bolt.Tx, msg *BuildUpdateData) time.Time {
+	if bb := tx.Bucket(TransitionsBucket).Bucket(Itob(msg.ID)); bb != nil {
+		_, v := bb.Cursor().Last()
+		var transition BuildTransition
+		if v != nil && json.Unmarshal(v, &transition) == nil && !transition.Synthetic && transition.Status == msg.Status {
+			return transition.Timestamp
+		}
+	}
+	if msg.StartedAt.IsZero() {
+		return time.Time{}
+	}
+	return msg.StartedAt.Add(msg.Duration)
+}
+
+// getBuildTransitions returns transitions of the build, the oldest first
+func getBuildTransitions(id int) ([]*BuildTransition, error) {
+	transitions := []*BuildTransition{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(HistoryBucket).Get(Itob(id)) == nil {
+			return fmt.Errorf("build %d not found", id)
+		}
+		bb := tx.Bucket(TransitionsBucket).Bucket(Itob(id))
+		if bb == nil {
+			return nil
+		}
+		return bb.ForEach(func(_, v []byte) error {
+			var transition BuildTransition
+			err := json.Unmarshal(v, &transition)
+			if err != nil {
+				return err
+			}
+			transitions = append(transitions, &transition)
+			return nil
+		})
+	})
+	return transitions, err
+}
+
+// HandleGetBuildTransitions returns status transitions of the build
+// @Summary      Return status transitions of the build
+// @Description  Every change of the status with the revision of the build record and the time. Builds created before transitions were tracked have a single synthetic transition to their status at that time
+// @Tags         build
+// @Produce      json
+// @Param        id       path    integer   true  "Build ID"
+// @Success      200      {array}    BuildTransition
+// @Failure      404      {string}   http.StatusNotFound
+// @Failure      500      {string}   http.StatusInternalServerError
+// @Router       /build/{id}/transitions [get]
+func HandleGetBuildTransitions(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	transitions, err := getBuildTransitions(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(transitions)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
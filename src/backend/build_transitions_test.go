@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBuildTransitions(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:  "transitions",
+		Tasks: []*Task{{Name: "true", Command: "true", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+
+	transitions, err := getBuildTransitions(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ItemStatus{StatusPending, StatusRunning, StatusFinished}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v, got %d", expected, len(transitions))
+	}
+	for i, transition := range transitions {
+		if transition.Status != expected[i] || transition.Synthetic {
+			t.Errorf("Expected transition to %s, got %+v", expected[i], transition)
+		}
+		if i > 0 && transition.Revision <= transitions[i-1].Revision {
+			t.Errorf("Expected revisions to increase, got %d after %d", transition.Revision, transitions[i-1].Revision)
+		}
+	}
+}
+
+func TestStaleBuildUpdateIsRejected(t *testing.T) {
+	setupTestEnv(t)
+	err := saveBuildUpdate(&BuildUpdateData{ID: 1, Name: "a", Status: StatusFinished, Revision: 5}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveBuildUpdate(&BuildUpdateData{ID: 1, Name: "a", Status: StatusRunning, Revision: 3}, FinalizationRetries)
+	if !errors.Is(err, errStaleBuildUpdate) {
+		t.Errorf("Expected the stale update to be rejected, got %v", err)
+	}
+	data, err := getBuildStatusData(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Status != StatusFinished || data.Revision != 5 {
+		t.Errorf("Expected the terminal record to be kept, got %s revision %d", data.Status, data.Revision)
+	}
+	transitions, err := getBuildTransitions(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 1 {
+		t.Errorf("Expected a single transition, got %d", len(transitions))
+	}
+}
+
+func TestBackfillBuildTransitions(t *testing.T) {
+	setupTestEnv(t)
+	completedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	putTestBuildWithArtifact(t, 1, "a", completedAt)
+
+	// Applied twice, the second time there is nothing to backfill
+	for i := 0; i < 2; i++ {
+		err := DB.Update(BackfillBuildTransitions)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	transitions, err := getBuildTransitions(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) != 1 {
+		t.Fatalf("Expected a single transition, got %d", len(transitions))
+	}
+	if !transitions[0].Synthetic || transitions[0].Status != StatusFinished || !transitions[0].Timestamp.Equal(completedAt) {
+		t.Errorf("Unexpected transition %+v", transitions[0])
+	}
+
+	// Transitions are removed with the build
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return removeBuildTransitions(tx, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transitions, err = getBuildTransitions(1)
+	if err != nil || len(transitions) != 0 {
+		t.Errorf("Expected no transitions, got %d, %v", len(transitions), err)
+	}
+	if _, err := getBuildTransitions(2); err == nil {
+		t.Error("Expected error for unknown build")
+	}
+}
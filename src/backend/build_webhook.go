@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// BuildWebhookTimeout limits duration of a single request of Job.Webhook
+const BuildWebhookTimeout = 5 * time.Second
+
+// BuildWebhookRetryDelay is the delay before the only retry of Job.Webhook
+var BuildWebhookRetryDelay = time.Second
+
+var buildWebhookClient = &http.Client{Timeout: BuildWebhookTimeout}
+
+// sendBuildWebhook posts the final status of the build to Job.Webhook if the
+// build is finished, failed or aborted. A failed request is retried once. It
+// doesn't block
+func (b *Build) sendBuildWebhook(status ItemStatus) {
+	if b.Job.Webhook == "" {
+		return
+	}
+	switch status {
+	case StatusFinished, StatusFailed, StatusAborted:
+	default:
+		return
+	}
+	go b.postWithRetry("Build webhook", b.Job.Webhook, b.GenerateBuildUpdateData())
+}
+
+// postWithRetry posts the payload as JSON and retries once after
+// BuildWebhookRetryDelay if the request fails
+func (b *Build) postWithRetry(name string, webhookURL string, payload interface{}) {
+	err := b.postJSON(name, webhookURL, payload)
+	if err == nil {
+		return
+	}
+	b.Logger.Printf("%s failed, retrying in %s: %s\n", name, BuildWebhookRetryDelay, err)
+	time.Sleep(BuildWebhookRetryDelay)
+	err = b.postJSON(name, webhookURL, payload)
+	if err != nil {
+		b.Logger.Printf("%s failed: %s\n", name, err)
+	}
+}
+
+func (b *Build) postJSON(name string, webhookURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := buildWebhookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	b.Logger.Printf("%s responded with %s\n", name, resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// Used to verify the build webhook before saving after editing
+func (j *Job) verifyWebhook() error {
+	if j.Webhook == "" {
+		return nil
+	}
+	u, err := url.Parse(j.Webhook)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook has to be an absolute http(s) URL: %s", j.Webhook)
+	}
+	return nil
+}
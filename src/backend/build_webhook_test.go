@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildWebhookRetriesOnce(t *testing.T) {
+	setupTestEnv(t)
+	defer func(d time.Duration) {
+		BuildWebhookRetryDelay = d
+	}(BuildWebhookRetryDelay)
+	BuildWebhookRetryDelay = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	var calls int
+	var received BuildUpdateData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected request %s with %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	job := &Job{
+		Name:    "notified",
+		Webhook: server.URL,
+		Tasks:   []*Task{{Name: "ok", Command: "true", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+
+	waitFor(t, 5*time.Second, "build is delivered", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return received.ID == build.ID
+	})
+	// Give a chance to unexpected requests
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("Expected 2 requests, got %d", calls)
+	}
+	if received.Name != "notified" || received.Status != StatusFinished {
+		t.Errorf("Unexpected payload %+v", received)
+	}
+}
+
+func TestBuildWebhook_OtherStatuses(t *testing.T) {
+	setupTestEnv(t)
+	var mu sync.Mutex
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+	}))
+	defer server.Close()
+
+	job := &Job{
+		Name:    "timed_out",
+		Webhook: server.URL,
+		Timeout: "200ms",
+		Tasks:   []*Task{{Name: "slow", Command: "sleep 30", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusTimedOut)
+	// Give a chance to unexpected requests
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 0 {
+		t.Errorf("Expected no requests for a timed out build, got %d", calls)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"":                                  true,
+		"https://dashboard.example.com/api": true,
+		"dashboard.example.com/api":         false,
+		"ftp://dashboard.example.com/api":   false,
+	} {
+		job := &Job{Webhook: value}
+		err := job.verifyWebhook()
+		if valid && err != nil || !valid && err == nil {
+			t.Errorf("%q: unexpected result %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ListBuildsDefaultLimit is the page size of ListBuilds if the limit is not
+// provided
+const ListBuildsDefaultLimit = 50
+
+// ListBuildsMaxLimit is the maximum page size of ListBuilds
+const ListBuildsMaxLimit = 500
+
+// BuildsFilter selects builds returned by ListBuilds
+type BuildsFilter struct {
+	// Name of the job, builds of instances of the job template match too
+	Job    string
+	Status ItemStatus
+	// Only builds older than the build with this ID are returned, 0 to start
+	// from the latest build
+	Cursor int
+	Limit  int
+}
+
+// matches returns true if the build passes the filter
+func (f *BuildsFilter) matches(msg *BuildUpdateData) bool {
+	if f.Job != "" && msg.Name != f.Job && msg.JobName() != f.Job {
+		return false
+	}
+	if f.Status != "" && msg.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+// ListBuilds returns a page of builds from the history, the latest first.
+// Records are read one by one starting from the cursor. NextCursor is 0 if
+// there are no older builds
+func ListBuilds(filter *BuildsFilter) (*BuildsListData, error) {
+	data := &BuildsListData{Builds: []*BuildUpdateData{}}
+	err := DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(HistoryBucket).Cursor()
+		var key, v []byte
+		if filter.Cursor > 0 {
+			// Seek returns the cursor build or the next one if it's removed
+			seek := Itob(filter.Cursor)
+			key, v = c.Seek(seek)
+			if key == nil {
+				key, v = c.Last()
+			}
+			for key != nil && bytes.Compare(key, seek) >= 0 {
+				key, v = c.Prev()
+			}
+		} else {
+			key, v = c.Last()
+		}
+		for ; key != nil; key, v = c.Prev() {
+			if len(data.Builds) == filter.Limit {
+				data.NextCursor = data.Builds[len(data.Builds)-1].ID
+				break
+			}
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			if filter.matches(&msg) {
+				data.Builds = append(data.Builds, &msg)
+			}
+		}
+		return nil
+	})
+	return data, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestListBuilds(t *testing.T) {
+	setupTestEnv(t)
+	err := DB.Update(func(tx *bolt.Tx) error {
+		for id := 1; id <= 7; id++ {
+			msg := &BuildUpdateData{ID: id, Name: "a", Status: StatusFinished}
+			if id%2 == 0 {
+				msg.Name = "b"
+			}
+			if id%3 == 0 {
+				msg.Status = StatusFailed
+			}
+			dataB, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			// Build 5 was removed by the cleaner
+			if id == 5 {
+				continue
+			}
+			err = tx.Bucket(HistoryBucket).Put(Itob(id), dataB)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		filter     BuildsFilter
+		ids        []int
+		nextCursor int
+	}{
+		{BuildsFilter{Limit: 3}, []int{7, 6, 4}, 4},
+		{BuildsFilter{Limit: 3, Cursor: 4}, []int{3, 2, 1}, 0},
+		{BuildsFilter{Limit: 2, Cursor: 5}, []int{4, 3}, 3},
+		{BuildsFilter{Limit: 2, Cursor: 100}, []int{7, 6}, 6},
+		{BuildsFilter{Limit: 10, Job: "a"}, []int{7, 3, 1}, 0},
+		{BuildsFilter{Limit: 10, Status: StatusFailed}, []int{6, 3}, 0},
+		{BuildsFilter{Limit: 10, Job: "b", Status: StatusFailed, Cursor: 6}, []int{}, 0},
+	}
+	for _, c := range cases {
+		data, err := ListBuilds(&c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, msg := range data.Builds {
+			ids = append(ids, msg.ID)
+		}
+		if !reflect.DeepEqual(ids, c.ids) || data.NextCursor != c.nextCursor {
+			t.Errorf("%+v: expected %v and cursor %d, got %v and cursor %d", c.filter, c.ids, c.nextCursor, ids, data.NextCursor)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -41,31 +42,126 @@ func (cl *Cleaner) Clean() {
 		// Find starting point for removing
 		fromB := make([]byte, 8)
 		binary.BigEndian.PutUint64(fromB, binary.BigEndian.Uint64(lastK)-uint64(preserve))
-		for key, _ := c.Seek(fromB); key != nil; key, _ = c.Prev() {
+		for key, v := c.Seek(fromB); key != nil; key, v = c.Prev() {
 			var id = binary.BigEndian.Uint64(key)
 			if id > binary.BigEndian.Uint64(fromB) {
 				continue
 			}
 			cl.Logger.Printf("Cleaning up build %d...\n", id)
-			err = os.RemoveAll(filepath.Join(Config.WorkDir, "workspace/", fmt.Sprintf("%d", id)))
+			var msg BuildUpdateData
+			err = json.Unmarshal(v, &msg)
 			if err != nil {
 				cl.Logger.Println(err)
+				removeBuild(tx, int(id), nil, cl.Logger)
+			} else {
+				removeBuild(tx, int(id), &msg, cl.Logger)
 			}
-			err = os.RemoveAll(filepath.Join(Config.WorkDir, "wakespace/", fmt.Sprintf("%d", id)))
+		}
+		return nil
+	})
+	cl.Logger.Printf("Took %s\n", time.Since(started))
+	if err != nil {
+		cl.Logger.Println(err)
+		return
+	}
+	cl.CleanUnusedArtifacts()
+	_, err = cl.ApplyBuildRetention(false)
+	if err != nil {
+		cl.Logger.Println(err)
+	}
+}
+
+// removeBuild removes the workspace, logs, artifacts and records of the build.
+// msg is nil if the build record can't be read. Errors are logged, so the
+// rest of the build data is removed anyway
+func removeBuild(tx *bolt.Tx, id int, msg *BuildUpdateData, logger *log.Logger) {
+	err := os.RemoveAll(filepath.Join(Config.WorkDir, "workspace/", fmt.Sprintf("%d", id)))
+	if err != nil {
+		logger.Println(err)
+	}
+	err = os.RemoveAll(filepath.Join(Config.WorkDir, "wakespace/", fmt.Sprintf("%d", id)))
+	if err != nil {
+		logger.Println(err)
+	}
+	if msg != nil {
+		err = removeJobBuildNumber(tx, msg)
+		if err != nil {
+			logger.Println(err)
+		}
+		if !msg.ArtifactsPurged {
+			err = addArtifactStorage(tx, -artifactsSize(msg))
+			if err != nil {
+				logger.Println(err)
+			}
+		}
+	}
+	err = tx.Bucket(HistoryBucket).Delete(Itob(id))
+	if err != nil {
+		logger.Println(err)
+	}
+	err = tx.Bucket(UsageBucket).Delete(Itob(id))
+	if err != nil {
+		logger.Println(err)
+	}
+	err = removeFromLogIndex(tx, id)
+	if err != nil {
+		logger.Println(err)
+	}
+	err = removeBuildTransitions(tx, id)
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+// CleanUnusedArtifacts removes artifacts of finished builds which were never
+// downloaded during UnusedArtifactsTTL
+func (cl *Cleaner) CleanUnusedArtifacts() {
+	if Config.UnusedArtifactsTTL == "" || GlobalUsage == nil {
+		return
+	}
+	ttl, err := time.ParseDuration(Config.UnusedArtifactsTTL)
+	if err != nil {
+		cl.Logger.Println(err)
+		return
+	}
+	GlobalUsage.Flush()
+	err = DB.Update(func(tx *bolt.Tx) error {
+		hb := tx.Bucket(HistoryBucket)
+		toUpdate := []*BuildUpdateData{}
+		c := hb.Cursor()
+		for key, v := c.First(); key != nil; key, v = c.Next() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
 			if err != nil {
 				cl.Logger.Println(err)
+				continue
+			}
+			if len(msg.BuildArtifacts) == 0 {
+				continue
 			}
-			err = hb.Delete(key)
+			switch msg.Status {
+			case StatusPending, StatusRunning:
+				continue
+			}
+			if time.Since(msg.StartedAt.Add(msg.Duration)) < ttl {
+				continue
+			}
+			if GetBuildUsage(tx, msg.ID).Downloads > 0 {
+				continue
+			}
+			toUpdate = append(toUpdate, &msg)
+		}
+		for _, msg := range toUpdate {
+			cl.Logger.Printf("Removing never downloaded artifacts of build %d...\n", msg.ID)
+			err := purgeArtifacts(hb, msg)
 			if err != nil {
 				cl.Logger.Println(err)
 			}
 		}
 		return nil
 	})
-	cl.Logger.Printf("Took %s\n", time.Since(started))
 	if err != nil {
 		cl.Logger.Println(err)
-		return
 	}
 }
 
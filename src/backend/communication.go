@@ -14,10 +14,22 @@ const MsgTypeInSubscribe = "in:subscribe"
 // MsgTypeInUnsubscribe is incoming message. Means a user has closed build page
 const MsgTypeInUnsubscribe = "in:unsubscribe"
 
+// MsgTypeSequences is sent to a client after it subscribes. Data contains the
+// current sequence number of each message type matching the subscription
+const MsgTypeSequences = "sequences"
+
+// MsgTypeQueueUpdate is sent when the queue is paused or resumed. Data
+// contains QueueStatusData
+const MsgTypeQueueUpdate = "queue:update"
+
 // MsgBroadcast ...
 type MsgBroadcast struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	// Increases with every message of the same type. Clients must ignore
+	// messages which sequence is not higher than the last seen one of the
+	// type, e.g. a stale build:update: which arrived after a newer one
+	Seq uint64 `json:"seq"`
 }
 
 // MsgIncoming ...
@@ -33,11 +45,17 @@ type InSubscribeData struct {
 
 // JobsListData is a format of data that JobsView receives and JobsBucket stores
 type JobsListData struct {
-	Name          string              `json:"name"`
-	Desc          string              `json:"desc"`
-	DefaultParams []map[string]string `json:"defaultParams"`
-	Interval      string              `json:"interval"`
-	Active        string              `json:"active"`
+	Name          string                       `json:"name"`
+	Desc          string                       `json:"desc"`
+	DefaultParams []map[string]string          `json:"defaultParams"`
+	Interval      string                       `json:"interval"`
+	Active        string                       `json:"active"`
+	Presets       map[string]map[string]string `json:"presets"`
+	// Next run of the job scheduled by cron, nil if the job isn't scheduled
+	NextRun *time.Time `json:"next_run,omitempty"`
+	// Active notice pinned by an operator
+	Notice *JobNotice `json:"notice,omitempty"`
+	Group  string     `json:"group,omitempty"`
 }
 
 // TaskStatus contains basic info about a task, used for status updates
@@ -47,6 +65,27 @@ type TaskStatus struct {
 	StartedAt time.Time     `json:"startedAt"`
 	Duration  time.Duration `json:"duration"`
 	Kind      string        `json:"kind"`
+	// The command executed with `bash -c` after expanding variables. Secrets
+	// are redacted
+	Command string `json:"command,omitempty"`
+	// The number of times the task has been run, more than 1 when the task
+	// was retried
+	Attempts int `json:"attempts,omitempty"`
+	// The task failed, but the build continued, see Task.AllowFailure
+	AllowedFailure bool `json:"allowed_failure,omitempty"`
+	// The command ignored SIGTERM or was killed immediately
+	ForceKilled bool          `json:"force_killed,omitempty"`
+	Stop        *TaskStopInfo `json:"stop,omitempty"`
+}
+
+// TaskStopInfo describes how the command of the task was stopped
+type TaskStopInfo struct {
+	Reason string `json:"reason"`
+	// The first signal sent to the command: SIGTERM or SIGKILL
+	Signal      string        `json:"signal"`
+	ForceKilled bool          `json:"force_killed"`
+	ExitedIn    time.Duration `json:"exited_in"` // ns since the first signal
+	startedAt   time.Time
 }
 
 // When StartedAt field is serialized to JSON, it has fixed second's precision
@@ -75,7 +114,9 @@ func (x TaskStatus) MarshalJSON() ([]byte, error) {
 type BuildUpdateData struct {
 	ID             int                 `json:"id"`
 	Name           string              `json:"name"`
+	Template       string              `json:"template,omitempty"` // Name of the job if Name is the name of the job instance
 	Status         ItemStatus          `json:"status"`
+	Revision       int                 `json:"revision"` // Increments with every saved update
 	Tasks          []*TaskStatus       `json:"tasks"`
 	Params         []map[string]string `json:"params"`
 	Artifacts      []string            `json:"artifacts"` // Deprecate in favor of BuildArtifacts
@@ -83,6 +124,87 @@ type BuildUpdateData struct {
 	StartedAt      time.Time           `json:"startedAt"`
 	Duration       time.Duration       `json:"duration"`
 	ETA            int                 `json:"eta"`
+	Trigger        *TriggerInfo        `json:"trigger"`
+	Prerequisites  *PrerequisitesCheck `json:"prerequisites,omitempty"`
+	PendingReason  string              `json:"pending_reason,omitempty"`
+	Labels         map[string]string   `json:"labels,omitempty"`
+	Warnings       []string            `json:"warnings,omitempty"`
+	// Artifacts of other builds fetched with the build token
+	ArtifactFetches []*ArtifactFetch `json:"artifact_fetches,omitempty"`
+	// Sequential number within the job if `job_build_numbers` is enabled
+	JobBuildNumber int `json:"job_build_number,omitempty"`
+	// ID of the previous attempt if the build was started by `retry`
+	RetryOf      int `json:"retry_of,omitempty"`
+	RetryAttempt int `json:"retry_attempt,omitempty"`
+	// FailureCauseInfra if the build failed because of infrastructure
+	FailureCause string `json:"failure_cause,omitempty"`
+	// Number of retries after infrastructure failures, see
+	// `auto_retry_infra_failures`
+	InfraRetryAttempt int `json:"infra_retry_attempt,omitempty"`
+	// Number of duplicate triggers merged into the build, see `dedupe`
+	Deduplicated int `json:"deduplicated,omitempty"`
+	// Some tasks failed, but the build continued, see Task.AllowFailure
+	AllowedFailures bool `json:"allowed_failures,omitempty"`
+	// Artifacts were removed by the retention policy or as never downloaded
+	ArtifactsPurged bool `json:"artifacts_purged,omitempty"`
+	// Collecting of artifacts stopped at `maxartifactsize`, the collected
+	// ones are available
+	ArtifactsTruncated bool `json:"artifacts_truncated,omitempty"`
+	// SHA-256 of the collected artifacts by name, also available as
+	// ArtifactChecksumsFile
+	ArtifactChecksums map[string]string `json:"artifact_checksums,omitempty"`
+	// Builds started by `triggers` of the job when the build finished
+	DownstreamBuilds []int `json:"downstream_builds,omitempty"`
+	// Set by tasks with DirectivePrefix lines
+	Description string   `json:"description,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+	// Files changed by main tasks if `audit_workspace` is enabled
+	WorkspaceAudit *WorkspaceAuditResult `json:"workspace_audit,omitempty"`
+	// Builds created from the same run of the job's `matrix` share the ID of
+	// the first one
+	MatrixBuildID int               `json:"matrix_build_id,omitempty"`
+	Matrix        map[string]string `json:"matrix,omitempty"`
+	// Priority of the build in the queue: low, normal or high
+	Priority string `json:"priority,omitempty"`
+	// Failures of stages after main tasks: artifact collection, on-status
+	// tasks, saving the final record. The status of the build doesn't change
+	FinalizationErrors []string `json:"finalization_errors,omitempty"`
+}
+
+// BuildsListData is a page of the build history
+type BuildsListData struct {
+	Builds []*BuildUpdateData `json:"builds"`
+	// Pass as `cursor` to get the next page, 0 if there are no older builds
+	NextCursor int `json:"next_cursor,omitempty"`
+}
+
+// JobScheduleData is the list of next runs of the job scheduled by cron
+type JobScheduleData struct {
+	Name     string      `json:"name"`
+	Interval string      `json:"interval"`
+	Next     []time.Time `json:"next"`
+	// Next runs of the sweep, see Job.ScheduleMatrix
+	SweepNext []time.Time `json:"sweep_next,omitempty"`
+}
+
+// TriggerInfo describes how the build was started
+type TriggerInfo struct {
+	Kind   string `json:"kind"`
+	Preset string `json:"preset,omitempty"`
+	// Priority requested for the build, it is stored in Build.Priority
+	Priority string `json:"-"`
+	// Build which started the build if Kind is TriggerUpstream
+	UpstreamBuildID int `json:"upstream_build_id,omitempty"`
+	// Jobs of the chain of upstream builds, the closest one is the last
+	UpstreamJobs []string `json:"upstream_jobs,omitempty"`
+}
+
+// JobName returns name of the job file the build was created from
+func (x *BuildUpdateData) JobName() string {
+	if x.Template != "" {
+		return x.Template
+	}
+	return x.Name
 }
 
 // When StartedAt field is serialized to JSON, it has fixed second's precision
@@ -107,11 +229,21 @@ func (x BuildUpdateData) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aliased)
 }
 
+// BuildGroupData is a group of builds with the same label value
+type BuildGroupData struct {
+	Label  string             `json:"label"`
+	Value  string             `json:"value"`
+	Status ItemStatus         `json:"status"`
+	Builds []*BuildUpdateData `json:"builds"`
+}
+
 // CommandLogData ...
 type CommandLogData struct {
-	TaskID int    `json:"taskID"`
-	ID     int    `json:"id"` // ID of a log message
-	Data   string `json:"data"`
+	TaskID    int    `json:"taskID"`
+	Iteration int    `json:"iteration"`
+	Key       string `json:"key"` // Identifier of the log stream, see TaskLogKey
+	ID        int    `json:"id"`  // ID of a log message
+	Data      string `json:"data"`
 }
 
 // SettingsData used for Settings view to allow user to modify settings
@@ -123,4 +255,46 @@ type SettingsData struct {
 // JobData used for editing a job
 type JobData struct {
 	Content string `json:"fileContent"`
+	// Active notice pinned by an operator
+	Notice *JobNotice `json:"notice,omitempty"`
+}
+
+// StatsData describes usage of server resources
+type StatsData struct {
+	// Total size of artifacts of all builds in bytes
+	ArtifactStorage int64 `json:"artifact_storage"`
+	// See `maxartifactstorage`, 0 if unlimited
+	MaxArtifactStorage int64 `json:"max_artifact_storage"`
+}
+
+// UsageStatsData contains usage counters of jobs and builds
+type UsageStatsData struct {
+	Jobs   []*JobUsageData   `json:"jobs"`
+	Builds []*BuildUsageData `json:"builds"`
+}
+
+// JobUsageData contains number of build page views of the job
+type JobUsageData struct {
+	Name  string `json:"name"`
+	Views int    `json:"views"`
+}
+
+// BuildUsageData contains number of artifact downloads of the build
+type BuildUsageData struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Artifacts    int       `json:"artifacts"`
+	Downloads    int       `json:"downloads"`
+	LastDownload time.Time `json:"last_download"`
+}
+
+// QueueStatusData describes load of the queue
+type QueueStatusData struct {
+	Running          int            `json:"running"`
+	Queued           int            `json:"queued"`
+	ConcurrentBuilds int            `json:"concurrent_builds"`
+	Paused           bool           `json:"paused"`
+	Draining         bool           `json:"draining"` // The server is shutting down
+	Reserved         ResourceUsage  `json:"reserved"`
+	Capacity         *ResourceUsage `json:"capacity"` // Nil if unlimited
 }
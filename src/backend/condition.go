@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Conditions in `when` use the syntax of bash `[[`, e.g.
+// `$BRANCH == release/* && ! -z ${TAG}`. Unlike bash, build params can be
+// referenced by the name without `$` on the left side and syntax errors are
+// reported when the job is loaded. Conditions the parser doesn't support,
+// e.g. `-f file`, `$N -lt 5`, `${N:-5}` or `$(cmd)`, are evaluated by bash
+
+// conditionPart is a piece of an operand: literal text, quoted text or a
+// variable
+type conditionPart struct {
+	text     string
+	quoted   bool
+	variable bool
+}
+
+// conditionToken is an operator or an operand of the condition
+type conditionToken struct {
+	op      string
+	operand []conditionPart
+}
+
+// conditionScope resolves variables of the condition
+type conditionScope struct {
+	env    func(string) string
+	params map[string]bool
+}
+
+// conditionNode is a node of the parsed condition
+type conditionNode interface {
+	eval(scope *conditionScope) (bool, error)
+}
+
+type conditionOr struct{ left, right conditionNode }
+
+type conditionAnd struct{ left, right conditionNode }
+
+type conditionNot struct{ node conditionNode }
+
+// conditionTest compares operands. Unary tests (-z, -n) have only right,
+// a single operand is true when it is not empty
+type conditionTest struct {
+	op          string
+	left, right []conditionPart
+}
+
+var conditionVariableRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// tokenizeCondition splits the condition into operators and operands
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	tokens := make([]conditionToken, 0)
+	for pos := 0; pos < len(expr); {
+		if expr[pos] == ' ' || expr[pos] == '\t' {
+			pos++
+			continue
+		}
+		op := ""
+		for _, candidate := range []string{"&&", "||", "==", "!=", "=~", "=", "!", "(", ")"} {
+			if strings.HasPrefix(expr[pos:], candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" && (strings.HasPrefix(expr[pos:], "-z") || strings.HasPrefix(expr[pos:], "-n")) &&
+			(pos+2 == len(expr) || expr[pos+2] == ' ') {
+			op = expr[pos : pos+2]
+		}
+		if op != "" {
+			pos += len(op)
+			if op == "=" {
+				op = "=="
+			}
+			tokens = append(tokens, conditionToken{op: op})
+			if op == "=~" {
+				// Unquoted regular expression can contain (, | and other
+				// operators, as in bash it lasts until a space
+				for pos < len(expr) && expr[pos] == ' ' {
+					pos++
+				}
+				end := pos
+				for end < len(expr) && expr[end] != ' ' && expr[end] != '\'' && expr[end] != '"' {
+					end++
+				}
+				if end > pos && (end == len(expr) || expr[end] == ' ') {
+					tokens = append(tokens, conditionToken{operand: []conditionPart{{text: expr[pos:end]}}})
+					pos = end
+				}
+			}
+			continue
+		}
+
+		operand := make([]conditionPart, 0)
+		for pos < len(expr) && !strings.ContainsRune(" \t()&|=!", rune(expr[pos])) {
+			switch expr[pos] {
+			case '\'':
+				end := strings.IndexByte(expr[pos+1:], '\'')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated string at position %d", pos)
+				}
+				operand = append(operand, conditionPart{text: expr[pos+1 : pos+1+end], quoted: true})
+				pos += end + 2
+			case '"':
+				end := strings.IndexByte(expr[pos+1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated string at position %d", pos)
+				}
+				// Variables are expanded in double quotes
+				quoted := expr[pos+1 : pos+1+end]
+				for len(quoted) > 0 {
+					idx := strings.IndexByte(quoted, '$')
+					if idx < 0 {
+						idx = len(quoted)
+					}
+					if idx > 0 {
+						operand = append(operand, conditionPart{text: quoted[:idx], quoted: true})
+					}
+					quoted = quoted[idx:]
+					if len(quoted) > 0 {
+						name, size, err := parseConditionVariable(quoted)
+						if err != nil {
+							return nil, err
+						}
+						operand = append(operand, conditionPart{text: name, quoted: true, variable: true})
+						quoted = quoted[size:]
+					}
+				}
+				if end == 0 {
+					operand = append(operand, conditionPart{quoted: true})
+				}
+				pos += end + 2
+			case '$':
+				name, size, err := parseConditionVariable(expr[pos:])
+				if err != nil {
+					return nil, err
+				}
+				operand = append(operand, conditionPart{text: name, variable: true})
+				pos += size
+			default:
+				end := pos
+				for end < len(expr) && !strings.ContainsRune(" \t()&|=!'\"$", rune(expr[end])) {
+					end++
+				}
+				operand = append(operand, conditionPart{text: expr[pos:end]})
+				pos = end
+			}
+		}
+		if len(operand) == 0 {
+			return nil, fmt.Errorf("unexpected '%c' at position %d", expr[pos], pos)
+		}
+		tokens = append(tokens, conditionToken{operand: operand})
+	}
+	return tokens, nil
+}
+
+// parseConditionVariable parses $NAME or ${NAME} at the start of the string
+// and returns the name and the length of the reference
+func parseConditionVariable(str string) (string, int, error) {
+	if strings.HasPrefix(str, "${") {
+		end := strings.IndexByte(str, '}')
+		if end < 2 || conditionVariableRegex.FindString(str[2:end]) != str[2:end] {
+			return "", 0, fmt.Errorf("invalid variable reference %s", str)
+		}
+		return str[2:end], end + 1, nil
+	}
+	name := conditionVariableRegex.FindString(str[1:])
+	if name == "" {
+		return "", 0, fmt.Errorf("invalid variable reference %s", str)
+	}
+	return name, len(name) + 1, nil
+}
+
+// conditionParser is a recursive descent parser of the condition. Operators
+// have the same precedence as in bash: !, &&, ||
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+// parseCondition parses the condition and returns its syntax errors
+func parseCondition(expr string) (conditionNode, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("condition is empty")
+	}
+	parser := &conditionParser{tokens: tokens}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(tokens) {
+		return nil, fmt.Errorf("unexpected %s", parser.describe(parser.pos))
+	}
+	return node, nil
+}
+
+// describe returns the token for error messages
+func (p *conditionParser) describe(pos int) string {
+	if pos >= len(p.tokens) {
+		return "end of the condition"
+	}
+	if p.tokens[pos].op != "" {
+		return "'" + p.tokens[pos].op + "'"
+	}
+	return "operand " + conditionOperandSource(p.tokens[pos].operand)
+}
+
+// peekOp returns the operator of the current token or an empty string
+func (p *conditionParser) peekOp() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos].op
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	switch p.peekOp() {
+	case "!":
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionNot{node: node}, nil
+	case "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekOp() != ")" {
+			return nil, fmt.Errorf("expected ')', got %s", p.describe(p.pos))
+		}
+		p.pos++
+		return node, nil
+	case "-z", "-n":
+		op := p.peekOp()
+		p.pos++
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionTest{op: op, right: operand}, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peekOp(); op {
+	case "==", "!=", "=~":
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if op == "=~" && !conditionHasVariables(right) {
+			_, err = regexp.Compile(conditionPattern(right, false, &conditionScope{}))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %s: %w", conditionOperandSource(right), err)
+			}
+		}
+		return &conditionTest{op: op, left: left, right: right}, nil
+	}
+	return &conditionTest{left: left}, nil
+}
+
+func (p *conditionParser) parseOperand() ([]conditionPart, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].op != "" {
+		return nil, fmt.Errorf("expected operand, got %s", p.describe(p.pos))
+	}
+	p.pos++
+	return p.tokens[p.pos-1].operand, nil
+}
+
+func (n *conditionOr) eval(scope *conditionScope) (bool, error) {
+	left, err := n.left.eval(scope)
+	if err != nil || left {
+		return left, err
+	}
+	return n.right.eval(scope)
+}
+
+func (n *conditionAnd) eval(scope *conditionScope) (bool, error) {
+	left, err := n.left.eval(scope)
+	if err != nil || !left {
+		return left, err
+	}
+	return n.right.eval(scope)
+}
+
+func (n *conditionNot) eval(scope *conditionScope) (bool, error) {
+	result, err := n.node.eval(scope)
+	return !result, err
+}
+
+func (n *conditionTest) eval(scope *conditionScope) (bool, error) {
+	switch n.op {
+	case "-z":
+		return scope.value(n.right, true) == "", nil
+	case "-n":
+		return scope.value(n.right, true) != "", nil
+	case "==", "!=":
+		// Unquoted right operand is a glob pattern, as in bash
+		matched := regexp.MustCompile(
+			"^(?s:" + conditionPattern(n.right, true, scope) + ")$",
+		).MatchString(scope.value(n.left, true))
+		return matched == (n.op == "=="), nil
+	case "=~":
+		re, err := regexp.Compile(conditionPattern(n.right, false, scope))
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return re.MatchString(scope.value(n.left, true)), nil
+	}
+	return scope.value(n.left, true) != "", nil
+}
+
+// value returns the value of the operand. With bareParams a single unquoted
+// word which is the name of a build param is the value of the param. Only
+// operands on the left side are resolved this way, so `$BRANCH == main` keeps
+// its meaning if there is a param called main
+func (s *conditionScope) value(operand []conditionPart, bareParams bool) string {
+	if bareParams && len(operand) == 1 && !operand[0].quoted && !operand[0].variable && s.params[operand[0].text] {
+		return s.env(operand[0].text)
+	}
+	var value strings.Builder
+	for _, part := range operand {
+		if part.variable {
+			value.WriteString(s.env(part.text))
+		} else {
+			value.WriteString(part.text)
+		}
+	}
+	return value.String()
+}
+
+// conditionPattern returns the regular expression of the operand. Quoted
+// parts match literally, unquoted parts are glob patterns or regular
+// expressions
+func conditionPattern(operand []conditionPart, glob bool, scope *conditionScope) string {
+	var pattern strings.Builder
+	for _, part := range operand {
+		text := part.text
+		if part.variable {
+			text = scope.env(part.text)
+		}
+		switch {
+		case part.quoted || (glob && part.variable):
+			pattern.WriteString(regexp.QuoteMeta(text))
+		case glob:
+			pattern.WriteString(globToRegexp(text))
+		default:
+			pattern.WriteString(text)
+		}
+	}
+	return pattern.String()
+}
+
+// globToRegexp converts *, ? and [...] of the glob to a regular expression
+func globToRegexp(glob string) string {
+	var pattern strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				pattern.WriteString(regexp.QuoteMeta(glob[i:]))
+				return pattern.String()
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			pattern.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return pattern.String()
+}
+
+// conditionHasVariables returns true if the operand refers to variables
+func conditionHasVariables(operand []conditionPart) bool {
+	for _, part := range operand {
+		if part.variable {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionOperandSource returns the operand as it is written in the condition
+func conditionOperandSource(operand []conditionPart) string {
+	var source strings.Builder
+	for _, part := range operand {
+		switch {
+		case part.variable:
+			source.WriteString("${" + part.text + "}")
+		case part.quoted:
+			source.WriteString(`"` + part.text + `"`)
+		default:
+			source.WriteString(part.text)
+		}
+	}
+	return source.String()
+}
+
+// evaluateCondition evaluates the `when` condition. env resolves variables,
+// bare words on the left side which are in params are resolved as variables
+// too
+func evaluateCondition(expr string, env func(string) string, params map[string]bool) (bool, error) {
+	node, err := parseCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	return node.eval(&conditionScope{env: env, params: params})
+}
+
+// evaluateBashCondition evaluates the condition with bash `[[` in the task
+// environment. Non-zero exit code means the condition is false. `[[` is bash
+// syntax, so Job.Shell isn't used
+func evaluateBashCondition(expr string, env []string, dir string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), WHEN_EVAL_TIMEOUT*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "bash", "-c", fmt.Sprintf("[[ %s ]]", expr))
+	cmd.Env = env
+	cmd.Dir = dir
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("condition timed out after %ds", WHEN_EVAL_TIMEOUT)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// evaluateTaskCondition evaluates the `when` condition of the task. The
+// condition which parseCondition can't parse is evaluated by bash
+func evaluateTaskCondition(expr string, env []string, dir string, params map[string]bool) (bool, error) {
+	node, err := parseCondition(expr)
+	if err != nil {
+		return evaluateBashCondition(expr, env, dir)
+	}
+	return node.eval(&conditionScope{env: getEnvMapper(env), params: params})
+}
+
+// verifyBashCondition checks syntax of the condition with `bash -n`. Bash
+// reports syntax errors of `[[` with zero exit code, so any output is an error
+func verifyBashCondition(expr string) error {
+	out, err := exec.Command("bash", "-n", "-c", fmt.Sprintf("[[ %s ]]", expr)).CombinedOutput()
+	if err != nil || len(out) != 0 {
+		return fmt.Errorf("bash: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Used to verify `when` conditions of tasks before saving after editing.
+// Conditions the parser can't parse are accepted if bash can parse them
+func (j *Job) verifyTaskConditions() error {
+	for _, task := range j.Tasks {
+		if task.When == "" {
+			continue
+		}
+		_, err := parseCondition(task.When)
+		if err == nil {
+			continue
+		}
+		if verifyBashCondition(task.When) != nil {
+			return fmt.Errorf("invalid `when` condition of task %s: %s: %w", task.Name, task.When, err)
+		}
+	}
+	return nil
+}
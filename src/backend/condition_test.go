@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	env := map[string]string{"BRANCH": "release/1.2", "DEPLOY": "true", "USER": "joe", "EMPTY": ""}
+	params := map[string]bool{"BRANCH": true, "DEPLOY": true}
+	for expr, expected := range map[string]bool{
+		`$USER == joe`:                       true,
+		`${USER} = "joe"`:                    true,
+		`DEPLOY == "true"`:                   true,
+		`USER == joe`:                        false,
+		`BRANCH == release/*`:                true,
+		`BRANCH == "release/*"`:              false,
+		`$BRANCH != main`:                    true,
+		`"$BRANCH" == "release/$EMPTY"1.2`:   true,
+		`$BRANCH =~ ^release/[0-9]+\.(1|2)$`: true,
+		`-z $EMPTY && -n $USER`:              true,
+		`! -z $EMPTY`:                        false,
+		`$EMPTY`:                             false,
+		`BRANCH == main || DEPLOY == true`:   true,
+		`BRANCH == main || DEPLOY == true && !(USER == joe)`: true,
+		`(BRANCH == main || DEPLOY == true) && $USER != joe`: false,
+	} {
+		result, err := evaluateCondition(expr, func(name string) string { return env[name] }, params)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", expr, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("Expected %t for %s, got %t", expected, expr, result)
+		}
+	}
+}
+
+func TestEvaluateCondition_BareParamNames(t *testing.T) {
+	env := map[string]string{"ENV": "main", "main": "release"}
+	// Bare names are resolved only on the left side
+	params := map[string]bool{"ENV": true, "main": true}
+	for expr, expected := range map[string]bool{
+		`$ENV == main`:    true,
+		`ENV == main`:     true,
+		`$ENV == release`: false,
+		`main == release`: true,
+		`$ENV =~ ^main$`:  true,
+		`-n main`:         true,
+	} {
+		result, err := evaluateCondition(expr, func(name string) string { return env[name] }, params)
+		if err != nil || result != expected {
+			t.Errorf("Expected %t for %s, got %t %v", expected, expr, result, err)
+		}
+	}
+}
+
+func TestEvaluateTaskCondition_Bash(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/file.txt", []byte("ok"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"N=3", "NAME=abc", "EQ=a=b"}
+	for expr, expected := range map[string]bool{
+		`-f file.txt`:                  true,
+		`-f missing.txt`:               false,
+		`-d file.txt`:                  false,
+		`$N -lt 5 && $N -ge 3`:         true,
+		`$N -eq 4`:                     false,
+		`$NAME < abd`:                  true,
+		`$(echo $NAME) == abc`:         true,
+		`-f file.txt && $NAME == ab*`:  true,
+		`$NAME == abc || -d file.txt`:  true,
+		`! -f file.txt || $N -gt 2`:    true,
+		`-e file.txt && $NAME == xyz*`: false,
+		// Parameter expansion and operands with = aren't parsed
+		`${DEPLOY:-false} == true`: false,
+		`${NAME:-x} == abc`:        true,
+		`$NAME == a=b`:             false,
+		`$EQ == a=b`:               true,
+		`"$EQ" != "a=b"`:           false,
+	} {
+		job := &Job{Tasks: []*Task{{Name: "hello", When: expr}}}
+		if err := job.verifyTaskConditions(); err != nil {
+			t.Errorf("Unexpected error for %s: %s", expr, err)
+			continue
+		}
+		result, err := evaluateTaskCondition(expr, env, dir, map[string]bool{"N": true})
+		if err != nil || result != expected {
+			t.Errorf("Expected %t for %s, got %t %v", expected, expr, result, err)
+		}
+	}
+}
+
+func TestVerifyTaskConditions(t *testing.T) {
+	for _, expr := range []string{
+		`$USER ==`,
+		`== joe`,
+		`($USER == joe`,
+		`$USER == joe)`,
+		`$USER = = joe`,
+		`foo bar`,
+		`$USER == "joe`,
+		`${USER == joe`,
+		`$USER =~ (`,
+		`$USER & joe`,
+		// Invalid conditions which are evaluated by bash
+		`$N -lt`,
+		`-f a b`,
+		`$A <`,
+	} {
+		job := &Job{Tasks: []*Task{{Name: "hello", When: expr}}}
+		if job.verifyTaskConditions() == nil {
+			t.Errorf("Expected syntax error for %s", expr)
+		}
+	}
+}
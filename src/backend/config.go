@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -24,8 +26,64 @@ type WakeConfig struct {
 	SecretsFile string `yaml:"secretsfile"`
 	// Map of secrets retrieved from SecretsFile
 	secrets map[string]string
+	// Path to the file with keys to encrypt sensitive values in the database
+	SecretsKeyFile string `yaml:"secretskeyfile"`
 	// Timezone for cron jobs (`interval` field in job files)
 	Timezone string `yaml:"timezone"`
+	// Disable collecting of artifact downloads and build page views counters
+	DisableUsageStats bool `yaml:"disableusagestats"`
+	// Remove artifacts which were never downloaded after this period of time
+	UnusedArtifactsTTL string `yaml:"unusedartifactsttl"`
+	// Remove artifacts of builds completed earlier than this period of time,
+	// e.g. 720h, or keep artifacts only of the latest N builds of each job
+	ArtifactRetention string `yaml:"artifactretention"`
+	// How often expired artifacts are removed, 1h by default
+	ArtifactRetentionInterval string `yaml:"artifactretentioninterval"`
+	// Remove builds completed more than this number of days ago, see
+	// BuildRetention. Disabled if 0
+	KeepBuildsDays int `yaml:"keepbuildsdays"`
+	// Remove builds of each job except the latest N completed ones. Disabled
+	// if 0
+	KeepBuildsCount int `yaml:"keepbuildscount"`
+	// Maximum total size of artifacts of a build, e.g. 5GB. Unlimited if empty
+	MaxArtifactSize string `yaml:"maxartifactsize"`
+	// Maximum total size of artifacts of all builds, e.g. 100GB. Unlimited if
+	// empty
+	MaxArtifactStorage string `yaml:"maxartifactstorage"`
+	// Default disk quota of the build workspace, e.g. 10GB
+	DiskQuota string `yaml:"diskquota"`
+	// Default period to verify disk quota of the build workspace
+	DiskQuotaInterval string `yaml:"diskquotainterval"`
+	// Default number of times a build which failed because of infrastructure
+	// is started again, see Job.AutoRetryInfraFailures
+	AutoRetryInfraFailures int `yaml:"autoretryinfrafailures"`
+	// SHA-256 hashes of API keys accepted in `Authorization: Bearer <key>`
+	// header, see GenerateAPIKeyCommand
+	APIKeys []string `yaml:"apikeys"`
+	// Serve /healthz and /metrics without authentication
+	PublicMonitoring bool `yaml:"publicmonitoring"`
+	// Serve status badges of jobs without authentication
+	PublicBadges bool `yaml:"publicbadges"`
+	// Path to the SQLite database which mirrors completed builds. Disabled if
+	// empty
+	SQLiteExport string `yaml:"sqliteexport"`
+	// Resources of the host shared by running builds which reserve them with
+	// `resources` in the job file. Unlimited if empty
+	Capacity *Resources `yaml:"capacity"`
+	// Regular expressions of volatile parts of log lines (timestamps, hosts)
+	// ignored when logs of two builds are compared
+	LogDiffIgnore []string `yaml:"logdiffignore"`
+	// Address of the listener for health check, metrics and pprof, e.g.
+	// 127.0.0.1:9090. They are served on the main port if empty
+	InternalAddr string `yaml:"internaladdr"`
+	// How long running builds are waited for on shutdown before they are
+	// aborted, e.g. 5m. One minute by default
+	ShutdownTimeout string `yaml:"shutdowntimeout"`
+	// Slack incoming webhook which receives statuses listed in `notify_on` of
+	// the job
+	SlackWebhookURL string `yaml:"slackwebhookurl"`
+	// Server which sends emails to `email_to` of jobs
+	SMTP *SMTPConfig `yaml:"smtp"`
 }
 
 // CreateWakeConfig creates new config instance
@@ -64,6 +122,90 @@ func CreateWakeConfig(path string) (*WakeConfig, error) {
 
 	config.jobsExt = ".yaml"
 
+	if config.UnusedArtifactsTTL != "" {
+		_, err := time.ParseDuration(config.UnusedArtifactsTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := ParseArtifactRetention(config.ArtifactRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ArtifactRetentionInterval != "" {
+		_, err := time.ParseDuration(config.ArtifactRetentionInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = BuildRetention{Days: config.KeepBuildsDays, Count: config.KeepBuildsCount}.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxArtifactSize != "" {
+		_, err := ParseSize(config.MaxArtifactSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxArtifactStorage != "" {
+		_, err := ParseSize(config.MaxArtifactStorage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DiskQuota != "" {
+		_, err := ParseSize(config.DiskQuota)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DiskQuotaInterval != "" {
+		_, err := time.ParseDuration(config.DiskQuotaInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ShutdownTimeout != "" {
+		_, err := time.ParseDuration(config.ShutdownTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = verifyAPIKeys(config.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySlackWebhookURL(config.SlackWebhookURL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.SMTP.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = config.Capacity.usage()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = CompileLogDiffPatterns(config.LogDiffIgnore)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load secrets
 	if config.SecretsFile != "" {
 		Logger.Printf("Loading secrets from: %s\n", config.SecretsFile)
@@ -89,6 +231,53 @@ func CreateWakeConfig(path string) (*WakeConfig, error) {
 	if !filepath.IsAbs(config.JobDir) {
 		config.JobDir = filepath.Join(cwd, config.JobDir) + "/"
 	}
-	Logger.Printf("Current config: %+v\n", config)
+	Logger.Printf("Current config: %+v\n", config.redacted())
 	return &config, nil
 }
+
+// redacted returns a copy of the config which is safe to log: secrets, the
+// Slack webhook, hashes of API keys and the SMTP password are replaced
+func (c WakeConfig) redacted() WakeConfig {
+	c.secrets = nil
+	if c.SlackWebhookURL != "" {
+		c.SlackWebhookURL = redactedSecret
+	}
+	if len(c.APIKeys) > 0 {
+		c.APIKeys = []string{fmt.Sprintf("%d keys %s", len(c.APIKeys), redactedSecret)}
+	}
+	if c.SMTP != nil {
+		smtp := *c.SMTP
+		if smtp.Password != "" {
+			smtp.Password = redactedSecret
+		}
+		c.SMTP = &smtp
+	}
+	return c
+}
+
+// getMaxArtifactSize returns the maximum total size of artifacts of a build in
+// bytes, 0 if unlimited
+func (c *WakeConfig) getMaxArtifactSize() int64 {
+	if c.MaxArtifactSize == "" {
+		return 0
+	}
+	size, err := ParseSize(c.MaxArtifactSize)
+	if err != nil {
+		Logger.Println(err)
+		return 0
+	}
+	return size
+}
+
+// getArtifactRetentionInterval returns how often expired artifacts are removed
+func (c *WakeConfig) getArtifactRetentionInterval() time.Duration {
+	if c.ArtifactRetentionInterval == "" {
+		return DefaultArtifactRetentionInterval
+	}
+	d, err := time.ParseDuration(c.ArtifactRetentionInterval)
+	if err != nil {
+		Logger.Println(err)
+		return DefaultArtifactRetentionInterval
+	}
+	return d
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWakeConfigRedacted(t *testing.T) {
+	config := WakeConfig{
+		Port:            "8081",
+		secrets:         map[string]string{"TOKEN": "t0ken"},
+		SlackWebhookURL: "https://hooks.slack.com/services/T000/B000/s3cr3t",
+		APIKeys:         []string{HashAPIKey("k3y")},
+		SMTP:            &SMTPConfig{Host: "smtp.example.com", Password: "p4ss"},
+	}
+	logged := fmt.Sprintf("%+v %+v", config.redacted(), *config.redacted().SMTP)
+	for _, secret := range []string{"t0ken", "s3cr3t", HashAPIKey("k3y"), "p4ss"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("Secret %s is logged: %s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, "Port:8081") || !strings.Contains(logged, "smtp.example.com") {
+		t.Errorf("Expected other fields to be logged: %s", logged)
+	}
+	if config.secrets["TOKEN"] != "t0ken" || config.SMTP.Password != "p4ss" || config.SlackWebhookURL == redactedSecret {
+		t.Error("The config is modified")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// taskResult is the status of a task of the dependency graph
+type taskResult struct {
+	task   *Task
+	status ItemStatus
+}
+
+// hasTaskDependencies returns true if main tasks form a dependency graph:
+// tasks have `depends_on` or the job has workflow stages
+func (j *Job) hasTaskDependencies() bool {
+	return j.hasDependsOn() || len(j.Workflow) > 0
+}
+
+// hasDependsOn returns true if any main task has `depends_on`
+func (j *Job) hasDependsOn() bool {
+	for _, task := range j.Tasks {
+		if task.Kind == KindMain && len(task.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mainTaskDependencies returns main tasks and the tasks each of them waits
+// for: tasks listed in `depends_on` and all tasks of the previous workflow
+// stage. Tasks which aren't in any stage aren't ordered by stages
+func (j *Job) mainTaskDependencies() ([]*Task, map[*Task][]*Task) {
+	tasks := make([]*Task, 0)
+	byName := make(map[string]*Task)
+	byID := make(map[int]*Task)
+	for _, task := range j.Tasks {
+		if task.Kind == KindMain {
+			tasks = append(tasks, task)
+			byName[task.Name] = task
+			byID[task.ID] = task
+		}
+	}
+	dependencies := make(map[*Task][]*Task)
+	for _, task := range tasks {
+		for _, name := range task.DependsOn {
+			if dependency, ok := byName[name]; ok {
+				dependencies[task] = append(dependencies[task], dependency)
+			}
+		}
+	}
+	var previous []*Task
+	for _, stage := range j.Workflow {
+		current := make([]*Task, 0, len(stage.Tasks))
+		for _, id := range stage.Tasks {
+			if task, ok := byID[id]; ok {
+				current = append(current, task)
+			}
+		}
+		if len(current) == 0 {
+			continue
+		}
+		for _, task := range current {
+			dependencies[task] = append(dependencies[task], previous...)
+		}
+		previous = current
+	}
+	return tasks, dependencies
+}
+
+// runTaskGraph runs main tasks as soon as their dependencies are completed,
+// at most workers at the same time, and returns the status of the build.
+// Tasks which depend on a failed task are skipped, other tasks continue
+func (b *Build) runTaskGraph(workers int) ItemStatus {
+	tasks, dependencies := b.Job.mainTaskDependencies()
+	// The number of dependencies which aren't completed yet
+	waiting := make(map[*Task]int)
+	dependents := make(map[*Task][]*Task)
+	ready := make([]*Task, 0)
+	for _, task := range tasks {
+		waiting[task] = len(dependencies[task])
+		for _, dependency := range dependencies[task] {
+			dependents[dependency] = append(dependents[dependency], task)
+		}
+		if len(dependencies[task]) == 0 {
+			ready = append(ready, task)
+		}
+	}
+
+	b.mutex.Lock()
+	b.parallelTasks = make(map[int]*taskChannels)
+	b.parallelAbortReason = ""
+	b.parallelFailed = false
+	b.mutex.Unlock()
+
+	done := make(chan struct{})
+	go b.forwardAbortRequests(done)
+
+	results := make(chan taskResult)
+	running := 0
+	failed := false
+	for {
+		for running < workers && len(ready) > 0 && !b.isParallelRunStopped() {
+			task := ready[0]
+			ready = ready[1:]
+			running++
+			go func(task *Task) {
+				results <- taskResult{task: task, status: b.runParallelTask(task)}
+			}(task)
+		}
+		if running == 0 {
+			break
+		}
+		result := <-results
+		running--
+		switch {
+		case result.status == StatusFinished, result.status == StatusSkipped,
+			result.status == StatusFailed && result.task.AllowFailure:
+			for _, dependent := range dependents[result.task] {
+				waiting[dependent]--
+				if waiting[dependent] == 0 {
+					ready = append(ready, dependent)
+				}
+			}
+		case result.status != StatusPending:
+			failed = true
+			b.skipDependentTasks(result.task, dependents)
+		}
+	}
+	close(done)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.parallelTasks = nil
+	if b.parallelAbortReason != "" {
+		return ItemStatus(b.parallelAbortReason)
+	}
+	if failed {
+		return StatusFailed
+	}
+	return StatusFinished
+}
+
+// skipDependentTasks marks all tasks which directly or indirectly depend on
+// the failed task as skipped
+func (b *Build) skipDependentTasks(failed *Task, dependents map[*Task][]*Task) {
+	for _, task := range dependents[failed] {
+		b.mutex.Lock()
+		skipped := task.Status == StatusSkipped
+		task.Status = StatusSkipped
+		b.mutex.Unlock()
+		if skipped {
+			continue
+		}
+		b.Logger.Printf("Skipping task %d, task %d has failed\n", task.ID, failed.ID)
+		b.BroadcastUpdate()
+		b.skipDependentTasks(task, dependents)
+	}
+}
+
+// Used to verify task dependencies before saving after editing
+func (j *Job) verifyTaskDependencies() error {
+	byName := make(map[string]*Task)
+	duplicates := make(map[string]bool)
+	hasDependencies := false
+	for _, task := range j.Tasks {
+		if task.Kind != "" && task.Kind != KindMain {
+			if len(task.DependsOn) > 0 {
+				return fmt.Errorf("depends_on can be used only by main tasks, task %s is %s", task.Name, task.Kind)
+			}
+			continue
+		}
+		if _, ok := byName[task.Name]; ok {
+			duplicates[task.Name] = true
+		}
+		byName[task.Name] = task
+		if len(task.DependsOn) > 0 {
+			hasDependencies = true
+		}
+	}
+	if !hasDependencies {
+		return nil
+	}
+	for _, task := range j.Tasks {
+		if task.Kind != "" && task.Kind != KindMain {
+			continue
+		}
+		if task.Group != "" {
+			return fmt.Errorf("group and depends_on can't be used in the same job")
+		}
+		for _, name := range task.DependsOn {
+			if _, ok := byName[name]; !ok {
+				return fmt.Errorf("task %s depends on unknown task %s", task.Name, name)
+			}
+			if duplicates[name] {
+				return fmt.Errorf("task %s depends on %s, but several tasks have this name", task.Name, name)
+			}
+		}
+	}
+
+	// Depth-first search, the path contains the tasks being visited
+	visited := make(map[string]bool)
+	path := make([]string, 0)
+	var visit func(name string) error
+	visit = func(name string) error {
+		for idx, pathName := range path {
+			if pathName == name {
+				cycle := append(path[idx:], name)
+				return fmt.Errorf("task dependencies form a cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if visited[name] {
+			return nil
+		}
+		path = append(path, name)
+		for _, dependency := range byName[name].DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		visited[name] = true
+		return nil
+	}
+	for _, task := range j.Tasks {
+		if task.Kind != "" && task.Kind != KindMain {
+			continue
+		}
+		if err := visit(task.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
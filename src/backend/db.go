@@ -19,6 +19,8 @@ import (
 // | desc          | New job |
 // | interval      |         |
 // | active        | true    |
+// | views         | 12      |
+// | presets       | null    |
 var JobsBucket = []byte("jobs")
 
 // GlobalBucket contains information about global configuration
@@ -28,6 +30,42 @@ var GlobalBucket = []byte("global")
 // HistoryBucket contains information about all executed builds
 var HistoryBucket = []byte("history")
 
+// TransitionsBucket contains status transitions of builds. Sub-bucket per id
+// of the build: sequence -> JSON BuildTransition
+var TransitionsBucket = []byte("transitions")
+
+// UsageBucket contains usage counters of builds, e.g. number of artifact
+// downloads. Key is the id of the build
+var UsageBucket = []byte("usage")
+
+// SecretsBucket contains sensitive values persisted by wakeci, e.g. tokens.
+// All values (including values in sub-buckets) are encrypted with the secrets
+// key, see PutSecretValue
+var SecretsBucket = []byte("secrets")
+
+// JobCountersBucket contains job scoped build numbers, see
+// `job_build_numbers`. Sub-bucket per job name:
+// - count: the latest number
+// - number -> id of the build
+var JobCountersBucket = []byte("jobcounters")
+
+// ParamSuggestionsBucket contains recently used values of params. Sub-bucket
+// per job name: param name -> JSON list of values, the most recent first
+var ParamSuggestionsBucket = []byte("paramsuggestions")
+
+// MaintenanceBucket contains maintenance windows: id -> JSON
+// MaintenanceWindow
+var MaintenanceBucket = []byte("maintenance")
+
+// LogIndexBucket is an inverted index of task logs of the latest builds
+// - terms: term -> concatenated ids of builds which logs contain the term
+// - builds: id of the build -> job name and indexed terms
+var LogIndexBucket = []byte("logindex")
+
+// LogIndexTermsKey and LogIndexBuildsKey are sub-buckets of LogIndexBucket
+var LogIndexTermsKey = []byte("terms")
+var LogIndexBuildsKey = []byte("builds")
+
 // ByteToInt convert byte to int via string
 func ByteToInt(b []byte) (int, error) {
 	bs := string(b)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// DeduplicatedHeader is set in responses which contain ID of the existing
+// pending build instead of a new one, see Job.Dedupe
+const DeduplicatedHeader = "X-Wake-Deduplicated"
+
+// DuplicateBuildError is returned instead of creating a build of the job with
+// `dedupe` when a pending build of the job has the same params
+type DuplicateBuildError struct {
+	Build *Build
+}
+
+func (e *DuplicateBuildError) Error() string {
+	return fmt.Sprintf("build %d of job %s with the same params is already pending", e.Build.ID, e.Build.Job.Name)
+}
+
+// flatParams returns values of params of the build
+func (b *Build) flatParams() map[string]string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	values := map[string]string{}
+	for idx := range b.Params {
+		for pkey, pval := range b.Params[idx] {
+			values[pkey] = pval
+		}
+	}
+	return values
+}
+
+// FindPendingDuplicate returns the pending build of the job with the same
+// values of params or nil
+func (q *Queue) FindPendingDuplicate(jobName string, values map[string]string) *Build {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, b := range q.queued {
+		if b.Job.Name != jobName || b.isAbortRequested() {
+			continue
+		}
+		if reflect.DeepEqual(b.flatParams(), values) {
+			return b
+		}
+	}
+	return nil
+}
+
+// addDeduplicated counts the trigger which was merged into the pending build
+func (b *Build) addDeduplicated(triggeredBy string) {
+	b.mutex.Lock()
+	b.Deduplicated++
+	b.mutex.Unlock()
+	b.Logger.Printf("Duplicate build triggered by %s is merged into the build\n", triggeredBy)
+	b.BroadcastUpdate()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDedupe(t *testing.T) {
+	setupTestEnv(t)
+	err := os.WriteFile(Config.JobDir+"dedupe.yaml", []byte("dedupe: true\nparams:\n  - COMMIT: \"\"\ntasks:\n  - run: \"true\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("dedupe"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Builds stay pending
+	err = GlobalQueue.SetPaused(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := RunJob("dedupe", url.Values{"COMMIT": {"abc"}}, TriggerWebhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunJob("dedupe", url.Values{"COMMIT": {"abc"}}, TriggerWebhook)
+	var duplicateErr *DuplicateBuildError
+	if !errors.As(err, &duplicateErr) || duplicateErr.Build != first {
+		t.Fatalf("Expected the pending build to be returned, got %v", err)
+	}
+	other, err := RunJob("dedupe", url.Values{"COMMIT": {"def"}}, TriggerWebhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Error("Expected a new build for different params")
+	}
+
+	router := chi.NewRouter()
+	router.Post("/job/{name}/run", HandleRunJob)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/job/dedupe/run?COMMIT=abc", nil))
+	if w.Code != http.StatusOK || w.Body.String() != strconv.Itoa(first.ID) || w.Header().Get(DeduplicatedHeader) != "true" {
+		t.Errorf("Expected ID of the pending build, got %d %s %v", w.Code, w.Body.String(), w.Header())
+	}
+	if data := first.GenerateBuildUpdateData(); data.Deduplicated != 2 {
+		t.Errorf("Expected 2 merged triggers, got %d", data.Deduplicated)
+	}
+	if _, queued := GlobalQueue.Count(); queued != 2 {
+		t.Errorf("Expected 2 pending builds, got %d", queued)
+	}
+
+	err = GlobalQueue.SetPaused(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, first, 5*time.Second, StatusFinished)
+	waitForTerminalState(t, other, 5*time.Second, StatusFinished)
+	// Completed builds are not reused
+	next, err := RunJob("dedupe", url.Values{"COMMIT": {"abc"}}, TriggerWebhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, next, 5*time.Second, StatusFinished)
+}
+
+func TestDedupe_Concurrent(t *testing.T) {
+	setupTestEnv(t)
+	putTestJob(t, "dedupe", "dedupe: true\nparams:\n  - COMMIT: \"\"\ntasks:\n  - run: \"true\"\n")
+	err := GlobalQueue.SetPaused(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deliveries of the same webhook
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	builds := make([]*Build, 10)
+	errs := make([]error, 10)
+	for i := range builds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			builds[i], errs[i] = RunJob("dedupe", url.Values{"COMMIT": {"abc"}}, TriggerWebhook)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	var created *Build
+	for i, err := range errs {
+		var duplicateErr *DuplicateBuildError
+		switch {
+		case err == nil && created == nil:
+			created = builds[i]
+		case err == nil:
+			t.Errorf("Expected one build, got %d and %d", created.ID, builds[i].ID)
+		case !errors.As(err, &duplicateErr):
+			t.Errorf("Unexpected error %v", err)
+		}
+	}
+	if _, queued := GlobalQueue.Count(); queued != 1 {
+		t.Errorf("Expected 1 pending build, got %d", queued)
+	}
+	if data := created.GenerateBuildUpdateData(); data.Deduplicated != 9 {
+		t.Errorf("Expected 9 merged triggers, got %d", data.Deduplicated)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DirectivePrefix is a prefix of task output lines which are handled by wake
+// instead of being logged, e.g.
+// `echo '::wake:: {"type":"set-description","value":"Release 1.2.3"}'`
+const DirectivePrefix = "::wake::"
+
+// Types of directives
+const (
+	DirectiveSetDescription = "set-description"
+	DirectiveAddLabel       = "add-label"
+	DirectiveSetOutput      = "set-output"
+	DirectiveWarning        = "warning"
+	DirectiveAnnotation     = "annotation"
+)
+
+// MaxTaskDirectives limits number of directives handled per run of a task,
+// the rest are ignored
+const MaxTaskDirectives = 100
+
+// MaxBuildAnnotations limits number of collected annotations
+const MaxBuildAnnotations = 50
+
+// invalidDirectivesWarning is reported once for all invalid directives of the
+// build, details are in the build log
+const invalidDirectivesWarning = "Some " + DirectivePrefix + " directives are invalid and were ignored, see the build log"
+
+// tooManyDirectivesWarning is reported once if any task exceeds
+// MaxTaskDirectives
+var tooManyDirectivesWarning = fmt.Sprintf("Some tasks printed more than %d %s directives, the rest were ignored", MaxTaskDirectives, DirectivePrefix)
+
+// Directive is a request of a task to wake. Key is used by add-label and
+// set-output
+type Directive struct {
+	Type  string `json:"type"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+// parseDirective parses the JSON directive after DirectivePrefix
+func parseDirective(line string) (*Directive, error) {
+	var d Directive
+	err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, DirectivePrefix))), &d)
+	if err != nil {
+		return nil, fmt.Errorf("malformed directive: %w", err)
+	}
+	switch d.Type {
+	case DirectiveSetDescription, DirectiveWarning, DirectiveAnnotation:
+		if d.Value == "" && d.Type != DirectiveSetDescription {
+			return nil, fmt.Errorf("%s directive without value", d.Type)
+		}
+	case DirectiveAddLabel, DirectiveSetOutput:
+		if !exportedParamName.MatchString(d.Key) {
+			return nil, fmt.Errorf("%s directive has invalid key %q", d.Type, d.Key)
+		}
+	default:
+		return nil, fmt.Errorf("unknown directive type %q", d.Type)
+	}
+	return &d, nil
+}
+
+// handleDirective handles the DirectivePrefix line printed by the task. The
+// line is expected to be redacted already
+func (b *Build) handleDirective(line string, task *Task) {
+	b.mutex.Lock()
+	if b.taskDirectives == nil {
+		b.taskDirectives = map[string]int{}
+	}
+	b.taskDirectives[task.LogKey()]++
+	count := b.taskDirectives[task.LogKey()]
+	b.mutex.Unlock()
+	if count > MaxTaskDirectives {
+		if count == MaxTaskDirectives+1 {
+			b.Logger.Printf("Task %s exceeded %d directives, the rest are ignored\n", task.LogKey(), MaxTaskDirectives)
+			b.addWarning(tooManyDirectivesWarning)
+		}
+		return
+	}
+
+	d, err := parseDirective(line)
+	if err != nil {
+		b.Logger.Printf("Task %s: %s: %s\n", task.LogKey(), err, line)
+		b.addWarning(invalidDirectivesWarning)
+		return
+	}
+	switch d.Type {
+	case DirectiveSetDescription:
+		b.mutex.Lock()
+		b.Description = d.Value
+		b.mutex.Unlock()
+	case DirectiveAddLabel:
+		b.mutex.Lock()
+		// The map might be shared with retries of the build
+		labels := make(map[string]string, len(b.Labels)+1)
+		for key, value := range b.Labels {
+			labels[key] = value
+		}
+		labels[d.Key] = d.Value
+		b.Labels = labels
+		b.mutex.Unlock()
+	case DirectiveSetOutput:
+		b.setParam(d.Key, d.Value)
+	case DirectiveWarning:
+		// Broadcasts the update itself
+		b.addWarning(d.Value)
+		return
+	case DirectiveAnnotation:
+		b.mutex.Lock()
+		if len(b.Annotations) >= MaxBuildAnnotations {
+			b.mutex.Unlock()
+			return
+		}
+		b.Annotations = append(b.Annotations, d.Value)
+		b.mutex.Unlock()
+	}
+	b.BroadcastUpdate()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDirective(t *testing.T) {
+	valid := map[string]Directive{
+		`::wake:: {"type":"set-description","value":"Release 1.2.3"}`:    {Type: DirectiveSetDescription, Value: "Release 1.2.3"},
+		`::wake:: {"type":"add-label","key":"TARGET","value":"prod"}`:    {Type: DirectiveAddLabel, Key: "TARGET", Value: "prod"},
+		`::wake:: {"type":"set-output","key":"VERSION","value":"1.2.3"}`: {Type: DirectiveSetOutput, Key: "VERSION", Value: "1.2.3"},
+		`::wake:: {"type":"warning","value":"API v1 is deprecated"}`:     {Type: DirectiveWarning, Value: "API v1 is deprecated"},
+		`::wake::{"type":"annotation","value":"Deployed to 3 hosts"}`:    {Type: DirectiveAnnotation, Value: "Deployed to 3 hosts"},
+		`::wake:: {"type":"set-description","value":""}`:                 {Type: DirectiveSetDescription},
+	}
+	for line, expected := range valid {
+		d, err := parseDirective(line)
+		if err != nil {
+			t.Errorf("%s: %s", line, err)
+			continue
+		}
+		if *d != expected {
+			t.Errorf("%s: expected %+v, got %+v", line, expected, *d)
+		}
+	}
+	for _, line := range []string{
+		`::wake:: set-description`,
+		`::wake:: {"type":"explode"}`,
+		`::wake:: {"type":"warning"}`,
+		`::wake:: {"type":"add-label","value":"prod"}`,
+		`::wake:: {"type":"set-output","key":"1VERSION","value":"1.2.3"}`,
+	} {
+		if _, err := parseDirective(line); err == nil {
+			t.Errorf("Expected error for %s", line)
+		}
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "directives",
+		Tasks: []*Task{
+			{Name: "main", Command: strings.Join([]string{
+				`echo '::wake:: {"type":"set-description","value":"Release 1.2.3"}'`,
+				`echo '::wake:: {"type":"add-label","key":"TARGET","value":"prod"}'`,
+				`echo '::wake:: {"type":"set-output","key":"VERSION","value":"1.2.3"}'`,
+				`echo '::wake:: {"type":"warning","value":"API v1 is deprecated"}'`,
+				`echo '::wake:: {"type":"annotation","value":"Deployed to 3 hosts"}'`,
+				`echo '::wake:: {"type":"explode"}'`,
+				`echo '::wake:: broken'`,
+				`echo done`,
+			}, "; "), Kind: KindMain},
+			{Name: "check", Command: `[ "$VERSION" = 1.2.3 ]`, Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Description != "Release 1.2.3" {
+		t.Errorf("Unexpected description %q", data.Description)
+	}
+	if data.Labels["TARGET"] != "prod" {
+		t.Errorf("Unexpected labels %v", data.Labels)
+	}
+	if len(data.Annotations) != 1 || data.Annotations[0] != "Deployed to 3 hosts" {
+		t.Errorf("Unexpected annotations %v", data.Annotations)
+	}
+	expected := []string{"API v1 is deprecated", invalidDirectivesWarning}
+	if len(data.Warnings) != len(expected) || data.Warnings[0] != expected[0] || data.Warnings[1] != expected[1] {
+		t.Errorf("Expected warnings %v, got %v", expected, data.Warnings)
+	}
+	logB, err := os.ReadFile(build.GetWakespaceDir() + job.Tasks[0].LogFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(logB), "] "+DirectivePrefix) || !strings.Contains(string(logB), "done") {
+		t.Errorf("Expected only regular lines in the log, got %q", logB)
+	}
+}
+
+func TestDirectivesLimit(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "directives_limit",
+		Tasks: []*Task{
+			{Name: "main", Command: fmt.Sprintf(
+				`for i in $(seq %d); do echo "::wake:: {\"type\":\"annotation\",\"value\":\"$i\"}"; done`,
+				MaxTaskDirectives+10,
+			), Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Annotations) != MaxBuildAnnotations {
+		t.Errorf("Expected %d annotations, got %d", MaxBuildAnnotations, len(data.Annotations))
+	}
+	if len(data.Warnings) != 1 || data.Warnings[0] != tooManyDirectivesWarning {
+		t.Errorf("Expected a single warning, got %v", data.Warnings)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDiskQuotaInterval is a default period to measure the size of the
+// build workspace
+const DefaultDiskQuotaInterval = 30 * time.Second
+
+var errSizeLimitReached = errors.New("size limit reached")
+
+// getDiskQuota returns disk quota of the build workspace in bytes and how often
+// it should be verified. Job configuration takes precedence over the global
+// one. Quota 0 means unlimited
+func (b *Build) getDiskQuota() (int64, time.Duration, error) {
+	quotaStr := b.Job.DiskQuota
+	if quotaStr == "" {
+		quotaStr = Config.DiskQuota
+	}
+	intervalStr := b.Job.DiskQuotaInterval
+	if intervalStr == "" {
+		intervalStr = Config.DiskQuotaInterval
+	}
+	if quotaStr == "" {
+		return 0, 0, nil
+	}
+	quota, err := ParseSize(quotaStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	interval := DefaultDiskQuotaInterval
+	if intervalStr != "" {
+		interval, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return quota, interval, nil
+}
+
+// startDiskQuotaWatcher periodically measures the size of the workspace and
+// aborts the build when it exceeds the disk quota
+func (b *Build) startDiskQuotaWatcher() {
+	quota, interval, err := b.getDiskQuota()
+	if err != nil {
+		b.Logger.Println(err)
+		return
+	}
+	if quota == 0 {
+		return
+	}
+	b.Logger.Printf("Disk quota is %d bytes, verified every %s\n", quota, interval)
+	b.quotaDone = make(chan bool)
+	ticker := time.NewTicker(interval)
+	go func(done chan bool) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				size, err := dirSize(b.GetWorkspaceDir(), quota)
+				if err != nil {
+					b.Logger.Println(err)
+					continue
+				}
+				if size > quota {
+					b.Logger.Printf("Build %d has exceeded disk quota: more than %d bytes used\n", b.ID, quota)
+					err = GlobalQueue.Abort(b.ID, StatusDiskQuotaExceeded)
+					if err != nil {
+						b.Logger.Println(err)
+					}
+					return
+				}
+			}
+		}
+	}(b.quotaDone)
+}
+
+// stopDiskQuotaWatcher stops measuring the size of the workspace
+func (b *Build) stopDiskQuotaWatcher() {
+	if b.quotaDone != nil {
+		close(b.quotaDone)
+		b.quotaDone = nil
+	}
+}
+
+// dirSize returns total size of files in the directory. It stops as soon as
+// the size exceeds limit, so the returned value is not exact in this case
+func dirSize(path string, limit int64) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files might be removed by the task while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		if limit > 0 && size > limit {
+			return errSizeLimitReached
+		}
+		return nil
+	})
+	if errors.Is(err, errSizeLimitReached) {
+		err = nil
+	}
+	return size, err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// TriggerUpstream indicates that a build was started by a finished build of
+// another job, see Job.Triggers
+const TriggerUpstream = "upstream"
+
+// DownstreamTrigger is a job started when a build of the job finishes. In the
+// job file it is either a job name or an object with `job` and `params` keys
+type DownstreamTrigger struct {
+	Job string `yaml:"job" json:"job"`
+	// Params of the downstream build, values can use params of the finished
+	// build, e.g. VERSION: ${VERSION}
+	Params map[string]string `yaml:"params" json:"params"`
+}
+
+// UnmarshalYAML accepts a plain string as the job name
+func (t *DownstreamTrigger) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var job string
+	if err := unmarshal(&job); err == nil {
+		t.Job = job
+		return nil
+	}
+	type plain DownstreamTrigger
+	return unmarshal((*plain)(t))
+}
+
+// upstreamJobs returns the chain of jobs which builds started the build
+// including the job of the build
+func (b *Build) upstreamJobs() []string {
+	jobs := []string{}
+	if b.Trigger != nil {
+		jobs = append(jobs, b.Trigger.UpstreamJobs...)
+	}
+	return append(jobs, b.Job.Name)
+}
+
+// triggerDownstreamJobs starts builds of Job.Triggers and records their IDs.
+// A job which already is in the chain of upstream builds isn't started again
+func (b *Build) triggerDownstreamJobs() {
+	chain := b.upstreamJobs()
+	for _, trigger := range b.Job.Triggers {
+		if inChain(chain, trigger.Job) {
+			msg := fmt.Sprintf(
+				"Job %s is not triggered to avoid a cycle: %s -> %s",
+				trigger.Job, strings.Join(chain, " -> "), trigger.Job,
+			)
+			b.Logger.Println(msg)
+			b.addWarning(msg)
+			continue
+		}
+		params := url.Values{}
+		for key, value := range trigger.Params {
+			params.Set(key, os.Expand(value, b.getParamsMapper()))
+		}
+		upstream := &TriggerInfo{
+			Kind:            TriggerUpstream,
+			UpstreamBuildID: b.ID,
+			UpstreamJobs:    chain,
+		}
+		build, err := runJobWithTrigger(trigger.Job, params, upstream, nil)
+		var duplicateErr *DuplicateBuildError
+		if errors.As(err, &duplicateErr) {
+			build, err = duplicateErr.Build, nil
+		}
+		if err != nil {
+			msg := fmt.Sprintf("Unable to trigger job %s: %s", trigger.Job, err)
+			b.Logger.Println(msg)
+			b.addWarning(msg)
+			continue
+		}
+		b.Logger.Printf("Triggered build %d of job %s\n", build.ID, trigger.Job)
+		b.mutex.Lock()
+		b.DownstreamBuilds = append(b.DownstreamBuilds, build.ID)
+		b.mutex.Unlock()
+	}
+}
+
+// inChain returns true if the job is in the chain of upstream jobs
+func inChain(chain []string, job string) bool {
+	for _, name := range chain {
+		if name == job {
+			return true
+		}
+	}
+	return false
+}
+
+// Used to verify downstream triggers before saving after editing
+func (j *Job) verifyTriggers() error {
+	for _, trigger := range j.Triggers {
+		if trigger == nil || trigger.Job == "" {
+			return fmt.Errorf("job of a trigger can't be empty")
+		}
+		for key := range trigger.Params {
+			if !exportedParamName.MatchString(key) {
+				return fmt.Errorf("trigger of job %s has invalid param name %q", trigger.Job, key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDownstreamTrigger_UnmarshalYAML(t *testing.T) {
+	var triggers []*DownstreamTrigger
+	err := yaml.Unmarshal([]byte("- deploy\n- job: notify\n  params:\n    VERSION: ${VERSION}\n"), &triggers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 2 || triggers[0].Job != "deploy" || triggers[1].Job != "notify" || triggers[1].Params["VERSION"] != "${VERSION}" {
+		t.Errorf("Unexpected triggers %+v", triggers)
+	}
+	if err := (&Job{Triggers: []*DownstreamTrigger{{}}}).verifyTriggers(); err == nil {
+		t.Error("Expected error for a trigger without job")
+	}
+}
+
+// waitForBuildStatus waits until the saved build has a terminal status
+func waitForBuildStatus(t *testing.T, id int) *BuildUpdateData {
+	var data *BuildUpdateData
+	waitFor(t, 5*time.Second, "build "+strconv.Itoa(id)+" is completed", func() bool {
+		var err error
+		data, err = getBuildStatusData(id)
+		return err == nil && isTerminalStatus(data.Status)
+	})
+	return data
+}
+
+func TestTriggerDownstreamJobs(t *testing.T) {
+	setupTestEnv(t)
+	GlobalCron = cron.New()
+	jobs := map[string]string{
+		"build": `
+params:
+  - VERSION: "1.0"
+triggers:
+  - job: deploy
+    params:
+      VERSION: ${VERSION}-rc
+  - missing
+tasks:
+  - run: "true"
+`,
+		// Triggers build back, which would start the chain again
+		"deploy": `
+params:
+  - VERSION: ""
+triggers:
+  - build
+tasks:
+  - run: echo ${WAKE_UPSTREAM_BUILD_ID} ${VERSION} > upstream
+`,
+	}
+	for name, content := range jobs {
+		err := os.WriteFile(Config.JobDir+name+".yaml", []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = RegisterJob(Config.JobDir + name + ".yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upstream, err := RunJob("build", url.Values{}, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := waitForBuildStatus(t, upstream.ID)
+	if data.Status != StatusFinished || len(data.DownstreamBuilds) != 1 {
+		t.Fatalf("Expected a downstream build, got %s %v", data.Status, data.DownstreamBuilds)
+	}
+	if len(data.Warnings) != 1 || !strings.Contains(data.Warnings[0], "missing") {
+		t.Errorf("Expected a warning about the missing job, got %v", data.Warnings)
+	}
+
+	downstreamID := data.DownstreamBuilds[0]
+	data = waitForBuildStatus(t, downstreamID)
+	if data.Name != "deploy" || data.Status != StatusFinished {
+		t.Fatalf("Unexpected downstream build %s %s", data.Name, data.Status)
+	}
+	if data.Trigger.Kind != TriggerUpstream || data.Trigger.UpstreamBuildID != upstream.ID {
+		t.Errorf("Unexpected trigger %+v", data.Trigger)
+	}
+	if len(data.DownstreamBuilds) != 0 || len(data.Warnings) != 1 || !strings.Contains(data.Warnings[0], "build -> deploy -> build") {
+		t.Errorf("Expected the cycle to be stopped, got %v %v", data.DownstreamBuilds, data.Warnings)
+	}
+	content, err := os.ReadFile(Config.WorkDir + "workspace/" + strconv.Itoa(downstreamID) + "/upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := strconv.Itoa(upstream.ID) + " 1.0-rc\n"; string(content) != expected {
+		t.Errorf("Expected %q in the downstream build, got %q", expected, content)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailLogTailLines is the number of the last lines of the failed task log
+// included in the email
+const EmailLogTailLines = 50
+
+// SMTPConfig is the server which sends emails to `email_to` of jobs
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	From string `yaml:"from"`
+	// PLAIN authentication is used if the username is set
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// sendMail is replaced in tests
+var sendMail = smtp.SendMail
+
+var emailHTML = template.Must(template.New("email").Parse(`<p><a href="{{ .URL }}">#{{ .ID }} {{ .Name }}</a> {{ .Status }} in {{ .Duration }}</p>
+{{ if .Task }}<p>Log of task {{ .Task }}:</p>
+<pre>{{ .Log }}</pre>{{ end }}`))
+
+// emailData is the content of the email about the build
+type emailData struct {
+	ID       int
+	Name     string
+	Status   ItemStatus
+	Duration string
+	URL      string
+	// The first failed task and the tail of its log
+	Task string
+	Log  string
+}
+
+// failedTaskLogTail returns the name of the first failed main task and the
+// last lines of its log
+func (b *Build) failedTaskLogTail() (string, string) {
+	for _, task := range b.Job.Tasks {
+		if task.Kind != KindMain {
+			continue
+		}
+		if task.Status != StatusFailed && task.Status != StatusTimedOut {
+			continue
+		}
+		logB, err := os.ReadFile(b.GetWakespaceDir() + task.LogFileName())
+		if err != nil {
+			b.Logger.Println(err)
+			return task.Name, ""
+		}
+		lines := strings.Split(strings.TrimRight(string(logB), "\n"), "\n")
+		if len(lines) > EmailLogTailLines {
+			lines = lines[len(lines)-EmailLogTailLines:]
+		}
+		return task.Name, strings.Join(lines, "\n")
+	}
+	return "", ""
+}
+
+// newEmail formats the final status of the build as multipart/alternative
+// message with plain text and HTML parts
+func (b *Build) newEmail(to []string) ([]byte, error) {
+	status := b.GenerateBuildUpdateData()
+	data := &emailData{
+		ID:       status.ID,
+		Name:     status.Name,
+		Status:   status.Status,
+		Duration: status.Duration.Truncate(time.Second).String(),
+		URL:      getBuildURL(status.ID),
+	}
+	data.Task, data.Log = b.failedTaskLogTail()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(part, "#%d %s %s in %s\n%s\n", data.ID, data.Name, data.Status, data.Duration, data.URL)
+	if data.Task != "" {
+		fmt.Fprintf(part, "\nLog of task %s:\n%s\n", data.Task, data.Log)
+	}
+	part, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=UTF-8"}})
+	if err != nil {
+		return nil, err
+	}
+	err = emailHTML.Execute(part, data)
+	if err != nil {
+		return nil, err
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", Config.SMTP.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: [wakeci] #%d %s %s\r\n", data.ID, data.Name, data.Status)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// sendEmail sends the final status of the build to Job.EmailTo if the job is
+// configured to notify about it. It doesn't block
+func (b *Build) sendEmail(status ItemStatus) {
+	if Config.SMTP == nil || len(b.Job.EmailTo) == 0 || !b.shouldNotify(status) {
+		return
+	}
+	to := append([]string(nil), b.Job.EmailTo...)
+	msg, err := b.newEmail(to)
+	if err != nil {
+		b.Logger.Println(err)
+		return
+	}
+	smtpConfig := Config.SMTP
+	go func() {
+		var auth smtp.Auth
+		if smtpConfig.Username != "" {
+			auth = smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, smtpConfig.Host)
+		}
+		addr := smtpConfig.Host + ":" + strconv.Itoa(smtpConfig.Port)
+		err := sendMail(addr, auth, smtpConfig.From, to, msg)
+		if err != nil {
+			b.Logger.Printf("Email to %s failed: %s\n", strings.Join(to, ", "), err)
+			return
+		}
+		b.Logger.Printf("Email is sent to %s\n", strings.Join(to, ", "))
+	}()
+}
+
+// verify checks that the server and the sender are set
+func (c *SMTPConfig) verify() error {
+	if c == nil {
+		return nil
+	}
+	if c.Host == "" || c.From == "" {
+		return fmt.Errorf("smtp requires host and from")
+	}
+	if c.Port <= 0 {
+		return fmt.Errorf("smtp has invalid port %d", c.Port)
+	}
+	return nil
+}
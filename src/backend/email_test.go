@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEmailNotification(t *testing.T) {
+	setupTestEnv(t)
+	defer func(f func(string, smtp.Auth, string, []string, []byte) error) {
+		sendMail = f
+	}(sendMail)
+	var mu sync.Mutex
+	var sent []string
+	sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if addr != "smtp.example.com:587" || from != "ci@example.com" || strings.Join(to, ",") != "dev@example.com" {
+			t.Errorf("Unexpected envelope %s %s %v", addr, from, to)
+		}
+		sent = append(sent, string(msg))
+		return nil
+	}
+	Config.Port = "8081"
+	Config.SMTP = &SMTPConfig{Host: "smtp.example.com", Port: 587, From: "ci@example.com"}
+
+	build := createTestBuild(t, &Job{
+		Name:     "emailed",
+		NotifyOn: []string{StatusFailed},
+		EmailTo:  []string{"dev@example.com"},
+		Tasks: []*Task{{
+			Name:    "long log",
+			Command: fmt.Sprintf("seq %d; exit 1", EmailLogTailLines+10),
+			Kind:    KindMain,
+		}},
+	})
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+
+	waitFor(t, 5*time.Second, "email is sent", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) > 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	msg := sent[0]
+	for _, expected := range []string{
+		"Subject: [wakeci] #1 emailed failed",
+		"Content-Type: multipart/alternative",
+		"Content-Type: text/plain",
+		"Content-Type: text/html",
+		`<a href="http://localhost:8081/build/1">`,
+		"Log of task long log:",
+		"] 60\n",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("Expected %q in the email:\n%s", expected, msg)
+		}
+	}
+	if strings.Contains(msg, "] 10\n") {
+		t.Errorf("Expected only the tail of the log in the email:\n%s", msg)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ExportAnnotation is a prefix of task output lines which set params of the
+// build for the next tasks, e.g. `echo "WAKE_EXPORT VERSION=1.2.3"`
+const ExportAnnotation = "WAKE_EXPORT"
+
+var exportedParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// exportParam parses the ExportAnnotation line and sets the param of the
+// build. Returns false if the line is not a valid annotation
+func (b *Build) exportParam(line string) bool {
+	if !strings.HasPrefix(line, ExportAnnotation+" ") {
+		return false
+	}
+	key, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, ExportAnnotation)), "=")
+	if !ok || !exportedParamName.MatchString(key) {
+		b.Logger.Printf("Invalid %s line: %s\n", ExportAnnotation, line)
+		return false
+	}
+	b.setParam(key, value)
+	return true
+}
+
+// setParam sets the param of the build for the next tasks. Declared params are
+// overridden, otherwise a new param is added
+func (b *Build) setParam(key, value string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// Running tasks might read the params, so maps are not modified in place
+	params := make([]map[string]string, 0, len(b.Params)+1)
+	declared := false
+	for idx := range b.Params {
+		param := make(map[string]string, len(b.Params[idx]))
+		for pkey, pval := range b.Params[idx] {
+			param[pkey] = pval
+		}
+		if _, ok := param[key]; ok {
+			param[key] = value
+			declared = true
+		}
+		params = append(params, param)
+	}
+	if !declared {
+		params = append(params, map[string]string{key: value})
+	}
+	b.Params = params
+	b.Logger.Printf("Param %s is exported by a task\n", key)
+}
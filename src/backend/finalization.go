@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FinalizationRetries is the number of attempts to save the record of a
+// completed build to the history
+const FinalizationRetries = 5
+
+// FinalizationRetryDelay is the delay before the second attempt to save the
+// record, it is doubled for every next attempt
+var FinalizationRetryDelay = 100 * time.Millisecond
+
+// addFinalizationError records a failure of a stage which runs after main
+// tasks are completed, e.g. artifact collection. The status of the build
+// doesn't change
+func (b *Build) addFinalizationError(stage string, err error) {
+	msg := fmt.Sprintf("%s: %s", stage, err)
+	b.Logger.Println(msg)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, e := range b.FinalizationErrors {
+		if e == msg {
+			return
+		}
+	}
+	b.FinalizationErrors = append(b.FinalizationErrors, msg)
+}
+
+// checkOnStatusTask records a failure of the on-status task which runs after
+// the build has reached a terminal status
+func (b *Build) checkOnStatusTask(task *Task, status ItemStatus) {
+	if status != StatusFailed && status != StatusTimedOut {
+		return
+	}
+	if task.Kind == string(StatusPending) || task.Kind == string(StatusRunning) {
+		return
+	}
+	b.addFinalizationError(fmt.Sprintf("%s task %s", task.Kind, task.Name), fmt.Errorf("task is %s", status))
+}
+
+// saveBuildUpdate writes the record of the build to the history, see
+// putBuildRecord. Failed writes are retried with exponential backoff
+func saveBuildUpdate(data *BuildUpdateData, attempts int) error {
+	delay := FinalizationRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := DB.Update(func(tx *bolt.Tx) error {
+			return putBuildRecord(tx, data)
+		})
+		// A stale record is never saved, retries don't help
+		if err == nil || attempt >= attempts || errors.Is(err, errStaleBuildUpdate) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFinalizationErrors_Artifacts(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name: "finalization_artifacts",
+		Tasks: []*Task{
+			{Name: "report", Command: "sleep 0.2; echo ok > report.txt", Kind: KindMain},
+			{Name: "notify", Command: "false", Kind: string(StatusFinished)},
+		},
+		Artifacts: []*ArtifactPattern{{Pattern: "*.txt"}},
+	}
+	build := createTestBuild(t, job)
+	// Artifacts can't be copied into a file
+	err := os.RemoveAll(build.GetArtifactsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(strings.TrimSuffix(build.GetArtifactsDir(), "/"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.FinalizationErrors) != 2 ||
+		!strings.HasPrefix(data.FinalizationErrors[0], "finished task notify:") ||
+		!strings.HasPrefix(data.FinalizationErrors[1], "artifacts:") {
+		t.Errorf("Unexpected finalization errors %q", data.FinalizationErrors)
+	}
+	if len(data.BuildArtifacts) != 0 {
+		t.Errorf("Expected no artifacts, got %+v", data.BuildArtifacts)
+	}
+}
+
+func TestFinalizationErrors_ClosedDB(t *testing.T) {
+	setupTestEnv(t)
+	FinalizationRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		FinalizationRetryDelay = 100 * time.Millisecond
+	})
+	build := &Build{
+		ID:     1,
+		Job:    &Job{Name: "finalization_db"},
+		Status: StatusFinished,
+		Logger: log.New(io.Discard, "", 0),
+	}
+	err := DB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build.BroadcastUpdate()
+	data := build.GenerateBuildUpdateData()
+	if len(data.FinalizationErrors) != 1 || !strings.HasPrefix(data.FinalizationErrors[0], "history:") {
+		t.Errorf("Unexpected finalization errors %q", data.FinalizationErrors)
+	}
+}
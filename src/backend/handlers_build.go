@@ -1,12 +1,21 @@
 package main
 
 import (
+	"archive/zip"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	bolt "go.etcd.io/bbolt"
@@ -52,6 +61,24 @@ func getBuildConfig(buildID int) (*Job, error) {
 	return job, nil
 }
 
+// getBuildStatusData returns the latest status update of the build from the
+// history bucket
+func getBuildStatusData(buildID int) (*BuildUpdateData, error) {
+	var buildStatusData BuildUpdateData
+	err := DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(HistoryBucket))
+		ud := b.Get(Itob(buildID))
+		if ud == nil {
+			return fmt.Errorf("build %d not found", buildID)
+		}
+		return json.Unmarshal(ud, &buildStatusData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &buildStatusData, nil
+}
+
 // HandleGetBuild Returns information required to bootstrap build page
 // @Summary      Return status of the build
 // @Description  Contains information about the job and the latest build status
@@ -108,6 +135,8 @@ func HandleGetBuild(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	GlobalUsage.RecordView(buildStatusData.JobName())
+
 	payload := GetBuildPayload{
 		Job:          job,
 		StatusUpdate: &buildStatusData,
@@ -202,7 +231,7 @@ func HandleFlushTaskLogs(w http.ResponseWriter, r *http.Request) {
 // @Success      200      {string}   string
 // @Failure      500      {string}   http.StatusInternalServerError
 // @Failure      404      {string}   http.StatusNotFound
-// @Router       /build/{id}/abort [post]
+// @Router       /build/{id}/start [post]
 func HandleStartBuild(w http.ResponseWriter, r *http.Request) {
 	logger, ok := r.Context().Value(HL).(*log.Logger)
 	if !ok {
@@ -224,3 +253,858 @@ func HandleStartBuild(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// HandleGetBuildParallelEfficiency returns how much time was saved by running
+// tasks in parallel
+// @Summary      Return parallel efficiency of the build
+// @Description  Compares the sum of main task durations with the actual build duration and calculates the critical path through the task dependency graph
+// @Tags         build
+// @Produce      json
+// @Param        id       path    integer   true  "Build ID"
+// @Success      200      {object}   ParallelEfficiencyPayload
+// @Failure      500      {string}   http.StatusInternalServerError
+// @Failure      404      {string}   http.StatusNotFound
+// @Router       /build/{id}/parallel-efficiency [get]
+func HandleGetBuildParallelEfficiency(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	idp := chi.URLParam(r, "id")
+	buildID, err := strconv.Atoi(idp)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := getBuildConfig(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	buildStatusData, err := getBuildStatusData(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	durations := make(map[int]time.Duration)
+	mainTasks := []*TaskStatus{}
+	for _, t := range buildStatusData.Tasks {
+		if t.Kind != KindMain {
+			continue
+		}
+		durations[t.ID] = t.Duration
+		mainTasks = append(mainTasks, t)
+	}
+
+	var sequential time.Duration
+	nodes := []*TaskNode{}
+	deps := taskDependencies(job)
+	for _, t := range job.Tasks {
+		if t.Kind != KindMain {
+			continue
+		}
+		sequential += durations[t.ID]
+		nodes = append(nodes, &TaskNode{
+			ID:        t.ID,
+			Duration:  durations[t.ID],
+			DependsOn: deps[t.ID],
+		})
+	}
+
+	path, slack, err := CriticalPath(nodes)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payload := ParallelEfficiencyPayload{
+		SequentialEquivalent: sequential.Milliseconds(),
+		ActualDuration:       buildStatusData.Duration.Milliseconds(),
+		CriticalPath:         path,
+		SlackTasks:           []*TaskSlack{},
+	}
+	level := ParallelismLevel(mainTasks)
+	if payload.ActualDuration > 0 {
+		payload.Efficiency = float64(payload.SequentialEquivalent) / float64(int64(level)*payload.ActualDuration) * 100
+	}
+	for _, n := range nodes {
+		if slack[n.ID] > 0 {
+			payload.SlackTasks = append(payload.SlackTasks, &TaskSlack{
+				TaskID: n.ID,
+				Slack:  slack[n.ID].Milliseconds(),
+			})
+		}
+	}
+
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleGetBuildCriticalPath returns every main task of the build with its
+// slack calculated by the critical path method
+// @Summary      Return critical path of the build
+// @Description  Forward and backward passes over the task dependency graph. Total slack is how much a task can be delayed without delaying the build, free slack - without delaying the tasks which depend on it
+// @Tags         build
+// @Produce      json
+// @Param        id       path    integer   true  "Build ID"
+// @Success      200      {array}    CriticalPathTask
+// @Failure      500      {string}   http.StatusInternalServerError
+// @Failure      404      {string}   http.StatusNotFound
+// @Router       /build/{id}/critical-path [get]
+func HandleGetBuildCriticalPath(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	idp := chi.URLParam(r, "id")
+	buildID, err := strconv.Atoi(idp)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := getBuildConfig(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	buildStatusData, err := getBuildStatusData(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	durations := make(map[int]time.Duration)
+	for _, t := range buildStatusData.Tasks {
+		durations[t.ID] = t.Duration
+	}
+	names := make(map[int]string)
+	nodes := []*TaskNode{}
+	deps := taskDependencies(job)
+	for _, t := range job.Tasks {
+		if t.Kind != KindMain {
+			continue
+		}
+		names[t.ID] = t.Name
+		nodes = append(nodes, &TaskNode{
+			ID:        t.ID,
+			Duration:  durations[t.ID],
+			DependsOn: deps[t.ID],
+		})
+	}
+
+	payload, err := AnalyzeCriticalPath(nodes, names)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleGetBuildWorkflow returns status of each workflow stage of the build
+// @Summary      Return status of workflow stages
+// @Description  Stage status is calculated from the status of its tasks. Duration is available when the stage is completed
+// @Tags         build
+// @Produce      json
+// @Param        id       path    integer   true  "Build ID"
+// @Success      200      {array}    WorkflowStageStatus
+// @Failure      500      {string}   http.StatusInternalServerError
+// @Failure      404      {string}   http.StatusNotFound
+// @Router       /build/{id}/workflow [get]
+func HandleGetBuildWorkflow(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	idp := chi.URLParam(r, "id")
+	buildID, err := strconv.Atoi(idp)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := getBuildConfig(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	buildStatusData, err := getBuildStatusData(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tasks := make(map[int]*TaskStatus)
+	for _, t := range buildStatusData.Tasks {
+		tasks[t.ID] = t
+	}
+
+	payload := []*WorkflowStageStatus{}
+	for _, stage := range job.Workflow {
+		stageTasks := []*TaskStatus{}
+		for _, id := range stage.Tasks {
+			if t, ok := tasks[id]; ok {
+				stageTasks = append(stageTasks, t)
+			}
+		}
+		status := getStageStatus(stageTasks)
+		item := WorkflowStageStatus{
+			Stage:  stage.Name,
+			Tasks:  stage.Tasks,
+			Status: status,
+		}
+		if status != StatusPending && status != StatusRunning {
+			var startedAt, finishedAt time.Time
+			for _, t := range stageTasks {
+				if t.StartedAt.IsZero() {
+					continue
+				}
+				if startedAt.IsZero() || t.StartedAt.Before(startedAt) {
+					startedAt = t.StartedAt
+				}
+				if t.StartedAt.Add(t.Duration).After(finishedAt) {
+					finishedAt = t.StartedAt.Add(t.Duration)
+				}
+			}
+			item.Duration = finishedAt.Sub(startedAt).Milliseconds()
+		}
+		payload = append(payload, &item)
+	}
+
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// getStageStatus calculates status of the stage from the status of its tasks.
+// The stage is skipped if all of its tasks are skipped
+func getStageStatus(tasks []*TaskStatus) ItemStatus {
+	pending := 0
+	completed := 0
+	skipped := 0
+	for _, t := range tasks {
+		switch t.Status {
+		case StatusRunning:
+			return StatusRunning
+		case StatusFailed, StatusAborted, StatusTimedOut, StatusDiskQuotaExceeded:
+			return t.Status
+		case StatusPending:
+			pending++
+		case StatusSkipped:
+			skipped++
+			completed++
+		default:
+			completed++
+		}
+	}
+	if skipped > 0 && skipped == len(tasks) {
+		return StatusSkipped
+	}
+	if pending > 0 && completed > 0 {
+		return StatusRunning
+	}
+	if pending > 0 || len(tasks) == 0 {
+		return StatusPending
+	}
+	return StatusFinished
+}
+
+// WorkflowStageStatus contains status of the workflow stage
+type WorkflowStageStatus struct {
+	Stage    string     `json:"stage"`
+	Tasks    []int      `json:"tasks"`
+	Status   ItemStatus `json:"status"`
+	Duration int64      `json:"duration_ms,omitempty"`
+}
+
+// ParallelEfficiencyPayload describes how effectively tasks of the build were
+// parallelized
+type ParallelEfficiencyPayload struct {
+	SequentialEquivalent int64        `json:"sequential_equivalent_ms"`
+	ActualDuration       int64        `json:"actual_duration_ms"`
+	Efficiency           float64      `json:"efficiency_pct"`
+	CriticalPath         []int        `json:"critical_path"`
+	SlackTasks           []*TaskSlack `json:"slack_tasks"`
+}
+
+// TaskSlack is the amount of time the task could be delayed without delaying
+// the build
+type TaskSlack struct {
+	TaskID int   `json:"task_id"`
+	Slack  int64 `json:"slack_ms"`
+}
+
+// HandleGetBuildLogParser returns structured events parsed from task logs
+// @Summary      Get parsed build log
+// @Description  Returns test results and other events parsed from task logs with the job's log_parser
+// @Tags         build
+// @Produce      json
+// @Param        id       path       integer  true  "Build ID"
+// @Success      200      {array}    LogEvent
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /build/{id}/log/parsed [get]
+func HandleGetBuildLogParser(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := getBuildConfig(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	parser, err := GetLogParser(job.LogParser)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	events := []*LogEvent{}
+	for _, task := range job.Tasks {
+		lines, err := readTaskLog(buildID, task.ID, 0)
+		if err != nil {
+			// The task wasn't started
+			if os.IsNotExist(err) {
+				continue
+			}
+			logger.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(err.Error()))
+			return
+		}
+		taskParser := parser
+		if taskParser == nil {
+			taskParser = DetectLogParser(lines)
+		}
+		for _, event := range taskParser.Parse(lines) {
+			event.TaskID = task.ID
+			events = append(events, event)
+		}
+	}
+
+	payloadB, err := json.Marshal(events)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleGetBuildLogLinks returns URLs mentioned in task logs of the build
+// @Summary      Get URLs mentioned in build logs
+// @Description  Returns http(s), ssh and git URLs found in task logs with the number of occurrences. URLs are deduplicated across tasks, task_id and line_no point to the first occurrence
+// @Tags         build
+// @Produce      json
+// @Param        id       path       integer  true  "Build ID"
+// @Success      200      {array}    LogLink
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /build/{id}/log/links [get]
+func HandleGetBuildLogLinks(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	_, err = getBuildStatusData(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	files, err := listTaskLogs(buildID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	links := []*LogLink{}
+	index := map[string]*LogLink{}
+	for _, file := range files {
+		lines, err := readTaskLog(buildID, file.taskID, file.iteration)
+		if err != nil {
+			logger.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(err.Error()))
+			return
+		}
+		for i, line := range lines {
+			for _, url := range FindLogLinks(line) {
+				link, ok := index[url]
+				if !ok {
+					link = &LogLink{
+						URL:    url,
+						TaskID: file.taskID,
+						LineNo: i + 1,
+					}
+					index[url] = link
+					links = append(links, link)
+				}
+				link.Count++
+			}
+		}
+	}
+
+	payloadB, err := json.Marshal(links)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleGetTaskLog streams the log file of the task
+// @Summary      Download the log of the task
+// @Description  The log is read from the wakespace, a running task returns the lines written so far. Ranges are supported to resume the download or to poll new lines, e.g. `Range: bytes=1024-`
+// @Tags         build
+// @Produce      plain
+// @Param        id         path       integer  true   "ID of the build"
+// @Param        taskID     path       integer  true   "ID of the task"
+// @Param        iteration  query      integer  false  "Index of the instance of the task which runs several times"
+// @Success      200        {string}   string
+// @Success      206        {string}   string
+// @Failure      400        {string}   string
+// @Failure      404        {string}   string
+// @Failure      416        {string}   string
+// @Router       /build/{id}/task/{taskID}/log [get]
+func HandleGetTaskLog(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	writeError := func(status int, err error) {
+		logger.Println(err)
+		w.WriteHeader(status)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+	}
+
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	taskID, err := strconv.Atoi(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	iteration := 0
+	if value := r.URL.Query().Get("iteration"); value != "" {
+		iteration, err = strconv.Atoi(value)
+		if err != nil {
+			writeError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	name := TaskLogFileName(taskID, iteration)
+	f, err := os.Open((&Build{ID: buildID}).GetWakespaceDir() + name)
+	if err != nil {
+		writeError(http.StatusNotFound, fmt.Errorf("log of task %d of build %d is not available", taskID, buildID))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// The log of the running task grows, ranges are served from the size at
+	// the time of the request
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// HandleDownloadArtifacts streams all artifacts of the build as a zip archive
+// @Summary      Download artifacts of the build
+// @Description  Zip archive with all artifacts of the build, paths are relative to the artifacts directory. Symlinks are followed. Compressed artifacts are decompressed. Tasks can download artifacts with `Authorization: Bearer $WAKE_BUILD_TOKEN`, see `can_read_artifacts_from`
+// @Tags         build
+// @Produce      application/zip
+// @Param        id       path       integer  true   "ID of the build"
+// @Success      200      {file}     file
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Router       /build/{id}/artifacts.zip [get]
+func HandleDownloadArtifacts(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Artifacts might be already removed together with the wakespace
+	artifactsDir := (&Build{ID: buildID}).GetArtifactsDir()
+	files, err := listArtifactFiles(artifactsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if len(files) == 0 {
+		err = fmt.Errorf("build %d has no artifacts", buildID)
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	compressed := map[string]bool{}
+	if data, err := getBuildStatusData(buildID); err == nil {
+		compressed = compressedArtifacts(data)
+	}
+
+	GlobalUsage.RecordDownload(buildID)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="build-%d-artifacts.zip"`, buildID))
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		// Checksums are of stored files, compressed ones are decompressed
+		// in the archive
+		if file.name == ArtifactChecksumsFile {
+			continue
+		}
+		err = addFileToZip(zw, file.path, file.name, compressed[filepath.ToSlash(file.name)])
+		if err != nil {
+			// The response is already started, the archive is incomplete
+			logger.Println(err)
+			return
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		logger.Println(err)
+	}
+}
+
+// artifactFile is a file of the artifacts archive
+type artifactFile struct {
+	path string
+	// Relative to the artifacts directory, symlinks are not resolved
+	name string
+}
+
+// listArtifactFiles returns regular files under the directory. Symlinks to
+// files and directories are followed, every directory is listed once. Broken
+// symlinks are skipped
+func listArtifactFiles(dir string) ([]*artifactFile, error) {
+	files := make([]*artifactFile, 0)
+	visited := map[string]bool{}
+	var walk func(dir string, prefix string) error
+	walk = func(dir string, prefix string) error {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if visited[realDir] {
+			return nil
+		}
+		visited[realDir] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			info, err := os.Stat(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				err = walk(path, prefix+entry.Name()+"/")
+				if err != nil {
+					return err
+				}
+			} else if info.Mode().IsRegular() {
+				files = append(files, &artifactFile{path: path, name: prefix + entry.Name()})
+			}
+		}
+		return nil
+	}
+	return files, walk(dir, "")
+}
+
+// addFileToZip writes the file to the archive under the name. Compressed
+// files are decompressed and stored without CompressedArtifactExt
+func addFileToZip(zw *zip.Writer, path string, name string, compressed bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Deflate
+	var content io.Reader = f
+	if compressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		content = zr
+		header.Name = strings.TrimSuffix(header.Name, CompressedArtifactExt)
+	}
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, content)
+	return err
+}
+
+// HandleDownloadArtifact streams a single artifact of the build
+// @Summary      Download an artifact of the build
+// @Description  Content type is detected from the extension of the file. Compressed artifacts are sent with `Content-Encoding: gzip` if the client accepts it, otherwise they are decompressed. The artifact is verified against the SHA-256 hash taken on collection, 409 is returned if the file has been modified. `checksums.sha256` returns hashes of all artifacts in the format of `sha256sum`. Tasks can download artifacts with `Authorization: Bearer $WAKE_BUILD_TOKEN`, see `can_read_artifacts_from`
+// @Tags         build
+// @Produce      octet-stream
+// @Param        id       path       integer  true   "ID of the build"
+// @Param        path     path       string   true   "Path of the artifact, e.g. dist/app.bin"
+// @Success      200      {file}     file
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      409      {string}   string
+// @Router       /build/{id}/artifacts/{path} [get]
+func HandleDownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	writeError := func(status int, err error) {
+		logger.Println(err)
+		w.WriteHeader(status)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+	}
+
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	name := chi.URLParam(r, "*")
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			writeError(http.StatusBadRequest, fmt.Errorf("artifact path %s is outside of the artifacts directory", name))
+			return
+		}
+	}
+
+	data, err := getBuildStatusData(buildID)
+	if err != nil {
+		writeError(http.StatusNotFound, err)
+		return
+	}
+	if name == ArtifactChecksumsFile {
+		if data.ArtifactChecksums == nil {
+			writeError(http.StatusNotFound, fmt.Errorf("build %d has no artifact checksums", buildID))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(formatChecksums(data.ArtifactChecksums)))
+		return
+	}
+	collected := false
+	compressed := false
+	for _, artifact := range data.BuildArtifacts {
+		if artifact.Filename == name {
+			collected = true
+			compressed = artifact.Compressed
+		}
+	}
+	// Old builds have only the list of names
+	for _, artifact := range data.Artifacts {
+		if artifact == name {
+			collected = true
+		}
+	}
+	if !collected {
+		writeError(http.StatusNotFound, fmt.Errorf("build %d has no artifact %s", buildID, name))
+		return
+	}
+
+	// Artifacts might be already removed together with the wakespace
+	path := filepath.Join((&Build{ID: buildID}).GetArtifactsDir(), name)
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(http.StatusNotFound, fmt.Errorf("artifact %s of build %d is not available", name, buildID))
+		return
+	}
+	defer f.Close()
+	modified, err := artifactModified(data, name, path)
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+	if modified {
+		writeError(http.StatusConflict, fmt.Errorf("artifact %s of build %d has been modified after collection", name, buildID))
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+
+	GlobalUsage.RecordDownload(buildID)
+	if compressed {
+		serveCompressedArtifact(w, r, name, f, logger)
+		return
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	// The file is copied to the response in chunks, ranges are supported
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// serveCompressedArtifact sends content of the gzip-compressed artifact as
+// the original file. Ranges are not supported
+func serveCompressedArtifact(w http.ResponseWriter, r *http.Request, name string, f *os.File, logger *log.Logger) {
+	original := strings.TrimSuffix(name, CompressedArtifactExt)
+	contentType := mime.TypeByExtension(filepath.Ext(original))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filepath.Base(original)))
+	w.Header().Set("Vary", "Accept-Encoding")
+	var content io.Reader = f
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+	} else {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			logger.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(err.Error()))
+			return
+		}
+		defer zr.Close()
+		content = zr
+	}
+	_, err := io.Copy(w, content)
+	if err != nil {
+		logger.Println(err)
+	}
+}
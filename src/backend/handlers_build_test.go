@@ -0,0 +1,406 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHandleDownloadArtifacts(t *testing.T) {
+	setupTestEnv(t)
+	artifactsDir := (&Build{ID: 3}).GetArtifactsDir()
+	err := os.MkdirAll(artifactsDir+"dist/", os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(artifactsDir+"dist/app.tar.gz", []byte("binary"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll((&Build{ID: 4}).GetArtifactsDir(), os.ModePerm)
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/artifacts.zip", HandleDownloadArtifacts)
+	get := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/build/"+id+"/artifacts.zip", nil))
+		return w
+	}
+
+	w := get("3")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Disposition") != `attachment; filename="build-3-artifacts.zip"` {
+		t.Fatalf("Unexpected response %d %v", w.Code, w.Header())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "dist/app.tar.gz" {
+		t.Fatalf("Unexpected files in the archive %+v", zr.File)
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(f)
+	if string(content) != "binary" {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	// Empty artifacts dir and removed wakespace
+	for _, id := range []string{"4", "5"} {
+		if w := get(id); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for build %s, got %d", id, w.Code)
+		}
+	}
+}
+
+func TestHandleDownloadArtifacts_Symlinks(t *testing.T) {
+	setupTestEnv(t)
+	artifactsDir := (&Build{ID: 3}).GetArtifactsDir()
+	outside := Config.WorkDir + "outside/"
+	for _, dir := range []string{artifactsDir + "dist/", outside + "docs/"} {
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{
+		artifactsDir + "dist/app.bin": "binary",
+		outside + "docs/index.html":   "docs",
+		outside + "report.txt":        "report",
+	} {
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		artifactsDir + "docs":            outside + "docs",
+		artifactsDir + "dist/report.txt": outside + "report.txt",
+		artifactsDir + "dist/loop":       artifactsDir + "dist",
+		artifactsDir + "broken":          outside + "missing",
+	} {
+		err := os.Symlink(target, link)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/artifacts.zip", HandleDownloadArtifacts)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/build/3/artifacts.zip", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d", w.Code)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"dist/app.bin":    "binary",
+		"dist/report.txt": "report",
+		"docs/index.html": "docs",
+	}
+	if len(zr.File) != len(expected) {
+		t.Errorf("Expected %d files, got %d", len(expected), len(zr.File))
+	}
+	for _, file := range zr.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(f)
+		f.Close()
+		if string(content) != expected[file.Name] {
+			t.Errorf("Unexpected content of %s: %q", file.Name, content)
+		}
+	}
+}
+
+func TestHandleDownloadArtifact(t *testing.T) {
+	setupTestEnv(t)
+	artifactsDir := (&Build{ID: 3}).GetArtifactsDir()
+	err := os.MkdirAll(artifactsDir+"dist/", os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dist/report.json", "dist/stale.txt"} {
+		err = os.WriteFile(artifactsDir+name, []byte(`{"passed":true}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := &BuildUpdateData{ID: 3, BuildArtifacts: []*ArtifactInfo{{Filename: "dist/report.json"}}}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		dataB, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(HistoryBucket).Put(Itob(data.ID), dataB)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/artifacts/*", HandleDownloadArtifact)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/build/3/artifacts/dist/report.json")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" || w.Body.String() != `{"passed":true}` {
+		t.Errorf("Unexpected response %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	for path, code := range map[string]int{
+		"/build/3/artifacts/dist/stale.txt":             http.StatusNotFound,
+		"/build/4/artifacts/dist/report.json":           http.StatusNotFound,
+		"/build/3/artifacts/dist/../../build_plan.yaml": http.StatusBadRequest,
+	} {
+		if w := get(path); w.Code != code {
+			t.Errorf("Expected %d for %s, got %d", code, path, w.Code)
+		}
+	}
+}
+
+func TestCompressedArtifacts(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:              "compressed_artifacts",
+		Tasks:             []*Task{{Name: "report", Command: "echo passed > report.txt", Kind: KindMain}},
+		Artifacts:         []*ArtifactPattern{{Pattern: "*.txt"}},
+		CompressArtifacts: true,
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.BuildArtifacts) != 1 || data.BuildArtifacts[0].Filename != "report.txt.gz" || !data.BuildArtifacts[0].Compressed {
+		t.Fatalf("Unexpected artifacts %+v", data.BuildArtifacts)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/artifacts.zip", HandleDownloadArtifacts)
+	router.Get("/build/{id}/artifacts/*", HandleDownloadArtifact)
+	get := func(path string, encoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/build/%d/%s", build.ID, path), nil)
+		if encoding != "" {
+			r.Header.Set("Accept-Encoding", encoding)
+		}
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("artifacts/report.txt.gz", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" || w.Body.String() != "passed\n" {
+		t.Errorf("Expected decompressed content, got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+	w = get("artifacts/report.txt.gz", "deflate, gzip;q=0.8")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip-encoded content, got %d %v", w.Code, w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	if string(content) != "passed\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	w = get("artifacts.zip", "")
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 1 || archive.File[0].Name != "report.txt" {
+		t.Fatalf("Unexpected files in the archive %+v", archive.File)
+	}
+	f, err := archive.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = io.ReadAll(f)
+	if string(content) != "passed\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestArtifactChecksums(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:      "checksums",
+		Tasks:     []*Task{{Name: "build", Command: "echo app > app.bin && echo notes > notes.txt && touch checksums.sha256", Kind: KindMain}},
+		Artifacts: []*ArtifactPattern{{Pattern: "*.bin"}, {Pattern: "*.txt"}, {Pattern: "*.sha256"}},
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256sum of "app\n" and "notes\n"
+	expected := map[string]string{
+		"app.bin":   "8a8f60ecb09b7e64c6d5214a8043865e608507db8c3f61f995eae6d078875901",
+		"notes.txt": "444e0fffbd825e9610ff5b199485707a0c895339ae80c15cc8a8aee41b106fda",
+	}
+	if len(data.ArtifactChecksums) != 2 || data.ArtifactChecksums["app.bin"] != expected["app.bin"] || data.ArtifactChecksums["notes.txt"] != expected["notes.txt"] {
+		t.Fatalf("Unexpected checksums %+v", data.ArtifactChecksums)
+	}
+	if len(data.Warnings) != 1 {
+		t.Errorf("Expected a warning about the reserved name, got %v", data.Warnings)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/artifacts/*", HandleDownloadArtifact)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/build/%d/artifacts/%s", build.ID, path), nil))
+		return w
+	}
+
+	w := get(ArtifactChecksumsFile)
+	sums := fmt.Sprintf("%s  app.bin\n%s  notes.txt\n", expected["app.bin"], expected["notes.txt"])
+	if w.Code != http.StatusOK || w.Body.String() != sums {
+		t.Errorf("Unexpected checksums file %d %q", w.Code, w.Body.String())
+	}
+	file, err := os.ReadFile(build.GetArtifactsDir() + ArtifactChecksumsFile)
+	if err != nil || string(file) != sums {
+		t.Errorf("Unexpected checksums on disk %q, %v", file, err)
+	}
+	if w := get("app.bin"); w.Code != http.StatusOK || w.Body.String() != "app\n" {
+		t.Errorf("Unexpected response %d %q", w.Code, w.Body.String())
+	}
+
+	err = os.WriteFile(build.GetArtifactsDir()+"app.bin", []byte("tampered\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := get("app.bin"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for the modified artifact, got %d", w.Code)
+	}
+	if w := get("notes.txt"); w.Code != http.StatusOK {
+		t.Errorf("Expected the intact artifact, got %d", w.Code)
+	}
+}
+
+func TestHandleGetTaskLog(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:  "task_log",
+		Tasks: []*Task{{Name: "print", Command: "echo first && echo second", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	content, err := os.ReadFile(build.GetWakespaceDir() + TaskLogFileName(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/task/{taskID}/log", HandleGetTaskLog)
+	get := func(path string, byteRange string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if byteRange != "" {
+			r.Header.Set("Range", byteRange)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	path := fmt.Sprintf("/build/%d/task/0/log", build.ID)
+	w := get(path, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" || w.Body.String() != string(content) {
+		t.Fatalf("Unexpected response %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+	if !bytes.Contains(content, []byte("second")) {
+		t.Errorf("Expected the output of the task in the log, got %q", content)
+	}
+	w = get(path, "bytes=10-")
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(content[10:]) {
+		t.Errorf("Expected the rest of the log, got %d %q", w.Code, w.Body.String())
+	}
+	for p, code := range map[string]int{
+		fmt.Sprintf("/build/%d/task/1/log", build.ID):             http.StatusNotFound,
+		fmt.Sprintf("/build/%d/task/0/log?iteration=2", build.ID): http.StatusNotFound,
+		fmt.Sprintf("/build/%d/task/first/log", build.ID):         http.StatusBadRequest,
+	} {
+		if w := get(p, ""); w.Code != code {
+			t.Errorf("Expected %d for %s, got %d", code, p, w.Code)
+		}
+	}
+	if w := get(path, fmt.Sprintf("bytes=%d-", len(content)+10)); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected 416 for the range after the end of the log, got %d", w.Code)
+	}
+}
+
+func TestHandleGetBuildWorkflow(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:     "workflow",
+		Parallel: 2,
+		Tasks: []*Task{
+			{Name: "compile", Command: "sleep 0.2", Kind: KindMain},
+			{Name: "lint", Command: "true", Kind: KindMain},
+			{Name: "test", Command: "false", Kind: KindMain},
+			{Name: "deploy", Command: "true", Kind: KindMain},
+		},
+		Workflow: []*WorkflowStage{
+			{Name: "build", Tasks: []int{0, 1}},
+			{Name: "test", Tasks: []int{2}},
+			{Name: "deploy", Tasks: []int{3}},
+		},
+	}
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+
+	router := chi.NewRouter()
+	router.Get("/build/{id}/workflow", HandleGetBuildWorkflow)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/build/%d/workflow", build.ID), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+	var stages []*WorkflowStageStatus
+	err := json.Unmarshal(w.Body.Bytes(), &stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ItemStatus{StatusFinished, StatusFailed, StatusSkipped}
+	if len(stages) != len(expected) {
+		t.Fatalf("Expected %d stages, got %d", len(expected), len(stages))
+	}
+	for i, stage := range stages {
+		if stage.Stage != job.Workflow[i].Name || stage.Status != expected[i] {
+			t.Errorf("Expected stage %s %q, got %s %q", job.Workflow[i].Name, expected[i], stage.Stage, stage.Status)
+		}
+	}
+	if stages[0].Duration < 200 || len(stages[0].Tasks) != 2 {
+		t.Errorf("Expected duration of both tasks of the first stage, got %+v", stages[0])
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/build/999/workflow", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown build, got %d", w.Code)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// HandleGetBuildLogDiff compares logs of the same task in two builds
+// @Summary      Compare task logs of two builds
+// @Description  Returns unified diff between normalized logs of the task in two builds of the same job. Numbers, UUIDs and durations are stripped out before comparison
+// @Tags         builds
+// @Produce      plain
+// @Param        a        query      integer   true  "ID of the first build"
+// @Param        b        query      integer   true  "ID of the second build"
+// @Param        task     query      integer   true  "Task ID"
+// @Param        iteration query     integer   false "Index of the task instance, 0 by default"
+// @Success      200      {string}   string
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /builds/log-diff [get]
+func HandleGetBuildLogDiff(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	var ids [3]int
+	for i, key := range []string{"a", "b", "task"} {
+		value, err := strconv.Atoi(r.URL.Query().Get(key))
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid %s: %q", key, r.URL.Query().Get(key))
+			logger.Println(errMsg)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(errMsg))
+			return
+		}
+		ids[i] = value
+	}
+	buildA, buildB, taskID := ids[0], ids[1], ids[2]
+	iteration := 0
+	if r.URL.Query().Get("iteration") != "" {
+		var err error
+		iteration, err = strconv.Atoi(r.URL.Query().Get("iteration"))
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid iteration: %q", r.URL.Query().Get("iteration"))
+			logger.Println(errMsg)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(errMsg))
+			return
+		}
+	}
+
+	dataA, err := getBuildStatusData(buildA)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	dataB, err := getBuildStatusData(buildB)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if dataA.JobName() != dataB.JobName() {
+		errMsg := fmt.Sprintf("Builds %d and %d belong to different jobs", buildA, buildB)
+		logger.Println(errMsg)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(errMsg))
+		return
+	}
+
+	linesA, err := readNormalizedTaskLog(buildA, taskID, iteration)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	linesB, err := readNormalizedTaskLog(buildB, taskID, iteration)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	diff, err := UnifiedDiff(
+		linesA, linesB,
+		fmt.Sprintf("build/%d/%s", buildA, TaskLogFileName(taskID, iteration)),
+		fmt.Sprintf("build/%d/%s", buildB, TaskLogFileName(taskID, iteration)),
+	)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(diff))
+}
+
+// readTaskLog returns lines of the task log
+func readTaskLog(buildID int, taskID int, iteration int) ([]string, error) {
+	data, err := os.ReadFile(Config.WorkDir + "wakespace/" + strconv.Itoa(buildID) + "/" + TaskLogFileName(taskID, iteration))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// readNormalizedTaskLog returns normalized lines of the task log
+func readNormalizedTaskLog(buildID int, taskID int, iteration int) ([]string, error) {
+	lines, err := readTaskLog(buildID, taskID, iteration)
+	if err != nil {
+		return nil, err
+	}
+	return NormalizeLogLines(lines, nil), nil
+}
+
+// HandleListBuilds returns a page of the build history
+// @Summary      Return builds from the history
+// @Description  Builds are returned from the latest to the oldest. Pass `next_cursor` of the response as `cursor` to get the next page
+// @Tags         builds
+// @Produce      json
+// @Param        job      query      string    false "Name of the job"
+// @Param        status   query      string    false "Status of builds, e.g. failed"
+// @Param        cursor   query      integer   false "Return builds older than the build with this ID"
+// @Param        limit    query      integer   false "Maximum number of builds, 50 by default, 500 at most"
+// @Success      200      {object}   BuildsListData
+// @Failure      400      {string}   string
+// @Failure      500      {string}   string
+// @Router       /builds/ [get]
+func HandleListBuilds(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	badRequest := func(errMsg string) {
+		logger.Println(errMsg)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(errMsg))
+	}
+
+	query := r.URL.Query()
+	filter := &BuildsFilter{
+		Job:    query.Get("job"),
+		Status: ItemStatus(query.Get("status")),
+		Limit:  ListBuildsDefaultLimit,
+	}
+	if query.Get("limit") != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || filter.Limit <= 0 || filter.Limit > ListBuildsMaxLimit {
+			badRequest(fmt.Sprintf("Invalid limit: %q", query.Get("limit")))
+			return
+		}
+	}
+	if query.Get("cursor") != "" {
+		var err error
+		filter.Cursor, err = strconv.Atoi(query.Get("cursor"))
+		if err != nil || filter.Cursor <= 0 {
+			badRequest(fmt.Sprintf("Invalid cursor: %q", query.Get("cursor")))
+			return
+		}
+	}
+
+	data, err := ListBuilds(filter)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleGetBuildsGrouped returns the latest builds grouped by the value of a
+// label with the aggregate status of each group
+// @Summary      Return builds grouped by a label
+// @Description  Groups builds by the value of the label, e.g. SWEEP_ID for scheduled sweeps. Status of a group is running while any of its builds is not completed, otherwise the first non successful status
+// @Tags         builds
+// @Produce      json
+// @Param        label    query      string    true  "Label name"
+// @Param        value    query      string    false "Return only the group with this label value"
+// @Param        limit    query      integer   false "Maximum number of groups, 20 by default"
+// @Success      200      {array}    BuildGroupData
+// @Failure      400      {string}   string
+// @Failure      500      {string}   string
+// @Router       /builds/grouped [get]
+func HandleGetBuildsGrouped(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	label := r.URL.Query().Get("label")
+	value := r.URL.Query().Get("value")
+	if label == "" {
+		errMsg := "label is required"
+		logger.Println(errMsg)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(errMsg))
+		return
+	}
+	limit := 20
+	if r.URL.Query().Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			errMsg := fmt.Sprintf("Invalid limit: %q", r.URL.Query().Get("limit"))
+			logger.Println(errMsg)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(errMsg))
+			return
+		}
+	}
+
+	groups := []*BuildGroupData{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		index := map[string]*BuildGroupData{}
+		c := tx.Bucket(HistoryBucket).Cursor()
+		for key, v := c.Last(); key != nil; key, v = c.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			labelValue := msg.Labels[label]
+			if labelValue == "" || (value != "" && labelValue != value) {
+				continue
+			}
+			group, ok := index[labelValue]
+			if !ok {
+				// Builds of older groups might be interleaved with the
+				// latest groups, so stop only on the next group
+				if len(groups) == limit {
+					break
+				}
+				group = &BuildGroupData{
+					Label: label,
+					Value: labelValue,
+				}
+				index[labelValue] = group
+				groups = append(groups, group)
+			}
+			group.Builds = append(group.Builds, &msg)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	for _, group := range groups {
+		statuses := make([]ItemStatus, len(group.Builds))
+		for i, b := range group.Builds {
+			statuses[i] = b.Status
+		}
+		group.Status = AggregateStatus(statuses)
+	}
+
+	payloadB, err := json.Marshal(groups)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleGetBuildLogSearchAll searches task logs of the latest builds
+// @Summary      Search task logs across builds
+// @Description  Searches the latest 100 builds of the job or the latest 20 builds of all jobs. The query is case insensitive
+// @Tags         builds
+// @Produce      json
+// @Param        q        query      string    true  "Search query"
+// @Param        job      query      string    false "Name of the job"
+// @Param        limit    query      integer   false "Maximum number of matches, 20 by default"
+// @Success      200      {array}    LogSearchMatch
+// @Failure      400      {string}   string
+// @Failure      500      {string}   string
+// @Router       /builds/log-search [get]
+func HandleGetBuildLogSearchAll(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		errMsg := "q is required"
+		logger.Println(errMsg)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(errMsg))
+		return
+	}
+	limit := 20
+	if r.URL.Query().Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			errMsg := fmt.Sprintf("Invalid limit: %q", r.URL.Query().Get("limit"))
+			logger.Println(errMsg)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(errMsg))
+			return
+		}
+	}
+
+	matches, err := SearchLogs(query, r.URL.Query().Get("job"), limit)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(matches)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// HandleGetCompareLogs compares logs of the same task in two builds
+// @Summary      Compare task logs of two builds
+// @Description  Returns unified diff between logs of the task in two builds of the same job. Durations, numbers, UUIDs and matches of logdiffignore patterns from the config are ignored. The diff is truncated to max_size bytes. In summary mode returns the number of added and removed lines per block of 1000 lines, which works for logs of any size
+// @Tags         compare
+// @Produce      plain
+// @Produce      json
+// @Param        from      query      integer   true  "ID of the first build"
+// @Param        to        query      integer   true  "ID of the second build"
+// @Param        task      query      integer   true  "Task ID"
+// @Param        iteration query      integer   false "Index of the task instance, 0 by default"
+// @Param        mode      query      string    false "diff (default) or summary"
+// @Param        max_size  query      integer   false "Maximum size of the diff in bytes, 1MB by default"
+// @Success      200      {array}    LogDiffBlock
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /compare/logs [get]
+func HandleGetCompareLogs(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	badRequest := func(errMsg string) {
+		logger.Println(errMsg)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(errMsg))
+	}
+
+	var ids [3]int
+	for i, key := range []string{"from", "to", "task"} {
+		value, err := strconv.Atoi(r.URL.Query().Get(key))
+		if err != nil {
+			badRequest(fmt.Sprintf("Invalid %s: %q", key, r.URL.Query().Get(key)))
+			return
+		}
+		ids[i] = value
+	}
+	buildFrom, buildTo, taskID := ids[0], ids[1], ids[2]
+	iteration := 0
+	if r.URL.Query().Get("iteration") != "" {
+		var err error
+		iteration, err = strconv.Atoi(r.URL.Query().Get("iteration"))
+		if err != nil {
+			badRequest(fmt.Sprintf("Invalid iteration: %q", r.URL.Query().Get("iteration")))
+			return
+		}
+	}
+	maxSize := LogDiffMaxSize
+	if r.URL.Query().Get("max_size") != "" {
+		var err error
+		maxSize, err = strconv.Atoi(r.URL.Query().Get("max_size"))
+		if err != nil || maxSize <= 0 {
+			badRequest(fmt.Sprintf("Invalid max_size: %q", r.URL.Query().Get("max_size")))
+			return
+		}
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "diff" && mode != "summary" {
+		badRequest(fmt.Sprintf("Invalid mode: %q", mode))
+		return
+	}
+
+	dataFrom, err := getBuildStatusData(buildFrom)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	dataTo, err := getBuildStatusData(buildTo)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if dataFrom.JobName() != dataTo.JobName() {
+		badRequest(fmt.Sprintf("Builds %d and %d belong to different jobs", buildFrom, buildTo))
+		return
+	}
+
+	patterns, err := CompileLogDiffPatterns(Config.LogDiffIgnore)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	linesFrom, err := readTaskLog(buildFrom, taskID, iteration)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	linesTo, err := readTaskLog(buildTo, taskID, iteration)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	linesFrom = NormalizeLogLines(linesFrom, patterns)
+	linesTo = NormalizeLogLines(linesTo, patterns)
+
+	if mode == "summary" {
+		payload, err := json.Marshal(SummarizeLogDiff(linesFrom, linesTo, LogDiffSummaryBlock))
+		if err != nil {
+			logger.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(payload)
+		return
+	}
+
+	diff, err := UnifiedDiff(
+		linesFrom, linesTo,
+		fmt.Sprintf("build/%d/%s", buildFrom, TaskLogFileName(taskID, iteration)),
+		fmt.Sprintf("build/%d/%s", buildTo, TaskLogFileName(taskID, iteration)),
+	)
+	if err != nil {
+		badRequest(err.Error() + ", use mode=summary")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(TruncateDiff(diff, maxSize)))
+}
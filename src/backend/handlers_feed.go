@@ -17,7 +17,7 @@ import (
 // @Tags         feed
 // @Produce      json
 // @Param        offset   query      integer   false  "Skip `offset` latest builds"
-// @Param        filter   query      string    false  "Returns only builds which ID, name, params or status contains any of the space-separated words. Requires presence of the prefixed with `+` words. Requires absence of the prefixed with `-` words. Phrases can be wrapped in single or double quotes"
+// @Param        filter   query      string    false  "Returns only builds which ID, name (of the job or the job instance), params, status or matrix group contains any of the space-separated words. Requires presence of the prefixed with `+` words. Requires absence of the prefixed with `-` words. Phrases can be wrapped in single or double quotes"
 // @Success      200      {array}    BuildUpdateData
 // @Failure      400      {string}   string
 // @Failure      500      {string}   string
@@ -90,7 +90,12 @@ func HandleFeedView(w http.ResponseWriter, r *http.Request) {
 					}
 				}
 				if filter != nil {
-					if matchesFilter(fmt.Sprintf("%v %s %s %s", msg.ID, msg.Name, msg.Status, msg.Params), filter) {
+					// Builds of the matrix are found with matrix:<id>
+					matrix := ""
+					if msg.MatrixBuildID != 0 {
+						matrix = fmt.Sprintf("matrix:%d", msg.MatrixBuildID)
+					}
+					if matchesFilter(fmt.Sprintf("%v %s %s %s %s %s", msg.ID, msg.Name, msg.Template, msg.Status, msg.Params, matrix), filter) {
 						count++
 						if count <= offset {
 							continue
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleGetJobGroups returns job groups with the latest builds of their jobs
+// @Summary      Return job groups
+// @Description  Jobs are grouped by `group` of the job. Status of a group is aggregated from the latest builds of its jobs like status of a sweep
+// @Tags         groups
+// @Produce      json
+// @Success      200      {array}    JobGroupData
+// @Failure      500      {string}   string
+// @Router       /groups/ [get]
+func HandleGetJobGroups(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	groups, err := GetJobGroups()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payloadB, err := json.Marshal(groups)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleRunJobGroup starts all jobs of the group
+// @Summary      Start all jobs of the group
+// @Description  A build is created for every job of the group, the builds share the GROUP_RUN_ID label. A job which can't be started is reported in `errors` and doesn't prevent other jobs from starting
+// @Tags         groups
+// @Produce      json
+// @Param        name     path       string   true   "Name of the group"
+// @Param        param1   query      string   false  "Override default `params` of the jobs"
+// @Success      200      {object}   JobGroupRunData
+// @Failure      404      {string}   string
+// @Router       /group/{name}/run [post]
+func HandleRunJobGroup(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		logger.Println(err)
+	}
+
+	data, err := RunJobGroup(chi.URLParam(r, "name"), r.Form, TriggerManual)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	bolt "go.etcd.io/bbolt"
+)
+
+// internalRoutes mounts endpoints for monitoring and debugging. They are
+// served by the internal listener if `internaladdr` is configured, otherwise
+// by the main router with authentication. Health check and metrics skip
+// authentication if `publicmonitoring` is enabled
+func internalRoutes(router chi.Router, auth ...func(http.Handler) http.Handler) {
+	monitoring := router.With(auth...)
+	if Config.PublicMonitoring {
+		monitoring = router
+	}
+	monitoring.Get("/healthz", HandleHealthz)
+	monitoring.Get("/metrics", HandleMetrics)
+	router.With(auth...).Mount("/debug", middleware.Profiler())
+}
+
+// HandleHealthz reports that the service is up and the database is readable
+// @Summary      Health check
+// @Description  Returns ok if the database is readable. Served by the internal listener if `internaladdr` is configured
+// @Tags         internal
+// @Produce      plain
+// @Success      200      {string}   string
+// @Failure      503      {string}   string
+// @Router       /healthz [get]
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	err := DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(GlobalBucket) == nil {
+			return fmt.Errorf("bucket %s is not found", GlobalBucket)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok"))
+}
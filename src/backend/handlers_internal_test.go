@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestInternalRoutes(t *testing.T) {
+	cases := []struct {
+		auth             bool
+		publicMonitoring bool
+		expected         map[string]int
+	}{
+		// Internal listener
+		{false, false, map[string]int{"/healthz": http.StatusOK, "/metrics": http.StatusOK, "/debug/pprof/": http.StatusOK}},
+		// Main router
+		{true, false, map[string]int{"/healthz": http.StatusForbidden, "/metrics": http.StatusForbidden, "/debug/pprof/": http.StatusForbidden}},
+		{true, true, map[string]int{"/healthz": http.StatusOK, "/metrics": http.StatusOK, "/debug/pprof/": http.StatusForbidden}},
+	}
+	for _, c := range cases {
+		setupTestEnv(t)
+		Config.PublicMonitoring = c.publicMonitoring
+		router := chi.NewRouter()
+		if c.auth {
+			internalRoutes(router, AuthMi)
+		} else {
+			internalRoutes(router)
+		}
+		for path, expected := range c.expected {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != expected {
+				t.Errorf("auth %t, public monitoring %t, %s: expected %d, got %d", c.auth, c.publicMonitoring, path, expected, w.Code)
+			}
+		}
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	setupTestEnv(t)
+	w := httptest.NewRecorder()
+	HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("Expected ok, got %d %s", w.Code, w.Body.String())
+	}
+
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(GlobalBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without the global bucket, got %d", w.Code)
+	}
+}
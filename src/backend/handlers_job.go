@@ -1,28 +1,56 @@
 package main
 
 import (
+	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	bolt "go.etcd.io/bbolt"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Headers of requests which start jobs. They are not mixed with params, so
+// a job can have params with any names
+const (
+	PresetHeader   = "X-Wake-Preset"
+	PriorityHeader = "X-Wake-Priority"
+)
+
+// requestTrigger returns the trigger of the build started by the request
+func requestTrigger(r *http.Request) *TriggerInfo {
+	return &TriggerInfo{
+		Kind:     TriggerManual,
+		Preset:   r.Header.Get(PresetHeader),
+		Priority: r.Header.Get(PriorityHeader),
+	}
+}
+
 // HandleRunJob adds job to queue
 // @Summary      Start a job
-// @Description  A new build for the job `name` is created and added to the queue. Returns build id
+// @Description  A new build for the job `name` is created and added to the queue. Returns build id. If the job has `matrix`, a build is created for every combination and id of the first one is returned
 // @Tags         job
 // @Produce      plain
 // @Param        name     path       string   true   "Name of the job"
 // @Param        param1   query      string   false  "Override default `params` of the job"
 // @Param        param2   formData   string   false  "Override default `params` of the job"
+// @Param        X-Wake-Preset    header  string  false  "Apply values of the preset before overriding `params` of the job"
+// @Param        X-Wake-Priority  header  string  false  "Priority of the build in the queue: low, normal (default) or high"
 // @Success      200      {integer}  integer
+// @Header       200      {string}   X-Wake-Deduplicated  "true if the job has `dedupe` and ID of the pending build with the same params is returned"
 // @Failure      400      {string}   string
+// @Failure      412      {string}   string
+// @Failure      422      {object}   ParamValidationError
 // @Router       /job/{name}/run [post]
 func HandleRunJob(w http.ResponseWriter, r *http.Request) {
 	logger, ok := r.Context().Value(HL).(*log.Logger)
@@ -35,11 +63,37 @@ func HandleRunJob(w http.ResponseWriter, r *http.Request) {
 		logger.Println(err)
 	}
 
-	build, err := RunJob(chi.URLParam(r, "name"), r.Form)
-	if err != nil {
+	build, err := runJobWithTrigger(chi.URLParam(r, "name"), r.Form, requestTrigger(r), nil)
+	writeRunJobResponse(w, logger, build, err)
+}
+
+// writeRunJobResponse writes id of the started build or the error of RunJob
+func writeRunJobResponse(w http.ResponseWriter, logger *log.Logger, build *Build, err error) {
+	var duplicateErr *DuplicateBuildError
+	if errors.As(err, &duplicateErr) {
 		logger.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		build = duplicateErr.Build
+		w.Header().Set(DeduplicatedHeader, "true")
+	} else if err != nil {
+		logger.Println(err)
+		var paramsErr *ParamValidationError
+		if errors.As(err, &paramsErr) {
+			payloadB, err := json.Marshal(paramsErr)
+			if err != nil {
+				logger.Println(err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(payloadB)
+			return
+		}
 		w.Header().Set("Content-Type", "text/plain")
+		var prerequisiteErr *PrerequisiteError
+		if errors.As(err, &prerequisiteErr) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
 		w.Write([]byte(err.Error()))
 		return
 	}
@@ -47,6 +101,116 @@ func HandleRunJob(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(strconv.Itoa(build.ID)))
 }
 
+// HandleTriggerBuild starts a job with params from the JSON body
+// @Summary      Start a job with params
+// @Description  Values of the JSON object override default `params` of the job, e.g. `{"BRANCH": "main"}`. Only params declared in the job can be set, empty values keep defaults. Returns build id
+// @Tags         job
+// @Accept       json
+// @Produce      plain
+// @Param        name     path       string             true   "Name of the job"
+// @Param        params   body       map[string]string  false  "Values of params"
+// @Param        X-Wake-Preset    header  string  false  "Apply values of the preset before overriding `params` of the job"
+// @Param        X-Wake-Priority  header  string  false  "Priority of the build in the queue: low, normal (default) or high"
+// @Success      200      {integer}  integer
+// @Header       200      {string}   X-Wake-Deduplicated  "true if the job has `dedupe` and ID of the pending build with the same params is returned"
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      412      {string}   string
+// @Failure      422      {object}   ParamValidationError
+// @Router       /job/{name}/trigger [post]
+func HandleTriggerBuild(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	writeError := func(status int, err error) {
+		logger.Println(err)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+	}
+
+	name := chi.URLParam(r, "name")
+	jobFile := Config.JobDir + name + Config.jobsExt
+	if _, err := os.Stat(jobFile); err != nil {
+		writeError(http.StatusNotFound, fmt.Errorf("job %s is not found", name))
+		return
+	}
+	job, err := CreateJobFromFile(jobFile)
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	values := map[string]string{}
+	err = json.NewDecoder(r.Body).Decode(&values)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(http.StatusBadRequest, fmt.Errorf("unable to parse params: %w", err))
+		return
+	}
+	undeclared := job.undeclaredParams(values)
+	if len(undeclared) > 0 {
+		writeError(http.StatusBadRequest, fmt.Errorf("params %s are not declared in job %s", strings.Join(undeclared, ", "), name))
+		return
+	}
+	params := url.Values{}
+	for key, value := range values {
+		params.Set(key, value)
+	}
+	build, err := runJobWithTrigger(name, params, requestTrigger(r), nil)
+	writeRunJobResponse(w, logger, build, err)
+}
+
+// undeclaredParams returns sorted names of values which are not params of the
+// job
+func (j *Job) undeclaredParams(values map[string]string) []string {
+	declared := map[string]bool{}
+	for _, param := range j.DefaultParams {
+		for key := range param {
+			declared[key] = true
+		}
+	}
+	undeclared := []string{}
+	for key := range values {
+		if !declared[key] {
+			undeclared = append(undeclared, key)
+		}
+	}
+	sort.Strings(undeclared)
+	return undeclared
+}
+
+// HandleAbortPendingJob aborts all pending builds of the job
+// @Summary      Abort pending builds of the job
+// @Description  Aborts builds of the job which are still in the queue, running builds are not affected. `on_aborted` tasks run for every aborted build. Returns IDs of aborted builds
+// @Tags         job
+// @Produce      json
+// @Param        name     path       string   true   "Name of the job"
+// @Success      200      {array}    integer
+// @Failure      500      {string}   string
+// @Router       /job/{name}/abort-pending [post]
+func HandleAbortPendingJob(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	name := chi.URLParam(r, "name")
+	ids := GlobalQueue.AbortPendingJob(name, StatusAborted)
+	logger.Printf("Aborting %d pending builds of job %s: %v\n", len(ids), name, ids)
+
+	payloadB, err := json.Marshal(ids)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
 // HandleJobGet returns content of a specific job file
 // @Summary      Return the content of the job
 // @Tags         job
@@ -69,8 +233,13 @@ func HandleJobGet(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(err.Error()))
 		return
 	}
+	notice, err := GetJobNotice(chi.URLParam(r, "name"))
+	if err != nil {
+		logger.Println(err)
+	}
 	jd := JobData{
 		Content: string(data),
+		Notice:  notice,
 	}
 	payloadB, err := json.Marshal(jd)
 	if err != nil {
@@ -124,6 +293,294 @@ func HandleJobPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verify provided presets
+	err = job.verifyPresets()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided requirements
+	err = job.verifyRequires()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided line buffer sizes
+	err = job.verifyLineBufferSize()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided log output modes
+	err = job.verifyLogOutput()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided resources
+	err = job.verifyResources()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided workspace audit
+	err = job.verifyAuditWorkspace()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided task timeouts
+	err = job.verifyTaskTimeouts()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided task retries
+	err = job.verifyTaskRetries()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided task groups
+	err = job.verifyTaskGroups()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided task conditions
+	err = job.verifyTaskConditions()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided task dependencies
+	err = job.verifyTaskDependencies()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided number of retries
+	err = job.verifyRetry()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided artifact patterns
+	err = job.verifyArtifacts()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided reap_children values
+	err = job.verifyReapChildren()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided status webhook
+	err = job.verifyStatusWebhook()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// Verify provided build webhook
+	err = job.verifyWebhook()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// Verify provided statuses to notify about
+	err = job.verifyNotifyOn()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided webhook params
+	err = job.verifyWebhookParams()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided branches
+	err = job.verifyBranches()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided schedule matrix
+	err = job.verifyScheduleMatrix()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided matrix
+	err = job.verifyMatrix()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided concurrency
+	err = job.verifyConcurrency()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided params schema
+	err = job.verifyParamsSchema()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided log parser
+	_, err = GetLogParser(job.LogParser)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided disk quota
+	err = job.verifyDiskQuota()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided artifact size limit
+	err = job.verifyMaxArtifactSize()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided downstream triggers
+	err = job.verifyTriggers()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided build retention
+	err = job.verifyBuildRetention()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Verify provided secret params
+	err = job.verifySecretParams()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	contentB = NormalizeNewlines(contentB)
 
 	path := Config.JobDir + chi.URLParam(r, "name") + Config.jobsExt
@@ -234,3 +691,348 @@ func HandleJobSetActive(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte(activeStatus))
 }
+
+// HandleJobContext returns everything that affects how builds of the job are
+// created and executed
+// @Summary      Return the execution context of the job
+// @Description  Diagnostic information: paths, included files, timeout, names of used secrets, environment, schedule and concurrency
+// @Tags         job
+// @Produce      json
+// @Param        name     path    string   true   "Name of the job"
+// @Success      200      {object}   JobContextData
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /job/{name}/context [get]
+func HandleJobContext(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	name := chi.URLParam(r, "name")
+	path := Config.JobDir + name + Config.jobsExt
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Println(err)
+		if os.IsNotExist(err) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Read tasks without expanding them to find included files
+	rawJob := Job{}
+	err = yaml.Unmarshal(data, &rawJob)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ot := OnTasks{}
+	err = yaml.Unmarshal(data, &ot)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	rawTasks := append([]*Task{}, rawJob.Tasks...)
+	for _, tasks := range [][]*Task{ot.OnPending, ot.OnRunning, ot.OnFailed, ot.OnAborted, ot.OnFinished, ot.Finally} {
+		rawTasks = append(rawTasks, tasks...)
+	}
+	includes, err := ListIncludes(rawTasks)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := CreateJobFromFile(path)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Secrets can be used in the job file and in included files
+	content := string(data)
+	for _, include := range includes {
+		includeData, err := os.ReadFile(include)
+		if err != nil {
+			logger.Println(err)
+			continue
+		}
+		content += "\n" + string(includeData)
+	}
+
+	envVars := map[string]string{
+		"WAKE_JOB_NAME":     job.Name,
+		"WAKE_JOB_TEMPLATE": job.Name,
+		"WAKE_CONFIG_DIR":   Config.JobDir,
+	}
+	for idx := range job.DefaultParams {
+		for pkey, pval := range job.DefaultParams[idx] {
+			envVars[pkey] = redactSecrets(injectSecrets(pval))
+		}
+	}
+
+	schedule := job.cronSpec()
+	if schedule != "" {
+		schedule = addCronTimezone(schedule)
+	}
+
+	payload := JobContextData{
+		JobDir:           Config.JobDir,
+		WorkDir:          Config.WorkDir,
+		JobFilePath:      path,
+		InheritsChain:    append([]string{path}, includes...),
+		EffectiveTimeout: job.Timeout,
+		ResolvedSecrets:  listSecretNames(content),
+		EnvVars:          envVars,
+		Schedule:         schedule,
+		Parallelism:      job.Concurrency,
+	}
+
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// JobContextData describes the context in which builds of the job are executed
+type JobContextData struct {
+	JobDir           string            `json:"job_dir"`
+	WorkDir          string            `json:"work_dir"`
+	JobFilePath      string            `json:"job_file_path"`
+	InheritsChain    []string          `json:"inherits_chain"` // The job file and all included files
+	EffectiveTimeout string            `json:"effective_timeout"`
+	ResolvedSecrets  []string          `json:"resolved_secrets"` // Only names
+	EnvVars          map[string]string `json:"env_vars"`
+	Schedule         string            `json:"schedule"`
+	Parallelism      int               `json:"parallelism"` // 0 - unlimited
+}
+
+// HandleJobBadge returns SVG badge with the status of the latest build of the
+// job
+// @Summary      Return status badge of the job
+// @Description  SVG badge with the status of the latest build of the job (or of the job instance). Available without authentication if `publicbadges` is enabled
+// @Tags         job
+// @Produce      image/svg+xml
+// @Param        name     path    string   true   "Name of the job"
+// @Param        style    query   string   false  "Badge style: flat (default) or flat-square"
+// @Param        label    query   string   false  "Left-hand side text of the badge (default: build)"
+// @Success      200      {string}    string
+// @Failure      500      {string}    string
+// @Router       /job/{name}/badge.svg [get]
+// @Router       /badge/{name} [get]
+func HandleJobBadge(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	name := chi.URLParam(r, "name")
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		label = "build"
+	}
+	style := r.URL.Query().Get("style")
+	if style != BadgeStyleFlatSquare {
+		style = BadgeStyleFlat
+	}
+
+	var status ItemStatus
+	err := DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(HistoryBucket).Cursor()
+		for key, v := c.Last(); key != nil; key, v = c.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				logger.Println(err)
+				continue
+			}
+			if msg.Name != name && msg.JobName() != name {
+				continue
+			}
+			status = msg.Status
+			switch status {
+			case StatusPending, StatusRunning:
+				if !GlobalQueue.Verify(msg.ID) {
+					status = StatusAborted
+				}
+			case StatusFinished:
+				if len(msg.FinalizationErrors) > 0 {
+					status = badgeFinalizationErrors
+				}
+			}
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	message, color := getBadgeMessage(status)
+	badge := RenderBadge(label, message, color, style)
+
+	// The status changes, but README renderers (e.g. GitHub camo) fetch the
+	// badge on every page view, so it is cached for a short time
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum([]byte(badge)))
+	w.Header().Set("Cache-Control", "max-age=30")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(badge))
+}
+
+// HandleGetBuildParamImpact groups the latest builds of the job by the value
+// of the param
+// @Summary      Return outcomes of builds per value of the param
+// @Description  Analyzes the latest 100 completed builds of the job. Builds without the param are ignored
+// @Tags         job
+// @Produce      json
+// @Param        name     path    string   true   "Name of the job"
+// @Param        param    query   string   true   "Name of the param, e.g. BRANCH"
+// @Success      200      {array}    ParamImpact
+// @Failure      400      {string}   string
+// @Failure      500      {string}   string
+// @Router       /job/{name}/param-impact [get]
+func HandleGetBuildParamImpact(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	name := chi.URLParam(r, "name")
+	param := r.URL.Query().Get("param")
+	if param == "" {
+		logger.Println("param is required")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("param is required"))
+		return
+	}
+
+	builds, err := getLatestCompletedBuilds(name, ParamImpactBuilds)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(CalculateParamImpact(builds, param))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleJobNoticePut pins a notice to the job
+// @Summary      Pin a notice to the job
+// @Description  The notice is returned with the job and the list of jobs, included in status webhooks of failed builds and shown on the status page until it expires. Empty `text` removes the notice
+// @Tags         job
+// @Produce      json
+// @Param        name        path       string   true   "Name of the job"
+// @Param        text        formData   string   false  "Text of the notice"
+// @Param        severity    formData   string   false  "info (default), warning or critical"
+// @Param        expires_at  formData   string   false  "The notice is removed after the time, RFC 3339"
+// @Param        author      formData   string   false  "Who pinned the notice, the user of basic auth by default"
+// @Success      200      {object}   JobNotice
+// @Failure      400      {string}   string
+// @Router       /job/{name}/notice [put]
+func HandleJobNoticePut(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	badRequest := func(err error) {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+	}
+
+	name := chi.URLParam(r, "name")
+	author := r.FormValue("author")
+	if author == "" {
+		author, _, _ = r.BasicAuth()
+	}
+	if author == "" {
+		author = r.RemoteAddr
+	}
+	var notice *JobNotice
+	if r.FormValue("text") != "" {
+		notice = &JobNotice{
+			Text:      r.FormValue("text"),
+			Severity:  r.FormValue("severity"),
+			Author:    author,
+			CreatedAt: time.Now(),
+		}
+		if notice.Severity == "" {
+			notice.Severity = NoticeInfo
+		}
+		if value := r.FormValue("expires_at"); value != "" {
+			expiresAt, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				badRequest(err)
+				return
+			}
+			notice.ExpiresAt = &expiresAt
+		}
+	}
+	err := SaveJobNotice(name, notice)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	if notice == nil {
+		AuditLogger.Printf("Notice of job %s has been removed by %s\n", name, author)
+	} else {
+		AuditLogger.Printf("Notice of job %s has been set by %s: [%s] %s\n", name, author, notice.Severity, notice.Text)
+	}
+
+	payloadB, err := json.Marshal(notice)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
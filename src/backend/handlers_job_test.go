@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHandleTriggerBuild(t *testing.T) {
+	setupTestEnv(t)
+	content := `
+params:
+  - ENV: staging
+  - VERSION: latest
+tasks:
+  - run: echo ${ENV} ${VERSION}
+`
+	err := os.WriteFile(Config.JobDir+"deploy.yaml", []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("deploy"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Post("/job/{name}/trigger", HandleTriggerBuild)
+	trigger := func(name, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/job/"+name+"/trigger", strings.NewReader(body)))
+		return w
+	}
+
+	for _, c := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"unknown", `{}`, http.StatusNotFound},
+		{"deploy", `{"ENV": "production", "REGION": "eu", "DEBUG": "1"}`, http.StatusBadRequest},
+		{"deploy", `["ENV"]`, http.StatusBadRequest},
+	} {
+		w := trigger(c.name, c.body)
+		if w.Code != c.code {
+			t.Errorf("Expected %d for %s, got %d %s", c.code, c.body, w.Code, w.Body.String())
+		}
+		if contentType := w.Result().Header.Get("Content-Type"); contentType != "text/plain" {
+			t.Errorf("Expected text/plain error for %s, got %q", c.body, contentType)
+		}
+	}
+	if w := trigger("deploy", `{"REGION": "eu", "DEBUG": "1"}`); !strings.Contains(w.Body.String(), "DEBUG, REGION") {
+		t.Errorf("Expected undeclared params to be listed, got %q", w.Body.String())
+	}
+	if GlobalQueue.HasJob("deploy") {
+		t.Fatal("Expected no builds to be created for invalid requests")
+	}
+
+	cases := []struct {
+		body     string
+		expected []string
+	}{
+		{`{"ENV": "production"}`, []string{"production", "latest"}},
+		{``, []string{"staging", "latest"}},
+	}
+	for _, c := range cases {
+		w := trigger("deploy", c.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+		}
+		id, err := strconv.Atoi(w.Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var params []map[string]string
+		waitFor(t, 5*time.Second, "the build is recorded", func() bool {
+			data, err := getBuildStatusData(id)
+			if err != nil || data.Name != "deploy" {
+				return false
+			}
+			params = data.Params
+			return true
+		})
+		if params[0]["ENV"] != c.expected[0] || params[1]["VERSION"] != c.expected[1] {
+			t.Errorf("%q: unexpected params %v", c.body, params)
+		}
+	}
+}
+
+func TestHandleRunJob_PresetAndPriority(t *testing.T) {
+	setupTestEnv(t)
+	// Params named as the headers are plain params
+	content := `
+params:
+  - ENV: staging
+  - preset: none
+  - priority: none
+presets:
+  canary:
+    ENV: production
+tasks:
+  - run: "true"
+`
+	err := os.WriteFile(Config.JobDir+"deploy.yaml", []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("deploy"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Post("/job/{name}/run", HandleRunJob)
+	run := func(preset, priority string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/job/deploy/run?preset=custom&priority=urgent", nil)
+		r.Header.Set(PresetHeader, preset)
+		r.Header.Set(PriorityHeader, priority)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, c := range [][2]string{{"unknown", ""}, {"", "urgent"}} {
+		if w := run(c[0], c[1]); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %d %s", c, w.Code, w.Body.String())
+		}
+	}
+
+	w := run("canary", PriorityHigh)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	id, err := strconv.Atoi(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data *BuildUpdateData
+	waitFor(t, 5*time.Second, "the build is recorded", func() bool {
+		data, err = getBuildStatusData(id)
+		return err == nil && data.Name == "deploy"
+	})
+	if data.Params[0]["ENV"] != "production" || data.Params[1]["preset"] != "custom" || data.Params[2]["priority"] != "urgent" {
+		t.Errorf("Unexpected params %v", data.Params)
+	}
+	if data.Priority != PriorityHigh || data.Trigger == nil || data.Trigger.Preset != "canary" {
+		t.Errorf("Expected high priority and preset canary, got %s %+v", data.Priority, data.Trigger)
+	}
+}
+
+func TestHandleRunJob_Presets(t *testing.T) {
+	setupTestEnv(t)
+	putTestJob(t, "deploy", `
+params:
+  - ENV: staging
+  - REGION: eu
+  - VERSION: latest
+presets:
+  canary:
+    ENV: production
+    REGION: us
+tasks:
+  - run: "true"
+`)
+	router := chi.NewRouter()
+	router.Post("/job/{name}/run", HandleRunJob)
+	run := func(preset, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/job/deploy/run?"+query, nil)
+		r.Header.Set(PresetHeader, preset)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := run("nightly", "")
+	if w.Code != http.StatusBadRequest || w.Body.String() != "job deploy doesn't have preset nightly" {
+		t.Errorf("Expected 400 for an unknown preset, got %d %s", w.Code, w.Body.String())
+	}
+	if GlobalQueue.HasJob("deploy") {
+		t.Fatal("Expected no builds to be created for an unknown preset")
+	}
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		// Values of the preset are applied over defaults
+		{"", []string{"production", "us", "latest"}},
+		// Explicit params override the preset
+		{"REGION=ap&VERSION=1.2", []string{"production", "ap", "1.2"}},
+	}
+	for _, c := range cases {
+		w := run("canary", c.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+		}
+		id, err := strconv.Atoi(w.Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data *BuildUpdateData
+		waitFor(t, 5*time.Second, "the build is recorded", func() bool {
+			data, err = getBuildStatusData(id)
+			return err == nil && data.Name == "deploy"
+		})
+		for i, name := range []string{"ENV", "REGION", "VERSION"} {
+			if data.Params[i][name] != c.expected[i] {
+				t.Errorf("%q: expected %s=%s, got %v", c.query, name, c.expected[i], data.Params)
+			}
+		}
+	}
+}
+
+func TestHandleJobContext(t *testing.T) {
+	setupTestEnv(t)
+	Config.Timezone = "Europe/Amsterdam"
+	Config.secrets = map[string]string{"api_token": "s3cr3t", "deploy_key": "k3y", "unused": "u"}
+	files := map[string]string{
+		"deploy.yaml": `
+timeout: 10m
+schedule: "0 3 * * *"
+concurrency: 2
+params:
+  - TOKEN: "{{ secrets.api_token }}"
+  - ENV: staging
+tasks:
+  - include: common.tasks
+on_failed:
+  - run: echo {{ secrets.unknown }}
+`,
+		"common.tasks": `
+- name: nested
+  include: nested.tasks
+- run: echo {{ secrets.deploy_key }} {{secrets.api_token}}
+`,
+		"nested.tasks": "- run: \"true\"\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(Config.JobDir+name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := chi.NewRouter()
+	router.Get("/job/{name}/context", HandleJobContext)
+	get := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/job/"+name+"/context", nil))
+		return w
+	}
+
+	if w := get("unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %d", w.Code)
+	}
+	w := get("deploy")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+	var data JobContextData
+	err := json.Unmarshal(w.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := []string{Config.JobDir + "deploy.yaml", Config.JobDir + "common.tasks", Config.JobDir + "nested.tasks"}
+	if strings.Join(data.InheritsChain, " ") != strings.Join(chain, " ") {
+		t.Errorf("Expected included files %v, got %v", chain, data.InheritsChain)
+	}
+	// Only names of known secrets are returned
+	if strings.Join(data.ResolvedSecrets, " ") != "api_token deploy_key" {
+		t.Errorf("Unexpected secrets %v", data.ResolvedSecrets)
+	}
+	if strings.Contains(w.Body.String(), "s3cr3t") || data.EnvVars["TOKEN"] != redactedSecret || data.EnvVars["ENV"] != "staging" || data.EnvVars["WAKE_JOB_NAME"] != "deploy" {
+		t.Errorf("Unexpected env variables %v", data.EnvVars)
+	}
+	if data.Schedule != "CRON_TZ=Europe/Amsterdam 0 3 * * *" || data.EffectiveTimeout != "10m" || data.Parallelism != 2 {
+		t.Errorf("Unexpected schedule, timeout or parallelism: %+v", data)
+	}
+}
@@ -2,16 +2,30 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/robfig/cron/v3"
 	bolt "go.etcd.io/bbolt"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// JobScheduleRuns is the default number of next runs returned by
+// HandleJobSchedule
+const JobScheduleRuns = 5
+
+// JobScheduleMaxRuns limits the number of next runs returned by
+// HandleJobSchedule
+const JobScheduleMaxRuns = 100
+
 // HandleJobsView returns all available jobs
 // @Summary      Returns list of available jobs
+// @Description  `next_run` is the next run of the job scheduled by cron
 // @Tags         jobs
 // @Produce      json
 // @Success      200      {array}    JobsListData
@@ -23,6 +37,7 @@ func HandleJobsView(w http.ResponseWriter, r *http.Request) {
 		logger = Logger
 	}
 
+	nextRuns := nextJobRuns(time.Now())
 	var data []*JobsListData
 	err := DB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(JobsBucket))
@@ -42,8 +57,23 @@ func HandleJobsView(w http.ResponseWriter, r *http.Request) {
 				job.Desc = string(desc)
 				interval := jb.Get([]byte("interval"))
 				job.Interval = string(interval)
+				job.Group = string(jb.Get([]byte("group")))
+				presets := jb.Get([]byte("presets"))
+				if presets != nil {
+					err = json.Unmarshal(presets, &job.Presets)
+					if err != nil {
+						return err
+					}
+				}
 				active := jb.Get([]byte("active"))
 				job.Active = string(active)
+				job.Notice, err = getJobNotice(jb)
+				if err != nil {
+					return err
+				}
+			}
+			if next, ok := nextRuns[job.Name]; ok {
+				job.NextRun = &next
 			}
 			data = append(data, &job)
 		}
@@ -128,3 +158,113 @@ func HandleJobsCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// HandleJobSchedule returns next runs of the job scheduled by cron
+// @Summary      Return next runs of the job
+// @Description  Next fire times of `interval` (or `schedule`) and `schedule_matrix` of the job. Empty if the job isn't scheduled
+// @Tags         jobs
+// @Produce      json
+// @Param        name     path       string    true  "Job name"
+// @Param        count    query      integer   false "Number of next runs, 5 by default"
+// @Success      200      {object}   JobScheduleData
+// @Failure      400      {string}   string
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /jobs/{name}/schedule [get]
+func HandleJobSchedule(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	count := JobScheduleRuns
+	if r.URL.Query().Get("count") != "" {
+		var err error
+		count, err = strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count <= 0 || count > JobScheduleMaxRuns {
+			errMsg := fmt.Sprintf("Invalid count: %q", r.URL.Query().Get("count"))
+			logger.Println(errMsg)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(errMsg))
+			return
+		}
+	}
+
+	data := &JobScheduleData{
+		Name: chi.URLParam(r, "name"),
+		Next: []time.Time{},
+	}
+	err := DB.View(func(tx *bolt.Tx) error {
+		jb := tx.Bucket(JobsBucket).Bucket([]byte(data.Name))
+		if jb == nil {
+			return fmt.Errorf("job %s is not found", data.Name)
+		}
+		data.Interval = string(jb.Get([]byte("interval")))
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range GlobalCron.Entries() {
+		switch entryJob := entry.Job.(type) {
+		case *Job:
+			if entryJob.Name == data.Name {
+				data.Next = nextCronRuns(entry.Schedule, now, count)
+			}
+		case *SweepSchedule:
+			if entryJob.Name == data.Name {
+				data.SweepNext = nextCronRuns(entry.Schedule, now, count)
+			}
+		}
+	}
+
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// nextJobRuns returns the next cron run after t of every scheduled job
+func nextJobRuns(t time.Time) map[string]time.Time {
+	result := map[string]time.Time{}
+	if GlobalCron == nil {
+		return result
+	}
+	for _, entry := range GlobalCron.Entries() {
+		entryJob, ok := entry.Job.(*Job)
+		if !ok {
+			continue
+		}
+		next := entry.Schedule.Next(t)
+		if !next.IsZero() {
+			result[entryJob.Name] = next
+		}
+	}
+	return result
+}
+
+// nextCronRuns returns count fire times of the schedule after t
+func nextCronRuns(schedule cron.Schedule, t time.Time, count int) []time.Time {
+	result := make([]time.Time, 0, count)
+	for i := 0; i < count; i++ {
+		t = schedule.Next(t)
+		if t.IsZero() {
+			break
+		}
+		result = append(result, t)
+	}
+	return result
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleGetMaintenanceWindows returns all maintenance windows
+// @Summary      Return maintenance windows
+// @Description  Windows with their current or next occurrence, the nearest first. One-off windows which have ended are the last
+// @Tags         admin
+// @Produce      json
+// @Success      200      {array}    MaintenanceWindowData
+// @Failure      500      {string}   string
+// @Router       /admin/maintenance-windows [get]
+func HandleGetMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	data, err := GetMaintenanceWindowsData(time.Now())
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleSaveMaintenanceWindow creates or updates a maintenance window
+// @Summary      Create or update a maintenance window
+// @Description  Builds of jobs with `pausable: true` are not started during the window: cron doesn't fire and other builds wait in the queue until the window ends
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id       path       integer             false "ID of the window to update"
+// @Param        window   body       MaintenanceWindow   true  "Maintenance window"
+// @Success      200      {object}   MaintenanceWindow
+// @Failure      400      {string}   string
+// @Router       /admin/maintenance-windows [post]
+// @Router       /admin/maintenance-windows/{id} [put]
+func HandleSaveMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	badRequest := func(err error) {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+	}
+
+	var window MaintenanceWindow
+	err := json.NewDecoder(r.Body).Decode(&window)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	window.ID = 0
+	if chi.URLParam(r, "id") != "" {
+		window.ID, err = strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			badRequest(err)
+			return
+		}
+	}
+	err = SaveMaintenanceWindow(&window)
+	if err != nil {
+		badRequest(err)
+		return
+	}
+	logger.Printf("Maintenance window %d %s has been saved\n", window.ID, window.Name)
+	// Held builds start as soon as the window is removed or moved
+	go GlobalQueue.Take()
+
+	payloadB, err := json.Marshal(window)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleDeleteMaintenanceWindow removes a maintenance window
+// @Summary      Delete a maintenance window
+// @Tags         admin
+// @Produce      plain
+// @Param        id       path       integer   true  "ID of the window"
+// @Success      200      {string}   string
+// @Failure      404      {string}   string
+// @Router       /admin/maintenance-windows/{id} [delete]
+func HandleDeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err == nil {
+		err = DeleteMaintenanceWindow(id)
+	}
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	logger.Printf("Maintenance window %d has been deleted\n", id)
+	go GlobalQueue.Take()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HandleQueueStatus returns number of running and queued builds and reserved
+// resources
+// @Summary      Return status of the queue
+// @Description  Resources reserved by running builds and the host capacity (null if unlimited)
+// @Tags         queue
+// @Produce      json
+// @Success      200      {object}   QueueStatusData
+// @Failure      500      {string}   string
+// @Router       /queue [get]
+func HandleQueueStatus(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	data := GlobalQueue.Status()
+	if Config.Capacity != nil {
+		capacity, err := Config.Capacity.usage()
+		if err != nil {
+			logger.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(err.Error()))
+			return
+		}
+		data.Capacity = &capacity
+	}
+
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleQueuePause pauses the queue
+// @Summary      Pause the queue
+// @Description  Queued builds are not started until the queue is resumed, running builds continue. New builds are queued as usual. The queue stays paused after restart
+// @Tags         queue
+// @Produce      json
+// @Success      200      {object}   QueueStatusData
+// @Failure      500      {string}   string
+// @Router       /queue/pause [post]
+func HandleQueuePause(w http.ResponseWriter, r *http.Request) {
+	setQueuePaused(w, r, true)
+}
+
+// HandleQueueResume resumes the paused queue
+// @Summary      Resume the queue
+// @Description  Queued builds are started again
+// @Tags         queue
+// @Produce      json
+// @Success      200      {object}   QueueStatusData
+// @Failure      500      {string}   string
+// @Router       /queue/resume [post]
+func HandleQueueResume(w http.ResponseWriter, r *http.Request) {
+	setQueuePaused(w, r, false)
+}
+
+// setQueuePaused pauses or resumes the queue and responds with its status
+func setQueuePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	err := GlobalQueue.SetPaused(paused)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	payloadB, err := json.Marshal(GlobalQueue.Status())
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
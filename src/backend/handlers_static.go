@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -57,6 +58,17 @@ func HandleWakespaceResource(h http.Handler) http.Handler {
 		*r2.URL = *r.URL
 		r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/storage/build/")
 		logger.Printf("storage %s --> %s\n", r.URL.Path, r2.URL.Path)
+
+		// Count artifact downloads
+		if r.Method == http.MethodGet {
+			parts := strings.SplitN(r2.URL.Path, "/", 3)
+			if len(parts) == 3 && parts[1] == "artifacts" && parts[2] != "" {
+				buildID, err := strconv.Atoi(parts[0])
+				if err == nil {
+					GlobalUsage.RecordDownload(buildID)
+				}
+			}
+		}
 		h.ServeHTTP(w, r2)
 	})
 }
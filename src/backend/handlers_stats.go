@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// HandleUsageStats returns usage counters of jobs and builds
+// @Summary      Return usage statistics
+// @Description  Returns number of build page views per job and number of artifact downloads per build with artifacts
+// @Tags         stats
+// @Produce      json
+// @Success      200      {object}   UsageStatsData
+// @Failure      404      {string}   string
+// @Failure      500      {string}   string
+// @Router       /stats/usage [get]
+func HandleUsageStats(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	if GlobalUsage == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Usage tracking is disabled"))
+		return
+	}
+	// Include counters which are not saved yet
+	GlobalUsage.Flush()
+
+	payload := UsageStatsData{
+		Jobs:   []*JobUsageData{},
+		Builds: []*BuildUsageData{},
+	}
+	err := DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(JobsBucket)
+		c := b.Cursor()
+		for key, _ := c.First(); key != nil; key, _ = c.Next() {
+			jb := b.Bucket(key)
+			if jb == nil {
+				continue
+			}
+			views, err := ByteToInt(jb.Get([]byte("views")))
+			if err != nil {
+				views = 0
+			}
+			payload.Jobs = append(payload.Jobs, &JobUsageData{
+				Name:  string(key),
+				Views: views,
+			})
+		}
+
+		hb := tx.Bucket(HistoryBucket)
+		hc := hb.Cursor()
+		for key, v := hc.Last(); key != nil; key, v = hc.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				logger.Println(err)
+				continue
+			}
+			if len(msg.BuildArtifacts) == 0 {
+				continue
+			}
+			usage := GetBuildUsage(tx, msg.ID)
+			payload.Builds = append(payload.Builds, &BuildUsageData{
+				ID:           msg.ID,
+				Name:         msg.Name,
+				Artifacts:    len(msg.BuildArtifacts),
+				Downloads:    usage.Downloads,
+				LastDownload: usage.LastDownload,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StatusPageBuilds is the number of completed builds shown on the status page
+const StatusPageBuilds = 5
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>{{.Server}} - wakeci status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.indicator { padding: 1em; border-radius: 4px; color: #fff; font-size: 1.4em; }
+.ok { background: #2e7d32; }
+.failing { background: #c62828; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { padding: 0.3em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+.muted { color: #777; }
+.notice { padding: 0.5em 1em; margin-top: 0.5em; border-left: 4px solid #1565c0; background: #f5f5f5; }
+.notice.warning { border-color: #f9a825; }
+.notice.critical { border-color: #c62828; }
+</style>
+</head>
+<body>
+<h1>{{.Server}}</h1>
+<p class="muted">{{.Now}}</p>
+{{if .Failing}}<div class="indicator failing">Builds failing</div>{{else}}<div class="indicator ok">All systems go</div>{{end}}
+<p>Running builds: {{.Running}}, pending builds: {{.Pending}}</p>
+{{range .Notices}}<div class="notice {{.Severity}}"><b>{{.Job}}</b>: {{.Text}} <span class="muted">({{.Author}})</span></div>
+{{end}}
+<table>
+<tr><th>Build</th><th>Job</th><th>Status</th><th>Duration</th></tr>
+{{range .Builds}}<tr><td>#{{.ID}}</td><td>{{.Name}}</td><td>{{.Status}}</td><td>{{.Duration}}</td></tr>
+{{else}}<tr><td colspan="4" class="muted">No completed builds</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// statusPageBuild is a completed build shown on the status page
+type statusPageBuild struct {
+	ID       int
+	Name     string
+	Status   ItemStatus
+	Duration time.Duration
+}
+
+// HandleGetBuildStatusPage returns a public HTML page with the status of the
+// service
+// @Summary      Public status page
+// @Description  HTML page with the number of running and pending builds, active job notices and the latest completed builds. Doesn't require authentication
+// @Tags         status
+// @Produce      html
+// @Success      200      {string}   string
+// @Failure      500      {string}   string
+// @Router       /status [get]
+func HandleGetBuildStatusPage(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	server := Config.Hostname
+	if server == "" {
+		server, _ = os.Hostname()
+	}
+	running, pending := GlobalQueue.Count()
+	data := struct {
+		Server  string
+		Now     string
+		Running int
+		Pending int
+		Failing bool
+		Builds  []*statusPageBuild
+		Notices []*JobNoticeData
+	}{
+		Server:  server,
+		Now:     time.Now().UTC().Format("2006-01-02 15:04:05 UTC"),
+		Running: running,
+		Pending: pending,
+		Builds:  []*statusPageBuild{},
+	}
+
+	err := DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(HistoryBucket).Cursor()
+		for key, v := c.Last(); key != nil && len(data.Builds) < StatusPageBuilds; key, v = c.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			switch msg.Status {
+			case StatusPending, StatusRunning:
+				continue
+			case StatusFailed, StatusTimedOut, StatusDiskQuotaExceeded:
+				data.Failing = true
+			}
+			data.Builds = append(data.Builds, &statusPageBuild{
+				ID:       msg.ID,
+				Name:     msg.Name,
+				Status:   msg.Status,
+				Duration: msg.Duration.Truncate(time.Second),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	data.Notices, err = GetActiveNotices()
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	err = statusPageTemplate.Execute(&buf, data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
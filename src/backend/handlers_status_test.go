@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHandleGetBuildStatusPage(t *testing.T) {
+	setupTestEnv(t)
+	Config.Hostname = "ci.example.com"
+	get := func() string {
+		w := httptest.NewRecorder()
+		HandleGetBuildStatusPage(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Fatalf("Unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		return w.Body.String()
+	}
+
+	page := get()
+	for _, expected := range []string{"<h1>ci.example.com</h1>", "All systems go", "Running builds: 0, pending builds: 0", "No completed builds"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected %q on the empty page: %s", expected, page)
+		}
+	}
+
+	putTestBuildStatus(t, 1, "deploy", StatusFailed, time.Now())
+	for id := 2; id <= 6; id++ {
+		putTestBuildStatus(t, id, "<b>build</b>", StatusFinished, time.Now())
+	}
+	putTestBuildStatus(t, 7, "deploy", StatusRunning, time.Now())
+	err := DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("deploy"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveJobNotice("deploy", &JobNotice{Text: "Registry is down", Severity: NoticeCritical, Author: "ops", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page = get()
+	// The failed build is older than the latest completed builds
+	if !strings.Contains(page, "All systems go") || strings.Contains(page, "#1<") || strings.Contains(page, "#7<") {
+		t.Errorf("Expected only the latest completed builds: %s", page)
+	}
+	if strings.Count(page, "&lt;b&gt;build&lt;/b&gt;") != 5 {
+		t.Errorf("Expected escaped names of 5 builds: %s", page)
+	}
+	if !strings.Contains(page, `<div class="notice critical"><b>deploy</b>: Registry is down`) {
+		t.Errorf("Expected the notice: %s", page)
+	}
+
+	putTestBuildStatus(t, 8, "deploy", StatusTimedOut, time.Now())
+	if page = get(); !strings.Contains(page, "Builds failing") {
+		t.Errorf("Expected failing builds: %s", page)
+	}
+}
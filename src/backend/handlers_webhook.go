@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MaxWebhookBodySize limits size of the body of the webhook request
+const MaxWebhookBodySize = 5 << 20
+
+// HandleWebhook starts a job with params taken from the JSON body
+// @Summary      Start a job via webhook
+// @Description  Fields of the body are mapped to params of the build according to `webhook_params` of the job. If the job has `webhook_secret`, the body has to be signed with it (X-Hub-Signature-256 header, as GitHub does), otherwise the request has to be authenticated. Returns build id. For GitHub pushes the commit and the branch are passed as GIT_COMMIT and GIT_BRANCH params. If the job has `branches`, only pushes to matching branches start it, other requests are accepted with 202 and ignored
+// @Tags         job
+// @Accept       json
+// @Produce      plain
+// @Param        name                  path     string   true   "Name of the job"
+// @Param        X-Hub-Signature-256   header   string   false  "sha256=<HMAC-SHA256 of the body>"
+// @Param        X-GitHub-Event        header   string   false  "Type of the event, e.g. push"
+// @Success      200      {integer}  integer
+// @Success      202      {string}   string
+// @Header       200      {string}   X-Wake-Deduplicated  "true if the job has `dedupe` and ID of the pending build with the same params is returned"
+// @Failure      400      {string}   string
+// @Failure      403      {string}   string  "The signature is invalid or the request is not authenticated. Returned for unknown jobs too"
+// @Failure      404      {string}   string  "Only for authenticated requests"
+// @Failure      412      {string}   string
+// @Failure      413      {string}   string
+// @Router       /webhook/{name} [post]
+func HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	writeError := func(status int, err error) {
+		logger.Println(err)
+		w.WriteHeader(status)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxWebhookBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(http.StatusRequestEntityTooLarge, err)
+		} else {
+			writeError(http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	// The route is public, so only authenticated requests can tell whether
+	// the job exists
+	name := chi.URLParam(r, "name")
+	jobFile := Config.JobDir + name + Config.jobsExt
+	if _, err := os.Stat(jobFile); err != nil {
+		AuthMi(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(http.StatusNotFound, fmt.Errorf("job %s is not found", name))
+		})).ServeHTTP(w, r)
+		return
+	}
+	job, err := CreateJobFromFile(jobFile)
+	if err != nil {
+		AuthMi(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(http.StatusBadRequest, err)
+		})).ServeHTTP(w, r)
+		return
+	}
+
+	run := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		push := parsePushEvent(r.Header, body)
+		if !job.matchesBranches(push) {
+			msg := fmt.Sprintf("job %s is not started, the webhook is not a push to one of its branches", name)
+			logger.Println(msg)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(msg))
+			return
+		}
+		params, err := job.webhookParams(body)
+		if err != nil {
+			writeError(http.StatusBadRequest, err)
+			return
+		}
+		// webhook_params win, the pushed ref is only a default
+		if push != nil {
+			for key, value := range map[string]string{WebhookCommitParam: push.After, WebhookBranchParam: push.Branch()} {
+				if params.Get(key) == "" && value != "" {
+					params.Set(key, value)
+				}
+			}
+		}
+		build, err := RunJob(name, params, TriggerWebhook)
+		var duplicateErr *DuplicateBuildError
+		if errors.As(err, &duplicateErr) {
+			build = duplicateErr.Build
+			w.Header().Set(DeduplicatedHeader, "true")
+		} else if err != nil {
+			var prerequisiteErr *PrerequisiteError
+			if errors.As(err, &prerequisiteErr) {
+				writeError(http.StatusPreconditionFailed, err)
+			} else {
+				writeError(http.StatusBadRequest, err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.Itoa(build.ID)))
+	})
+
+	// Without the secret anyone could start the job
+	if job.WebhookSecret == "" {
+		AuthMi(run).ServeHTTP(w, r)
+		return
+	}
+	err = verifyWebhookSignature(injectSecrets(job.WebhookSecret), body, r.Header.Get(WebhookSignatureHeader))
+	if err != nil {
+		// The same response as for unknown jobs
+		logger.Println(err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Forbidden"))
+		return
+	}
+	run.ServeHTTP(w, r)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	_ "modernc.org/sqlite"
+)
+
+// HistoryExportSyncPeriod is a period to export builds which were missed,
+// e.g. completed while the export queue was full
+const HistoryExportSyncPeriod = 10 * time.Minute
+
+// historyExportQueueSize is the number of builds waiting to be exported
+const historyExportQueueSize = 100
+
+const historyExportSchema = `
+CREATE TABLE IF NOT EXISTS builds (
+	id INTEGER PRIMARY KEY,
+	job TEXT NOT NULL,
+	template TEXT NOT NULL,
+	status TEXT NOT NULL,
+	started_at TIMESTAMP,
+	duration_ms INTEGER NOT NULL,
+	trigger TEXT NOT NULL,
+	params TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS builds_job ON builds (job);
+CREATE TABLE IF NOT EXISTS tasks (
+	build_id INTEGER NOT NULL REFERENCES builds (id) ON DELETE CASCADE,
+	task_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	started_at TIMESTAMP,
+	duration_ms INTEGER NOT NULL,
+	PRIMARY KEY (build_id, task_id)
+);
+CREATE TABLE IF NOT EXISTS artifacts (
+	build_id INTEGER NOT NULL REFERENCES builds (id) ON DELETE CASCADE,
+	filename TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	PRIMARY KEY (build_id, filename)
+);
+`
+
+// HistoryExporter mirrors records of completed builds into a SQLite
+// database, so they can be queried with SQL without touching the live
+// database. The mirror is eventually consistent
+type HistoryExporter struct {
+	db    *sql.DB
+	queue chan int
+}
+
+// GlobalExporter is nil if the export is disabled
+var GlobalExporter *HistoryExporter
+
+// isTerminalStatus reports whether the build with this status is completed
+func isTerminalStatus(status ItemStatus) bool {
+	switch status {
+	case StatusPending, StatusRunning:
+		return false
+	}
+	return true
+}
+
+// Enqueue schedules export of the build. The build is exported during the
+// next sync if the queue is full
+func (e *HistoryExporter) Enqueue(buildID int) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- buildID:
+	default:
+		Logger.Printf("Export queue is full, build %d will be exported later\n", buildID)
+	}
+}
+
+// Export writes the build record to SQLite, replacing the existing one
+func (e *HistoryExporter) Export(data *BuildUpdateData) error {
+	params, err := json.Marshal(data.Params)
+	if err != nil {
+		return err
+	}
+	trigger := ""
+	if data.Trigger != nil {
+		trigger = data.Trigger.Kind
+	}
+
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"tasks", "artifacts"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE build_id = ?", data.ID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO builds (id, job, template, status, started_at, duration_ms, trigger, params) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		data.ID, data.Name, data.JobName(), string(data.Status), data.StartedAt.UTC(), data.Duration.Milliseconds(), trigger, string(params),
+	)
+	if err != nil {
+		return err
+	}
+	for _, task := range data.Tasks {
+		_, err = tx.Exec(
+			"INSERT INTO tasks (build_id, task_id, kind, status, started_at, duration_ms) VALUES (?, ?, ?, ?, ?, ?)",
+			data.ID, task.ID, task.Kind, string(task.Status), task.StartedAt.UTC(), task.Duration.Milliseconds(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, artifact := range data.BuildArtifacts {
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO artifacts (build_id, filename, size) VALUES (?, ?, ?)",
+			data.ID, artifact.Filename, artifact.Size,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// exportBuild reads the build record from history and exports it
+func (e *HistoryExporter) exportBuild(buildID int) {
+	data, err := getBuildStatusData(buildID)
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	err = e.Export(data)
+	if err != nil {
+		Logger.Printf("Unable to export build %d: %s\n", buildID, err.Error())
+	}
+}
+
+// Sync exports all completed builds from history which are missing in SQLite
+func (e *HistoryExporter) Sync() {
+	exported := map[int]bool{}
+	rows, err := e.db.Query("SELECT id FROM builds")
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			Logger.Println(err)
+			rows.Close()
+			return
+		}
+		exported[id] = true
+	}
+	rows.Close()
+
+	missing := []*BuildUpdateData{}
+	err = DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(HistoryBucket).ForEach(func(_, v []byte) error {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			if isTerminalStatus(msg.Status) && !exported[msg.ID] {
+				missing = append(missing, &msg)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	for _, data := range missing {
+		err = e.Export(data)
+		if err != nil {
+			Logger.Printf("Unable to export build %d: %s\n", data.ID, err.Error())
+		}
+	}
+	if len(missing) > 0 {
+		Logger.Printf("Exported %d builds to SQLite\n", len(missing))
+	}
+}
+
+// run exports enqueued builds and periodically syncs the whole history
+func (e *HistoryExporter) run(period time.Duration) {
+	e.Sync()
+	ticker := time.NewTicker(period)
+	for {
+		select {
+		case buildID := <-e.queue:
+			e.exportBuild(buildID)
+		case <-ticker.C:
+			e.Sync()
+		}
+	}
+}
+
+// CreateHistoryExporter opens the SQLite database, creates the schema and
+// starts exporting. Returns nil if the export is disabled
+func CreateHistoryExporter(path string, period time.Duration) (*HistoryExporter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// Writes are serialized by the exporter
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(historyExportSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	e := &HistoryExporter{
+		db:    db,
+		queue: make(chan int, historyExportQueueSize),
+	}
+	Logger.Printf("Exporting build history to %s\n", path)
+	go e.run(period)
+	return e, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryExporter_Export(t *testing.T) {
+	setupTestEnv(t)
+	e, err := CreateHistoryExporter(t.TempDir()+"/history.sqlite", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &BuildUpdateData{
+		ID:     7,
+		Name:   "deploy",
+		Status: StatusFinished,
+		Tasks: []*TaskStatus{
+			{ID: 0, Status: StatusFinished, Kind: KindMain},
+			{ID: 1, Status: StatusSkipped, Kind: StatusFailed},
+		},
+		BuildArtifacts: []*ArtifactInfo{{Filename: "app.tar.gz", Size: 42}},
+	}
+	// Export is idempotent
+	for i := 0; i < 2; i++ {
+		err = e.Export(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var builds, tasks, size int
+	err = e.db.QueryRow("SELECT COUNT(*) FROM builds WHERE job = 'deploy'").Scan(&builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.db.QueryRow("SELECT COUNT(*) FROM tasks WHERE build_id = 7").Scan(&tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.db.QueryRow("SELECT size FROM artifacts WHERE build_id = 7").Scan(&size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if builds != 1 || tasks != 2 || size != 42 {
+		t.Errorf("Unexpected export result: %d builds, %d tasks, artifact size %d", builds, tasks, size)
+	}
+}
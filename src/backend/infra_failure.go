@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FailureCauseInfra is the failure cause of builds which failed because of
+// the server rather than the job, e.g. the workspace is missing or the command
+// couldn't be started. Such builds can be retried automatically, see
+// Job.AutoRetryInfraFailures
+const FailureCauseInfra = "infrastructure"
+
+// markInfraFailure records the failure cause of the build and shows the error
+// as a warning
+func (b *Build) markInfraFailure(err error) {
+	b.Logger.Printf("Infrastructure failure: %s\n", err)
+	b.mutex.Lock()
+	b.FailureCause = FailureCauseInfra
+	b.mutex.Unlock()
+	b.addWarning(err.Error())
+}
+
+// checkWorkspace verifies that the workspace of the build still exists, e.g.
+// it wasn't removed while the build was in the queue
+func (b *Build) checkWorkspace() error {
+	_, err := os.Stat(b.GetWorkspaceDir())
+	if err != nil {
+		return fmt.Errorf("workspace is not available: %w", err)
+	}
+	return nil
+}
+
+// explainSpawnFailure describes why the process of the task couldn't be
+// started. Limits of the server are named explicitly, otherwise they look
+// like odd errors of the job
+func explainSpawnFailure(err error) error {
+	switch {
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return fmt.Errorf("could not start process: too many open files — check server limits: %w", err)
+	case errors.Is(err, syscall.EAGAIN):
+		return fmt.Errorf("could not start process: too many processes — check server limits: %w", err)
+	case errors.Is(err, syscall.ENOMEM):
+		return fmt.Errorf("could not start process: not enough memory — check server limits: %w", err)
+	}
+	return fmt.Errorf("could not start process: %w", err)
+}
+
+// autoRetryInfraFailures returns the number of times a build which failed
+// because of infrastructure is started again
+func (j *Job) autoRetryInfraFailures() int {
+	if j.AutoRetryInfraFailures != 0 {
+		return j.AutoRetryInfraFailures
+	}
+	return Config.AutoRetryInfraFailures
+}
+
+// shouldRetryInfraFailure returns true if the failed build is retried because
+// of infrastructure failure. Retries are counted from the original build
+func (b *Build) shouldRetryInfraFailure() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Status == StatusFailed && b.FailureCause == FailureCauseInfra &&
+		b.InfraRetryAttempt < b.Job.autoRetryInfraFailures()
+}
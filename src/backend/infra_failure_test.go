@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAutoRetryInfraFailures(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		// Breaks the build before it is started
+		setup func(t *testing.T, b *Build)
+		// Status of the retry, empty if the build is not retried
+		retry ItemStatus
+	}{
+		{
+			name:    "workspace",
+			content: "auto_retry_infra_failures: 1\ntasks:\n  - run: \"true\"\n",
+			setup: func(t *testing.T, b *Build) {
+				if err := os.RemoveAll(b.GetWorkspaceDir()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			retry: StatusFinished,
+		},
+		{
+			name:    "task log",
+			content: "auto_retry_infra_failures: 1\ntasks:\n  - run: \"true\"\n",
+			setup: func(t *testing.T, b *Build) {
+				// The log file can't be opened
+				if err := os.MkdirAll(b.GetWakespaceDir()+b.Job.Tasks[0].LogFileName(), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+			},
+			retry: StatusFinished,
+		},
+		{
+			name:    "shell",
+			content: "auto_retry_infra_failures: 1\nshell: no-such-shell\ntasks:\n  - run: \"true\"\n",
+			setup:   func(t *testing.T, b *Build) {},
+			retry:   StatusFailed,
+		},
+		{
+			name:    "job failure",
+			content: "auto_retry_infra_failures: 1\ntasks:\n  - run: \"false\"\n",
+			setup:   func(t *testing.T, b *Build) {},
+		},
+	}
+	for _, c := range cases {
+		setupTestEnv(t)
+		jobFile := Config.JobDir + "infra" + Config.jobsExt
+		err := os.WriteFile(jobFile, []byte(c.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := CreateJobFromFile(jobFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, task := range job.Tasks {
+			task.ID = i
+			task.Status = StatusPending
+		}
+		build, err := CreateBuild(job, jobFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		build.Logger = log.New(io.Discard, "", 0)
+		c.setup(t, build)
+		GlobalQueue.Add(build)
+		GlobalQueue.Take()
+
+		waitForTerminalState(t, build, 5*time.Second, StatusFailed)
+		data, err := getBuildStatusData(build.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.retry == "" {
+			if data.FailureCause != "" {
+				t.Errorf("%s: expected no failure cause, got %q", c.name, data.FailureCause)
+			}
+			time.Sleep(200 * time.Millisecond)
+			if _, err := getBuildStatusData(build.ID + 1); err == nil {
+				t.Errorf("%s: expected the build not to be retried", c.name)
+			}
+			continue
+		}
+		if data.FailureCause != FailureCauseInfra {
+			t.Errorf("%s: expected infrastructure failure, got %q", c.name, data.FailureCause)
+		}
+		waitFor(t, 5*time.Second, c.name+": the retry is completed", func() bool {
+			data, err := getBuildStatusData(build.ID + 1)
+			return err == nil && isTerminalStatus(data.Status)
+		})
+		retry, err := getBuildStatusData(build.ID + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retry.Status != c.retry || retry.RetryOf != build.ID || retry.InfraRetryAttempt != 1 {
+			t.Errorf("%s: unexpected retry: %s, retry_of %d, attempt %d", c.name, retry.Status, retry.RetryOf, retry.InfraRetryAttempt)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if _, err := getBuildStatusData(build.ID + 2); err == nil {
+			t.Errorf("%s: expected exactly one retry", c.name)
+		}
+	}
+}
+
+func TestExplainSpawnFailure(t *testing.T) {
+	cases := map[error]string{
+		syscall.EMFILE: "could not start process: too many open files — check server limits",
+		syscall.EAGAIN: "could not start process: too many processes — check server limits",
+		syscall.ENOMEM: "could not start process: not enough memory — check server limits",
+		syscall.EPERM:  "could not start process: fork/exec /bin/bash: operation not permitted",
+	}
+	for errno, expected := range cases {
+		spawnErr := &os.PathError{Op: "fork/exec", Path: "/bin/bash", Err: errno}
+		err := explainSpawnFailure(spawnErr)
+		if !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%s: unexpected explanation %q", errno, err)
+		}
+		if !errors.Is(err, errno) {
+			t.Errorf("%s: expected the original error to be wrapped", errno)
+		}
+	}
+}
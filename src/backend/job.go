@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -36,11 +37,111 @@ type Job struct {
 	Desc          string              `yaml:"desc" json:"desc"`
 	Tasks         []*Task             `yaml:"tasks" json:"tasks"`
 	DefaultParams []map[string]string `yaml:"params" json:"defaultParams"`
-	Artifacts     []string            `yaml:"artifacts" json:"artifacts"`
+	Artifacts     []*ArtifactPattern  `yaml:"artifacts" json:"artifacts"`
 	Interval      string              `yaml:"interval" json:"interval"`
+	Schedule      string              `yaml:"schedule" json:"schedule"` // Alias of Interval
 	Timeout       string              `yaml:"timeout" json:"timeout"`
 	Concurrency   int                 `yaml:"concurrency" json:"concurrency"`
 	Priority      int                 `yaml:"priority" json:"priority"`
+	// What to do if a build of the job is still queued or running when cron
+	// fires: OverlapAllow (default) or OverlapSkip
+	ScheduleOverlap string `yaml:"schedule_overlap" json:"schedule_overlap"`
+	// Abort the build if its workspace takes more than DiskQuota
+	DiskQuota         string `yaml:"disk_quota" json:"disk_quota"`
+	DiskQuotaInterval string `yaml:"disk_quota_interval" json:"disk_quota_interval"`
+	// Maximum total size of artifacts of a build, overrides maxartifactsize of
+	// the server config
+	MaxArtifactSize string `yaml:"max_artifact_size" json:"max_artifact_size"`
+	// Override keepbuildsdays and keepbuildscount of the server config if not
+	// 0, see BuildRetention
+	KeepBuildsDays  int `yaml:"keep_builds_days" json:"keep_builds_days"`
+	KeepBuildsCount int `yaml:"keep_builds_count" json:"keep_builds_count"`
+	// Named sets of param values which can be selected when running the job
+	Presets map[string]map[string]string `yaml:"presets" json:"presets"`
+	// Template of the name of the job instance, e.g. deploy-${SERVICE}. It is
+	// expanded with params of the build
+	InstanceName string `yaml:"instance_name" json:"instance_name"`
+	// Jobs of the same group can be started together, see RunJobGroup
+	Group string `yaml:"group" json:"group"`
+	// Groups main tasks into stages which run one after another
+	Workflow []*WorkflowStage `yaml:"workflow" json:"workflow"`
+	// Jobs which latest completed build has to be successful
+	Requires []*Requirement `yaml:"requires" json:"requires"`
+	// When requirements are verified: RequiresCheckEnqueue (default) or
+	// RequiresCheckTake
+	RequiresCheck string `yaml:"requires_check" json:"requires_check"`
+	// Parser of task logs: auto (default), plain, go-test or pytest
+	LogParser string `yaml:"log_parser" json:"log_parser"`
+	// Runs the job with every param set on schedule
+	ScheduleMatrix *ScheduleMatrix `yaml:"schedule_matrix" json:"schedule_matrix"`
+	// Maximum length of log lines, e.g. 1MB. Longer lines are truncated
+	LineBufferSize string `yaml:"line_buffer_size" json:"line_buffer_size"`
+	// How task output is written to the log file: sanitize (default) or raw
+	LogOutput string `yaml:"log_output" json:"log_output"`
+	// Jobs which artifacts can be fetched with WAKE_BUILD_TOKEN
+	CanReadArtifactsFrom []string `yaml:"can_read_artifacts_from" json:"can_read_artifacts_from"`
+	// PUT the latest status of the job to the URL on every completed build
+	StatusWebhook *StatusWebhook `yaml:"status_webhook" json:"status_webhook"`
+	// POST the status of every completed build to the URL
+	Webhook string `yaml:"webhook" json:"webhook"`
+	// Notify slackwebhookurl of the server config and EmailTo when the build
+	// completes with one of the statuses, e.g. [failed, aborted]
+	NotifyOn []string `yaml:"notify_on" json:"notify_on"`
+	// Email the build status to the addresses, statuses are chosen by NotifyOn
+	EmailTo []string `yaml:"email_to" json:"email_to"`
+	// Number builds sequentially within the job (WAKE_JOB_BUILD_NUMBER)
+	JobBuildNumbers bool `yaml:"job_build_numbers" json:"job_build_numbers"`
+	// Host resources reserved by the build while it is running
+	Resources *Resources `yaml:"resources" json:"resources"`
+	// Number of main tasks executed at the same time, 1 by default
+	Parallel int `yaml:"parallel" json:"parallel"`
+	// Number of times a failed or aborted build is started again
+	Retry int `yaml:"retry" json:"retry"`
+	// A pending build with the same params is returned instead of creating
+	// a new build, see DuplicateBuildError
+	Dedupe bool `yaml:"dedupe" json:"dedupe"`
+	// Number of times a build which failed because of infrastructure is
+	// started again. Overrides the global setting if not 0
+	AutoRetryInfraFailures int `yaml:"auto_retry_infra_failures" json:"auto_retry_infra_failures"`
+	// Runs commands of tasks and `if` conditions, bash by default
+	Shell string `yaml:"shell" json:"shell"`
+	// Builds are not started during maintenance windows
+	Pausable bool `yaml:"pausable" json:"pausable"`
+	// Params of builds started via webhook taken from the JSON body, e.g.
+	// COMMIT_SHA: $.head_commit.id
+	WebhookParams map[string]string `yaml:"webhook_params" json:"webhook_params"`
+	// Key of HMAC-SHA256 signature of webhook requests, can use secrets
+	WebhookSecret string `yaml:"webhook_secret" json:"-"`
+	// Globs of branches which pushes start the job via webhook, e.g.
+	// release/*. Other pushes and events are ignored
+	Branches []string `yaml:"branches" json:"branches"`
+	// Jobs started when a build of the job finishes
+	Triggers []*DownstreamTrigger `yaml:"triggers" json:"triggers"`
+	// Compare files of the workspace before and after main tasks
+	AuditWorkspace *WorkspaceAudit `yaml:"audit_workspace" json:"audit_workspace"`
+	// Valid values of params, builds with invalid params aren't created
+	ParamsSchema map[string]*ParamSchema `yaml:"params_schema" json:"params_schema"`
+	// Files matching these globs are not collected even if they match
+	// Artifacts, e.g. dist/node_modules/**
+	ArtifactsExclude []string `yaml:"artifacts_exclude" json:"artifacts_exclude"`
+	// Collected artifacts are stored gzip-compressed, see CompressedArtifactExt
+	CompressArtifacts bool `yaml:"compress_artifacts" json:"compress_artifacts"`
+	// Names of params which values are masked in task logs, the same as
+	// marking the params with SensitiveParamFlag
+	SecretParams []string `yaml:"secrets" json:"secrets"`
+	// Values of these params are masked in task logs, see SensitiveParamFlag
+	// and SecretParams
+	sensitiveParams map[string]bool
+	// A build is created for every combination of values, e.g.
+	// GO_VERSION: [1.21, 1.22]
+	Matrix map[string][]string `yaml:"matrix" json:"matrix"`
+}
+
+// WorkflowStage is a named group of main tasks. A stage starts when all tasks
+// of the previous stage are completed
+type WorkflowStage struct {
+	Name  string `yaml:"name" json:"name"`
+	Tasks []int  `yaml:"tasks" json:"tasks"`
 }
 
 // AddToCron adds a job to cron
@@ -48,15 +149,20 @@ func (j *Job) AddToCron() error {
 	// Remove cron entry if the job is already in cron
 	RemoveJobFromCron(j.Name)
 
-	if j.Interval == "" {
-		return nil
+	if j.ScheduleMatrix != nil {
+		intervalStr := addCronTimezone(j.ScheduleMatrix.Interval)
+		_, err := GlobalCron.AddJob(intervalStr, &SweepSchedule{Name: j.Name, Matrix: j.ScheduleMatrix, Pausable: j.Pausable})
+		if err != nil {
+			return err
+		}
+		Logger.Printf("Add sweep of job %s to cron with interval %s\n", j.Name, intervalStr)
 	}
 
-	intervalStr := j.Interval
-	if !strings.HasPrefix(intervalStr, "CRON_TZ=") && Config.Timezone != "" {
-		intervalStr = "CRON_TZ=" + Config.Timezone + " " + intervalStr
+	if j.cronSpec() == "" {
+		return nil
 	}
 
+	intervalStr := addCronTimezone(j.cronSpec())
 	_, err := GlobalCron.AddJob(intervalStr, j)
 	Logger.Printf("Add job %s to cron with interval %s\n", j.Name, intervalStr)
 	return err
@@ -64,8 +170,16 @@ func (j *Job) AddToCron() error {
 
 // Run is used to run a job via cron
 func (j *Job) Run() {
+	if j.Pausable && isMaintenanceActive() {
+		Logger.Printf("Skipping cron run of job %s during maintenance window\n", j.Name)
+		return
+	}
+	if j.ScheduleOverlap == OverlapSkip && GlobalQueue.HasJob(j.Name) {
+		Logger.Printf("Skipping cron run of job %s: the previous build is still running\n", j.Name)
+		return
+	}
 	var params url.Values
-	build, err := RunJob(j.Name, params)
+	build, err := RunJob(j.Name, params, TriggerCron)
 	if err != nil {
 		Logger.Printf("Unable to schedule a build via cron for job %s: %s\n", j.Name, err.Error())
 		return
@@ -73,15 +187,152 @@ func (j *Job) Run() {
 	build.Logger.Printf("The build for job %s is scheduled via cron\n", j.Name)
 }
 
+// cronSpec returns the cron expression of the job, `schedule` is an alias of
+// `interval`
+func (j *Job) cronSpec() string {
+	if j.Interval != "" {
+		return j.Interval
+	}
+	return j.Schedule
+}
+
 // Used to verify interval before saving after editing
 func (j *Job) verifyInterval() error {
-	if j.Interval == "" {
+	if j.Interval != "" && j.Schedule != "" && j.Interval != j.Schedule {
+		return fmt.Errorf("interval and schedule are aliases, only one of them can be set")
+	}
+	switch j.ScheduleOverlap {
+	case "", OverlapAllow, OverlapSkip:
+	default:
+		return fmt.Errorf("invalid schedule_overlap value: %s", j.ScheduleOverlap)
+	}
+	if j.cronSpec() == "" {
 		return nil
 	}
-	_, err := cron.ParseStandard(j.Interval)
+	_, err := cron.ParseStandard(j.cronSpec())
 	return err
 }
 
+// Used to verify task timeouts before saving after editing
+func (j *Job) verifyTaskTimeouts() error {
+	for _, task := range j.Tasks {
+		if task.Timeout == "" {
+			continue
+		}
+		d, err := time.ParseDuration(task.Timeout)
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("timeout of task %s has to be positive: %s", task.Name, task.Timeout)
+		}
+	}
+	return nil
+}
+
+// Used to verify the artifact size limit before saving after editing
+func (j *Job) verifyMaxArtifactSize() error {
+	if j.MaxArtifactSize == "" {
+		return nil
+	}
+	_, err := ParseSize(j.MaxArtifactSize)
+	return err
+}
+
+// Used to verify disk quota before saving after editing
+func (j *Job) verifyDiskQuota() error {
+	if j.DiskQuota != "" {
+		_, err := ParseSize(j.DiskQuota)
+		if err != nil {
+			return err
+		}
+	}
+	if j.DiskQuotaInterval != "" {
+		_, err := time.ParseDuration(j.DiskQuotaInterval)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Used to verify that presets set only declared params
+func (j *Job) verifyPresets() error {
+	declared := make(map[string]bool)
+	for idx := range j.DefaultParams {
+		for pkey := range j.DefaultParams[idx] {
+			declared[pkey] = true
+		}
+	}
+	for name, preset := range j.Presets {
+		for pkey := range preset {
+			if !declared[pkey] {
+				return fmt.Errorf("preset %s sets undeclared param %s", name, pkey)
+			}
+		}
+	}
+	return nil
+}
+
+// Used to verify the number of concurrent builds of the job before saving
+// after editing
+func (j *Job) verifyConcurrency() error {
+	if j.Concurrency < 0 {
+		return fmt.Errorf("concurrency can't be negative: %d", j.Concurrency)
+	}
+	return nil
+}
+
+// Used to verify that workflow stages refer to existing main tasks. Task IDs
+// are assigned after expanding all tasks
+func (j *Job) verifyWorkflow() error {
+	if len(j.Workflow) == 0 {
+		return nil
+	}
+	for _, task := range j.Tasks {
+		if task.Kind == KindMain && task.Group != "" {
+			return fmt.Errorf("group and workflow can't be used in the same job")
+		}
+	}
+	staged := make(map[int]string)
+	for _, stage := range j.Workflow {
+		for _, id := range stage.Tasks {
+			if id < 0 || id >= len(j.Tasks) {
+				return fmt.Errorf("stage %s refers to unknown task %d", stage.Name, id)
+			}
+			if j.Tasks[id].Kind != KindMain {
+				return fmt.Errorf("stage %s refers to task %d which is not a main task", stage.Name, id)
+			}
+			if other, ok := staged[id]; ok {
+				return fmt.Errorf("task %d is in stages %s and %s", id, other, stage.Name)
+			}
+			staged[id] = stage.Name
+		}
+	}
+	return nil
+}
+
+// Used to verify requirements before saving after editing
+func (j *Job) verifyRequires() error {
+	switch j.RequiresCheck {
+	case "", RequiresCheckEnqueue, RequiresCheckTake:
+	default:
+		return fmt.Errorf("invalid requires_check value: %s", j.RequiresCheck)
+	}
+	for _, req := range j.Requires {
+		if req.Job == "" {
+			return fmt.Errorf("job name is required in requires")
+		}
+		if req.Within != "" {
+			_, err := time.ParseDuration(req.Within)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Task is a command to execute
 // .Kind - Possible values: `KindMain` for main tasks; one of `StatusRunning` (and etc) for tasks that are executed when
 // the job status has changed
@@ -98,8 +349,62 @@ type Task struct {
 	IncludePath  string            `yaml:"include" json:"include"`
 	Block        []*Task           `yaml:"block" json:"block"`
 	IgnoreErrors bool              `yaml:"ignore_errors" json:"ignore_errors"`
-	startedAt    time.Time
-	duration     time.Duration
+	// The task keeps the failed status, but the build continues
+	AllowFailure bool `yaml:"allow_failure" json:"allow_failure"`
+	// The running task can be aborted alone and the build continues, see
+	// Build.RequestTaskAbort
+	Skippable bool `yaml:"skippable" json:"skippable"`
+	// Overrides Job.LineBufferSize
+	LineBufferSize string `yaml:"line_buffer_size" json:"line_buffer_size"`
+	// Overrides Job.LogOutput
+	LogOutput string `yaml:"log_output" json:"log_output"`
+	// What to do with processes left running by the task: warn (default),
+	// kill or ignore
+	ReapChildren string `yaml:"reap_children" json:"reap_children"`
+	// The task fails if it takes longer, the build continues as usual
+	Timeout string `yaml:"timeout" json:"timeout"`
+	// The number of times a failed main task is run again
+	Retries int `yaml:"retries" json:"retries"`
+	// Consecutive main tasks of the same group run concurrently
+	Group string `yaml:"group" json:"group"`
+	// Names of main tasks which have to finish before the task starts
+	DependsOn []string `yaml:"depends_on" json:"depends_on"`
+	startedAt time.Time
+	// Command after expanding variables with redacted secrets
+	resolvedCommand string
+	attempts        int           // The number of times the task has been run
+	stop            *TaskStopInfo // Set when the command is stopped on abort or timeout
+	duration        time.Duration
+	abortChannel    chan bool // Abort requests of the task alone
+	skipRequested   bool      // See Build.RequestTaskSkip
+}
+
+// LogKey returns identifier of the task's log stream. Every task runs once,
+// so it is the first iteration
+func (t *Task) LogKey() string {
+	return TaskLogKey(t.ID, 0)
+}
+
+// LogFileName returns name of the task's log file in the wakespace
+func (t *Task) LogFileName() string {
+	return TaskLogFileName(t.ID, 0)
+}
+
+// TaskLogKey returns identifier of the log stream of the task instance
+func TaskLogKey(taskID int, iteration int) string {
+	if iteration == 0 {
+		return strconv.Itoa(taskID)
+	}
+	return fmt.Sprintf("%d.%d", taskID, iteration)
+}
+
+// TaskLogFileName returns name of the log file of the task instance. Tasks
+// which run once keep the task_{id}.log name
+func TaskLogFileName(taskID int, iteration int) string {
+	if iteration == 0 {
+		return fmt.Sprintf("task_%d.log", taskID)
+	}
+	return fmt.Sprintf("task_%d_%d.log", taskID, iteration)
 }
 
 // OnTasks is a list of tasks that should be ran on status change
@@ -123,6 +428,7 @@ func CreateJobFromFile(path string) (*Job, error) {
 	if err != nil {
 		return nil, err
 	}
+	job.extractSensitiveParams()
 
 	// Assign main kind to all tasks
 	for _, t := range job.Tasks {
@@ -191,6 +497,156 @@ func CreateJobFromFile(path string) (*Job, error) {
 
 	job.Name = GetJobNameFromPath(path)
 
+	err = job.verifyInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyPresets()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyWorkflow()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyRequires()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = GetLogParser(job.LogParser)
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyScheduleMatrix()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyLineBufferSize()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyStatusWebhook()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyWebhook()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyNotifyOn()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyWebhookParams()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyBranches()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyMaxArtifactSize()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyTriggers()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyBuildRetention()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifySecretParams()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyLogOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyReapChildren()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyTaskTimeouts()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyTaskRetries()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyTaskGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyTaskConditions()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyTaskDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyRetry()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyArtifacts()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyResources()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyAuditWorkspace()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyMatrix()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyParamsSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	err = job.verifyConcurrency()
+	if err != nil {
+		return nil, err
+	}
+
 	Logger.Printf("Read job from file %s: %s, tasks %d\n", path, job.Name, len(job.Tasks))
 	return &job, nil
 }
@@ -244,7 +700,19 @@ func RegisterJob(filename string) error {
 		if err != nil {
 			return err
 		}
-		err = jb.Put([]byte("interval"), []byte(job.Interval))
+		err = jb.Put([]byte("interval"), []byte(job.cronSpec()))
+		if err != nil {
+			return err
+		}
+		presetsB, err := json.Marshal(job.Presets)
+		if err != nil {
+			return err
+		}
+		err = jb.Put([]byte("presets"), presetsB)
+		if err != nil {
+			return err
+		}
+		err = jb.Put([]byte("group"), []byte(job.Group))
 		if err != nil {
 			return err
 		}
@@ -278,17 +746,60 @@ func UnregisterJob(filename string) {
 
 func RemoveJobFromCron(name string) {
 	for _, entry := range GlobalCron.Entries() {
-		entryJob, ok := entry.Job.(*Job)
-		if ok && entryJob.Name == name {
-			GlobalCron.Remove(entry.ID)
-			Logger.Printf("Removing job %s from cron\n", name)
-			break
+		switch entryJob := entry.Job.(type) {
+		case *Job:
+			if entryJob.Name == name {
+				GlobalCron.Remove(entry.ID)
+				Logger.Printf("Removing job %s from cron\n", name)
+			}
+		case *SweepSchedule:
+			if entryJob.Name == name {
+				GlobalCron.Remove(entry.ID)
+				Logger.Printf("Removing sweep of job %s from cron\n", name)
+			}
 		}
 	}
 }
 
 // RunJob creates a new build and schedules it for execution
-func RunJob(name string, params url.Values) (*Build, error) {
+func RunJob(name string, params url.Values, triggeredBy string) (*Build, error) {
+	return RunJobWithLabels(name, params, triggeredBy, nil)
+}
+
+// RunJobWithLabels is RunJob which attaches labels to the build. If the job
+// has a matrix, the first build of the matrix group is returned
+func RunJobWithLabels(name string, params url.Values, triggeredBy string, labels map[string]string) (*Build, error) {
+	return runJobWithTrigger(name, params, &TriggerInfo{Kind: triggeredBy}, labels)
+}
+
+// runJobWithTrigger is RunJobWithLabels which copies the trigger to builds.
+// Values of the preset of the trigger are applied first, and then explicitly
+// provided params
+func runJobWithTrigger(name string, params url.Values, trigger *TriggerInfo, labels map[string]string) (*Build, error) {
+	builds, err := runJobMatrix(name, params, trigger, labels)
+	if err != nil {
+		return nil, err
+	}
+	return builds[0], nil
+}
+
+// RunJobMatrix creates a build for every combination of the job's matrix and
+// schedules them for execution. Builds share MatrixBuildID, the ID of the first
+// build. Values of
+// the matrix override params and preset. If the job has no matrix, a single
+// build is created. If a build can't be created, already created builds of
+// the group are aborted. If the job has `dedupe`, pending builds with the same
+// params are returned instead of new ones, DuplicateBuildError is returned if
+// no build is created
+func RunJobMatrix(name string, params url.Values, triggeredBy string, labels map[string]string) ([]*Build, error) {
+	return runJobMatrix(name, params, &TriggerInfo{Kind: triggeredBy}, labels)
+}
+
+// runJobMatrix is RunJobMatrix which copies the trigger to builds
+func runJobMatrix(name string, params url.Values, trigger *TriggerInfo, labels map[string]string) ([]*Build, error) {
+	if GlobalQueue.isDraining() {
+		return nil, ErrServerShuttingDown
+	}
 	// Check if job is enabled
 	err := DB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(JobsBucket))
@@ -314,26 +825,148 @@ func RunJob(name string, params url.Values) (*Build, error) {
 	if err != nil {
 		return nil, err
 	}
-	build, err := CreateBuild(job, jobFile)
+
+	err = job.verifyResourcesFitCapacity()
+	if err != nil {
+		return nil, err
+	}
+
+	var prerequisites *PrerequisitesCheck
+	if len(job.Requires) > 0 && job.RequiresCheck != RequiresCheckTake {
+		prerequisites = CheckPrerequisites(job)
+		if !prerequisites.Passed {
+			return nil, &PrerequisiteError{
+				Message: fmt.Sprintf("job %s can't be started: %s", name, prerequisites.Message),
+			}
+		}
+	}
+
+	presetName := trigger.Preset
+	preset, ok := job.Presets[presetName]
+	if presetName != "" && !ok {
+		return nil, fmt.Errorf("job %s doesn't have preset %s", name, presetName)
+	}
+
+	priority, err := parseBuildPriority(trigger.Priority)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update params from URL
-	for idx := range build.Params {
-		for pkey := range build.Params[idx] {
-			value := params.Get(pkey)
-			if value != "" {
-				build.Params[idx][pkey] = value
-				build.Logger.Printf("Updating key %s to %s", pkey, value)
+	combinations := job.matrixCombinations()
+	if combinations == nil {
+		combinations = []map[string]string{nil}
+	}
+	// Builds are created only if params of all of them are valid
+	for _, combination := range combinations {
+		err = job.validateParams(job.resolveParams(preset, params, combination))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if job.Dedupe {
+		GlobalQueue.dedupeMutex.Lock()
+		defer GlobalQueue.dedupeMutex.Unlock()
+	}
+	var builds []*Build
+	// Builds created by this call, pending duplicates belong to other triggers
+	var created []*Build
+	var matrixBuildID int
+	deduplicated := 0
+	for i, combination := range combinations {
+		if job.Dedupe {
+			existing := GlobalQueue.FindPendingDuplicate(job.Name, job.resolveParams(preset, params, combination))
+			if existing != nil {
+				existing.addDeduplicated(trigger.Kind)
+				builds = append(builds, existing)
+				deduplicated++
+				continue
+			}
+		}
+
+		// Status of tasks is stored in the job, so every build needs its own
+		// copy of the job
+		if i > 0 {
+			job, err = CreateJobFromFile(jobFile)
+			if err != nil {
+				abortMatrixBuilds(created)
+				return nil, err
+			}
+		}
+
+		build, err := CreateBuild(job, jobFile)
+		if err != nil {
+			abortMatrixBuilds(created)
+			return nil, err
+		}
+		buildTrigger := *trigger
+		build.Trigger = &buildTrigger
+		build.Prerequisites = prerequisites
+		build.Labels = labels
+		build.Priority = priority
+
+		// Apply preset
+		for idx := range build.Params {
+			for pkey := range build.Params[idx] {
+				value, ok := preset[pkey]
+				if ok {
+					build.Params[idx][pkey] = value
+					build.Logger.Printf("Updating key %s to %s from preset %s", pkey, value, presetName)
+				}
+			}
+		}
+
+		// Update params from URL
+		for idx := range build.Params {
+			for pkey := range build.Params[idx] {
+				value := params.Get(pkey)
+				if value != "" {
+					build.Params[idx][pkey] = value
+					build.Logger.Printf("Updating key %s to %s", pkey, value)
+				}
+			}
+		}
+
+		if combination != nil {
+			if matrixBuildID == 0 {
+				matrixBuildID = build.ID
 			}
+			build.MatrixBuildID = matrixBuildID
+			build.applyMatrix(combination)
 		}
+
+		if job.InstanceName != "" {
+			build.InstanceName = os.Expand(job.InstanceName, build.getParamsMapper())
+			build.Logger.Printf("Job instance name is %s\n", build.InstanceName)
+		}
+
+		err = RecordParamSuggestions(job.Name, build.Params)
+		if err != nil {
+			build.Logger.Println(err)
+		}
+
+		GlobalQueue.Add(build)
+		GlobalQueue.Take()
+		build.BroadcastUpdate()
+		builds = append(builds, build)
+		created = append(created, build)
+	}
+	if matrixBuildID != 0 {
+		Logger.Printf("Matrix build %d of job %s has %d builds\n", matrixBuildID, name, len(builds))
+	}
+	if deduplicated == len(builds) {
+		return nil, &DuplicateBuildError{Build: builds[0]}
 	}
+	return builds, nil
+}
 
-	GlobalQueue.Add(build)
-	GlobalQueue.Take()
-	build.BroadcastUpdate()
-	return build, nil
+// abortMatrixBuilds aborts builds of the partially created matrix
+func abortMatrixBuilds(builds []*Build) {
+	for _, build := range builds {
+		err := GlobalQueue.Abort(build.ID, StatusAborted)
+		if err != nil {
+			build.Logger.Println(err)
+		}
+	}
 }
 
 // InitJobWatcher initializes watcher which uses fsnotify to watch for changes
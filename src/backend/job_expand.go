@@ -97,3 +97,34 @@ func ReadTasks(path string) ([]*Task, error) {
 	}
 	return tasks, nil
 }
+
+// ListIncludes returns paths of all files included by tasks, recursively
+func ListIncludes(tasks []*Task) ([]string, error) {
+	includes := []string{}
+	for _, t := range tasks {
+		if t.IncludePath != "" {
+			includePath := filepath.Join(Config.JobDir, t.IncludePath)
+			if filepath.IsAbs(t.IncludePath) {
+				includePath = t.IncludePath
+			}
+			includes = append(includes, includePath)
+			included, err := ReadTasks(t.IncludePath)
+			if err != nil {
+				return nil, err
+			}
+			nested, err := ListIncludes(included)
+			if err != nil {
+				return nil, err
+			}
+			includes = append(includes, nested...)
+		}
+		if t.Block != nil {
+			nested, err := ListIncludes(t.Block)
+			if err != nil {
+				return nil, err
+			}
+			includes = append(includes, nested...)
+		}
+	}
+	return includes, nil
+}
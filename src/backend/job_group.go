@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// LabelGroupRunID links builds started by the same run of a job group
+const LabelGroupRunID = "GROUP_RUN_ID"
+
+// JobGroupMember is a job of the group with its latest build
+type JobGroupMember struct {
+	Name    string     `json:"name"`
+	BuildID int        `json:"build_id,omitempty"`
+	Status  ItemStatus `json:"status,omitempty"`
+}
+
+// JobGroupData is a group of jobs with the aggregate status of their latest
+// builds, see Job.Group
+type JobGroupData struct {
+	Name   string            `json:"name"`
+	Status ItemStatus        `json:"status,omitempty"`
+	Jobs   []*JobGroupMember `json:"jobs"`
+}
+
+// JobGroupRunData contains builds started by a run of the job group
+type JobGroupRunData struct {
+	GroupRunID string         `json:"group_run_id"`
+	Builds     map[string]int `json:"builds"`
+	// Members which couldn't be started, other members are started anyway
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// getJobGroupMembers returns names of jobs of each group sorted by name
+func getJobGroupMembers(tx *bolt.Tx) map[string][]string {
+	groups := map[string][]string{}
+	b := tx.Bucket(JobsBucket)
+	b.ForEach(func(key, v []byte) error {
+		jb := b.Bucket(key)
+		if jb == nil {
+			return nil
+		}
+		if group := string(jb.Get([]byte("group"))); group != "" {
+			groups[group] = append(groups[group], string(key))
+		}
+		return nil
+	})
+	for _, members := range groups {
+		sort.Strings(members)
+	}
+	return groups
+}
+
+// GetJobGroups returns all job groups sorted by name
+func GetJobGroups() ([]*JobGroupData, error) {
+	groups := []*JobGroupData{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		members := getJobGroupMembers(tx)
+		// The latest build of every member
+		latest := map[string]*JobGroupMember{}
+		for group, jobs := range members {
+			data := &JobGroupData{Name: group}
+			for _, name := range jobs {
+				member := &JobGroupMember{Name: name}
+				latest[name] = member
+				data.Jobs = append(data.Jobs, member)
+			}
+			groups = append(groups, data)
+		}
+		remaining := len(latest)
+		c := tx.Bucket(HistoryBucket).Cursor()
+		for key, v := c.Last(); key != nil && remaining > 0; key, v = c.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			member, ok := latest[msg.JobName()]
+			if !ok || member.BuildID != 0 {
+				continue
+			}
+			member.BuildID = msg.ID
+			member.Status = msg.Status
+			remaining--
+		}
+		return nil
+	})
+	for _, group := range groups {
+		statuses := []ItemStatus{}
+		for _, member := range group.Jobs {
+			if member.BuildID != 0 {
+				statuses = append(statuses, member.Status)
+			}
+		}
+		if len(statuses) > 0 {
+			group.Status = AggregateStatus(statuses)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, err
+}
+
+// RunJobGroup starts a build of every job of the group. Builds share the
+// GROUP_RUN_ID label. A member which can't be started doesn't stop others
+func RunJobGroup(name string, params url.Values, triggeredBy string) (*JobGroupRunData, error) {
+	var jobs []string
+	err := DB.View(func(tx *bolt.Tx) error {
+		jobs = getJobGroupMembers(tx)[name]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("job group %s has no jobs", name)
+	}
+	data := &JobGroupRunData{
+		GroupRunID: name + "-" + time.Now().Format("20060102-150405"),
+		Builds:     map[string]int{},
+		Errors:     map[string]string{},
+	}
+	Logger.Printf("Starting group run %s with %d jobs\n", data.GroupRunID, len(jobs))
+	for _, job := range jobs {
+		build, err := RunJobWithLabels(job, params, triggeredBy, map[string]string{
+			LabelGroupRunID: data.GroupRunID,
+		})
+		var duplicateErr *DuplicateBuildError
+		if errors.As(err, &duplicateErr) {
+			build, err = duplicateErr.Build, nil
+		}
+		if err != nil {
+			Logger.Printf("Unable to start job %s of group run %s: %s\n", job, data.GroupRunID, err)
+			data.Errors[job] = err.Error()
+			continue
+		}
+		build.Logger.Printf("The build is started as part of group run %s\n", data.GroupRunID)
+		data.Builds[job] = build.ID
+	}
+	return data, nil
+}
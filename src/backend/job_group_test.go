@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRunJobGroup(t *testing.T) {
+	setupTestEnv(t)
+	GlobalCron = cron.New()
+	jobs := map[string]string{
+		"group-ok":       "group: deploy\ntasks:\n  - run: \"true\"\n",
+		"group-fail":     "group: deploy\ntasks:\n  - run: \"false\"\n",
+		"group-disabled": "group: deploy\ntasks:\n  - run: \"true\"\n",
+		"other":          "tasks:\n  - run: \"true\"\n",
+	}
+	for name, content := range jobs {
+		err := os.WriteFile(Config.JobDir+name+".yaml", []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = RegisterJob(Config.JobDir + name + ".yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(JobsBucket).Bucket([]byte("group-disabled")).Put([]byte("active"), []byte("false"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunJobGroup("unknown", url.Values{}, TriggerManual); err == nil {
+		t.Error("Expected error for a group without jobs")
+	}
+	data, err := RunJobGroup("deploy", url.Values{}, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Builds) != 2 || data.Builds["group-ok"] == 0 || data.Builds["group-fail"] == 0 {
+		t.Errorf("Expected builds of enabled jobs, got %v", data.Builds)
+	}
+	if len(data.Errors) != 1 || data.Errors["group-disabled"] == "" {
+		t.Errorf("Expected the disabled job to be reported, got %v", data.Errors)
+	}
+	for _, id := range data.Builds {
+		waitFor(t, 5*time.Second, "builds are completed", func() bool {
+			status, err := getBuildStatusData(id)
+			return err == nil && isTerminalStatus(status.Status)
+		})
+		status, err := getBuildStatusData(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Labels[LabelGroupRunID] != data.GroupRunID {
+			t.Errorf("Expected build %d to have group run id %s, got %v", id, data.GroupRunID, status.Labels)
+		}
+	}
+
+	groups, err := GetJobGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "deploy" || len(groups[0].Jobs) != 3 {
+		t.Fatalf("Expected a single group with 3 jobs, got %+v", groups)
+	}
+	if groups[0].Status != StatusFailed {
+		t.Errorf("Expected the group to fail, got %q", groups[0].Status)
+	}
+	for _, member := range groups[0].Jobs {
+		if member.BuildID != data.Builds[member.Name] {
+			t.Errorf("Expected latest build of %s to be %d, got %d", member.Name, data.Builds[member.Name], member.BuildID)
+		}
+	}
+}
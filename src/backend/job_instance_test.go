@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobInstanceName(t *testing.T) {
+	setupTestEnv(t)
+	putTestJob(t, "deploy", `
+instance_name: deploy-${SERVICE}
+params:
+  - SERVICE: web
+tasks:
+  - run: echo ${WAKE_JOB_NAME} ${WAKE_JOB_TEMPLATE}
+`)
+	build, err := RunJob("deploy", url.Values{"SERVICE": {"api"}}, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+
+	data, err := getBuildStatusData(build.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Name != "deploy-api" || data.Template != "deploy" || data.JobName() != "deploy" {
+		t.Errorf("Expected instance deploy-api of job deploy, got %q %q", data.Name, data.Template)
+	}
+	logB, err := os.ReadFile(build.GetWakespaceDir() + TaskLogFileName(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logB), "] deploy-api deploy\n") {
+		t.Errorf("Expected instance and template names in env variables: %s", logB)
+	}
+
+	// Builds of jobs without instance_name keep the job name
+	putTestJob(t, "lint", "tasks:\n  - run: \"true\"\n")
+	other, err := RunJob("lint", nil, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTerminalState(t, other, 5*time.Second, StatusFinished)
+	if data := other.GenerateBuildUpdateData(); data.Name != "lint" || data.Template != "" {
+		t.Errorf("Expected job name lint without template, got %q %q", data.Name, data.Template)
+	}
+
+	// Builds are found by the name of the instance and of the job
+	for _, filter := range []string{"deploy-api", "+deploy"} {
+		w := httptest.NewRecorder()
+		HandleFeedView(w, httptest.NewRequest(http.MethodGet, "/api/feed/?filter="+url.QueryEscape(filter), nil))
+		var builds []*BuildUpdateData
+		err = json.Unmarshal(w.Body.Bytes(), &builds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(builds) != 1 || builds[0].ID != build.ID {
+			t.Errorf("%s: expected build %d, got %d builds", filter, build.ID, len(builds))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// DefaultLineBufferSize is the maximum length of a log line, longer lines are
+// truncated
+const DefaultLineBufferSize = 491520
+
+// LineTruncatedMarker is appended to truncated log lines
+const LineTruncatedMarker = " [line truncated, %d bytes omitted]"
+
+// lineLimitWriter truncates lines longer than limit. Without it go-cmd fails
+// to stream the rest of the output once a line doesn't fit into its buffer
+type lineLimitWriter struct {
+	w       io.Writer
+	limit   int
+	lineLen int // Bytes of the current line written to w
+	dropped int // Bytes of the current line which were omitted
+}
+
+func (lw *lineLimitWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		idx := bytes.IndexByte(p, '\n')
+		chunk := p
+		if idx >= 0 {
+			chunk = p[:idx]
+		}
+		free := lw.limit - lw.lineLen
+		if free < 0 {
+			free = 0
+		}
+		if len(chunk) > free {
+			lw.dropped += len(chunk) - free
+			chunk = chunk[:free]
+		}
+		if len(chunk) > 0 {
+			_, err := lw.w.Write(chunk)
+			if err != nil {
+				return 0, err
+			}
+			lw.lineLen += len(chunk)
+		}
+		if idx < 0 {
+			break
+		}
+		end := "\n"
+		if lw.dropped > 0 {
+			end = fmt.Sprintf(LineTruncatedMarker, lw.dropped) + end
+		}
+		_, err := lw.w.Write([]byte(end))
+		if err != nil {
+			return 0, err
+		}
+		lw.lineLen = 0
+		lw.dropped = 0
+		p = p[idx+1:]
+	}
+	return n, nil
+}
+
+// limitLines wraps output of the command to truncate long lines. It is used
+// as BeforeExec hook of go-cmd
+func limitLines(limit int) func(*exec.Cmd) {
+	return func(c *exec.Cmd) {
+		if c.Stdout != nil {
+			c.Stdout = &lineLimitWriter{w: c.Stdout, limit: limit}
+		}
+		if c.Stderr != nil {
+			c.Stderr = &lineLimitWriter{w: c.Stderr, limit: limit}
+		}
+	}
+}
+
+// getLineBufferSize returns the maximum length of log lines of the task. The
+// task value overrides the job one
+func (b *Build) getLineBufferSize(task *Task) int {
+	for _, value := range []string{task.LineBufferSize, b.Job.LineBufferSize} {
+		if value == "" {
+			continue
+		}
+		size, err := ParseSize(value)
+		if err != nil {
+			b.Logger.Println(err)
+			continue
+		}
+		return int(size)
+	}
+	return DefaultLineBufferSize
+}
+
+// Used to verify line buffer sizes before saving after editing
+func (j *Job) verifyLineBufferSize() error {
+	values := []string{j.LineBufferSize}
+	for _, task := range j.Tasks {
+		values = append(values, task.LineBufferSize)
+	}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		size, err := ParseSize(value)
+		if err != nil {
+			return err
+		}
+		if size <= 0 {
+			return fmt.Errorf("line_buffer_size has to be positive: %s", value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LogDiffContext is the number of unchanged lines around each change in the
+// unified diff
+const LogDiffContext = 3
+
+// LogDiffMaxCells limits the size of the LCS table to keep memory usage sane
+const LogDiffMaxCells = 16 * 1024 * 1024
+
+// LogDiffMaxSize is the default limit of the diff size in bytes
+const LogDiffMaxSize = 1024 * 1024
+
+// LogDiffSummaryBlock is the number of lines in a block of the diff summary
+const LogDiffSummaryBlock = 1000
+
+// LogDiffTruncatedNotice is appended to truncated diffs
+const LogDiffTruncatedNotice = "... diff is truncated: %d of %d bytes shown, use mode=summary to find changed blocks\n"
+
+var logTimestampRE = regexp.MustCompile(`^\[\s*[^\]]*\] `)
+var logUUIDRE = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+var logNumberRE = regexp.MustCompile(`[0-9]+`)
+
+// NormalizeLogLine removes parts of the log line which are different in every
+// build: duration prefix, UUIDs and numbers
+func NormalizeLogLine(line string) string {
+	line = logTimestampRE.ReplaceAllString(line, "")
+	line = logUUIDRE.ReplaceAllString(line, "<uuid>")
+	line = logNumberRE.ReplaceAllString(line, "N")
+	return line
+}
+
+// CompileLogDiffPatterns compiles regular expressions of volatile parts of log
+// lines, e.g. timestamps
+func CompileLogDiffPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log diff pattern %q: %w", p, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// NormalizeLogLines normalizes lines like NormalizeLogLine and additionally
+// replaces matches of the patterns with "<ignored>". Patterns are applied
+// before numbers are replaced, so they see the original text of the line
+func NormalizeLogLines(lines []string, patterns []*regexp.Regexp) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		line = logTimestampRE.ReplaceAllString(line, "")
+		for _, re := range patterns {
+			line = re.ReplaceAllString(line, "<ignored>")
+		}
+		line = logUUIDRE.ReplaceAllString(line, "<uuid>")
+		result[i] = logNumberRE.ReplaceAllString(line, "N")
+	}
+	return result
+}
+
+// TruncateDiff cuts the diff at the line boundary to fit into max bytes and
+// appends a notice
+func TruncateDiff(diff string, max int) string {
+	if len(diff) <= max {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:max], '\n') + 1
+	return diff[:cut] + fmt.Sprintf(LogDiffTruncatedNotice, cut, len(diff))
+}
+
+// LogDiffBlock is the number of changed lines in a block of the log
+type LogDiffBlock struct {
+	FromLine int `json:"from_line"`
+	ToLine   int `json:"to_line"`
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+}
+
+// SummarizeLogDiff compares blocks of size lines with the same position in
+// both logs and returns blocks which differ. Unlike UnifiedDiff it works with
+// logs of any size, but a line moved to another block is reported twice
+func SummarizeLogDiff(a, b []string, size int) []*LogDiffBlock {
+	result := []*LogDiffBlock{}
+	total := len(a)
+	if len(b) > total {
+		total = len(b)
+	}
+	block := func(lines []string, from int) []string {
+		if from >= len(lines) {
+			return nil
+		}
+		to := from + size
+		if to > len(lines) {
+			to = len(lines)
+		}
+		return lines[from:to]
+	}
+	for from := 0; from < total; from += size {
+		counts := make(map[string]int)
+		for _, line := range block(a, from) {
+			counts[line]++
+		}
+		added := 0
+		for _, line := range block(b, from) {
+			if counts[line] > 0 {
+				counts[line]--
+			} else {
+				added++
+			}
+		}
+		removed := 0
+		for _, c := range counts {
+			removed += c
+		}
+		if added == 0 && removed == 0 {
+			continue
+		}
+		result = append(result, &LogDiffBlock{
+			FromLine: from + 1,
+			ToLine:   from + size,
+			Added:    added,
+			Removed:  removed,
+		})
+	}
+	return result
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns unified diff between a and b
+func UnifiedDiff(a, b []string, nameA, nameB string) (string, error) {
+	// Strip common prefix and suffix to reduce the LCS table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+	if (len(ma)+1)*(len(mb)+1) > LogDiffMaxCells {
+		return "", fmt.Errorf("logs are too different to compare: %d and %d changed lines", len(ma), len(mb))
+	}
+
+	// Longest common subsequence
+	lcs := make([][]int32, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	i, j := 0, 0
+	for i < len(ma) && j < len(mb) {
+		switch {
+		case ma[i] == mb[j]:
+			ops = append(ops, diffOp{' ', ma[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', ma[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', mb[j]})
+			j++
+		}
+	}
+	for ; i < len(ma); i++ {
+		ops = append(ops, diffOp{'-', ma[i]})
+	}
+	for ; j < len(mb); j++ {
+		ops = append(ops, diffOp{'+', mb[j]})
+	}
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	return formatUnifiedDiff(ops, nameA, nameB), nil
+}
+
+// formatUnifiedDiff groups operations into hunks
+func formatUnifiedDiff(ops []diffOp, nameA, nameB string) string {
+	var sb strings.Builder
+	idx := 0
+	for idx < len(ops) {
+		// Find the next change
+		start := idx
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		// Extend the hunk while changes are close to each other
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*LogDiffContext {
+				break
+			}
+			end = next
+		}
+		from := start - LogDiffContext
+		if from < idx {
+			from = idx
+		}
+		to := end + LogDiffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		// Calculate line numbers of the hunk
+		lineA, lineB := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				lineA++
+			}
+			if op.kind != '-' {
+				lineB++
+			}
+		}
+		countA, countB := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		if countA == 0 {
+			lineA--
+		}
+		if countB == 0 {
+			lineB--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+		for _, op := range ops[from:to] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		idx = to
+	}
+	return sb.String()
+}
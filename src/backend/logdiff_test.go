@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNormalizeLogLine(t *testing.T) {
+	input := "[   1.234s] Build 42 uploaded as 0b4f3c7e-43a1-4c83-9df6-1a2b3c4d5e6f"
+	result := NormalizeLogLine(input)
+	expected := "Build N uploaded as <uuid>"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+		return
+	}
+}
+
+func TestUnifiedDiff_Equal(t *testing.T) {
+	lines := []string{"a", "b", "c"}
+	result, err := UnifiedDiff(lines, lines, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result != "" {
+		t.Errorf("Expected empty diff, got %q", result)
+		return
+	}
+}
+
+func TestUnifiedDiff_Change(t *testing.T) {
+	a := []string{"one", "two", "three", "four", "five", "six", "seven", "eight"}
+	b := []string{"one", "two", "three", "four", "FIVE", "six", "seven", "eight"}
+	result, err := UnifiedDiff(a, b, "build/1", "build/2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "--- build/1\n+++ build/2\n@@ -2,7 +2,7 @@\n two\n three\n four\n-five\n+FIVE\n six\n seven\n eight\n"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+		return
+	}
+}
+
+func TestUnifiedDiff_Append(t *testing.T) {
+	a := []string{"one"}
+	b := []string{"one", "two"}
+	result, err := UnifiedDiff(a, b, "a", "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "--- a\n+++ b\n@@ -1,1 +1,2 @@\n one\n+two\n"
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+		return
+	}
+}
+
+func TestNormalizeLogLines_Patterns(t *testing.T) {
+	patterns, err := CompileLogDiffPatterns([]string{`host-[a-z]+`})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result := NormalizeLogLines([]string{"[ 1.5s] connected to host-abc on port 80"}, patterns)
+	expected := "connected to <ignored> on port N"
+	if result[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, result[0])
+		return
+	}
+	_, err = CompileLogDiffPatterns([]string{`(`})
+	if err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	diff := "--- a\n+++ b\n-one\n+two\n"
+	if TruncateDiff(diff, len(diff)) != diff {
+		t.Error("Expected diff to be returned as is")
+		return
+	}
+	expected := "--- a\n+++ b\n" + fmt.Sprintf(LogDiffTruncatedNotice, 12, len(diff))
+	result := TruncateDiff(diff, 15)
+	if result != expected {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+func TestSummarizeLogDiff(t *testing.T) {
+	a := []string{"one", "two", "three", "four", "five"}
+	b := []string{"one", "two", "THREE", "four", "five", "six"}
+	result := SummarizeLogDiff(a, b, 2)
+	if len(result) != 2 {
+		t.Errorf("Expected 2 blocks, got %d", len(result))
+		return
+	}
+	if result[0].FromLine != 3 || result[0].Added != 1 || result[0].Removed != 1 {
+		t.Errorf("Unexpected first block: %+v", result[0])
+	}
+	if result[1].FromLine != 5 || result[1].Added != 1 || result[1].Removed != 0 {
+		t.Errorf("Unexpected second block: %+v", result[1])
+	}
+}
+
+func TestHandleGetBuildLogDiff_Iteration(t *testing.T) {
+	setupTestEnv(t)
+	for id, line := range map[int]string{1: "deploy eu", 2: "deploy us"} {
+		putTestBuildStatus(t, id, "deploy", StatusFinished, time.Now())
+		dir := (&Build{ID: id}).GetWakespaceDir()
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first instance of the task logs the same line in both builds
+		err = os.WriteFile(dir+TaskLogFileName(0, 0), []byte("[ 1ms] same\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(dir+TaskLogFileName(0, 1), []byte("[ 1ms] "+line+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		HandleGetBuildLogDiff(w, httptest.NewRequest(http.MethodGet, "/api/builds/log-diff?a=1&b=2&task=0"+query, nil))
+		return w
+	}
+
+	if w := get(""); w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Errorf("Expected no difference of the first instance, got %d %q", w.Code, w.Body.String())
+	}
+	expected := "--- build/1/task_0_1.log\n+++ build/2/task_0_1.log\n@@ -1,1 +1,1 @@\n-deploy eu\n+deploy us\n"
+	if w := get("&iteration=1"); w.Code != http.StatusOK || w.Body.String() != expected {
+		t.Errorf("Expected diff of the second instance, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("&iteration=2"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing instance, got %d", w.Code)
+	}
+	if w := get("&iteration=x"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid iteration, got %d", w.Code)
+	}
+}
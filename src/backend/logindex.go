@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// LogIndexMaxBuilds limits number of builds in the log index
+const LogIndexMaxBuilds = 1000
+
+// Number of the latest builds to search in
+const (
+	LogSearchJobBuilds = 100
+	LogSearchAllBuilds = 20
+)
+
+// Terms shorter than this are not indexed
+const logIndexMinTermLength = 3
+
+var logTermRE = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+var taskLogFileRE = regexp.MustCompile(`^task_(\d+)(?:_(\d+))?\.log$`)
+
+// LogSearchMatch is a line of a task log which contains the search query
+type LogSearchMatch struct {
+	BuildID   int    `json:"build_id"`
+	TaskID    int    `json:"task_id"`
+	Iteration int    `json:"iteration"`
+	LineNo    int    `json:"line_no"`
+	Line      string `json:"line"`
+}
+
+// logIndexEntry is stored in the builds sub-bucket of LogIndexBucket and used
+// to remove the build from the index
+type logIndexEntry struct {
+	Job   string   `json:"job"`
+	Terms []string `json:"terms"`
+}
+
+// TokenizeLogLine returns lowercase terms of the line which are indexed
+func TokenizeLogLine(line string) []string {
+	terms := []string{}
+	for _, term := range logTermRE.FindAllString(strings.ToLower(line), -1) {
+		if len(term) >= logIndexMinTermLength {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// taskLogFile is a log file of a task instance in the wakespace
+type taskLogFile struct {
+	path      string
+	taskID    int
+	iteration int
+}
+
+// listTaskLogs returns log files of the build ordered by task ID
+func listTaskLogs(buildID int) ([]*taskLogFile, error) {
+	paths, err := filepath.Glob(filepath.Join(Config.WorkDir, "wakespace", strconv.Itoa(buildID), "task_*.log"))
+	if err != nil {
+		return nil, err
+	}
+	files := []*taskLogFile{}
+	for _, path := range paths {
+		m := taskLogFileRE.FindStringSubmatch(filepath.Base(path))
+		if m == nil {
+			continue
+		}
+		file := &taskLogFile{path: path}
+		file.taskID, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			file.iteration, _ = strconv.Atoi(m[2])
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].taskID != files[j].taskID {
+			return files[i].taskID < files[j].taskID
+		}
+		return files[i].iteration < files[j].iteration
+	})
+	return files, nil
+}
+
+// IndexBuildLogs adds terms of all task logs of the build to the log index
+// and removes the oldest builds from the index
+func IndexBuildLogs(buildID int, jobName string) error {
+	files, err := listTaskLogs(buildID)
+	if err != nil {
+		return err
+	}
+	termSet := map[string]bool{}
+	for _, file := range files {
+		f, err := os.Open(file.path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			for _, term := range TokenizeLogLine(stripLogPrefix(scanner.Text())) {
+				termSet[term] = true
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			Logger.Println(err)
+		}
+	}
+	entry := logIndexEntry{
+		Job:   jobName,
+		Terms: make([]string, 0, len(termSet)),
+	}
+	for term := range termSet {
+		entry.Terms = append(entry.Terms, term)
+	}
+	entryB, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return DB.Update(func(tx *bolt.Tx) error {
+		lb := tx.Bucket(LogIndexBucket)
+		err := lb.Bucket(LogIndexBuildsKey).Put(Itob(buildID), entryB)
+		if err != nil {
+			return err
+		}
+		tb := lb.Bucket(LogIndexTermsKey)
+		for _, term := range entry.Terms {
+			postings := tb.Get([]byte(term))
+			updated := make([]byte, len(postings), len(postings)+8)
+			copy(updated, postings)
+			err = tb.Put([]byte(term), append(updated, Itob(buildID)...))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Keep only the latest builds
+		bb := lb.Bucket(LogIndexBuildsKey)
+		outdated := [][]byte{}
+		c := bb.Cursor()
+		count := 0
+		for key, _ := c.Last(); key != nil; key, _ = c.Prev() {
+			count++
+			if count > LogIndexMaxBuilds {
+				outdated = append(outdated, key)
+			}
+		}
+		for _, key := range outdated {
+			err = removeFromLogIndex(tx, int(binary.BigEndian.Uint64(key)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// removeFromLogIndex removes the build from postings of all its terms
+func removeFromLogIndex(tx *bolt.Tx, buildID int) error {
+	lb := tx.Bucket(LogIndexBucket)
+	bb := lb.Bucket(LogIndexBuildsKey)
+	data := bb.Get(Itob(buildID))
+	if data == nil {
+		return nil
+	}
+	var entry logIndexEntry
+	err := json.Unmarshal(data, &entry)
+	if err != nil {
+		return err
+	}
+	id := Itob(buildID)
+	tb := lb.Bucket(LogIndexTermsKey)
+	for _, term := range entry.Terms {
+		postings := tb.Get([]byte(term))
+		updated := make([]byte, 0, len(postings))
+		for i := 0; i+8 <= len(postings); i += 8 {
+			if !bytes.Equal(postings[i:i+8], id) {
+				updated = append(updated, postings[i:i+8]...)
+			}
+		}
+		if len(updated) == 0 {
+			err = tb.Delete([]byte(term))
+		} else {
+			err = tb.Put([]byte(term), updated)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bb.Delete(id)
+}
+
+// SearchLogs returns lines of task logs which contain the query. Only the
+// latest indexed builds of the job (or of all jobs if job is empty) are
+// searched, newest builds first
+func SearchLogs(query string, job string, limit int) ([]*LogSearchMatch, error) {
+	scope := LogSearchAllBuilds
+	if job != "" {
+		scope = LogSearchJobBuilds
+	}
+	terms := TokenizeLogLine(query)
+
+	candidates := []int{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		lb := tx.Bucket(LogIndexBucket)
+		c := lb.Bucket(LogIndexBuildsKey).Cursor()
+		for key, v := c.Last(); key != nil && len(candidates) < scope; key, v = c.Prev() {
+			if job != "" {
+				var entry logIndexEntry
+				err := json.Unmarshal(v, &entry)
+				if err != nil {
+					return err
+				}
+				if entry.Job != job {
+					continue
+				}
+			}
+			candidates = append(candidates, int(binary.BigEndian.Uint64(key)))
+		}
+
+		// Keep only builds which contain all terms of the query
+		tb := lb.Bucket(LogIndexTermsKey)
+		for _, term := range terms {
+			postings := tb.Get([]byte(term))
+			found := map[int]bool{}
+			for i := 0; i+8 <= len(postings); i += 8 {
+				found[int(binary.BigEndian.Uint64(postings[i:i+8]))] = true
+			}
+			filtered := candidates[:0]
+			for _, id := range candidates {
+				if found[id] {
+					filtered = append(filtered, id)
+				}
+			}
+			candidates = filtered
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []*LogSearchMatch{}
+	lowerQuery := strings.ToLower(query)
+	for _, buildID := range candidates {
+		files, err := listTaskLogs(buildID)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			f, err := os.Open(file.path)
+			if err != nil {
+				// The build might be removed by the cleaner
+				Logger.Println(err)
+				continue
+			}
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			lineNo := 0
+			for scanner.Scan() {
+				lineNo++
+				if !strings.Contains(strings.ToLower(stripLogPrefix(scanner.Text())), lowerQuery) {
+					continue
+				}
+				matches = append(matches, &LogSearchMatch{
+					BuildID:   buildID,
+					TaskID:    file.taskID,
+					Iteration: file.iteration,
+					LineNo:    lineNo,
+					Line:      scanner.Text(),
+				})
+				if len(matches) == limit {
+					f.Close()
+					return matches, nil
+				}
+			}
+			f.Close()
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeLogLine(t *testing.T) {
+	result := TokenizeLogLine("Error: connection to DB-01 refused (exit 1)")
+	expected := []string{"error", "connection", "refused", "exit"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
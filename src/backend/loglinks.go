@@ -0,0 +1,36 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matches http(s), ssh and git URLs and scp-like git remotes, e.g.
+// git@github.com:jsnjack/wakeci.git
+var logLinkRE = regexp.MustCompile(`(?:(?:https?|ssh|git)://|\b[\w.-]+@[\w.-]+\.[a-z]{2,}:)[^\s"'<>\x60]+`)
+
+// LogLink is a URL mentioned in task logs of the build. TaskID and LineNo
+// point to the first occurrence
+type LogLink struct {
+	URL    string `json:"url"`
+	TaskID int    `json:"task_id"`
+	LineNo int    `json:"line_no"`
+	Count  int    `json:"count"`
+}
+
+// FindLogLinks returns URLs found in the line
+func FindLogLinks(line string) []string {
+	links := logLinkRE.FindAllString(line, -1)
+	for i, link := range links {
+		// Punctuation at the end most likely belongs to the sentence
+		link = strings.TrimRight(link, ".,;:!?")
+		// Keep balanced brackets, e.g. wiki links
+		for _, pair := range []string{"()", "[]", "{}"} {
+			for strings.HasSuffix(link, pair[1:]) && strings.Count(link, pair[1:]) > strings.Count(link, pair[:1]) {
+				link = strings.TrimSuffix(link, pair[1:])
+			}
+		}
+		links[i] = link
+	}
+	return links
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindLogLinks(t *testing.T) {
+	line := "[  1.2s] Cloning git@github.com:jsnjack/wakeci.git (see https://example.com/report?id=1). Mirror: ssh://git@host/repo, docs (https://en.wikipedia.org/wiki/Go_(language))"
+	expected := []string{
+		"git@github.com:jsnjack/wakeci.git",
+		"https://example.com/report?id=1",
+		"ssh://git@host/repo",
+		"https://en.wikipedia.org/wiki/Go_(language)",
+	}
+	result := FindLogLinks(line)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// LogOutputSanitize removes trailing carriage returns and escapes invalid
+	// UTF-8 and control characters as \xNN, so the log stays valid text
+	LogOutputSanitize = "sanitize"
+	// LogOutputRaw writes the output to the log file as is. Lines sent to the
+	// browser are sanitized anyway
+	LogOutputRaw = "raw"
+)
+
+// SanitizeLogLine converts CRLF line endings to LF and escapes bytes which
+// can't be displayed as text. Tabs and escape sequences (colors) are kept
+func SanitizeLogLine(line string) string {
+	line = strings.TrimRight(line, "\r")
+	clean := true
+	for i := 0; i < len(line); i++ {
+		if line[i] < 0x20 && line[i] != '\t' && line[i] != 0x1b || line[i] == 0x7f || line[i] >= utf8.RuneSelf {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return line
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			fmt.Fprintf(&sb, "\\x%02x", line[i])
+		case r < 0x20 && r != '\t' && r != 0x1b, r == 0x7f:
+			fmt.Fprintf(&sb, "\\x%02x", r)
+		default:
+			sb.WriteString(line[i : i+size])
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// getLogOutput returns the log output mode of the task. The task value
+// overrides the job one
+func (b *Build) getLogOutput(task *Task) string {
+	for _, value := range []string{task.LogOutput, b.Job.LogOutput} {
+		if value != "" {
+			return value
+		}
+	}
+	return LogOutputSanitize
+}
+
+// Used to verify log output modes before saving after editing
+func (j *Job) verifyLogOutput() error {
+	values := []string{j.LogOutput}
+	for _, task := range j.Tasks {
+		values = append(values, task.LogOutput)
+	}
+	for _, value := range values {
+		switch value {
+		case "", LogOutputSanitize, LogOutputRaw:
+		default:
+			return fmt.Errorf("log_output has to be %s or %s: %s", LogOutputSanitize, LogOutputRaw, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestSanitizeLogLine(t *testing.T) {
+	cases := map[string]string{
+		"plain text":             "plain text",
+		"windows line\r":         "windows line",
+		"double\r\r":             "double",
+		"progress 10%\r20%":      "progress 10%\\x0d20%",
+		"binary \xff\xfe data":   "binary \\xff\\xfe data",
+		"nul\x00byte":            "nul\\x00byte",
+		"tab\tand \x1b[31mred":   "tab\tand \x1b[31mred",
+		"unicode ✓ and \xe2\x9c": "unicode ✓ and \\xe2\\x9c",
+	}
+	for input, expected := range cases {
+		result := SanitizeLogLine(input)
+		if result != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, input, result)
+		}
+	}
+}
+
+func TestVerifyLogOutput(t *testing.T) {
+	job := &Job{LogOutput: LogOutputRaw, Tasks: []*Task{{LogOutput: LogOutputSanitize}}}
+	if err := job.verifyLogOutput(); err != nil {
+		t.Error(err)
+	}
+	job.Tasks[0].LogOutput = "binary"
+	if err := job.verifyLogOutput(); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
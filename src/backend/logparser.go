@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Supported values of Job.LogParser
+const (
+	LogParserAuto   = "auto"
+	LogParserPlain  = "plain"
+	LogParserGoTest = "go-test"
+	LogParserPytest = "pytest"
+)
+
+// Types of events produced by log parsers
+const (
+	LogEventTestPass = "test_pass"
+	LogEventTestFail = "test_fail"
+	LogEventTestSkip = "test_skip"
+)
+
+// LogEventMaxMessage limits the size of failure messages
+const LogEventMaxMessage = 4096
+
+// LogEvent is a structured event found in a task log
+type LogEvent struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	TaskID   int    `json:"task_id"`
+	Duration int64  `json:"duration_ms,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// LogParser extracts structured events from log lines
+type LogParser interface {
+	Parse(lines []string) []*LogEvent
+}
+
+// GetLogParser returns the parser by its name. Returns nil for "auto"
+func GetLogParser(name string) (LogParser, error) {
+	switch name {
+	case "", LogParserAuto:
+		return nil, nil
+	case LogParserPlain:
+		return &plainLogParser{}, nil
+	case LogParserGoTest:
+		return &goTestLogParser{}, nil
+	case LogParserPytest:
+		return &pytestLogParser{}, nil
+	}
+	return nil, fmt.Errorf("unknown log parser: %s", name)
+}
+
+// DetectLogParser chooses the parser by the content of the log
+func DetectLogParser(lines []string) LogParser {
+	for _, line := range lines {
+		line = stripLogPrefix(line)
+		if goTestRunRE.MatchString(line) || goTestResultRE.MatchString(line) {
+			return &goTestLogParser{}
+		}
+		if pytestResultRE.MatchString(line) {
+			return &pytestLogParser{}
+		}
+	}
+	return &plainLogParser{}
+}
+
+// stripLogPrefix removes the duration prefix added by ProcessLogEntry
+func stripLogPrefix(line string) string {
+	return logTimestampRE.ReplaceAllString(line, "")
+}
+
+// isInternalLogLine reports whether the line was written by wakeci itself
+func isInternalLogLine(line string) bool {
+	return strings.HasPrefix(line, "> ")
+}
+
+func truncateMessage(msg string) string {
+	if len(msg) > LogEventMaxMessage {
+		return msg[:LogEventMaxMessage] + "..."
+	}
+	return msg
+}
+
+// plainLogParser doesn't produce any events
+type plainLogParser struct{}
+
+func (p *plainLogParser) Parse(lines []string) []*LogEvent {
+	return []*LogEvent{}
+}
+
+var goTestRunRE = regexp.MustCompile(`^=== (RUN|CONT|NAME)\s+(\S+)`)
+var goTestResultRE = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([0-9.]+)s\)`)
+
+// goTestLogParser parses output of `go test -v`
+type goTestLogParser struct{}
+
+func (p *goTestLogParser) Parse(lines []string) []*LogEvent {
+	events := []*LogEvent{}
+	output := map[string][]string{}
+	current := ""
+	for _, line := range lines {
+		line = stripLogPrefix(line)
+		if isInternalLogLine(line) {
+			continue
+		}
+		if m := goTestRunRE.FindStringSubmatch(line); m != nil {
+			current = m[2]
+			continue
+		}
+		if m := goTestResultRE.FindStringSubmatch(line); m != nil {
+			event := &LogEvent{Name: m[2]}
+			seconds, err := strconv.ParseFloat(m[3], 64)
+			if err == nil {
+				event.Duration = int64(seconds * 1000)
+			}
+			switch m[1] {
+			case "PASS":
+				event.Type = LogEventTestPass
+			case "FAIL":
+				event.Type = LogEventTestFail
+				event.Message = truncateMessage(strings.Join(output[event.Name], "\n"))
+			case "SKIP":
+				event.Type = LogEventTestSkip
+			}
+			delete(output, event.Name)
+			events = append(events, event)
+			continue
+		}
+		if current != "" && strings.HasPrefix(line, "    ") {
+			output[current] = append(output[current], strings.TrimSpace(line))
+		}
+	}
+	return events
+}
+
+var pytestResultRE = regexp.MustCompile(`^(\S+::\S+) (PASSED|FAILED|SKIPPED|ERROR|XFAIL|XPASS)`)
+var pytestSummaryRE = regexp.MustCompile(`^(?:FAILED|ERROR) (\S+::\S+)(?: - (.*))?$`)
+
+// pytestLogParser parses output of `pytest -v`
+type pytestLogParser struct{}
+
+func (p *pytestLogParser) Parse(lines []string) []*LogEvent {
+	events := []*LogEvent{}
+	failed := map[string]*LogEvent{}
+	for _, line := range lines {
+		line = stripLogPrefix(line)
+		if isInternalLogLine(line) {
+			continue
+		}
+		if m := pytestResultRE.FindStringSubmatch(line); m != nil {
+			event := &LogEvent{Name: m[1]}
+			switch m[2] {
+			case "PASSED", "XFAIL":
+				event.Type = LogEventTestPass
+			case "FAILED", "ERROR", "XPASS":
+				event.Type = LogEventTestFail
+				failed[event.Name] = event
+			case "SKIPPED":
+				event.Type = LogEventTestSkip
+			}
+			events = append(events, event)
+			continue
+		}
+		// Short test summary contains the reason of failures
+		if m := pytestSummaryRE.FindStringSubmatch(line); m != nil {
+			if event, ok := failed[m[1]]; ok {
+				event.Message = truncateMessage(m[2])
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGoTestLogParser(t *testing.T) {
+	lines := []string{
+		"[      10ms] > Running command: go test -v ./...",
+		"[     1.2s] === RUN   TestFoo",
+		"[     1.2s] --- PASS: TestFoo (0.01s)",
+		"[     1.2s] === RUN   TestBar",
+		"[     1.2s]     bar_test.go:12: expected 1, got 2",
+		"[     1.2s] --- FAIL: TestBar (0.00s)",
+		"[     1.2s] === RUN   TestBaz",
+		"[     1.2s]     baz_test.go:7: not supported",
+		"[     1.2s] --- SKIP: TestBaz (0.00s)",
+		"[     1.3s] FAIL",
+	}
+	parser := DetectLogParser(lines)
+	if _, ok := parser.(*goTestLogParser); !ok {
+		t.Errorf("Expected go-test parser, got %T", parser)
+		return
+	}
+	events := parser.Parse(lines)
+	expected := []LogEvent{
+		{Type: LogEventTestPass, Name: "TestFoo", Duration: 10},
+		{Type: LogEventTestFail, Name: "TestBar", Message: "bar_test.go:12: expected 1, got 2"},
+		{Type: LogEventTestSkip, Name: "TestBaz"},
+	}
+	if len(events) != len(expected) {
+		t.Errorf("Expected %d events, got %d", len(expected), len(events))
+		return
+	}
+	for i := range expected {
+		if *events[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *events[i])
+		}
+	}
+}
+
+func TestPytestLogParser(t *testing.T) {
+	lines := []string{
+		"tests/test_a.py::test_one PASSED        [ 50%]",
+		"tests/test_a.py::test_two FAILED        [100%]",
+		"FAILED tests/test_a.py::test_two - assert 1 == 2",
+	}
+	events := DetectLogParser(lines).Parse(lines)
+	if len(events) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(events))
+		return
+	}
+	if events[1].Type != LogEventTestFail || events[1].Message != "assert 1 == 2" {
+		t.Errorf("Unexpected event %+v", *events[1])
+	}
+}
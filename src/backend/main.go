@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"embed"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -41,24 +42,44 @@ var Config *WakeConfig
 // WSHub is the websocket hub
 var WSHub *Hub
 
+// GlobalUsage is a global usage tracker object
+var GlobalUsage *UsageTracker
+
 //go:embed assets/*
 var Assets embed.FS
 
 //go:embed docs/swagger.json
 var APIDocs embed.FS
 
+// rotateSecretsKey is set by --rotate-secrets-key flag
+var rotateSecretsKey bool
+
 func initApp() {
 	Logger = log.New(os.Stdout, "", log.Lmicroseconds|log.Lshortfile)
 
 	configFlag := flag.String("config", "Wakefile.yaml", "Configuration file location")
 	compactDBFlag := flag.Bool("compactdb", false, "Reclaim space in the database which is no longer used")
+	flag.BoolVar(&rotateSecretsKey, "rotate-secrets-key", false, "Generate a new secrets key and re-encrypt all stored sensitive values with it")
+	internalAddrFlag := flag.String("internal-addr", "", "Serve health check, metrics and pprof on this address instead of the main port, overrides internaladdr")
 	flag.Parse()
 
+	if flag.Arg(0) == GenerateAPIKeyCommand {
+		key, hash, err := GenerateAPIKey()
+		if err != nil {
+			Logger.Fatal(err)
+		}
+		fmt.Printf("API key: %s\nSHA-256 hash for apikeys: %s\n", key, hash)
+		os.Exit(0)
+	}
+
 	var err error
 	Config, err = CreateWakeConfig(*configFlag)
 	if err != nil {
 		Logger.Fatal(err)
 	}
+	if *internalAddrFlag != "" {
+		Config.InternalAddr = *internalAddrFlag
+	}
 
 	if *compactDBFlag {
 		err = CompactDB()
@@ -124,6 +145,57 @@ func main() {
 			return err
 		}
 
+		_, err = tx.CreateBucketIfNotExists(TransitionsBucket)
+		if err != nil {
+			return err
+		}
+		err = BackfillBuildTransitions(tx)
+		if err != nil {
+			return err
+		}
+		err = RecountArtifactStorage(tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(UsageBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(SecretsBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(JobCountersBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(ParamSuggestionsBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(MaintenanceBucket)
+		if err != nil {
+			return err
+		}
+
+		lb, err := tx.CreateBucketIfNotExists(LogIndexBucket)
+		if err != nil {
+			return err
+		}
+		_, err = lb.CreateBucketIfNotExists(LogIndexTermsKey)
+		if err != nil {
+			return err
+		}
+		_, err = lb.CreateBucketIfNotExists(LogIndexBuildsKey)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 
@@ -131,8 +203,32 @@ func main() {
 		Logger.Fatal(err)
 	}
 
+	Keyring, err = LoadSecretsKeyring(Config.SecretsKeyFile, os.Getenv(SecretsKeyEnv))
+	if err != nil {
+		Logger.Fatal(err)
+	}
+	err = VerifySecretsKeyring()
+	if err != nil {
+		Logger.Fatal(err)
+	}
+	if rotateSecretsKey {
+		id, err := RotateSecretsKey(Config.SecretsKeyFile)
+		if err != nil {
+			Logger.Fatal(err)
+		}
+		Logger.Printf("Secrets key %s is active now\n", id)
+		os.Exit(0)
+	}
+
 	GlobalSessionStorage = CreateSessionStorage(SessionCleanupPeriod)
 
+	GlobalUsage = CreateUsageTracker(UsageFlushPeriod)
+
+	GlobalExporter, err = CreateHistoryExporter(Config.SQLiteExport, HistoryExportSyncPeriod)
+	if err != nil {
+		Logger.Fatal(err)
+	}
+
 	GlobalQueue, err = CreateQueue()
 	if err != nil {
 		Logger.Fatal(err)
@@ -141,6 +237,8 @@ func main() {
 	GlobalCron = cron.New()
 	GlobalCron.Start()
 
+	HandleShutdownSignals()
+
 	err = os.MkdirAll(Config.JobDir, os.ModePerm)
 	if err != nil {
 		Logger.Fatal(err)
@@ -151,6 +249,9 @@ func main() {
 	CleanupJobsBucket()
 	ScanAllJobs()
 	CleanupOldBuilds(BuildCleanupPeriod)
+	CleanupExpiredArtifacts(Config.getArtifactRetentionInterval())
+	WatchMaintenanceWindows(MaintenanceCheckPeriod)
+	CleanupExpiredNotices(NoticeCleanupPeriod)
 
 	WSHub = newHub()
 	go WSHub.run()
@@ -181,31 +282,107 @@ func main() {
 		router.Route("/jobs", func(router chi.Router) {
 			router.Get("/", HandleJobsView)
 			router.Post("/create", HandleJobsCreate)
+			router.Get("/{name}/schedule", HandleJobSchedule)
 		})
 
 		router.Route("/job", func(router chi.Router) {
 			router.Post("/{name}/run", HandleRunJob)
+			router.Post("/{name}/trigger", HandleTriggerBuild)
+			router.Post("/{name}/abort-pending", HandleAbortPendingJob)
 			router.Delete("/{name}", HandleDeleteJob)
 			router.Post("/{name}", HandleJobPost)
 			router.Get("/{name}", HandleJobGet)
+			router.Get("/{name}/context", HandleJobContext)
 			router.Post("/{name}/set_active", HandleJobSetActive)
+			router.Put("/{name}/notice", HandleJobNoticePut)
+			router.Get("/{name}/builds/{number}", HandleGetJobBuild)
+			router.Get("/{name}/param-impact", HandleGetBuildParamImpact)
+			router.Get("/{name}/params/{param}/suggestions", HandleGetParamSuggestions)
+		})
+
+		router.Route("/builds", func(router chi.Router) {
+			router.Get("/", HandleListBuilds)
+			router.Get("/log-diff", HandleGetBuildLogDiff)
+			router.Get("/grouped", HandleGetBuildsGrouped)
+			router.Get("/log-search", HandleGetBuildLogSearchAll)
+		})
+
+		router.Route("/matrix", func(router chi.Router) {
+			router.Get("/{id}", HandleGetMatrixBuild)
+			router.Post("/{id}/abort", HandleAbortMatrixBuild)
+		})
+
+		router.Route("/compare", func(router chi.Router) {
+			router.Get("/logs", HandleGetCompareLogs)
 		})
 
 		router.Route("/build", func(router chi.Router) {
 			router.Get("/{id}", HandleGetBuild)
+			router.Get("/{id}/transitions", HandleGetBuildTransitions)
 			router.Post("/{id}/abort", HandleAbortBuild)
+			router.Post("/{id}/task/{taskID}/abort", HandleAbortTask)
+			router.Post("/{id}/task/{taskID}/skip", HandleSkipTask)
+			router.Get("/{id}/task/{taskID}/log", HandleGetTaskLog)
 			router.Post("/{id}/flush", HandleFlushTaskLogs)
 			router.Post("/{id}/start", HandleStartBuild)
+			router.Get("/{id}/parallel-efficiency", HandleGetBuildParallelEfficiency)
+			router.Get("/{id}/critical-path", HandleGetBuildCriticalPath)
+			router.Get("/{id}/workflow", HandleGetBuildWorkflow)
+			router.Get("/{id}/log/parsed", HandleGetBuildLogParser)
+			router.Get("/{id}/log/links", HandleGetBuildLogLinks)
 		})
 
+		router.Get("/groups/", HandleGetJobGroups)
+		router.Post("/group/{name}/run", HandleRunJobGroup)
+
+		router.Get("/stats", HandleStats)
+		router.Get("/stats/usage", HandleUsageStats)
+		router.Get("/queue", HandleQueueStatus)
+		router.Post("/queue/pause", HandleQueuePause)
+		router.Post("/queue/resume", HandleQueueResume)
+
 		router.Get("/settings", HandleSettingsGet)
 		router.Post("/settings", HandleSettingsPost)
+
+		router.Route("/admin", func(router chi.Router) {
+			router.Get("/maintenance-windows", HandleGetMaintenanceWindows)
+			router.Post("/maintenance-windows", HandleSaveMaintenanceWindow)
+			router.Put("/maintenance-windows/{id}", HandleSaveMaintenanceWindow)
+			router.Delete("/maintenance-windows/{id}", HandleDeleteMaintenanceWindow)
+			router.Post("/retention", HandleApplyBuildRetention)
+		})
 	})
 
+	// Artifacts can be also downloaded by tasks with WAKE_BUILD_TOKEN, so the
+	// routes aren't behind AuthMi of /api
+	router.With(ArtifactAuthMi).Get("/api/build/{id}/artifacts.zip", HandleDownloadArtifacts)
+	router.With(ArtifactAuthMi).Get("/api/build/{id}/artifacts/*", HandleDownloadArtifact)
+
+	badgeRoutes(router)
+
+	// Public status page for monitoring displays
+	router.Get("/status", HandleGetBuildStatusPage)
+
+	// Authenticated by the signature or by AuthMi if the job has no secret
+	router.Post("/webhook/{name}", HandleWebhook)
+
+	if Config.InternalAddr == "" {
+		internalRoutes(router, AuthMi)
+	} else {
+		internalRouter := chi.NewRouter()
+		internalRouter.Use(LogMi)
+		internalRoutes(internalRouter)
+		internalServer := HTTPServers.Add(&http.Server{Addr: Config.InternalAddr, Handler: internalRouter})
+		go func() {
+			Logger.Printf("Internal endpoints are listening on %s...\n", Config.InternalAddr)
+			serveUntilShutdown(internalServer.ListenAndServe)
+		}()
+	}
+
 	router.Route("/storage", func(router chi.Router) {
 		// Storage server
 		router.Use(StorageSecurityMi)
-		router.Use(AuthMi)
+		router.Use(StorageAuthMi)
 		storageServer := http.FileServer(http.Dir(Config.WorkDir + "wakespace"))
 		router.Method("GET", "/build/*", HandleWakespaceResource(storageServer))
 		router.Method("HEAD", "/build/*", HandleWakespaceResource(storageServer))
@@ -226,16 +403,14 @@ func main() {
 	}
 
 	if Config.Port == "443" {
+		redirectServer := HTTPServers.Add(&http.Server{Addr: ":80", Handler: certManager.HTTPHandler(nil)})
 		go func() {
 			Logger.Println("Listening on port 80...")
-			err := http.ListenAndServe(":80", certManager.HTTPHandler(nil))
-			if err != nil {
-				Logger.Fatal(err)
-			}
+			serveUntilShutdown(redirectServer.ListenAndServe)
 		}()
 
 		Logger.Println("Listening on port 443...")
-		server := &http.Server{
+		server := HTTPServers.Add(&http.Server{
 			Addr: ":443",
 			TLSConfig: &tls.Config{
 				// https://ssl-config.mozilla.org/#server=golang&version=1.13.6&config=intermediate&guideline=5.4
@@ -252,17 +427,14 @@ func main() {
 				GetCertificate: certManager.GetCertificate,
 			},
 			Handler: compress(router),
-		}
+		})
 
-		err = server.ListenAndServeTLS("", "")
-		if err != nil {
-			Logger.Fatal(err)
-		}
+		serveUntilShutdown(func() error {
+			return server.ListenAndServeTLS("", "")
+		})
 	} else {
 		Logger.Printf("Listening on port %s...\n", Config.Port)
-		err := http.ListenAndServe(":"+Config.Port, compress(router))
-		if err != nil {
-			Logger.Fatal(err)
-		}
+		server := HTTPServers.Add(&http.Server{Addr: ":" + Config.Port, Handler: compress(router)})
+		serveUntilShutdown(server.ListenAndServe)
 	}
 }
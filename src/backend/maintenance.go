@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Recurrence of maintenance windows
+const (
+	MaintenanceOnce   = ""
+	MaintenanceDaily  = "daily"
+	MaintenanceWeekly = "weekly"
+)
+
+// MaintenanceCheckPeriod is how often held builds are checked after
+// maintenance windows end
+const MaintenanceCheckPeriod = 30 * time.Second
+
+// MaintenanceWindow is a period of time when builds of pausable jobs are not
+// started: cron doesn't fire and other builds wait in the queue
+type MaintenanceWindow struct {
+	ID    int       `json:"id"`
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Repeat the window: daily, weekly or empty for one-off windows
+	Recurrence string `json:"recurrence,omitempty"`
+}
+
+// MaintenanceWindowData is a maintenance window with its current or next
+// occurrence
+type MaintenanceWindowData struct {
+	*MaintenanceWindow
+	Active bool `json:"active"`
+	// One-off window which has ended, NextStart and NextEnd are the last
+	// occurrence
+	Over      bool      `json:"over"`
+	NextStart time.Time `json:"next_start"`
+	NextEnd   time.Time `json:"next_end"`
+}
+
+// period returns the interval between occurrences, 0 for one-off windows
+func (m *MaintenanceWindow) period() time.Duration {
+	switch m.Recurrence {
+	case MaintenanceDaily:
+		return 24 * time.Hour
+	case MaintenanceWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// verify validates the window before saving
+func (m *MaintenanceWindow) verify() error {
+	if m.Name == "" {
+		return fmt.Errorf("name of the maintenance window can't be empty")
+	}
+	if !m.End.After(m.Start) {
+		return fmt.Errorf("end of the maintenance window has to be after the start")
+	}
+	switch m.Recurrence {
+	case MaintenanceOnce, MaintenanceDaily, MaintenanceWeekly:
+	default:
+		return fmt.Errorf("invalid recurrence of the maintenance window: %s", m.Recurrence)
+	}
+	if period := m.period(); period > 0 && m.End.Sub(m.Start) >= period {
+		return fmt.Errorf("%s maintenance window has to be shorter than %s", m.Recurrence, period)
+	}
+	return nil
+}
+
+// occurrence returns the occurrence of the window which is active at t or the
+// next one. False if the window is over
+func (m *MaintenanceWindow) occurrence(t time.Time) (time.Time, time.Time, bool) {
+	period := m.period()
+	if period == 0 || t.Before(m.Start) {
+		return m.Start, m.End, t.Before(m.End)
+	}
+	start := m.Start.Add(t.Sub(m.Start) / period * period)
+	end := start.Add(m.End.Sub(m.Start))
+	if !t.Before(end) {
+		start = start.Add(period)
+		end = end.Add(period)
+	}
+	return start, end, true
+}
+
+// GetMaintenanceWindows returns all maintenance windows
+func GetMaintenanceWindows() ([]*MaintenanceWindow, error) {
+	windows := make([]*MaintenanceWindow, 0)
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(MaintenanceBucket).ForEach(func(k, v []byte) error {
+			var window MaintenanceWindow
+			err := json.Unmarshal(v, &window)
+			if err != nil {
+				return err
+			}
+			windows = append(windows, &window)
+			return nil
+		})
+	})
+	return windows, err
+}
+
+// GetMaintenanceWindowsData returns windows with their current or next
+// occurrence at t, the nearest first. Windows which are over are the last
+func GetMaintenanceWindowsData(t time.Time) ([]*MaintenanceWindowData, error) {
+	windows, err := GetMaintenanceWindows()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*MaintenanceWindowData, 0)
+	for _, window := range windows {
+		start, end, ok := window.occurrence(t)
+		result = append(result, &MaintenanceWindowData{
+			MaintenanceWindow: window,
+			Active:            ok && !t.Before(start),
+			Over:              !ok,
+			NextStart:         start,
+			NextEnd:           end,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Over != result[j].Over {
+			return !result[i].Over
+		}
+		return result[i].NextStart.Before(result[j].NextStart)
+	})
+	return result, nil
+}
+
+// SaveMaintenanceWindow creates the window if its ID is 0, otherwise updates
+// the existing one
+func SaveMaintenanceWindow(window *MaintenanceWindow) error {
+	err := window.verify()
+	if err != nil {
+		return err
+	}
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MaintenanceBucket)
+		if window.ID == 0 {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			window.ID = int(id)
+		} else if b.Get(Itob(window.ID)) == nil {
+			return fmt.Errorf("maintenance window %d is not found", window.ID)
+		}
+		data, err := json.Marshal(window)
+		if err != nil {
+			return err
+		}
+		return b.Put(Itob(window.ID), data)
+	})
+}
+
+// DeleteMaintenanceWindow removes the window
+func DeleteMaintenanceWindow(id int) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MaintenanceBucket)
+		if b.Get(Itob(id)) == nil {
+			return fmt.Errorf("maintenance window %d is not found", id)
+		}
+		return b.Delete(Itob(id))
+	})
+}
+
+// activeMaintenanceWindow returns the window which is active at t and ends
+// last or nil
+func activeMaintenanceWindow(t time.Time) (*MaintenanceWindowData, error) {
+	data, err := GetMaintenanceWindowsData(t)
+	if err != nil {
+		return nil, err
+	}
+	var result *MaintenanceWindowData
+	for _, window := range data {
+		if window.Active && (result == nil || window.NextEnd.After(result.NextEnd)) {
+			result = window
+		}
+	}
+	return result, nil
+}
+
+// isMaintenanceActive returns true if a maintenance window is active now
+func isMaintenanceActive() bool {
+	window, err := activeMaintenanceWindow(time.Now())
+	if err != nil {
+		Logger.Println(err)
+		return false
+	}
+	return window != nil
+}
+
+// checkMaintenanceOnTake holds builds of pausable jobs in the queue while a
+// maintenance window is active
+func (b *Build) checkMaintenanceOnTake() bool {
+	if !b.Job.Pausable {
+		return true
+	}
+	window, err := activeMaintenanceWindow(time.Now())
+	if err != nil {
+		b.Logger.Println(err)
+		return true
+	}
+	reason := ""
+	if window != nil {
+		reason = fmt.Sprintf(
+			"Paused by maintenance window %s until %s", window.Name, window.NextEnd.Format(time.RFC3339),
+		)
+	}
+	b.mutex.Lock()
+	if window == nil && !b.heldByMaintenance {
+		// Keep the reason set by other checks
+		b.mutex.Unlock()
+		return true
+	}
+	changed := b.PendingReason != reason
+	b.PendingReason = reason
+	b.heldByMaintenance = window != nil
+	b.mutex.Unlock()
+	if changed {
+		b.Logger.Println(reason)
+		go b.BroadcastUpdate()
+	}
+	return window == nil
+}
+
+// isHeldByMaintenance returns true if the build waits for the end of a
+// maintenance window
+func (b *Build) isHeldByMaintenance() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.heldByMaintenance
+}
+
+// WatchMaintenanceWindows periodically starts builds held by maintenance
+// windows which are over
+func WatchMaintenanceWindows(d time.Duration) {
+	ticker := time.NewTicker(d)
+	go func() {
+		for range ticker.C {
+			if GlobalQueue.HasHeldByMaintenance() {
+				GlobalQueue.Take()
+			}
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow_Occurrence(t *testing.T) {
+	start := time.Date(2024, 1, 6, 22, 0, 0, 0, time.UTC) // Saturday
+	window := &MaintenanceWindow{Name: "weekend", Start: start, End: start.Add(4 * time.Hour), Recurrence: MaintenanceWeekly}
+	cases := []struct {
+		t      time.Time
+		start  time.Time
+		active bool
+	}{
+		{start.Add(-time.Hour), start, false},
+		{start.Add(time.Hour), start, true},
+		{start.Add(5 * time.Hour), start.Add(7 * 24 * time.Hour), false},
+		{start.Add(14*24*time.Hour + time.Hour), start.Add(14 * 24 * time.Hour), true},
+	}
+	for _, c := range cases {
+		s, e, ok := window.occurrence(c.t)
+		if !ok || !s.Equal(c.start) || !e.Equal(c.start.Add(4*time.Hour)) {
+			t.Errorf("%s: expected occurrence at %s, got %s - %s (%v)", c.t, c.start, s, e, ok)
+		}
+		if active := !c.t.Before(s); active != c.active {
+			t.Errorf("%s: expected active %v", c.t, c.active)
+		}
+	}
+
+	window.Recurrence = MaintenanceOnce
+	if _, _, ok := window.occurrence(start.Add(5 * time.Hour)); ok {
+		t.Error("Expected one-off window to be over")
+	}
+}
+
+func TestMaintenanceWindow_Verify(t *testing.T) {
+	start := time.Now()
+	invalid := []*MaintenanceWindow{
+		{Start: start, End: start.Add(time.Hour)},
+		{Name: "backwards", Start: start, End: start.Add(-time.Hour)},
+		{Name: "monthly", Start: start, End: start.Add(time.Hour), Recurrence: "monthly"},
+		{Name: "long", Start: start, End: start.Add(25 * time.Hour), Recurrence: MaintenanceDaily},
+	}
+	for _, window := range invalid {
+		if window.verify() == nil {
+			t.Errorf("Expected %+v to be invalid", window)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// MaxMatrixBuilds limits number of builds created from the job's matrix
+const MaxMatrixBuilds = 64
+
+// matrixCombinations returns every combination of matrix values. Keys are
+// iterated in alphabetical order and values in the order they are listed, so
+// combinations are the same on every run. Returns nil if the job has no
+// matrix
+func (j *Job) matrixCombinations() []map[string]string {
+	if len(j.Matrix) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(j.Matrix))
+	for key := range j.Matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		var expanded []map[string]string
+		for _, combination := range combinations {
+			for _, value := range j.Matrix[key] {
+				next := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					next[k] = v
+				}
+				next[key] = value
+				expanded = append(expanded, next)
+			}
+		}
+		combinations = expanded
+	}
+	return combinations
+}
+
+// Used to verify matrix before saving after editing
+func (j *Job) verifyMatrix() error {
+	total := 1
+	for key, values := range j.Matrix {
+		if len(values) == 0 {
+			return fmt.Errorf("matrix key %s has no values", key)
+		}
+		total *= len(values)
+		if total > MaxMatrixBuilds {
+			return fmt.Errorf("matrix produces more than %d builds", MaxMatrixBuilds)
+		}
+	}
+	return nil
+}
+
+// applyMatrix sets values of the matrix combination to params of the build.
+// Params which are not declared by the job are added
+func (b *Build) applyMatrix(combination map[string]string) {
+	b.Matrix = combination
+	keys := make([]string, 0, len(combination))
+	for key := range combination {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := combination[key]
+		declared := false
+		for idx := range b.Params {
+			if _, ok := b.Params[idx][key]; ok {
+				b.Params[idx][key] = value
+				declared = true
+			}
+		}
+		if !declared {
+			b.Params = append(b.Params, map[string]string{key: value})
+		}
+		b.Logger.Printf("Updating key %s to %s from matrix", key, value)
+	}
+}
+
+// AbortMatrixBuild schedules all queued and running builds of the matrix to
+// be aborted. Returns IDs of the builds
+func (q *Queue) AbortMatrixBuild(matrixBuildID int, reason string) ([]int, error) {
+	var ids []int
+	q.mutex.Lock()
+	for _, list := range [][]*Build{q.running, q.queued} {
+		for _, b := range list {
+			if b.MatrixBuildID == matrixBuildID {
+				ids = append(ids, b.ID)
+			}
+		}
+	}
+	q.mutex.Unlock()
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no builds of matrix build %d in Q", matrixBuildID)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		// The build might be completed in the meantime
+		err := q.Abort(id, reason)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+	return ids, nil
+}
+
+// GetMatrixBuild returns builds of the matrix with the aggregate status. The
+// matrix is running while any of its builds is not completed
+func GetMatrixBuild(matrixBuildID int) (*BuildGroupData, error) {
+	data := &BuildGroupData{
+		Label:  "matrix_build_id",
+		Value:  strconv.Itoa(matrixBuildID),
+		Builds: []*BuildUpdateData{},
+	}
+	err := DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(HistoryBucket).Cursor()
+		// Builds of the matrix are created after the first one
+		for key, v := c.Seek(Itob(matrixBuildID)); key != nil; key, v = c.Next() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			if msg.MatrixBuildID == matrixBuildID {
+				data.Builds = append(data.Builds, &msg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Builds) == 0 {
+		return nil, fmt.Errorf("matrix build %d is not found", matrixBuildID)
+	}
+	statuses := make([]ItemStatus, len(data.Builds))
+	for i, b := range data.Builds {
+		statuses[i] = b.Status
+	}
+	data.Status = AggregateStatus(statuses)
+	return data, nil
+}
+
+// HandleGetMatrixBuild returns builds of the matrix
+// @Summary      Return builds of the matrix
+// @Description  Builds created from the same run of the job's `matrix` with the aggregate status. The matrix is finished only when all builds are finished
+// @Tags         builds
+// @Produce      json
+// @Param        id    path    integer   true  "Matrix build ID, see `matrix_build_id` of builds"
+// @Success      200   {object}   BuildGroupData
+// @Failure      400   {string}   string
+// @Failure      404   {string}   string
+// @Failure      500   {string}   string
+// @Router       /matrix/{id} [get]
+func HandleGetMatrixBuild(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	data, err := GetMatrixBuild(id)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(data)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
+
+// HandleAbortMatrixBuild aborts all builds of the matrix
+// @Summary      Abort all builds of the matrix
+// @Description  Aborts queued and running builds created from the same run of the job's `matrix`. Returns IDs of aborted builds
+// @Tags         builds
+// @Produce      json
+// @Param        id    path    integer   true  "Matrix build ID, see `matrix_build_id` of builds"
+// @Success      200   {array}    integer
+// @Failure      400   {string}   string
+// @Failure      404   {string}   string
+// @Failure      500   {string}   string
+// @Router       /matrix/{id}/abort [post]
+func HandleAbortMatrixBuild(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ids, err := GlobalQueue.AbortMatrixBuild(id, StatusAborted)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(ids)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMatrixCombinations(t *testing.T) {
+	job := &Job{Matrix: map[string][]string{
+		"OS":         {"linux", "darwin"},
+		"GO_VERSION": {"1.21", "1.22"},
+	}}
+	expected := []map[string]string{
+		{"GO_VERSION": "1.21", "OS": "linux"},
+		{"GO_VERSION": "1.21", "OS": "darwin"},
+		{"GO_VERSION": "1.22", "OS": "linux"},
+		{"GO_VERSION": "1.22", "OS": "darwin"},
+	}
+	result := job.matrixCombinations()
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+	if (&Job{}).matrixCombinations() != nil {
+		t.Error("Expected no combinations without matrix")
+	}
+}
+
+func TestVerifyMatrix(t *testing.T) {
+	job := &Job{Matrix: map[string][]string{"OS": {}}}
+	if job.verifyMatrix() == nil {
+		t.Error("Expected error for a key without values")
+	}
+	values := make([]string, 9)
+	job = &Job{Matrix: map[string][]string{"A": values, "B": values}}
+	if job.verifyMatrix() == nil {
+		t.Errorf("Expected error for more than %d combinations", MaxMatrixBuilds)
+	}
+}
+
+func TestRunJobMatrix(t *testing.T) {
+	setupTestEnv(t)
+	content := `
+params:
+  - GO_VERSION: "1.20"
+  - TARGET: dev
+matrix:
+  GO_VERSION: [1.21, 1.22]
+  OS: [linux]
+tasks:
+  - run: sleep 30
+`
+	err := os.WriteFile(Config.JobDir+"matrix.yaml", []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("matrix"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builds, err := RunJobMatrix("matrix", url.Values{"TARGET": {"prod"}}, TriggerManual, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 2 {
+		t.Fatalf("Expected 2 builds, got %d", len(builds))
+	}
+	for i, version := range []string{"1.21", "1.22"} {
+		data := builds[i].GenerateBuildUpdateData()
+		expected := []map[string]string{{"GO_VERSION": version}, {"TARGET": "prod"}, {"OS": "linux"}}
+		if !reflect.DeepEqual(data.Params, expected) {
+			t.Errorf("Expected params %v of build %d, got %v", expected, i, data.Params)
+		}
+		if data.MatrixBuildID != builds[0].ID {
+			t.Errorf("Expected build %d of matrix build %d, got %d", i, builds[0].ID, data.MatrixBuildID)
+		}
+	}
+
+	ids, err := GlobalQueue.AbortMatrixBuild(builds[0].ID, StatusAborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected 2 aborted builds, got %v", ids)
+	}
+	for _, build := range builds {
+		waitForTerminalState(t, build, 10*time.Second, StatusAborted)
+	}
+	group, err := GetMatrixBuild(builds[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Builds) != 2 || group.Status != StatusAborted {
+		t.Errorf("Expected 2 aborted builds of the matrix, got %s %d", group.Status, len(group.Builds))
+	}
+
+	router := chi.NewRouter()
+	router.Get("/matrix/{id}", HandleGetMatrixBuild)
+	for path, expected := range map[string]int{
+		fmt.Sprintf("/matrix/%d", builds[0].ID): http.StatusOK,
+		"/matrix/999":                           http.StatusNotFound,
+		"/matrix/abc":                           http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected %d, got %d %s", path, expected, w.Code, w.Body.String())
+		}
+	}
+	if _, err := GetMatrixBuild(999); err == nil {
+		t.Error("Expected error for unknown matrix build")
+	}
+	if _, err := GlobalQueue.AbortMatrixBuild(builds[0].ID, StatusAborted); err == nil {
+		t.Error("Expected error when no builds of the matrix are in the queue")
+	}
+}
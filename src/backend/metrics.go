@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// BuildDurationBuckets are upper bounds of wakeci_build_duration_seconds, s
+var BuildDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+var (
+	metricsQueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "wakeci_queue_depth",
+		Help: "Number of builds waiting in the queue",
+	}, func() float64 {
+		if GlobalQueue == nil {
+			return 0
+		}
+		_, queued := GlobalQueue.Count()
+		return float64(queued)
+	})
+	metricsRunningBuilds = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "wakeci_running_builds",
+		Help: "Number of running builds",
+	}, func() float64 {
+		if GlobalQueue == nil {
+			return 0
+		}
+		running, _ := GlobalQueue.Count()
+		return float64(running)
+	})
+	metricsBuildsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wakeci_builds_total",
+		Help: "Number of completed builds by status",
+	}, []string{"status"})
+	metricsBuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wakeci_build_duration_seconds",
+		Help:    "Duration of completed builds which have been started",
+		Buckets: BuildDurationBuckets,
+	}, []string{"job"})
+	metricsOpenTaskLogs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "wakeci_open_task_logs",
+		Help: "Number of task log files open for writing",
+	})
+	metricsTaskProcesses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "wakeci_task_processes",
+		Help: "Number of running task commands",
+	})
+	metricsHandler = promhttp.Handler()
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricsQueueDepth, metricsRunningBuilds, metricsBuildsTotal, metricsBuildDuration,
+		metricsOpenTaskLogs, metricsTaskProcesses,
+	)
+}
+
+// recordMetrics counts the completed build and its duration. Builds which
+// were aborted in the queue have no duration
+func (b *Build) recordMetrics(status ItemStatus) {
+	metricsBuildsTotal.WithLabelValues(string(status)).Inc()
+	if !b.StartedAt.IsZero() {
+		metricsBuildDuration.WithLabelValues(b.Job.Name).Observe(b.Duration.Seconds())
+	}
+}
+
+// HandleMetrics returns metrics in Prometheus text format
+// @Summary      Prometheus metrics
+// @Description  Queue depth, number of running builds, completed builds by status and build durations by job, open task logs and running task commands. Served by the internal listener if `internaladdr` is configured
+// @Tags         internal
+// @Produce      plain
+// @Success      200      {string}   string
+// @Router       /metrics [get]
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeMetric returns the line of the metric with the labels
+func scrapeMetric(t *testing.T, metric string) string {
+	w := httptest.NewRecorder()
+	HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d", w.Code)
+	}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, metric+" ") {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestMetrics(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:  "metrics",
+		Tasks: []*Task{{Name: "sleep", Command: "sleep 0.2", Kind: KindMain}},
+	}
+	build := createTestBuild(t, job)
+
+	waitFor(t, 5*time.Second, "the build is running", func() bool {
+		return scrapeMetric(t, "wakeci_running_builds") == "wakeci_running_builds 1"
+	})
+	waitFor(t, 5*time.Second, "the task is running", func() bool {
+		return scrapeMetric(t, "wakeci_task_processes") == "wakeci_task_processes 1"
+	})
+	if line := scrapeMetric(t, "wakeci_open_task_logs"); line != "wakeci_open_task_logs 1" {
+		t.Errorf("Expected the task log to be open, got %q", line)
+	}
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	if line := scrapeMetric(t, "wakeci_running_builds"); line != "wakeci_running_builds 0" {
+		t.Errorf("Expected no running builds, got %q", line)
+	}
+	for _, line := range []string{"wakeci_task_processes 0", "wakeci_open_task_logs 0"} {
+		if metric := strings.Fields(line)[0]; scrapeMetric(t, metric) != line {
+			t.Errorf("Expected %q, got %q", line, scrapeMetric(t, metric))
+		}
+	}
+	if line := scrapeMetric(t, "wakeci_queue_depth"); line != "wakeci_queue_depth 0" {
+		t.Errorf("Expected empty queue, got %q", line)
+	}
+	if line := scrapeMetric(t, `wakeci_builds_total{status="finished"}`); line == "" {
+		t.Error("Expected finished builds to be counted")
+	}
+	if line := scrapeMetric(t, `wakeci_build_duration_seconds_count{job="metrics"}`); line != `wakeci_build_duration_seconds_count{job="metrics"} 1` {
+		t.Errorf("Expected duration of the build, got %q", line)
+	}
+}
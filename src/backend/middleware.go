@@ -105,6 +105,19 @@ func AuthMi(next http.Handler) http.Handler {
 			logger = Logger
 		}
 
+		// API keys
+		if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if !isValidAPIKey(key) {
+				logger.Println("Invalid API key")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("Unauthorized"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Basic auth for API calls
 		_, password, ok := r.BasicAuth()
 		if ok {
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Severities of job notices
+const (
+	NoticeInfo     = "info"
+	NoticeWarning  = "warning"
+	NoticeCritical = "critical"
+)
+
+// NoticeCleanupPeriod is how often expired job notices are removed
+const NoticeCleanupPeriod = time.Minute
+
+// AuditLogger records changes made by operators
+var AuditLogger = log.New(os.Stdout, "[audit] ", log.Lmicroseconds|log.Lshortfile)
+
+// JobNotice is a note pinned to the job by an operator, e.g. a known issue
+type JobNotice struct {
+	Text     string `json:"text"`
+	Severity string `json:"severity"`
+	// The notice is removed after the time, nil if it doesn't expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Author    string     `json:"author"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// verify validates the notice before saving
+func (n *JobNotice) verify() error {
+	if n.Text == "" {
+		return fmt.Errorf("text of the notice can't be empty")
+	}
+	switch n.Severity {
+	case NoticeInfo, NoticeWarning, NoticeCritical:
+	default:
+		return fmt.Errorf("invalid severity of the notice %q: expected %s, %s or %s", n.Severity, NoticeInfo, NoticeWarning, NoticeCritical)
+	}
+	if n.ExpiresAt != nil && !n.ExpiresAt.After(n.CreatedAt) {
+		return fmt.Errorf("the notice has to expire in the future")
+	}
+	return nil
+}
+
+// isActive returns false if the notice has expired at t
+func (n *JobNotice) isActive(t time.Time) bool {
+	return n.ExpiresAt == nil || t.Before(*n.ExpiresAt)
+}
+
+// getJobNotice returns the active notice of the job from its bucket or nil
+func getJobNotice(jb *bolt.Bucket) (*JobNotice, error) {
+	data := jb.Get([]byte("notice"))
+	if data == nil {
+		return nil, nil
+	}
+	var notice JobNotice
+	err := json.Unmarshal(data, &notice)
+	if err != nil {
+		return nil, err
+	}
+	if !notice.isActive(time.Now()) {
+		return nil, nil
+	}
+	return &notice, nil
+}
+
+// GetJobNotice returns the active notice of the job or nil
+func GetJobNotice(name string) (*JobNotice, error) {
+	var notice *JobNotice
+	err := DB.View(func(tx *bolt.Tx) error {
+		jb := tx.Bucket(JobsBucket).Bucket([]byte(name))
+		if jb == nil {
+			return fmt.Errorf("invalid job name: %s", name)
+		}
+		var err error
+		notice, err = getJobNotice(jb)
+		return err
+	})
+	return notice, err
+}
+
+// SaveJobNotice pins the notice to the job. A nil notice removes the current
+// one
+func SaveJobNotice(name string, notice *JobNotice) error {
+	if notice != nil {
+		err := notice.verify()
+		if err != nil {
+			return err
+		}
+	}
+	return DB.Update(func(tx *bolt.Tx) error {
+		jb := tx.Bucket(JobsBucket).Bucket([]byte(name))
+		if jb == nil {
+			return fmt.Errorf("invalid job name: %s", name)
+		}
+		if notice == nil {
+			return jb.Delete([]byte("notice"))
+		}
+		data, err := json.Marshal(notice)
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("notice"), data)
+	})
+}
+
+// JobNoticeData is the active notice of the job
+type JobNoticeData struct {
+	Job string `json:"job"`
+	*JobNotice
+}
+
+// GetActiveNotices returns active notices of all jobs sorted by job name
+func GetActiveNotices() ([]*JobNoticeData, error) {
+	notices := []*JobNoticeData{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(JobsBucket)
+		return b.ForEach(func(key, v []byte) error {
+			jb := b.Bucket(key)
+			if jb == nil {
+				return nil
+			}
+			notice, err := getJobNotice(jb)
+			if err != nil || notice == nil {
+				return err
+			}
+			notices = append(notices, &JobNoticeData{Job: string(key), JobNotice: notice})
+			return nil
+		})
+	})
+	sort.Slice(notices, func(i, j int) bool {
+		return notices[i].Job < notices[j].Job
+	})
+	return notices, err
+}
+
+// ClearExpiredNotices removes notices which have expired at t
+func ClearExpiredNotices(t time.Time) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(JobsBucket)
+		return b.ForEach(func(key, v []byte) error {
+			jb := b.Bucket(key)
+			if jb == nil || jb.Get([]byte("notice")) == nil {
+				return nil
+			}
+			var notice JobNotice
+			err := json.Unmarshal(jb.Get([]byte("notice")), &notice)
+			if err != nil {
+				return err
+			}
+			if notice.isActive(t) {
+				return nil
+			}
+			AuditLogger.Printf("Notice of job %s by %s has expired\n", key, notice.Author)
+			return jb.Delete([]byte("notice"))
+		})
+	})
+}
+
+// CleanupExpiredNotices periodically removes expired job notices
+func CleanupExpiredNotices(d time.Duration) {
+	ticker := time.NewTicker(d)
+	go func() {
+		for range ticker.C {
+			err := ClearExpiredNotices(time.Now())
+			if err != nil {
+				Logger.Println(err)
+			}
+		}
+	}()
+}
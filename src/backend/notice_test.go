@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestJobNotice(t *testing.T) {
+	setupTestEnv(t)
+	err := DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("flaky"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Put("/job/{name}/notice", HandleJobNoticePut)
+	put := func(name string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/job/"+name+"/notice", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, form := range []url.Values{
+		{"text": {"outage"}, "severity": {"fatal"}},
+		{"text": {"outage"}, "expires_at": {time.Now().Add(-time.Hour).Format(time.RFC3339)}},
+	} {
+		if w := put("flaky", form); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %d", form, w.Code)
+		}
+	}
+	if w := put("unknown", url.Values{"text": {"outage"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown job, got %d", w.Code)
+	}
+
+	w := put("flaky", url.Values{"text": {"upstream outage"}, "severity": {NoticeWarning}, "author": {"ops"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+	notice, err := GetJobNotice("flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice == nil || notice.Text != "upstream outage" || notice.Author != "ops" || notice.Severity != NoticeWarning {
+		t.Fatalf("Unexpected notice %+v", notice)
+	}
+	notices, err := GetActiveNotices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 1 || notices[0].Job != "flaky" {
+		t.Errorf("Unexpected active notices %+v", notices)
+	}
+
+	// Expired notices are hidden and removed by the sweep
+	expiresAt := time.Now().Add(time.Minute)
+	err = SaveJobNotice("flaky", &JobNotice{Text: "outage", Severity: NoticeInfo, CreatedAt: time.Now(), ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ClearExpiredNotices(expiresAt.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice, _ := GetJobNotice("flaky"); notice == nil {
+		t.Error("Expected the notice to be active")
+	}
+	err = ClearExpiredNotices(expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(JobsBucket).Bucket([]byte("flaky")).Get([]byte("notice")) != nil {
+			t.Error("Expected the expired notice to be removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put("flaky", url.Values{"text": {"outage"}})
+	if w := put("flaky", url.Values{}); w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d", w.Code)
+	}
+	if notice, _ := GetJobNotice("flaky"); notice != nil {
+		t.Errorf("Expected the notice to be removed, got %+v", notice)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// ReapChildrenWarn reports processes left by the task as build warnings
+	ReapChildrenWarn = "warn"
+	// ReapChildrenKill kills processes left by the task
+	ReapChildrenKill = "kill"
+	// ReapChildrenIgnore doesn't look for processes left by the task
+	ReapChildrenIgnore = "ignore"
+)
+
+// procDir is where information about processes is read from
+var procDir = "/proc"
+
+// OrphanProcess is a process which is still running after its task completed
+type OrphanProcess struct {
+	PID     int
+	Command string
+}
+
+// findProcessGroup returns running processes of the process group. Every task
+// command is started in its own process group, which id is the pid of the
+// command. Processes which started a new session or process group (setsid,
+// double fork daemons) are not found
+func findProcessGroup(pgid int) ([]*OrphanProcess, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	processes := []*OrphanProcess{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// The process might have exited since the directory was read
+		stat, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// Format: pid (comm) state ppid pgrp ..., comm can contain spaces
+		idx := strings.LastIndexByte(string(stat), ')')
+		if idx < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[idx+1:]))
+		if len(fields) < 3 || fields[0] == "Z" || fields[0] == "X" {
+			continue
+		}
+		pgrp, err := strconv.Atoi(fields[2])
+		if err != nil || pgrp != pgid {
+			continue
+		}
+		processes = append(processes, &OrphanProcess{
+			PID:     pid,
+			Command: readProcessCommand(pid),
+		})
+	}
+	return processes, nil
+}
+
+// readProcessCommand returns the command line of the process
+func readProcessCommand(pid int) string {
+	data, err := os.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return "?"
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+}
+
+// reapChildren looks for processes left running by the command of the task
+// and warns about them or kills them according to `reap_children`
+func (b *Build) reapChildren(task *Task, pgid int, buffer *bufio.Writer) {
+	mode := task.ReapChildren
+	if mode == "" {
+		mode = ReapChildrenWarn
+	}
+	if mode == ReapChildrenIgnore || pgid <= 0 {
+		return
+	}
+	processes, err := findProcessGroup(pgid)
+	if err != nil {
+		b.Logger.Printf("Unable to look for orphaned processes of task %d: %s\n", task.ID, err)
+		return
+	}
+	if len(processes) == 0 {
+		return
+	}
+	list := make([]string, 0, len(processes))
+	for _, p := range processes {
+		list = append(list, fmt.Sprintf("%d (%s)", p.PID, p.Command))
+	}
+	msg := fmt.Sprintf("Task %d left %d processes running: %s", task.ID, len(processes), strings.Join(list, ", "))
+	b.Logger.Println(msg)
+
+	if mode == ReapChildrenKill {
+		err = syscall.Kill(-pgid, syscall.SIGKILL)
+		if err != nil {
+			b.Logger.Printf("Unable to kill orphaned processes of task %d: %s\n", task.ID, err)
+		}
+		b.ProcessLogEntry("> Killed orphaned processes: "+strings.Join(list, ", "), buffer, task, task.startedAt)
+		return
+	}
+	b.ProcessLogEntry("> Orphaned processes are still running: "+strings.Join(list, ", "), buffer, task, task.startedAt)
+	b.addWarning(msg)
+}
+
+// Used to verify reap_children values before saving after editing
+func (j *Job) verifyReapChildren() error {
+	for _, task := range j.Tasks {
+		switch task.ReapChildren {
+		case "", ReapChildrenWarn, ReapChildrenKill, ReapChildrenIgnore:
+		default:
+			return fmt.Errorf(
+				"reap_children has to be %s, %s or %s: %s",
+				ReapChildrenWarn, ReapChildrenKill, ReapChildrenIgnore, task.ReapChildren,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// taskChannels deliver abort requests to a main task which runs in parallel
+// with other tasks. Requests received via Build.abortedChannel and
+// Build.killChannel are forwarded to every running task
+type taskChannels struct {
+	aborted chan string
+	kill    chan bool
+}
+
+// getTaskChannels returns abort channels of the task or nil if the task
+// doesn't run in parallel
+func (b *Build) getTaskChannels(task *Task) *taskChannels {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.parallelTasks[task.ID]
+}
+
+// runMainTasksParallel runs all main tasks with the pool of workers and
+// returns the status of the build
+func (b *Build) runMainTasksParallel(workers int) ItemStatus {
+	tasks := make([]*Task, 0)
+	for _, task := range b.Job.Tasks {
+		if task.Kind == KindMain {
+			tasks = append(tasks, task)
+		}
+	}
+	return b.runTasksParallel(tasks, workers)
+}
+
+// runTasksParallel runs tasks with the pool of workers and returns their
+// status. After a task fails or an abort request is received no new tasks are
+// started, but it waits for running tasks to settle
+func (b *Build) runTasksParallel(tasks []*Task, workers int) ItemStatus {
+	b.mutex.Lock()
+	b.parallelTasks = make(map[int]*taskChannels)
+	b.parallelAbortReason = ""
+	b.parallelFailed = false
+	b.mutex.Unlock()
+
+	done := make(chan struct{})
+	go b.forwardAbortRequests(done)
+
+	queue := make(chan *Task)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				status := b.runParallelTask(task)
+				if status == StatusFailed && !task.AllowFailure {
+					b.mutex.Lock()
+					b.parallelFailed = true
+					b.mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, task := range tasks {
+		if b.isParallelRunStopped() {
+			break
+		}
+		queue <- task
+	}
+	close(queue)
+	wg.Wait()
+	close(done)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.parallelTasks = nil
+	if b.parallelAbortReason != "" {
+		return ItemStatus(b.parallelAbortReason)
+	}
+	if b.parallelFailed {
+		return StatusFailed
+	}
+	return StatusFinished
+}
+
+// isParallelRunStopped returns true if new tasks shouldn't be started
+func (b *Build) isParallelRunStopped() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.parallelAbortReason != "" || b.parallelFailed
+}
+
+// runParallelTask runs the task unless the build is aborted or failed and
+// returns its status, StatusPending if the task hasn't been started
+func (b *Build) runParallelTask(task *Task) ItemStatus {
+	channels := &taskChannels{
+		aborted: make(chan string, 1),
+		kill:    make(chan bool, 1),
+	}
+	b.mutex.Lock()
+	if b.parallelAbortReason != "" || b.parallelFailed {
+		b.mutex.Unlock()
+		return StatusPending
+	}
+	b.parallelTasks[task.ID] = channels
+	b.mutex.Unlock()
+
+	if !b.startTask(task) {
+		b.mutex.Lock()
+		delete(b.parallelTasks, task.ID)
+		b.mutex.Unlock()
+		return StatusSkipped
+	}
+	b.BroadcastUpdate()
+
+	status := b.runTaskWithRetries(task)
+
+	b.finishTask(task, status)
+	b.isFailureAllowed(task, status)
+	status = b.checkTaskAborted(task, status)
+	b.mutex.Lock()
+	delete(b.parallelTasks, task.ID)
+	b.mutex.Unlock()
+	b.BroadcastUpdate()
+	return status
+}
+
+// mainTaskSteps splits main tasks into steps which run one after another.
+// Consecutive tasks of the same group form one step
+func (j *Job) mainTaskSteps() [][]*Task {
+	steps := make([][]*Task, 0)
+	for _, task := range j.Tasks {
+		if task.Kind != KindMain {
+			continue
+		}
+		last := len(steps) - 1
+		if task.Group != "" && last >= 0 && steps[last][0].Group == task.Group {
+			steps[last] = append(steps[last], task)
+			continue
+		}
+		steps = append(steps, []*Task{task})
+	}
+	return steps
+}
+
+// Used to verify task groups before saving after editing
+func (j *Job) verifyTaskGroups() error {
+	seen := make(map[string]bool)
+	previous := ""
+	for _, task := range j.Tasks {
+		if task.Kind != "" && task.Kind != KindMain {
+			continue
+		}
+		if task.Group != "" && task.Group != previous && seen[task.Group] {
+			return fmt.Errorf("tasks of group %s have to follow each other", task.Group)
+		}
+		seen[task.Group] = true
+		previous = task.Group
+	}
+	return nil
+}
+
+// forwardAbortRequests sends abort requests to all running tasks until done is
+// closed
+func (b *Build) forwardAbortRequests(done chan struct{}) {
+	for {
+		select {
+		case reason := <-b.abortedChannel:
+			b.mutex.Lock()
+			if b.parallelAbortReason == "" {
+				b.parallelAbortReason = reason
+			}
+			for _, channels := range b.parallelTasks {
+				select {
+				case channels.aborted <- reason:
+				default:
+				}
+			}
+			b.mutex.Unlock()
+		case <-b.killChannel:
+			b.mutex.Lock()
+			if b.parallelAbortReason == "" {
+				b.parallelAbortReason = StatusAborted
+			}
+			for _, channels := range b.parallelTasks {
+				select {
+				case channels.kill <- true:
+				default:
+				}
+			}
+			b.mutex.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// ParamSuggestionsMax is the number of distinct values stored per param
+	ParamSuggestionsMax = 50
+	// ParamSuggestionsTTL is the age after which unused values are removed
+	ParamSuggestionsTTL = 90 * 24 * time.Hour
+	// ParamSuggestionsLimit is the default number of returned values
+	ParamSuggestionsLimit = 20
+)
+
+// ParamSuggestion is a value of a param used in a build
+type ParamSuggestion struct {
+	Value  string    `json:"value"`
+	UsedAt time.Time `json:"used_at"`
+}
+
+// isSecretParamValue reports whether the value of a param is or refers to a
+// secret. Such values are never suggested
+func isSecretParamValue(value string) bool {
+	if secretsRegex.MatchString(value) {
+		return true
+	}
+	for _, secret := range Config.secrets {
+		if secret != "" && strings.Contains(value, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// addParamSuggestion moves the value to the top of the most recently used list
+// and removes the oldest and expired values
+func addParamSuggestion(list []*ParamSuggestion, value string, now time.Time) []*ParamSuggestion {
+	result := []*ParamSuggestion{{Value: value, UsedAt: now}}
+	for _, s := range list {
+		if s.Value == value || now.Sub(s.UsedAt) > ParamSuggestionsTTL {
+			continue
+		}
+		if len(result) >= ParamSuggestionsMax {
+			break
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// RecordParamSuggestions updates lists of recently used values of the params
+// of the build
+func RecordParamSuggestions(jobName string, params []map[string]string) error {
+	now := time.Now()
+	return DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(ParamSuggestionsBucket).CreateBucketIfNotExists([]byte(jobName))
+		if err != nil {
+			return err
+		}
+		for _, param := range params {
+			for name, value := range param {
+				if value == "" || isSecretParamValue(value) {
+					continue
+				}
+				list := []*ParamSuggestion{}
+				data := jb.Get([]byte(name))
+				if data != nil {
+					err = json.Unmarshal(data, &list)
+					if err != nil {
+						return err
+					}
+				}
+				data, err = json.Marshal(addParamSuggestion(list, value, now))
+				if err != nil {
+					return err
+				}
+				err = jb.Put([]byte(name), data)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetParamSuggestions returns recently used values of the param which contain
+// the query, the most recent first
+func GetParamSuggestions(jobName string, param string, query string, limit int) ([]string, error) {
+	list := []*ParamSuggestion{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		jb := tx.Bucket(ParamSuggestionsBucket).Bucket([]byte(jobName))
+		if jb == nil {
+			return nil
+		}
+		data := jb.Get([]byte(param))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+	values := []string{}
+	for _, s := range list {
+		if len(values) >= limit {
+			break
+		}
+		if time.Since(s.UsedAt) > ParamSuggestionsTTL || isSecretParamValue(s.Value) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(s.Value), query) {
+			continue
+		}
+		values = append(values, s.Value)
+	}
+	return values, nil
+}
+
+// HandleGetParamSuggestions returns recently used values of the param
+// @Summary      Return recently used values of the param
+// @Description  Distinct values from builds of the job, the most recent first. Values of secrets are never returned
+// @Tags         job
+// @Produce      json
+// @Param        name     path    string    true   "Name of the job"
+// @Param        param    path    string    true   "Name of the param"
+// @Param        q        query   string    false  "Return only values which contain the string"
+// @Param        limit    query   integer   false  "Maximum number of values, 20 by default"
+// @Success      200      {array}    string
+// @Failure      400      {string}   string
+// @Failure      500      {string}   string
+// @Router       /job/{name}/params/{param}/suggestions [get]
+func HandleGetParamSuggestions(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	limit := ParamSuggestionsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			logger.Printf("Invalid limit: %s\n", l)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("limit has to be a positive number"))
+			return
+		}
+	}
+
+	values, err := GetParamSuggestions(chi.URLParam(r, "name"), chi.URLParam(r, "param"), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	payloadB, err := json.Marshal(values)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payloadB)
+}
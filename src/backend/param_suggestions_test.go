@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParamSuggestions(t *testing.T) {
+	setupTestEnv(t)
+	Config.secrets = map[string]string{"TOKEN": "s3cr3t"}
+
+	for _, branch := range []string{"main", "release-1.0", "main", "release-1.1", "{{ secrets.TOKEN }}", "s3cr3t"} {
+		err := RecordParamSuggestions("deploy", []map[string]string{{"BRANCH": branch}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values, err := GetParamSuggestions("deploy", "BRANCH", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"release-1.1", "main", "release-1.0"}
+	if len(values) != len(expected) || values[0] != expected[0] || values[1] != expected[1] || values[2] != expected[2] {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+
+	values, err = GetParamSuggestions("deploy", "BRANCH", "REL", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "release-1.1" {
+		t.Errorf("Expected [release-1.1], got %v", values)
+	}
+}
+
+func TestAddParamSuggestion(t *testing.T) {
+	now := time.Now()
+	list := []*ParamSuggestion{{Value: "old", UsedAt: now.Add(-ParamSuggestionsTTL - time.Hour)}}
+	for i := 0; i < ParamSuggestionsMax+5; i++ {
+		list = addParamSuggestion(list, string(rune('a'+i)), now)
+	}
+	if len(list) != ParamSuggestionsMax {
+		t.Errorf("Expected %d values, got %d", ParamSuggestionsMax, len(list))
+	}
+	for _, s := range list {
+		if s.Value == "old" {
+			t.Errorf("Expired value wasn't removed")
+		}
+	}
+}
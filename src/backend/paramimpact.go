@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ParamImpactBuilds is the number of the latest completed builds analyzed
+const ParamImpactBuilds = 100
+
+// ParamImpact contains outcomes of builds with the same value of a param
+type ParamImpact struct {
+	Value         string  `json:"value"`
+	Total         int     `json:"total"`
+	SuccessRate   float64 `json:"success_rate"`
+	AvgDurationMs int64   `json:"avg_duration_ms"`
+}
+
+// getParamValue returns the value of the param in the build or false if the
+// build doesn't have it
+func getParamValue(params []map[string]string, name string) (string, bool) {
+	// Iterate backwards as the last value will be the actual value
+	for i := len(params) - 1; i >= 0; i-- {
+		value, ok := params[i][name]
+		if ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// CalculateParamImpact groups completed builds by the value of the param.
+// Groups are sorted by the number of builds
+func CalculateParamImpact(builds []*BuildUpdateData, param string) []*ParamImpact {
+	groups := make(map[string]*ParamImpact)
+	succeeded := make(map[string]int)
+	durations := make(map[string]time.Duration)
+	for _, build := range builds {
+		value, ok := getParamValue(build.Params, param)
+		if !ok {
+			continue
+		}
+		group, ok := groups[value]
+		if !ok {
+			group = &ParamImpact{Value: value}
+			groups[value] = group
+		}
+		group.Total++
+		if build.Status == StatusFinished {
+			succeeded[value]++
+		}
+		durations[value] += build.Duration
+	}
+
+	result := []*ParamImpact{}
+	for value, group := range groups {
+		group.SuccessRate = float64(succeeded[value]) / float64(group.Total)
+		group.AvgDurationMs = (durations[value] / time.Duration(group.Total)).Milliseconds()
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// getLatestCompletedBuilds returns up to limit latest completed builds of the
+// job, the newest first
+func getLatestCompletedBuilds(jobName string, limit int) ([]*BuildUpdateData, error) {
+	builds := []*BuildUpdateData{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(HistoryBucket).Cursor()
+		for key, v := c.Last(); key != nil && len(builds) < limit; key, v = c.Prev() {
+			var msg BuildUpdateData
+			err := json.Unmarshal(v, &msg)
+			if err != nil {
+				return err
+			}
+			if msg.JobName() != jobName || !isTerminalStatus(msg.Status) {
+				continue
+			}
+			builds = append(builds, &msg)
+		}
+		return nil
+	})
+	return builds, err
+}
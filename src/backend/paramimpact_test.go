@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateParamImpact(t *testing.T) {
+	build := func(branch string, status ItemStatus, duration time.Duration) *BuildUpdateData {
+		return &BuildUpdateData{
+			Status:   status,
+			Duration: duration,
+			Params:   []map[string]string{{"BRANCH": branch}},
+		}
+	}
+	builds := []*BuildUpdateData{
+		build("main", StatusFinished, 2*time.Minute),
+		build("main", StatusFinished, 4*time.Minute),
+		build("main", StatusFailed, 3*time.Minute),
+		build("feature-x", StatusTimedOut, time.Minute),
+		{Status: StatusFinished, Params: []map[string]string{{"OTHER": "1"}}},
+	}
+
+	result := CalculateParamImpact(builds, "BRANCH")
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(result))
+	}
+	main := result[0]
+	if main.Value != "main" || main.Total != 3 || main.AvgDurationMs != 180000 {
+		t.Errorf("Unexpected group %+v", main)
+	}
+	if main.SuccessRate < 0.66 || main.SuccessRate > 0.67 {
+		t.Errorf("Expected success rate 0.67, got %f", main.SuccessRate)
+	}
+	if result[1].Value != "feature-x" || result[1].SuccessRate != 0 {
+		t.Errorf("Unexpected group %+v", result[1])
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of params in Job.ParamsSchema
+const (
+	ParamTypeString = "string"
+	ParamTypeInt    = "int"
+	ParamTypeBool   = "bool"
+)
+
+// ParamSchema describes valid values of the param
+type ParamSchema struct {
+	// ParamTypeString (default), ParamTypeInt or ParamTypeBool
+	Type string `yaml:"type" json:"type"`
+	// The value can't be empty
+	Required bool `yaml:"required" json:"required"`
+	// Regular expression the value has to match, e.g. ^v\d+\.\d+$
+	Pattern string `yaml:"pattern" json:"pattern"`
+}
+
+// ParamFieldError describes why the value of the param is invalid
+type ParamFieldError struct {
+	Param string `json:"param"`
+	Value string `json:"value"`
+	Error string `json:"error"`
+}
+
+// ParamValidationError is returned when params of a new build don't match
+// Job.ParamsSchema
+type ParamValidationError struct {
+	Job    string             `json:"job"`
+	Fields []*ParamFieldError `json:"fields"`
+}
+
+func (e *ParamValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = fmt.Sprintf("%s %s", field.Param, field.Error)
+	}
+	return fmt.Sprintf("invalid params of job %s: %s", e.Job, strings.Join(fields, ", "))
+}
+
+// Used to verify the params schema before saving after editing
+func (j *Job) verifyParamsSchema() error {
+	for name, schema := range j.ParamsSchema {
+		if schema == nil {
+			return fmt.Errorf("params_schema of %s is empty", name)
+		}
+		switch schema.Type {
+		case "", ParamTypeString, ParamTypeInt, ParamTypeBool:
+		default:
+			return fmt.Errorf("unknown type %q of param %s: expected %s, %s or %s", schema.Type, name, ParamTypeString, ParamTypeInt, ParamTypeBool)
+		}
+		if schema.Pattern != "" {
+			_, err := regexp.Compile(schema.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern of param %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveParams returns values of params the build would get: default
+// values are overridden by the preset, explicitly provided params and values
+// of the matrix combination, see RunJobMatrix
+func (j *Job) resolveParams(preset map[string]string, params url.Values, combination map[string]string) map[string]string {
+	values := map[string]string{}
+	for idx := range j.DefaultParams {
+		for pkey, pval := range j.DefaultParams[idx] {
+			values[pkey] = pval
+			if value, ok := preset[pkey]; ok {
+				values[pkey] = value
+			}
+			if value := params.Get(pkey); value != "" {
+				values[pkey] = value
+			}
+		}
+	}
+	for pkey, pval := range combination {
+		values[pkey] = pval
+	}
+	return values
+}
+
+// validateParams verifies values of params against the params schema.
+// Returns ParamValidationError listing all invalid params
+func (j *Job) validateParams(values map[string]string) error {
+	names := make([]string, 0, len(j.ParamsSchema))
+	for name := range j.ParamsSchema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []*ParamFieldError
+	for _, name := range names {
+		schema := j.ParamsSchema[name]
+		value := values[name]
+		if value == "" {
+			if schema.Required {
+				fields = append(fields, &ParamFieldError{Param: name, Error: "is required"})
+			}
+			continue
+		}
+		var err error
+		switch schema.Type {
+		case ParamTypeInt:
+			_, err = strconv.Atoi(value)
+		case ParamTypeBool:
+			_, err = strconv.ParseBool(value)
+		}
+		if err != nil {
+			fields = append(fields, &ParamFieldError{Param: name, Value: value, Error: "is not " + schema.Type})
+			continue
+		}
+		if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(value) {
+			fields = append(fields, &ParamFieldError{Param: name, Value: value, Error: "doesn't match " + schema.Pattern})
+		}
+	}
+	if len(fields) > 0 {
+		return &ParamValidationError{Job: j.Name, Fields: fields}
+	}
+	return nil
+}
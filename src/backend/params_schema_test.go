@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestVerifyParamsSchema(t *testing.T) {
+	valid := &Job{ParamsSchema: map[string]*ParamSchema{
+		"VERSION": {Pattern: `^v\d+\.\d+$`},
+		"COUNT":   {Type: ParamTypeInt, Required: true},
+	}}
+	if err := valid.verifyParamsSchema(); err != nil {
+		t.Error(err)
+	}
+	for _, schema := range []*ParamSchema{{Type: "float"}, {Pattern: "v("}, nil} {
+		job := &Job{ParamsSchema: map[string]*ParamSchema{"P": schema}}
+		if err := job.verifyParamsSchema(); err == nil {
+			t.Errorf("Expected error for %+v", schema)
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	job := &Job{
+		Name: "release",
+		ParamsSchema: map[string]*ParamSchema{
+			"VERSION": {Required: true, Pattern: `^v\d+\.\d+$`},
+			"COUNT":   {Type: ParamTypeInt},
+			"DRY":     {Type: ParamTypeBool},
+		},
+	}
+	if err := job.validateParams(map[string]string{"VERSION": "v1.2", "COUNT": "3", "DRY": "true"}); err != nil {
+		t.Error(err)
+	}
+	err := job.validateParams(map[string]string{"VERSION": "1.2", "COUNT": "three", "DRY": ""})
+	paramsErr, ok := err.(*ParamValidationError)
+	if !ok {
+		t.Fatalf("Expected ParamValidationError, got %v", err)
+	}
+	if len(paramsErr.Fields) != 2 || paramsErr.Fields[0].Param != "COUNT" || paramsErr.Fields[1].Param != "VERSION" {
+		t.Errorf("Unexpected invalid params %+v", paramsErr.Fields)
+	}
+	err = job.validateParams(map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "VERSION is required") {
+		t.Errorf("Expected VERSION to be required, got %v", err)
+	}
+}
+
+func TestHandleRunJob_InvalidParams(t *testing.T) {
+	setupTestEnv(t)
+	content := `
+params:
+  - VERSION: ""
+params_schema:
+  VERSION:
+    required: true
+    pattern: ^v\d+$
+tasks:
+  - run: "true"
+`
+	err := os.WriteFile(Config.JobDir+"schema.yaml", []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("schema"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Post("/job/{name}/run", HandleRunJob)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/job/schema/run?"+url.Values{"VERSION": {"1"}}.Encode(), nil))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422, got %d %s", w.Code, w.Body.String())
+	}
+	var paramsErr ParamValidationError
+	err = json.Unmarshal(w.Body.Bytes(), &paramsErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paramsErr.Fields) != 1 || paramsErr.Fields[0].Param != "VERSION" || paramsErr.Fields[0].Value != "1" {
+		t.Errorf("Unexpected invalid params %+v", paramsErr.Fields)
+	}
+	if _, queued := GlobalQueue.Count(); queued != 0 || GlobalQueue.HasJob("schema") {
+		t.Error("Expected no builds to be created")
+	}
+}
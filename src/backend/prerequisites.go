@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RequiresCheckEnqueue rejects the build when prerequisites are not met
+const RequiresCheckEnqueue = "enqueue"
+
+// RequiresCheckTake keeps the build in the queue until prerequisites are met
+const RequiresCheckTake = "take"
+
+// Requirement is a job which latest completed build has to be successful
+type Requirement struct {
+	Job string `yaml:"job" json:"job"`
+	// Maximum age of the successful build, e.g. 24h
+	Within string `yaml:"within" json:"within"`
+}
+
+// PrerequisiteResult contains information about the build of the required job
+// which was consulted
+type PrerequisiteResult struct {
+	Job     string     `json:"job"`
+	BuildID int        `json:"build_id"`
+	Status  ItemStatus `json:"status"`
+	Passed  bool       `json:"passed"`
+}
+
+// PrerequisitesCheck is the result of verification of job requirements
+type PrerequisitesCheck struct {
+	Passed    bool                  `json:"passed"`
+	Message   string                `json:"message"`
+	CheckedAt time.Time             `json:"checked_at"`
+	Results   []*PrerequisiteResult `json:"results"`
+}
+
+// PrerequisiteError is returned when the build is rejected because the
+// requirements of the job are not met
+type PrerequisiteError struct {
+	Message string
+}
+
+func (e *PrerequisiteError) Error() string {
+	return e.Message
+}
+
+// getLatestCompletedBuild returns the latest build of the job which is not
+// pending or running
+func getLatestCompletedBuild(tx *bolt.Tx, jobName string) (*BuildUpdateData, error) {
+	c := tx.Bucket(HistoryBucket).Cursor()
+	for key, v := c.Last(); key != nil; key, v = c.Prev() {
+		var msg BuildUpdateData
+		err := json.Unmarshal(v, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if msg.JobName() != jobName {
+			continue
+		}
+		switch msg.Status {
+		case StatusPending, StatusRunning:
+			continue
+		}
+		return &msg, nil
+	}
+	return nil, nil
+}
+
+// CheckPrerequisites verifies that the latest completed build of every
+// required job is successful
+func CheckPrerequisites(job *Job) *PrerequisitesCheck {
+	check := &PrerequisitesCheck{
+		Passed:    true,
+		CheckedAt: time.Now(),
+		Results:   []*PrerequisiteResult{},
+	}
+	fail := func(msg string) {
+		if check.Passed {
+			check.Passed = false
+			check.Message = msg
+		}
+	}
+	err := DB.View(func(tx *bolt.Tx) error {
+		for _, req := range job.Requires {
+			latest, err := getLatestCompletedBuild(tx, req.Job)
+			if err != nil {
+				return err
+			}
+			if latest == nil {
+				fail(fmt.Sprintf("required job %s has no completed builds", req.Job))
+				check.Results = append(check.Results, &PrerequisiteResult{Job: req.Job})
+				continue
+			}
+			result := &PrerequisiteResult{
+				Job:     req.Job,
+				BuildID: latest.ID,
+				Status:  latest.Status,
+				Passed:  latest.Status == StatusFinished,
+			}
+			if !result.Passed {
+				fail(fmt.Sprintf("the latest build #%d of required job %s is %s", latest.ID, req.Job, latest.Status))
+			} else if req.Within != "" {
+				within, err := time.ParseDuration(req.Within)
+				if err != nil {
+					return err
+				}
+				if time.Since(latest.StartedAt.Add(latest.Duration)) > within {
+					result.Passed = false
+					fail(fmt.Sprintf("the latest successful build #%d of required job %s is older than %s", latest.ID, req.Job, req.Within))
+				}
+			}
+			check.Results = append(check.Results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		Logger.Println(err)
+		fail(err.Error())
+	}
+	return check
+}
+
+// checkPrerequisitesOnTake verifies requirements of the job before taking the
+// build from the queue. Returns true if the build can be started
+func (b *Build) checkPrerequisitesOnTake() bool {
+	if len(b.Job.Requires) == 0 || b.Job.RequiresCheck != RequiresCheckTake {
+		return true
+	}
+	check := CheckPrerequisites(b.Job)
+	b.mutex.Lock()
+	changed := b.Prerequisites == nil || b.Prerequisites.Message != check.Message
+	b.Prerequisites = check
+	if check.Passed {
+		b.PendingReason = ""
+	} else {
+		b.PendingReason = check.Message
+	}
+	b.mutex.Unlock()
+	if changed {
+		b.Logger.Printf("Prerequisites check: passed %v %s\n", check.Passed, check.Message)
+		go b.BroadcastUpdate()
+	}
+	return check.Passed
+}
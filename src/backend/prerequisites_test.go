@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// putTestJob writes the job file and enables the job
+func putTestJob(t *testing.T, name, content string) {
+	err := os.WriteFile(Config.JobDir+name+Config.jobsExt, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putTestBuildStatus saves the record of the completed build of the job
+func putTestBuildStatus(t *testing.T, id int, name string, status ItemStatus, completedAt time.Time) {
+	dataB, err := json.Marshal(&BuildUpdateData{
+		ID:        id,
+		Name:      name,
+		Status:    status,
+		StartedAt: completedAt.Add(-time.Minute),
+		Duration:  time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(HistoryBucket).Put(Itob(id), dataB)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckPrerequisites(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{Requires: []*Requirement{{Job: "build"}, {Job: "test", Within: "1h"}}}
+
+	check := CheckPrerequisites(job)
+	if check.Passed || check.Message != "required job build has no completed builds" {
+		t.Errorf("Expected no builds of the required job, got %+v", check)
+	}
+
+	putTestBuildStatus(t, 1, "build", StatusFinished, time.Now())
+	putTestBuildStatus(t, 2, "test", StatusFinished, time.Now().Add(-2*time.Hour))
+	check = CheckPrerequisites(job)
+	if check.Passed || check.Message != "the latest successful build #2 of required job test is older than 1h" {
+		t.Errorf("Expected the old build to fail the check, got %+v", check)
+	}
+	if len(check.Results) != 2 || !check.Results[0].Passed || check.Results[1].Passed || check.Results[1].BuildID != 2 {
+		t.Errorf("Unexpected results %+v %+v", check.Results[0], check.Results[1])
+	}
+
+	putTestBuildStatus(t, 3, "test", StatusFinished, time.Now())
+	if check = CheckPrerequisites(job); !check.Passed {
+		t.Errorf("Expected the check to pass, got %+v", check)
+	}
+
+	// Running builds are not consulted
+	putTestBuildStatus(t, 4, "build", StatusRunning, time.Now())
+	putTestBuildStatus(t, 5, "build", StatusFailed, time.Now())
+	putTestBuildStatus(t, 6, "build", StatusRunning, time.Now())
+	check = CheckPrerequisites(job)
+	if check.Passed || check.Message != "the latest build #5 of required job build is failed" {
+		t.Errorf("Expected the failed build to fail the check, got %+v", check)
+	}
+}
+
+func TestHandleRunJob_Prerequisites(t *testing.T) {
+	setupTestEnv(t)
+	putTestJob(t, "deploy", "requires:\n  - job: build\ntasks:\n  - run: \"true\"\n")
+	router := chi.NewRouter()
+	router.Post("/job/{name}/run", HandleRunJob)
+	run := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/job/deploy/run", nil))
+		return w
+	}
+
+	putTestBuildStatus(t, 100, "build", StatusFailed, time.Now())
+	w := run()
+	if w.Code != http.StatusPreconditionFailed || w.Body.String() != "job deploy can't be started: the latest build #100 of required job build is failed" {
+		t.Errorf("Expected 412, got %d %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Result().Header.Get("Content-Type"); contentType != "text/plain" {
+		t.Errorf("Expected text/plain error, got %q", contentType)
+	}
+	if GlobalQueue.HasJob("deploy") {
+		t.Fatal("Expected the build not to be queued")
+	}
+
+	putTestBuildStatus(t, 101, "build", StatusFinished, time.Now())
+	w = run()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+	id, err := strconv.Atoi(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data *BuildUpdateData
+	waitFor(t, 5*time.Second, "the build is recorded", func() bool {
+		data, err = getBuildStatusData(id)
+		return err == nil && data.Name == "deploy"
+	})
+	if data.Prerequisites == nil || !data.Prerequisites.Passed || data.Prerequisites.Results[0].BuildID != 101 {
+		t.Errorf("Expected the passed check to be recorded, got %+v", data.Prerequisites)
+	}
+}
+
+func TestQueueTake_Prerequisites(t *testing.T) {
+	setupTestEnv(t)
+	putTestJob(t, "deploy", "requires:\n  - job: build\nrequires_check: take\ntasks:\n  - run: \"true\"\n")
+
+	// The build is queued and waits for the required job
+	build, err := RunJob("deploy", nil, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := build.GenerateBuildUpdateData()
+	if data.Status != StatusPending || data.PendingReason != "required job build has no completed builds" {
+		t.Fatalf("Expected the build to wait for the required job, got %s %q", data.Status, data.PendingReason)
+	}
+	putTestBuildStatus(t, 100, "build", StatusFailed, time.Now())
+	GlobalQueue.Take()
+	if data := build.GenerateBuildUpdateData(); data.Status != StatusPending || data.PendingReason != "the latest build #100 of required job build is failed" {
+		t.Fatalf("Expected the build to wait for the required job, got %s %q", data.Status, data.PendingReason)
+	}
+
+	putTestBuildStatus(t, 101, "build", StatusFinished, time.Now())
+	GlobalQueue.Take()
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	if data := build.GenerateBuildUpdateData(); data.PendingReason != "" || !data.Prerequisites.Passed {
+		t.Errorf("Expected the passed check to be recorded, got %q %+v", data.PendingReason, data.Prerequisites)
+	}
+}
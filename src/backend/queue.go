@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sasha-s/go-deadlock"
 
@@ -14,29 +18,77 @@ type Queue struct {
 	running          []*Build
 	mutex            deadlock.Mutex
 	concurrentBuilds int
+	// Queued builds are not taken while the queue is paused, see SetPaused
+	paused bool
+	// New builds are not accepted and queued builds are not taken while the
+	// server is shutting down, see Drain
+	draining bool
+	// Goroutines of builds started by the queue, see track
+	active sync.WaitGroup
+	// Held from FindPendingDuplicate until the new build is added, so builds
+	// of jobs with `dedupe` triggered concurrently aren't duplicated
+	dedupeMutex deadlock.Mutex
+}
+
+// track runs f in a goroutine which is awaited by waitIdle
+func (q *Queue) track(f func()) {
+	q.active.Add(1)
+	go func() {
+		defer q.active.Done()
+		f()
+	}()
+}
+
+// waitIdle waits until builds started by the queue have completed, including
+// notifications and indexing after the builds are removed from the queue.
+// Returns false on timeout
+func (q *Queue) waitIdle(timeout time.Duration) bool {
+	idle := make(chan struct{})
+	go func() {
+		q.active.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 // Take takes build from queue and starts running it
 func (q *Queue) Take() {
 	q.mutex.Lock()
 	toRun := len(q.running) < q.concurrentBuilds && len(q.queued) > 0
+	if toRun && q.paused {
+		Logger.Println("Queue is paused")
+		toRun = false
+	}
+	if toRun && q.draining {
+		toRun = false
+	}
 	var foundItem bool
 	var foundItemID int
 	if toRun {
+		reserved := q.reservedResources()
 	QLoop:
-		for id, qItem := range q.queued {
+		for _, id := range q.takeOrder() {
+			qItem := q.queued[id]
 			Logger.Printf("Inspecting build %d from queue\n", qItem.ID)
-			if qItem.Job.Concurrency != 0 {
-				// Verify number of running builds of the same job
-				parallel := 0
-				for _, rItem := range q.running {
-					if rItem.Job.Name == qItem.Job.Name {
-						parallel++
-					}
-				}
-				if parallel >= qItem.Job.Concurrency {
-					continue QLoop
-				}
+			if qItem.isAbortRequested() {
+				continue QLoop
+			}
+			if !qItem.checkMaintenanceOnTake() {
+				continue QLoop
+			}
+			if !qItem.checkPrerequisitesOnTake() {
+				continue QLoop
+			}
+			if !qItem.checkConcurrencyOnTake(q.running) {
+				continue QLoop
+			}
+			if !qItem.checkResourcesOnTake(reserved) {
+				continue QLoop
 			}
 			foundItem = true
 			foundItemID = id
@@ -45,7 +97,7 @@ func (q *Queue) Take() {
 		if foundItem {
 			Logger.Printf("Running item %d, build %d\n", foundItemID, q.queued[foundItemID].ID)
 			q.running = append(q.running, q.queued[foundItemID])
-			go q.queued[foundItemID].Start()
+			q.track(q.queued[foundItemID].Start)
 			q.queued[foundItemID] = nil
 			q.queued = append(q.queued[:foundItemID], q.queued[foundItemID+1:]...)
 		} else {
@@ -59,16 +111,54 @@ func (q *Queue) Take() {
 	Logger.Printf("Executing %d builds, %d in queue\n", len(q.running), len(q.queued))
 }
 
-// TakeNow takes the build from the queue and starts executing it now
+// checkConcurrencyOnTake verifies the number of running builds of the same
+// job against Job.Concurrency and updates the pending reason
+func (b *Build) checkConcurrencyOnTake(running []*Build) bool {
+	if b.Job.Concurrency == 0 {
+		return true
+	}
+	parallel := 0
+	for _, rItem := range running {
+		if rItem.Job.Name == b.Job.Name {
+			parallel++
+		}
+	}
+	b.mutex.Lock()
+	reason := b.PendingReason
+	if parallel >= b.Job.Concurrency {
+		reason = fmt.Sprintf("%s: %d of %d", concurrencyPendingReason, parallel, b.Job.Concurrency)
+	} else if strings.HasPrefix(reason, concurrencyPendingReason) {
+		reason = ""
+	}
+	changed := b.PendingReason != reason
+	b.PendingReason = reason
+	b.mutex.Unlock()
+	if changed {
+		b.Logger.Println(reason)
+		go b.BroadcastUpdate()
+	}
+	return parallel < b.Job.Concurrency
+}
+
+// concurrencyPendingReason is the pending reason of builds which wait for
+// running builds of the same job, see Job.Concurrency
+const concurrencyPendingReason = "Waiting for running builds of the job"
+
+// TakeNow takes the build from the queue and starts executing it now. The
+// number of concurrent builds, Job.Concurrency and the paused queue are
+// ignored
 func (q *Queue) TakeNow(buildID int) error {
 	var foundItem bool
 
 	q.mutex.Lock()
 	for id, qItem := range q.queued {
-		if qItem.ID == buildID {
+		if qItem.ID == buildID && !qItem.isAbortRequested() {
 			Logger.Printf("Running immediately item %d, build %d\n", id, q.queued[id].ID)
+			if qItem.Job.Concurrency != 0 {
+				qItem.Logger.Printf("Concurrency %d of job %s is ignored\n", qItem.Job.Concurrency, qItem.Job.Name)
+			}
 			q.running = append(q.running, q.queued[id])
-			go q.queued[id].Start()
+			q.track(q.queued[id].Start)
 			q.queued[id] = nil
 			q.queued = append(q.queued[:id], q.queued[id+1:]...)
 			foundItem = true
@@ -125,6 +215,70 @@ func (q *Queue) Remove(id int) {
 	Logger.Printf("Build %d was not found in Q\n", id)
 }
 
+// HasHeldByMaintenance returns true if a queued build waits for the end of a
+// maintenance window
+func (q *Queue) HasHeldByMaintenance() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, b := range q.queued {
+		if b.isHeldByMaintenance() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasJob returns true if a build of the job is queued or running
+func (q *Queue) HasJob(jobName string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, list := range [][]*Build{q.running, q.queued} {
+		for _, b := range list {
+			if b.Job.Name == jobName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasSweep returns true if a build of the job's scheduled sweep is queued or
+// running
+func (q *Queue) HasSweep(jobName string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, list := range [][]*Build{q.running, q.queued} {
+		for _, b := range list {
+			if b.Job.Name == jobName && b.Labels[LabelSweepID] != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Count returns number of running and queued builds
+func (q *Queue) Count() (int, int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.running), len(q.queued)
+}
+
+// Status returns number of running and queued builds and resources reserved
+// by running builds
+func (q *Queue) Status() *QueueStatusData {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return &QueueStatusData{
+		Running:          len(q.running),
+		Queued:           len(q.queued),
+		ConcurrentBuilds: q.concurrentBuilds,
+		Paused:           q.paused,
+		Draining:         q.draining,
+		Reserved:         q.reservedResources(),
+	}
+}
+
 // Verify returns true if a build with provided id is queued or running
 func (q *Queue) Verify(id int) bool {
 	q.mutex.Lock()
@@ -148,19 +302,47 @@ func (q *Queue) Abort(id int, reason string) error {
 	defer q.mutex.Unlock()
 	for _, item := range q.running {
 		if item.ID == id {
-			item.abortedChannel <- reason
+			item.RequestAbort(reason)
 			return nil
 		}
 	}
 	for _, item := range q.queued {
 		if item.ID == id {
-			go item.SetBuildStatus(StatusAborted)
+			// The build stays in the queue until on_aborted tasks are
+			// completed, but it is never taken
+			if item.RequestAbort(reason) {
+				q.track(func() {
+					item.SetBuildStatus(StatusAborted)
+				})
+			}
 			return nil
 		}
 	}
 	return fmt.Errorf("Build %d not found in Q", id)
 }
 
+// AbortPendingJob schedules all queued builds of the job to be aborted,
+// running builds are not affected. Returns IDs of the builds
+func (q *Queue) AbortPendingJob(jobName string, reason string) []int {
+	ids := []int{}
+	q.mutex.Lock()
+	for _, b := range q.queued {
+		if b.Job.Name == jobName && !b.isAbortRequested() {
+			ids = append(ids, b.ID)
+		}
+	}
+	q.mutex.Unlock()
+	for _, id := range ids {
+		// on_aborted tasks run as for any aborted pending build. The build
+		// might be started in the meantime, then it is aborted as running
+		err := q.Abort(id, reason)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+	return ids
+}
+
 // FlushLogs instructs to flush logs
 func (q *Queue) FlushLogs(id int) error {
 	q.mutex.Lock()
@@ -195,9 +377,38 @@ func (q *Queue) SetConcurrency(number int) {
 	q.Take()
 }
 
+// SetPaused pauses or resumes the queue. Running builds are not affected,
+// new builds are queued as usual. The state is kept after restart
+func (q *Queue) SetPaused(paused bool) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		gb := tx.Bucket(GlobalBucket)
+		return gb.Put([]byte("queuePaused"), []byte(strconv.FormatBool(paused)))
+	})
+	if err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	q.paused = paused
+	q.mutex.Unlock()
+	if paused {
+		Logger.Println("Queue is paused")
+	} else {
+		Logger.Println("Queue is resumed")
+	}
+	WSHub.broadcast <- &MsgBroadcast{
+		Type: MsgTypeQueueUpdate,
+		Data: q.Status(),
+	}
+	if !paused {
+		q.Take()
+	}
+	return nil
+}
+
 // CreateQueue creates new Queue object
 func CreateQueue() (*Queue, error) {
 	var cb int
+	var paused bool
 	err := DB.View(func(tx *bolt.Tx) error {
 		var err error
 		gb := tx.Bucket(GlobalBucket)
@@ -205,6 +416,7 @@ func CreateQueue() (*Queue, error) {
 		if err != nil {
 			return err
 		}
+		paused = string(gb.Get([]byte("queuePaused"))) == "true"
 		return nil
 	})
 
@@ -213,8 +425,12 @@ func CreateQueue() (*Queue, error) {
 	}
 
 	Logger.Printf("Creating Queue with %d concurrent builds\n", cb)
+	if paused {
+		Logger.Println("Queue is paused")
+	}
 	q := &Queue{
 		concurrentBuilds: cb,
+		paused:           paused,
 	}
 	return q, nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Resources is a reservation of host resources by a build (`resources` in the
+// job file) or the capacity of the host (`capacity` in the configuration)
+type Resources struct {
+	// Number of CPU cores, can be fractional
+	CPU float64 `yaml:"cpu" json:"cpu"`
+	// Amount of memory, e.g. 4GB
+	Memory string `yaml:"memory" json:"memory"`
+}
+
+// ResourceUsage is an amount of resources with parsed memory
+type ResourceUsage struct {
+	CPU    float64 `json:"cpu"`
+	Memory int64   `json:"memory_bytes"`
+}
+
+// usage parses the resources. Nil resources are empty
+func (r *Resources) usage() (ResourceUsage, error) {
+	if r == nil {
+		return ResourceUsage{}, nil
+	}
+	u := ResourceUsage{CPU: r.CPU}
+	if r.CPU < 0 {
+		return u, fmt.Errorf("cpu can't be negative: %v", r.CPU)
+	}
+	if r.Memory != "" {
+		size, err := ParseSize(r.Memory)
+		if err != nil {
+			return u, err
+		}
+		u.Memory = int64(size)
+	}
+	return u, nil
+}
+
+// fits reports whether the reservation fits into the free resources. Zero
+// capacity means unlimited
+func (u ResourceUsage) fits(reserved ResourceUsage, capacity ResourceUsage) bool {
+	if capacity.CPU > 0 && reserved.CPU+u.CPU > capacity.CPU {
+		return false
+	}
+	if capacity.Memory > 0 && reserved.Memory+u.Memory > capacity.Memory {
+		return false
+	}
+	return true
+}
+
+func (u ResourceUsage) String() string {
+	return fmt.Sprintf("cpu %g, memory %d bytes", u.CPU, u.Memory)
+}
+
+// Used to verify resources before saving after editing
+func (j *Job) verifyResources() error {
+	_, err := j.Resources.usage()
+	return err
+}
+
+// verifyResourcesFitCapacity rejects builds which would never fit into the
+// host capacity
+func (j *Job) verifyResourcesFitCapacity() error {
+	if Config.Capacity == nil || j.Resources == nil {
+		return nil
+	}
+	reservation, err := j.Resources.usage()
+	if err != nil {
+		return err
+	}
+	capacity, err := Config.Capacity.usage()
+	if err != nil {
+		return err
+	}
+	if !reservation.fits(ResourceUsage{}, capacity) {
+		return fmt.Errorf("job %s reserves more resources (%s) than the host capacity (%s)", j.Name, reservation, capacity)
+	}
+	return nil
+}
+
+// reservedResources returns resources reserved by running builds. Has to be
+// called with the queue mutex locked
+func (q *Queue) reservedResources() ResourceUsage {
+	reserved := ResourceUsage{}
+	for _, b := range q.running {
+		u, err := b.Job.Resources.usage()
+		if err != nil {
+			continue
+		}
+		reserved.CPU += u.CPU
+		reserved.Memory += u.Memory
+	}
+	return reserved
+}
+
+// checkResourcesOnTake verifies that the reservation of the build fits into
+// the remaining host capacity. Sets the pending reason otherwise
+func (b *Build) checkResourcesOnTake(reserved ResourceUsage) bool {
+	if Config.Capacity == nil || b.Job.Resources == nil {
+		return true
+	}
+	capacity, err := Config.Capacity.usage()
+	if err != nil {
+		b.Logger.Println(err)
+		return true
+	}
+	reservation, err := b.Job.Resources.usage()
+	if err != nil {
+		b.Logger.Println(err)
+		return true
+	}
+	fits := reservation.fits(reserved, capacity)
+	reason := ""
+	if !fits {
+		reason = fmt.Sprintf("Waiting for resources: requires %s, reserved %s of %s", reservation, reserved, capacity)
+	}
+	b.mutex.Lock()
+	changed := b.PendingReason != reason
+	b.PendingReason = reason
+	b.mutex.Unlock()
+	if changed {
+		b.Logger.Println(reason)
+		go b.BroadcastUpdate()
+	}
+	return fits
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+)
+
+// runTaskWithRetries runs the main task again while it fails and retries
+// remain. All attempts are written to the same log file
+func (b *Build) runTaskWithRetries(task *Task) ItemStatus {
+	for attempt := 1; ; attempt++ {
+		b.mutex.Lock()
+		task.attempts = attempt
+		b.mutex.Unlock()
+
+		status := b.runTask(task)
+		if status != StatusFailed || attempt > task.Retries {
+			return status
+		}
+		// Abort request or the job timeout might be received between attempts
+		if reason := b.takeAbortRequest(task); reason != "" {
+			return ItemStatus(reason)
+		}
+		b.Logger.Printf("Task %d failed, retrying %d/%d\n", task.ID, attempt, task.Retries)
+	}
+}
+
+// takeAbortRequest returns the abort request received while the task wasn't
+// running or an empty string
+func (b *Build) takeAbortRequest(task *Task) string {
+	abortedChannel := b.abortedChannel
+	killChannel := b.killChannel
+	if channels := b.getTaskChannels(task); channels != nil {
+		abortedChannel = channels.aborted
+		killChannel = channels.kill
+	}
+	select {
+	case reason := <-abortedChannel:
+		return reason
+	case <-killChannel:
+		return StatusAborted
+	default:
+		return ""
+	}
+}
+
+// Used to verify task retries before saving after editing
+func (j *Job) verifyTaskRetries() error {
+	for _, task := range j.Tasks {
+		if task.Retries < 0 {
+			return fmt.Errorf("retries of task %s can't be negative: %d", task.Name, task.Retries)
+		}
+	}
+	return nil
+}
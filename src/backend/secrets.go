@@ -38,3 +38,22 @@ func redactSecrets(str string) string {
 	}
 	return str
 }
+
+var secretNameRegex = regexp.MustCompile(`{{\s*secrets\.([A-Za-z0-9_]+)\s*}}`)
+
+// listSecretNames returns names of known secrets referenced in the string
+func listSecretNames(str string) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, match := range secretNameRegex.FindAllStringSubmatch(str, -1) {
+		name := match[1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if _, ok := Config.secrets[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
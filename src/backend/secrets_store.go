@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// SecretsKeyEnv is the environment variable with the secrets key in the same
+// format as lines of Config.SecretsKeyFile. It takes priority over the file
+const SecretsKeyEnv = "WAKE_SECRETS_KEY"
+
+// sealedPrefix marks encrypted values, it is followed by the key ID and
+// base64 encoded nonce and sealed box
+const sealedPrefix = "wake:v1:"
+
+// ErrNoSecretsKey is returned when a sensitive value has to be stored, but the
+// secrets key isn't configured
+var ErrNoSecretsKey = errors.New("secrets key is not configured, set secretskeyfile in the configuration or " + SecretsKeyEnv)
+
+// SecretsKeyring contains keys used to encrypt sensitive values stored in
+// SecretsBucket. Values are encrypted with the active key, other keys are
+// used only to decrypt values encrypted before the key rotation
+type SecretsKeyring struct {
+	keys   map[string]*[32]byte
+	active string
+}
+
+// Keyring is nil if the secrets key isn't configured
+var Keyring *SecretsKeyring
+
+// parseSecretsKey parses `id:base64 encoded 32 bytes key`
+func parseSecretsKey(line string) (string, *[32]byte, error) {
+	id, encoded, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok || id == "" {
+		return "", nil, fmt.Errorf("invalid secrets key format, expected id:base64key")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid secrets key %s: %w", id, err)
+	}
+	if len(data) != 32 {
+		return "", nil, fmt.Errorf("invalid secrets key %s: expected 32 bytes, got %d", id, len(data))
+	}
+	var key [32]byte
+	copy(key[:], data)
+	return id, &key, nil
+}
+
+// LoadSecretsKeyring reads keys from the file (one key per line, the last one
+// is active) and from the environment variable. Returns nil if no keys are
+// configured
+func LoadSecretsKeyring(path string, envKey string) (*SecretsKeyring, error) {
+	k := &SecretsKeyring{keys: make(map[string]*[32]byte)}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id, key, err := parseSecretsKey(line)
+			if err != nil {
+				return nil, err
+			}
+			k.keys[id] = key
+			k.active = id
+		}
+	}
+	if envKey != "" {
+		id, key, err := parseSecretsKey(envKey)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = key
+		k.active = id
+	}
+	if k.active == "" {
+		return nil, nil
+	}
+	return k, nil
+}
+
+// GenerateSecretsKey returns a new random key in the key file format
+func GenerateSecretsKey() (string, string, error) {
+	idB := make([]byte, 4)
+	key := make([]byte, 32)
+	for _, b := range [][]byte{idB, key} {
+		_, err := io.ReadFull(rand.Reader, b)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	id := hex.EncodeToString(idB)
+	return id, id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// IsSealedValue reports whether the value was encrypted by SecretsKeyring
+func IsSealedValue(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(sealedPrefix))
+}
+
+// sealedKeyID returns ID of the key which was used to encrypt the value
+func sealedKeyID(value []byte) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(string(value), sealedPrefix), ":")
+	return id
+}
+
+// Seal encrypts the value with the active key
+func (k *SecretsKeyring) Seal(value []byte) ([]byte, error) {
+	var nonce [24]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	box := secretbox.Seal(nonce[:], value, &nonce, k.keys[k.active])
+	return []byte(sealedPrefix + k.active + ":" + base64.StdEncoding.EncodeToString(box)), nil
+}
+
+// Open decrypts the value sealed with any key of the keyring
+func (k *SecretsKeyring) Open(sealed []byte) ([]byte, error) {
+	if !IsSealedValue(sealed) {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+	id, encoded, _ := strings.Cut(strings.TrimPrefix(string(sealed), sealedPrefix), ":")
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("value is encrypted with unknown secrets key %s", id)
+	}
+	box, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(box) < 24 {
+		return nil, fmt.Errorf("encrypted value is too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], box[:24])
+	value, ok := secretbox.Open(nil, box[24:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("unable to decrypt value with secrets key %s", id)
+	}
+	return value, nil
+}
+
+// PutSecretValue encrypts the value and stores it in the bucket. All
+// sensitive values have to be stored in SecretsBucket (or its sub-buckets)
+func PutSecretValue(b *bolt.Bucket, key []byte, value []byte) error {
+	if Keyring == nil {
+		return ErrNoSecretsKey
+	}
+	sealed, err := Keyring.Seal(value)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, sealed)
+}
+
+// GetSecretValue returns the decrypted value or nil if the key doesn't exist
+func GetSecretValue(b *bolt.Bucket, key []byte) ([]byte, error) {
+	sealed := b.Get(key)
+	if sealed == nil {
+		return nil, nil
+	}
+	if Keyring == nil {
+		return nil, ErrNoSecretsKey
+	}
+	return Keyring.Open(sealed)
+}
+
+// walkSecretValues calls fn for every value in the bucket and its
+// sub-buckets
+func walkSecretValues(b *bolt.Bucket, fn func(b *bolt.Bucket, key, value []byte) error) error {
+	return b.ForEach(func(key, value []byte) error {
+		if value == nil {
+			return walkSecretValues(b.Bucket(key), fn)
+		}
+		return fn(b, key, value)
+	})
+}
+
+// VerifySecretsKeyring verifies that all stored sensitive values can be
+// decrypted with configured keys
+func VerifySecretsKeyring() error {
+	return DB.View(func(tx *bolt.Tx) error {
+		return walkSecretValues(tx.Bucket(SecretsBucket), func(b *bolt.Bucket, key, value []byte) error {
+			if !IsSealedValue(value) {
+				return nil
+			}
+			if Keyring == nil {
+				return fmt.Errorf(
+					"the database contains encrypted values, but the secrets key is not configured. "+
+						"Set secretskeyfile in the configuration or %s to the key which was used to encrypt them",
+					SecretsKeyEnv,
+				)
+			}
+			if _, ok := Keyring.keys[sealedKeyID(value)]; !ok {
+				return fmt.Errorf(
+					"the database contains values encrypted with secrets key %s which is not configured. "+
+						"Add the key to secretskeyfile",
+					sealedKeyID(value),
+				)
+			}
+			return nil
+		})
+	})
+}
+
+// RotateSecretsKey generates a new key, appends it to the key file and
+// re-encrypts all stored sensitive values with it. Previous keys are kept in
+// the file, so they can be removed manually after the rotation
+func RotateSecretsKey(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("key rotation requires secretskeyfile in the configuration")
+	}
+	if os.Getenv(SecretsKeyEnv) != "" {
+		return "", fmt.Errorf("unset %s before the key rotation, the new key is stored in secretskeyfile", SecretsKeyEnv)
+	}
+	id, line, err := GenerateSecretsKey()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(line + "\n")
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	Keyring, err = LoadSecretsKeyring(path, "")
+	if err != nil {
+		return "", err
+	}
+	count := 0
+	err = DB.Update(func(tx *bolt.Tx) error {
+		type update struct {
+			b     *bolt.Bucket
+			key   []byte
+			value []byte
+		}
+		updates := []*update{}
+		err := walkSecretValues(tx.Bucket(SecretsBucket), func(b *bolt.Bucket, key, value []byte) error {
+			plain := value
+			if IsSealedValue(value) {
+				var err error
+				plain, err = Keyring.Open(value)
+				if err != nil {
+					return err
+				}
+			}
+			sealed, err := Keyring.Seal(plain)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, &update{b, append([]byte{}, key...), sealed})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Buckets can't be modified during iteration
+		for _, u := range updates {
+			err = u.b.Put(u.key, u.value)
+			if err != nil {
+				return err
+			}
+		}
+		count = len(updates)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	Logger.Printf("Re-encrypted %d values with secrets key %s\n", count, id)
+	return id, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSecretsKeyring_SealOpen(t *testing.T) {
+	_, oldLine, err := GenerateSecretsKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := LoadSecretsKeyring("", oldLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.Seal([]byte("top secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealedValue(sealed) {
+		t.Errorf("Expected sealed value, got %q", sealed)
+	}
+
+	// Values encrypted with the previous key are still readable after rotation
+	_, newLine, err := GenerateSecretsKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/keys"
+	err = os.WriteFile(path, []byte("# keys\n"+oldLine+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := LoadSecretsKeyring(path, newLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := rotated.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "top secret" {
+		t.Errorf("Expected %q, got %q", "top secret", value)
+	}
+
+	// Characters at the end might contain only padding bits
+	sealed[len(sealed)-10] ^= 1
+	_, err = rotated.Open(sealed)
+	if err == nil {
+		t.Errorf("Expected error for tampered value")
+	}
+}
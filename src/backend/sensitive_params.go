@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SensitiveParamFlag marks params of the entry in `params` as sensitive, e.g.
+//
+//	params:
+//	  - DEPLOY_TOKEN: ""
+//	    sensitive: true
+const SensitiveParamFlag = "sensitive"
+
+// maskedParam replaces values of sensitive params in task logs
+const maskedParam = "***"
+
+// extractSensitiveParams removes SensitiveParamFlag from entries of
+// DefaultParams and remembers names of sensitive params and SecretParams. An
+// entry which contains only the flag is an ordinary param
+func (j *Job) extractSensitiveParams() {
+	for _, pkey := range j.SecretParams {
+		if j.sensitiveParams == nil {
+			j.sensitiveParams = make(map[string]bool)
+		}
+		j.sensitiveParams[pkey] = true
+	}
+	for idx := range j.DefaultParams {
+		flag, ok := j.DefaultParams[idx][SensitiveParamFlag]
+		if !ok || len(j.DefaultParams[idx]) == 1 {
+			continue
+		}
+		delete(j.DefaultParams[idx], SensitiveParamFlag)
+		if flag != "true" {
+			continue
+		}
+		if j.sensitiveParams == nil {
+			j.sensitiveParams = make(map[string]bool)
+		}
+		for pkey := range j.DefaultParams[idx] {
+			j.sensitiveParams[pkey] = true
+		}
+	}
+}
+
+// maskSensitiveParams replaces values of sensitive params of the build with
+// maskedParam. Longer values are replaced first, so a value which contains
+// another one is masked completely
+func (b *Build) maskSensitiveParams(str string) string {
+	if len(b.Job.sensitiveParams) == 0 {
+		return str
+	}
+	var values []string
+	for idx := range b.Params {
+		for pkey, pval := range b.Params[idx] {
+			if !b.Job.sensitiveParams[pkey] {
+				continue
+			}
+			for _, value := range []string{pval, injectSecrets(pval)} {
+				if value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	for _, value := range values {
+		str = strings.ReplaceAll(str, value, maskedParam)
+	}
+	return str
+}
+
+// Used to verify that secret params are declared in `params` before saving
+// after editing
+func (j *Job) verifySecretParams() error {
+	declared := map[string]bool{}
+	for _, param := range j.DefaultParams {
+		for pkey := range param {
+			declared[pkey] = true
+		}
+	}
+	for _, pkey := range j.SecretParams {
+		if !declared[pkey] {
+			return fmt.Errorf("secret param %s is not declared in params", pkey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretParamsMasked(t *testing.T) {
+	setupTestEnv(t)
+	client := &Client{
+		hub:          WSHub,
+		send:         make(chan []byte, 4096),
+		SubscribedTo: []string{"build:log:"},
+		Logger:       Logger,
+	}
+	WSHub.register <- client
+	defer func() {
+		WSHub.unregister <- client
+	}()
+
+	job := &Job{
+		Name: "secret_params",
+		DefaultParams: []map[string]string{
+			{"TOKEN": "tok-3141"},
+			{"API_KEY": "key-2718", SensitiveParamFlag: "true"},
+			{"PUBLIC": "pub-1618"},
+		},
+		SecretParams: []string{"TOKEN"},
+		Tasks: []*Task{{
+			Name:    "print",
+			Command: "echo token=${TOKEN} key=${API_KEY} public=${PUBLIC}",
+			Kind:    KindMain,
+		}},
+	}
+	err := job.verifySecretParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.extractSensitiveParams()
+	build := createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+
+	logB, err := os.ReadFile(build.GetWakespaceDir() + TaskLogFileName(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hub might still be delivering the latest lines
+	var streamed strings.Builder
+	waitFor(t, 5*time.Second, "log lines are streamed", func() bool {
+		for len(client.send) > 0 {
+			msg := readTestMessage(t, client)
+			if msg.Type == "build:log:"+strconv.Itoa(build.ID) {
+				streamed.WriteString(msg.Data.(map[string]interface{})["data"].(string))
+			}
+		}
+		return strings.Count(streamed.String(), "public=pub-1618") >= 2
+	})
+	for name, content := range map[string]string{"log file": string(logB), "stream": streamed.String()} {
+		if strings.Contains(content, "tok-3141") || strings.Contains(content, "key-2718") {
+			t.Errorf("Secret values leaked to the %s: %q", name, content)
+		}
+		// Both the expanded command and the output are masked
+		if strings.Count(content, "token=*** key=*** public=pub-1618") != 2 {
+			t.Errorf("Expected masked values in the %s: %q", name, content)
+		}
+	}
+}
+
+func TestVerifySecretParams(t *testing.T) {
+	job := &Job{
+		DefaultParams: []map[string]string{{"TOKEN": ""}},
+		SecretParams:  []string{"TOKEN", "PASSWORD"},
+	}
+	err := job.verifySecretParams()
+	if err == nil || !strings.Contains(err.Error(), "PASSWORD") {
+		t.Errorf("Expected an error about the undeclared param, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DefaultShell runs commands of tasks if the job doesn't specify Job.Shell
+const DefaultShell = "bash"
+
+// shellCommand returns the shell of the job and its arguments which precede
+// the command. `-c` is used if the shell is specified without arguments, e.g.
+// `shell: sh`, otherwise the arguments are used as is: `shell: pwsh -Command`
+func (j *Job) shellCommand() (string, []string) {
+	fields := strings.Fields(j.Shell)
+	if len(fields) == 0 {
+		return DefaultShell, []string{"-c"}
+	}
+	if len(fields) == 1 {
+		return fields[0], []string{"-c"}
+	}
+	return fields[0], fields[1:]
+}
+
+// checkShell returns error if the shell of the job is not installed
+func (b *Build) checkShell() error {
+	shell, _ := b.Job.shellCommand()
+	_, err := exec.LookPath(shell)
+	if err != nil {
+		return fmt.Errorf("shell %s is not found: %w", shell, err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sasha-s/go-deadlock"
+)
+
+// DefaultShutdownTimeout is how long running builds are waited for on
+// shutdown if ShutdownTimeout is not configured
+const DefaultShutdownTimeout = time.Minute
+
+// ShutdownAbortGrace is how long builds aborted on shutdown are waited for,
+// they might run on_aborted tasks
+var ShutdownAbortGrace = 10 * time.Second
+
+// MsgTypeServerShutdown is sent when the server starts shutting down. Data
+// contains ShutdownData
+const MsgTypeServerShutdown = "server:shutdown"
+
+// ErrServerShuttingDown is returned for new builds while the queue is draining
+var ErrServerShuttingDown = errors.New("server is shutting down, new builds are not accepted")
+
+// ShutdownData is sent to clients when the server starts shutting down
+type ShutdownData struct {
+	// Running builds are aborted after the timeout
+	Timeout string `json:"timeout"`
+}
+
+// HTTPServers are stopped gracefully on shutdown: listeners are closed and
+// requests in progress are completed, see HandleShutdownSignals
+var HTTPServers = &ServerRegistry{}
+
+// ServerRegistry contains HTTP servers of the process
+type ServerRegistry struct {
+	servers []*http.Server
+	mu      deadlock.Mutex
+}
+
+// Add registers the server to be stopped on shutdown
+func (r *ServerRegistry) Add(server *http.Server) *http.Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.servers = append(r.servers, server)
+	return server
+}
+
+// Shutdown stops all servers at the same time. Requests in progress are
+// waited for until the context is done
+func (r *ServerRegistry) Shutdown(ctx context.Context) {
+	r.mu.Lock()
+	servers := append([]*http.Server{}, r.servers...)
+	r.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			err := server.Shutdown(ctx)
+			if err != nil {
+				Logger.Printf("Unable to stop the server on %s gracefully: %s\n", server.Addr, err)
+			}
+		}(server)
+	}
+	wg.Wait()
+}
+
+// serveUntilShutdown runs the server and blocks forever once it is stopped by
+// Shutdown, so the process exits only after the queue is drained
+func serveUntilShutdown(serve func() error) {
+	err := serve()
+	if !errors.Is(err, http.ErrServerClosed) {
+		Logger.Fatal(err)
+	}
+	select {}
+}
+
+// getShutdownTimeout returns how long running builds are waited for on
+// shutdown
+func (c *WakeConfig) getShutdownTimeout() time.Duration {
+	if c.ShutdownTimeout == "" {
+		return DefaultShutdownTimeout
+	}
+	d, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil {
+		Logger.Println(err)
+		return DefaultShutdownTimeout
+	}
+	return d
+}
+
+// isDraining returns true if the queue doesn't accept new builds
+func (q *Queue) isDraining() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.draining
+}
+
+// Drain stops accepting new builds and waits for running builds to complete.
+// Queued builds are aborted, they wouldn't survive the restart anyway.
+// Running builds which don't complete within the timeout are aborted
+func (q *Queue) Drain(timeout time.Duration) {
+	q.mutex.Lock()
+	q.draining = true
+	queued := make([]int, 0, len(q.queued))
+	for _, b := range q.queued {
+		queued = append(queued, b.ID)
+	}
+	q.mutex.Unlock()
+	WSHub.broadcast <- &MsgBroadcast{
+		Type: MsgTypeServerShutdown,
+		Data: &ShutdownData{Timeout: timeout.String()},
+	}
+	for _, id := range queued {
+		Logger.Printf("Build %d is queued, aborting it...\n", id)
+		err := q.Abort(id, StatusAborted)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+
+	running, _ := q.Count()
+	Logger.Printf("Waiting up to %s for %d running builds...\n", timeout, running)
+	if !q.waitEmpty(timeout) {
+		q.mutex.Lock()
+		ids := make([]int, 0, len(q.running))
+		for _, b := range q.running {
+			ids = append(ids, b.ID)
+		}
+		q.mutex.Unlock()
+		for _, id := range ids {
+			Logger.Printf("Build %d is still running, aborting it...\n", id)
+			err := q.Abort(id, StatusAborted)
+			if err != nil {
+				Logger.Println(err)
+			}
+		}
+		if !q.waitEmpty(ShutdownAbortGrace) {
+			Logger.Println("Aborted builds haven't completed in time")
+		}
+	}
+	q.flushAllLogs()
+	if !q.waitIdle(ShutdownAbortGrace) {
+		Logger.Println("Completed builds haven't been finalized in time")
+	}
+}
+
+// waitEmpty waits until there are no running and queued builds. Returns false
+// on timeout
+func (q *Queue) waitEmpty(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		running, queued := q.Count()
+		if running == 0 && queued == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// flushAllLogs asks running builds to flush log buffers. Builds which are
+// busy are skipped, so it never blocks
+func (q *Queue) flushAllLogs() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, b := range q.running {
+		select {
+		case b.flushChannel <- true:
+		default:
+		}
+	}
+}
+
+// HandleShutdownSignals drains the queue on SIGINT or SIGTERM and exits. HTTP
+// servers stop accepting connections at the same time and requests in
+// progress are completed before exiting. The second signal exits immediately
+func HandleShutdownSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		Logger.Printf("Received %s, shutting down...\n", sig)
+		GlobalCron.Stop()
+		go func() {
+			<-signals
+			Logger.Println("Exiting immediately")
+			os.Exit(1)
+		}()
+		timeout := Config.getShutdownTimeout()
+		serversStopped := make(chan struct{})
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout+ShutdownAbortGrace)
+			defer cancel()
+			HTTPServers.Shutdown(ctx)
+			close(serversStopped)
+		}()
+		GlobalQueue.Drain(timeout)
+		<-serversStopped
+		err := DB.Close()
+		if err != nil {
+			Logger.Println(err)
+		}
+		os.Exit(0)
+	}()
+}
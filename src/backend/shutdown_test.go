@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestQueueDrain(t *testing.T) {
+	setupTestEnv(t)
+	defer func(d time.Duration) {
+		ShutdownAbortGrace = d
+	}(ShutdownAbortGrace)
+	ShutdownAbortGrace = 5 * time.Second
+
+	quick := createTestBuild(t, &Job{
+		Name:  "quick",
+		Tasks: []*Task{{Name: "sleep", Command: "sleep 0.2", Kind: KindMain}},
+	})
+	slow := createTestBuild(t, &Job{
+		Name:  "slow",
+		Tasks: []*Task{{Name: "sleep", Command: "sleep 10", Kind: KindMain}},
+	})
+	queued := createTestBuild(t, &Job{
+		Name:  "queued",
+		Tasks: []*Task{{Name: "true", Command: "true", Kind: KindMain}},
+	})
+	waitFor(t, 5*time.Second, "builds are running", func() bool {
+		running, _ := GlobalQueue.Count()
+		return running == 2
+	})
+
+	done := make(chan bool)
+	go func() {
+		GlobalQueue.Drain(time.Second)
+		close(done)
+	}()
+	waitFor(t, 5*time.Second, "the queue is draining", GlobalQueue.isDraining)
+	if _, err := RunJob("quick", url.Values{}, ""); !errors.Is(err, ErrServerShuttingDown) {
+		t.Errorf("Expected new builds to be rejected, got %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Drain hasn't completed")
+	}
+
+	waitForTerminalState(t, quick, time.Second, StatusFinished)
+	waitForTerminalState(t, slow, time.Second, StatusAborted)
+	waitForTerminalState(t, queued, time.Second, StatusAborted)
+}
+
+func TestServerRegistryShutdown(t *testing.T) {
+	registry := &ServerRegistry{}
+	started := make(chan struct{})
+	serve := func(handler http.HandlerFunc) (string, chan error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := registry.Add(&http.Server{Handler: handler})
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve(ln)
+		}()
+		return ln.Addr().String(), served
+	}
+	// The main server has a request in progress, the internal one is idle
+	mainAddr, mainServed := serve(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	internalAddr, internalServed := serve(func(w http.ResponseWriter, r *http.Request) {})
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + mainAddr + "/")
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{string(body), err}
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		registry.Shutdown(ctx)
+		close(stopped)
+	}()
+	for _, served := range []chan error{mainServed, internalServed} {
+		select {
+		case err := <-served:
+			if !errors.Is(err, http.ErrServerClosed) {
+				t.Errorf("Unexpected error of the server: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Server hasn't stopped accepting connections")
+		}
+	}
+	for _, addr := range []string{mainAddr, internalAddr} {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			t.Errorf("Expected connections to %s to be refused", addr)
+		}
+	}
+
+	res := <-response
+	if res.err != nil || res.body != "done" {
+		t.Errorf("Expected the request in progress to complete, got %q %v", res.body, res.err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown hasn't completed")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// SlackMessage is a message of Slack incoming webhook with a single
+// attachment
+type SlackMessage struct {
+	Attachments []*SlackAttachment `json:"attachments"`
+}
+
+// SlackAttachment is a legacy Slack message attachment, the color is shown as
+// a bar next to it
+type SlackAttachment struct {
+	Fallback  string        `json:"fallback"`
+	Color     string        `json:"color"`
+	Title     string        `json:"title"`
+	TitleLink string        `json:"title_link"`
+	Fields    []*SlackField `json:"fields"`
+}
+
+// SlackField is a field of SlackAttachment
+type SlackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackColor returns color of the attachment for the status of the build
+func slackColor(status ItemStatus) string {
+	switch status {
+	case StatusFinished:
+		return "good"
+	case StatusFailed, StatusTimedOut, StatusDiskQuotaExceeded:
+		return "danger"
+	}
+	return "warning"
+}
+
+// newSlackMessage formats the final status of the build
+func (b *Build) newSlackMessage() *SlackMessage {
+	data := b.GenerateBuildUpdateData()
+	title := fmt.Sprintf("#%d %s %s", data.ID, data.Name, data.Status)
+	return &SlackMessage{
+		Attachments: []*SlackAttachment{{
+			Fallback:  fmt.Sprintf("%s: %s", title, getBuildURL(data.ID)),
+			Color:     slackColor(data.Status),
+			Title:     title,
+			TitleLink: getBuildURL(data.ID),
+			Fields: []*SlackField{
+				{Title: "Status", Value: string(data.Status), Short: true},
+				{Title: "Duration", Value: data.Duration.Truncate(time.Second).String(), Short: true},
+			},
+		}},
+	}
+}
+
+// shouldNotify returns true if the status is listed in Job.NotifyOn
+func (b *Build) shouldNotify(status ItemStatus) bool {
+	for _, s := range b.Job.NotifyOn {
+		if ItemStatus(s) == status {
+			return true
+		}
+	}
+	return false
+}
+
+// notifySlack posts the final status of the build to SlackWebhookURL if the
+// job is configured to notify about it. It doesn't block
+func (b *Build) notifySlack(status ItemStatus) {
+	if Config.SlackWebhookURL == "" || !b.shouldNotify(status) {
+		return
+	}
+	go b.postWithRetry("Slack notification", Config.SlackWebhookURL, b.newSlackMessage())
+}
+
+// Used to verify statuses of notify_on before saving after editing
+func (j *Job) verifyNotifyOn() error {
+	for _, status := range j.NotifyOn {
+		switch ItemStatus(status) {
+		case StatusFinished, StatusFailed, StatusAborted, StatusTimedOut, StatusDiskQuotaExceeded, StatusSkipped:
+		default:
+			return fmt.Errorf("notify_on has unknown status %q", status)
+		}
+	}
+	return nil
+}
+
+// verifySlackWebhookURL checks that SlackWebhookURL is an absolute http(s)
+// URL
+func verifySlackWebhookURL(value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("slackwebhookurl has to be an absolute http(s) URL: %s", value)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlackNotification(t *testing.T) {
+	setupTestEnv(t)
+	var mu sync.Mutex
+	var received []*SlackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var msg SlackMessage
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		if err != nil {
+			t.Error(err)
+		}
+		received = append(received, &msg)
+	}))
+	defer server.Close()
+	Config.Port = "8081"
+	Config.SlackWebhookURL = server.URL
+
+	failed := createTestBuild(t, &Job{
+		Name:     "notified",
+		NotifyOn: []string{StatusFailed, StatusAborted},
+		Tasks:    []*Task{{Name: "false", Command: "false", Kind: KindMain}},
+	})
+	waitForTerminalState(t, failed, 5*time.Second, StatusFailed)
+	finished := createTestBuild(t, &Job{
+		Name:     "notified",
+		NotifyOn: []string{StatusFailed, StatusAborted},
+		Tasks:    []*Task{{Name: "true", Command: "true", Kind: KindMain}},
+	})
+	waitForTerminalState(t, finished, 5*time.Second, StatusFinished)
+
+	waitFor(t, 5*time.Second, "notification is delivered", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) > 0
+	})
+	// Give a chance to unexpected notifications
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || len(received[0].Attachments) != 1 {
+		t.Fatalf("Expected a single notification, got %d", len(received))
+	}
+	attachment := received[0].Attachments[0]
+	if attachment.Color != "danger" || attachment.TitleLink != "http://localhost:8081/build/1" || attachment.Title != "#1 notified failed" {
+		t.Errorf("Unexpected attachment %+v", attachment)
+	}
+}
+
+func TestVerifyNotifyOn(t *testing.T) {
+	if err := (&Job{NotifyOn: []string{"failed", "timed out"}}).verifyNotifyOn(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Job{NotifyOn: []string{"broken"}}).verifyNotifyOn(); err == nil {
+		t.Error("Expected error for unknown status")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sasha-s/go-deadlock"
+)
+
+const (
+	// StatusWebhookRetries is the number of attempts to deliver the status
+	StatusWebhookRetries = 5
+	// StatusWebhookTimeout limits duration of a single request
+	StatusWebhookTimeout = 10 * time.Second
+)
+
+// StatusWebhookBackoff is the delay before the first retry, it is doubled
+// after each failed attempt
+var StatusWebhookBackoff = 2 * time.Second
+
+// StatusWebhook mirrors the latest status of the job to an external URL. The
+// status is sent with PUT request on every completed build
+type StatusWebhook struct {
+	URL string `yaml:"url" json:"url"`
+	// Values can contain secrets, e.g. "Bearer {{ secrets.DASHBOARD_TOKEN }}"
+	Headers map[string]string `yaml:"headers" json:"headers"`
+}
+
+// StatusWebhookPayload is the body of the request
+type StatusWebhookPayload struct {
+	Job       string     `json:"job"`
+	Status    ItemStatus `json:"status"`
+	BuildID   int        `json:"build_id"`
+	BuildURL  string     `json:"build_url"`
+	Timestamp time.Time  `json:"timestamp"`
+	// Active notice of the job if the build has failed
+	Notice *JobNotice `json:"notice,omitempty"`
+	// The build is completed, but artifacts or the record may be incomplete
+	FinalizationErrors []string `json:"finalization_errors,omitempty"`
+}
+
+// statusWebhookTarget delivers payloads to the URL one by one. If a newer
+// status arrives while the previous one is being retried, only the newer one
+// is sent
+type statusWebhookTarget struct {
+	webhook *StatusWebhook
+	latest  *StatusWebhookPayload
+	running bool
+}
+
+// StatusWebhookSender keeps delivery state per job and URL
+type StatusWebhookSender struct {
+	targets map[string]*statusWebhookTarget
+	client  *http.Client
+	mu      deadlock.Mutex
+}
+
+// GlobalStatusWebhooks sends status webhooks of all jobs
+var GlobalStatusWebhooks = &StatusWebhookSender{
+	targets: make(map[string]*statusWebhookTarget),
+	client:  &http.Client{Timeout: StatusWebhookTimeout},
+}
+
+// getWakeURL returns base URL of the service
+func getWakeURL() string {
+	if Config.Port == "443" {
+		return fmt.Sprintf("https://%s/", Config.Hostname)
+	}
+	return fmt.Sprintf("http://localhost:%s/", Config.Port)
+}
+
+// getBuildURL returns URL of the build page
+func getBuildURL(id int) string {
+	return fmt.Sprintf("%sbuild/%d", getWakeURL(), id)
+}
+
+// Push schedules delivery of the build status. It doesn't block
+func (s *StatusWebhookSender) Push(b *Build) {
+	if b.Job.StatusWebhook == nil {
+		return
+	}
+	// The build can still be changed by other goroutines, e.g. by aborting
+	b.mutex.Lock()
+	payload := &StatusWebhookPayload{
+		Job:                b.GetJobName(),
+		Status:             b.Status,
+		BuildID:            b.ID,
+		BuildURL:           getBuildURL(b.ID),
+		Timestamp:          time.Now(),
+		FinalizationErrors: append([]string(nil), b.FinalizationErrors...),
+	}
+	b.mutex.Unlock()
+	switch payload.Status {
+	case StatusFailed, StatusTimedOut, StatusDiskQuotaExceeded:
+		notice, err := GetJobNotice(b.Job.Name)
+		if err != nil {
+			Logger.Println(err)
+		}
+		payload.Notice = notice
+	}
+	key := payload.Job + " " + b.Job.StatusWebhook.URL
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target, ok := s.targets[key]
+	if !ok {
+		target = &statusWebhookTarget{}
+		s.targets[key] = target
+	}
+	// Builds can complete out of order, the mirror keeps the latest one
+	if target.latest != nil && target.latest.BuildID > payload.BuildID {
+		return
+	}
+	target.webhook = b.Job.StatusWebhook
+	target.latest = payload
+	if !target.running {
+		target.running = true
+		go s.deliver(key, target)
+	}
+}
+
+// deliver sends the latest payload of the target until there is nothing new
+func (s *StatusWebhookSender) deliver(key string, target *statusWebhookTarget) {
+	var sent *StatusWebhookPayload
+	for {
+		s.mu.Lock()
+		payload, webhook := target.latest, target.webhook
+		if payload == sent {
+			target.running = false
+			delete(s.targets, key)
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		backoff := StatusWebhookBackoff
+		for attempt := 1; attempt <= StatusWebhookRetries; attempt++ {
+			err := s.send(webhook, payload)
+			if err == nil {
+				break
+			}
+			Logger.Printf("Status webhook of job %s, attempt %d/%d: %s\n", payload.Job, attempt, StatusWebhookRetries, err)
+			if attempt == StatusWebhookRetries {
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			// Stop retrying the outdated status
+			s.mu.Lock()
+			outdated := target.latest != payload
+			s.mu.Unlock()
+			if outdated {
+				break
+			}
+		}
+		sent = payload
+	}
+}
+
+func (s *StatusWebhookSender) send(webhook *StatusWebhook, payload *StatusWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, injectSecrets(value))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// Used to verify status webhook before saving after editing
+func (j *Job) verifyStatusWebhook() error {
+	if j.StatusWebhook == nil {
+		return nil
+	}
+	u, err := url.Parse(j.StatusWebhook.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("status_webhook url has to be an absolute http(s) URL: %s", j.StatusWebhook.URL)
+	}
+	return nil
+}
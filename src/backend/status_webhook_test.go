@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusWebhookRetries(t *testing.T) {
+	Logger = log.New(io.Discard, "", 0)
+	Config = &WakeConfig{Port: "8081"}
+	defer func(d time.Duration) {
+		StatusWebhookBackoff = d
+	}(StatusWebhookBackoff)
+	StatusWebhookBackoff = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	var calls int
+	var received StatusWebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if r.Method != http.MethodPut || r.Header.Get("X-Token") != "abc" {
+			t.Errorf("Unexpected request %s with token %q", r.Method, r.Header.Get("X-Token"))
+		}
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	job := &Job{
+		Name:          "mirrored",
+		StatusWebhook: &StatusWebhook{URL: server.URL, Headers: map[string]string{"X-Token": "abc"}},
+	}
+	GlobalStatusWebhooks.Push(&Build{ID: 7, Job: job, Status: StatusFailed})
+
+	waitFor(t, 5*time.Second, "status is delivered", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return received.BuildID == 7
+	})
+	if received.Job != "mirrored" || received.Status != StatusFailed || received.BuildURL != "http://localhost:8081/build/7" {
+		t.Errorf("Unexpected payload %+v", received)
+	}
+}
+
+func TestVerifyStatusWebhook(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"https://status.example.com/jobs/a": true,
+		"http://localhost:8080/":            true,
+		"status.example.com/jobs/a":         false,
+		"ftp://status.example.com/":         false,
+	} {
+		job := &Job{StatusWebhook: &StatusWebhook{URL: value}}
+		err := job.verifyStatusWebhook()
+		if (err == nil) != valid {
+			t.Errorf("Unexpected result for %q: %v", value, err)
+		}
+	}
+}
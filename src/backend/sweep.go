@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// LabelSweepID links builds enqueued by the same run of schedule_matrix
+const LabelSweepID = "SWEEP_ID"
+
+// Overlap policies of scheduled sweeps
+const (
+	OverlapAllow = "allow"
+	OverlapSkip  = "skip"
+)
+
+// ScheduleMatrix runs the job once per param set on every tick of the cron
+// schedule
+type ScheduleMatrix struct {
+	Interval string              `yaml:"interval" json:"interval"`
+	Params   []map[string]string `yaml:"params" json:"params"`
+	// What to do if builds of the previous sweep are still queued or running:
+	// OverlapAllow (default) or OverlapSkip
+	Overlap string `yaml:"overlap" json:"overlap"`
+}
+
+// SweepSchedule is a cron entry of the job's schedule_matrix
+type SweepSchedule struct {
+	Name     string
+	Matrix   *ScheduleMatrix
+	Pausable bool // See Job.Pausable
+}
+
+// Run enqueues a build per param set, all of them share the same SWEEP_ID
+// label
+func (s *SweepSchedule) Run() {
+	if s.Pausable && isMaintenanceActive() {
+		Logger.Printf("Skipping sweep of job %s during maintenance window\n", s.Name)
+		return
+	}
+	if s.Matrix.Overlap == OverlapSkip && GlobalQueue.HasSweep(s.Name) {
+		Logger.Printf("Skipping sweep of job %s: the previous sweep is still running\n", s.Name)
+		return
+	}
+	sweepID := s.Name + "-" + time.Now().Format("20060102-150405")
+	Logger.Printf("Starting sweep %s with %d builds\n", sweepID, len(s.Matrix.Params))
+	for _, set := range s.Matrix.Params {
+		params := url.Values{}
+		for key, value := range set {
+			params.Set(key, value)
+		}
+		build, err := RunJobWithLabels(s.Name, params, TriggerCron, map[string]string{
+			LabelSweepID: sweepID,
+		})
+		if err != nil {
+			Logger.Printf("Unable to schedule a build of sweep %s: %s\n", sweepID, err.Error())
+			continue
+		}
+		build.Logger.Printf("The build is scheduled as part of sweep %s\n", sweepID)
+	}
+}
+
+// Used to verify schedule matrix before saving after editing
+func (j *Job) verifyScheduleMatrix() error {
+	if j.ScheduleMatrix == nil {
+		return nil
+	}
+	_, err := cron.ParseStandard(j.ScheduleMatrix.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid schedule_matrix interval: %w", err)
+	}
+	if len(j.ScheduleMatrix.Params) == 0 {
+		return fmt.Errorf("schedule_matrix requires at least one param set")
+	}
+	switch j.ScheduleMatrix.Overlap {
+	case "", OverlapAllow, OverlapSkip:
+	default:
+		return fmt.Errorf("invalid schedule_matrix overlap value: %s", j.ScheduleMatrix.Overlap)
+	}
+	return nil
+}
+
+// AggregateStatus returns the status of a group of builds: pending or running
+// while any build isn't completed, otherwise the worst status of the builds
+func AggregateStatus(statuses []ItemStatus) ItemStatus {
+	result := ItemStatus(StatusFinished)
+	for _, status := range statuses {
+		switch status {
+		case StatusPending, StatusRunning:
+			return StatusRunning
+		case StatusFinished, StatusSkipped:
+		default:
+			// Keep the first non successful status
+			if result == StatusFinished {
+				result = status
+			}
+		}
+	}
+	return result
+}
+
+// addCronTimezone prepends the configured time zone to the cron spec
+func addCronTimezone(spec string) string {
+	if !strings.HasPrefix(spec, "CRON_TZ=") && Config.Timezone != "" {
+		return "CRON_TZ=" + Config.Timezone + " " + spec
+	}
+	return spec
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestAggregateStatus(t *testing.T) {
+	cases := []struct {
+		statuses []ItemStatus
+		expected ItemStatus
+	}{
+		{[]ItemStatus{StatusFinished, StatusFinished}, StatusFinished},
+		{[]ItemStatus{StatusFinished, StatusFailed, StatusAborted}, StatusFailed},
+		{[]ItemStatus{StatusFailed, StatusPending}, StatusRunning},
+		{[]ItemStatus{}, StatusFinished},
+	}
+	for _, c := range cases {
+		result := AggregateStatus(c.statuses)
+		if result != c.expected {
+			t.Errorf("%v: expected %q, got %q", c.statuses, c.expected, result)
+		}
+	}
+}
+
+func TestNextCronRuns(t *testing.T) {
+	schedule, err := cron.ParseStandard("*/15 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 10, 7, 0, 0, time.UTC)
+	result := nextCronRuns(schedule, start, 3)
+	expected := []time.Time{
+		time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC),
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d runs, got %v", len(expected), result)
+	}
+	for i := range expected {
+		if !result[i].Equal(expected[i]) {
+			t.Errorf("Expected run %d at %s, got %s", i, expected[i], result[i])
+		}
+	}
+}
+
+func TestVerifyInterval_Schedule(t *testing.T) {
+	job := &Job{Schedule: "*/15 * * * *"}
+	if err := job.verifyInterval(); err != nil || job.cronSpec() != "*/15 * * * *" {
+		t.Errorf("Expected schedule to be used as interval, got %q: %v", job.cronSpec(), err)
+	}
+	job.Interval = "@daily"
+	if job.verifyInterval() == nil {
+		t.Error("Expected error when interval and schedule are different")
+	}
+	job = &Job{Schedule: "not a cron"}
+	if job.verifyInterval() == nil {
+		t.Error("Expected error for invalid schedule")
+	}
+}
+
+func TestVerifyInterval_ScheduleOverlap(t *testing.T) {
+	job := &Job{Interval: "@daily", ScheduleOverlap: OverlapSkip}
+	if err := job.verifyInterval(); err != nil {
+		t.Error(err)
+	}
+	job.ScheduleOverlap = "queue"
+	if job.verifyInterval() == nil {
+		t.Error("Expected error for invalid schedule_overlap")
+	}
+}
+
+func TestJobRun_ScheduleOverlap(t *testing.T) {
+	setupTestEnv(t)
+	err := os.WriteFile(Config.JobDir+"overlap.yaml", []byte("schedule_overlap: skip\ntasks:\n  - run: sleep 30\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("overlap"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := CreateJobFromFile(Config.JobDir + "overlap.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := RunJob("overlap", url.Values{}, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for id := build.ID; GlobalQueue.Verify(id); id++ {
+			GlobalQueue.Abort(id, StatusAborted)
+		}
+		waitFor(t, 10*time.Second, "builds are aborted", func() bool {
+			running, queued := GlobalQueue.Count()
+			return running+queued == 0
+		})
+	})
+
+	job.Run()
+	if running, queued := GlobalQueue.Count(); running+queued != 1 {
+		t.Errorf("Expected the cron run to be skipped, got %d running and %d queued builds", running, queued)
+	}
+	job.ScheduleOverlap = OverlapAllow
+	job.Run()
+	if running, queued := GlobalQueue.Count(); running+queued != 2 {
+		t.Errorf("Expected the cron run to be enqueued, got %d running and %d queued builds", running, queued)
+	}
+	data, err := getBuildStatusData(build.ID + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Trigger.Kind != TriggerCron {
+		t.Errorf("Expected the build to be triggered by cron, got %+v", data.Trigger)
+	}
+}
+
+func TestNextJobRuns(t *testing.T) {
+	GlobalCron = cron.New()
+	job := &Job{Name: "nightly", Interval: "0 3 * * *"}
+	_, err := GlobalCron.AddJob(job.Interval, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GlobalCron.AddJob("0 2 * * *", &SweepSchedule{Name: "sweep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 10, 7, 0, 0, time.Local)
+	result := nextJobRuns(start)
+	expected := time.Date(2024, 1, 2, 3, 0, 0, 0, time.Local)
+	if len(result) != 1 || !result["nightly"].Equal(expected) {
+		t.Errorf("Expected the next run of nightly at %s, got %v", expected, result)
+	}
+}
+
+func TestHandleJobsView_Schedule(t *testing.T) {
+	setupTestEnv(t)
+	GlobalCron = cron.New()
+	err := os.WriteFile(Config.JobDir+"nightly.yaml", []byte("schedule: \"0 3 * * *\"\ntasks:\n  - run: \"true\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rescanning jobs must not schedule the job twice
+	for i := 0; i < 2; i++ {
+		err = ScanAllJobs()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(GlobalCron.Entries()) != 1 {
+		t.Fatalf("Expected the job to be scheduled once, got %d entries", len(GlobalCron.Entries()))
+	}
+
+	w := httptest.NewRecorder()
+	HandleJobsView(w, httptest.NewRequest(http.MethodGet, "/api/jobs/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	var data []*JobsListData
+	err = json.Unmarshal(w.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0].Interval != "0 3 * * *" || data[0].NextRun == nil {
+		t.Fatalf("Expected the schedule and the next run of the job, got %+v", data)
+	}
+	// Missed runs are not backfilled, the next run is always ahead
+	next := *data[0].NextRun
+	if !next.After(time.Now()) || next.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("Expected the next run within a day, got %s", next)
+	}
+	if next.Hour() != 3 || next.Minute() != 0 {
+		t.Errorf("Expected the next run at 03:00, got %s", next)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// StatusTaskAborted is returned by runTask when only the task was aborted,
+// see Build.RequestTaskAbort. The task gets StatusAborted
+const StatusTaskAborted = "task aborted"
+
+// RequestTaskAbort stops the running main task, other tasks of the build are
+// not affected. The build continues if the task is Task.Skippable, otherwise
+// it fails
+func (b *Build) RequestTaskAbort(taskID int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, task := range b.Job.Tasks {
+		if task.ID != taskID {
+			continue
+		}
+		if task.Kind != KindMain || task.Status != StatusRunning || task.abortChannel == nil {
+			return fmt.Errorf("task %d of build %d is not running", taskID, b.ID)
+		}
+		b.Logger.Printf("Abort of task %d requested\n", taskID)
+		select {
+		case task.abortChannel <- true:
+		default:
+		}
+		return nil
+	}
+	return fmt.Errorf("task %d of build %d not found", taskID, b.ID)
+}
+
+// getTaskAbortChannel returns the channel of abort requests of the task
+func (b *Build) getTaskAbortChannel(task *Task) chan bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return task.abortChannel
+}
+
+// checkTaskAborted returns the status of the build step after the task was
+// aborted alone: finished if the task is skippable, otherwise failed
+func (b *Build) checkTaskAborted(task *Task, status ItemStatus) ItemStatus {
+	if status != StatusTaskAborted {
+		return status
+	}
+	if task.Skippable {
+		b.Logger.Printf("Task %d was aborted, it is skippable\n", task.ID)
+		b.addWarning(fmt.Sprintf("Task %s was aborted (skippable)", task.Name))
+		return StatusFinished
+	}
+	b.addWarning(fmt.Sprintf("Task %s was aborted", task.Name))
+	return StatusFailed
+}
+
+// AbortTask stops the running main task of the build
+func (q *Queue) AbortTask(buildID int, taskID int) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, item := range q.running {
+		if item.ID == buildID {
+			return item.RequestTaskAbort(taskID)
+		}
+	}
+	return fmt.Errorf("build %d is not running", buildID)
+}
+
+// HandleAbortTask aborts a single task of the build
+// @Summary      Abort a running task of the build
+// @Description  Only the main task is stopped (SIGTERM, then SIGKILL). The build continues if the task is `skippable`, otherwise the build fails
+// @Tags         build
+// @Produce      plain
+// @Param        id       path    integer   true  "Build ID"
+// @Param        taskID   path    integer   true  "Task ID"
+// @Success      200      {string}   string
+// @Failure      400      {string}   http.StatusBadRequest
+// @Failure      404      {string}   http.StatusNotFound
+// @Router       /build/{id}/task/{taskID}/abort [post]
+func HandleAbortTask(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	taskID, err := strconv.Atoi(chi.URLParam(r, "taskID"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = GlobalQueue.AbortTask(buildID, taskID)
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
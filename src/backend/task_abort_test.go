@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAbortTask(t *testing.T) {
+	cases := []struct {
+		skippable bool
+		status    ItemStatus
+		// Status of the task after the aborted one
+		next ItemStatus
+	}{
+		{true, StatusFinished, StatusFinished},
+		{false, StatusFailed, StatusPending},
+	}
+	for _, c := range cases {
+		setupTestEnv(t)
+		job := &Job{
+			Name: "abort_task",
+			Tasks: []*Task{
+				{Name: "upload docs", Command: "sleep 10", Kind: KindMain, Skippable: c.skippable},
+				{Name: "deploy", Command: "true", Kind: KindMain},
+			},
+		}
+		build := createTestBuild(t, job)
+
+		waitFor(t, 5*time.Second, "the task is running", func() bool {
+			return build.GenerateBuildUpdateData().Tasks[0].Status == StatusRunning
+		})
+		if err := GlobalQueue.AbortTask(build.ID, 1); err == nil {
+			t.Error("Expected error for the task which is not running")
+		}
+		err := GlobalQueue.AbortTask(build.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitForTerminalState(t, build, 5*time.Second, c.status)
+		data, err := getBuildStatusData(build.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Tasks[0].Status != StatusAborted || data.Tasks[1].Status != c.next {
+			t.Errorf("skippable %v: unexpected statuses of tasks %s, %s", c.skippable, data.Tasks[0].Status, data.Tasks[1].Status)
+		}
+	}
+}
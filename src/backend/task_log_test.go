@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskLogKey(t *testing.T) {
+	cases := []struct {
+		iteration int
+		key       string
+		fileName  string
+	}{
+		{0, "3", "task_3.log"},
+		{2, "3.2", "task_3_2.log"},
+	}
+	for _, c := range cases {
+		if key := TaskLogKey(3, c.iteration); key != c.key {
+			t.Errorf("Expected key %q, got %q", c.key, key)
+		}
+		if fileName := TaskLogFileName(3, c.iteration); fileName != c.fileName {
+			t.Errorf("Expected file name %q, got %q", c.fileName, fileName)
+		}
+	}
+	task := &Task{ID: 3}
+	if task.LogKey() != "3" || task.LogFileName() != "task_3.log" {
+		t.Errorf("Unexpected key %q and file name %q of the task", task.LogKey(), task.LogFileName())
+	}
+}
+
+func TestProcessLogEntry_Key(t *testing.T) {
+	setupTestEnv(t)
+	client := &Client{
+		hub:          WSHub,
+		send:         make(chan []byte, 16),
+		SubscribedTo: []string{"build:log:"},
+		Logger:       Logger,
+	}
+	WSHub.register <- client
+	defer func() {
+		WSHub.unregister <- client
+	}()
+
+	tasks := []*Task{{ID: 0, Kind: KindMain}, {ID: 1, Kind: KindMain}}
+	build := &Build{ID: 7, Job: &Job{Name: "log_key", Tasks: tasks}, Logger: log.New(io.Discard, "", 0)}
+	var buffer strings.Builder
+	bw := bufio.NewWriter(&buffer)
+	for i, task := range tasks {
+		build.ProcessLogEntry("line", bw, task, time.Now())
+		msg := readTestMessage(t, client)
+		data := msg.Data.(map[string]interface{})
+		if msg.Type != "build:log:7" || data["taskID"] != float64(task.ID) || data["iteration"] != 0.0 || data["key"] != task.LogKey() {
+			t.Errorf("%d: unexpected message %s %v", i, msg.Type, data)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// errTaskStarted is returned by RequestTaskSkip if the task can't be skipped
+// anymore
+var errTaskStarted = errors.New("task has already started")
+
+// RequestTaskSkip marks the main task which hasn't started yet as skipped, the
+// build passes over it when the task is reached
+func (b *Build) RequestTaskSkip(taskID int) error {
+	b.mutex.Lock()
+	var found *Task
+	for _, task := range b.Job.Tasks {
+		if task.ID == taskID && task.Kind == KindMain {
+			found = task
+		}
+	}
+	if found == nil {
+		b.mutex.Unlock()
+		return fmt.Errorf("main task %d of build %d not found", taskID, b.ID)
+	}
+	if found.Status != StatusPending || found.skipRequested {
+		status := found.Status
+		b.mutex.Unlock()
+		return fmt.Errorf("task %d of build %d is %s: %w", taskID, b.ID, status, errTaskStarted)
+	}
+	found.skipRequested = true
+	found.Status = StatusSkipped
+	b.mutex.Unlock()
+
+	b.Logger.Printf("Task %d will be skipped\n", taskID)
+	b.addWarning(fmt.Sprintf("Task %s was skipped on request", found.Name))
+	b.BroadcastUpdate()
+	return nil
+}
+
+// SkipTask skips the pending main task of the queued or running build
+func (q *Queue) SkipTask(buildID int, taskID int) error {
+	q.mutex.Lock()
+	var build *Build
+	for _, list := range [][]*Build{q.running, q.queued} {
+		for _, item := range list {
+			if item.ID == buildID {
+				build = item
+			}
+		}
+	}
+	q.mutex.Unlock()
+	if build == nil {
+		return fmt.Errorf("build %d is not queued or running", buildID)
+	}
+	return build.RequestTaskSkip(taskID)
+}
+
+// HandleSkipTask skips a single task of the build
+// @Summary      Skip a pending task of the build
+// @Description  The main task is marked as skipped and the build passes over it. Tasks which have already started can't be skipped
+// @Tags         build
+// @Produce      plain
+// @Param        id       path    integer   true  "Build ID"
+// @Param        taskID   path    integer   true  "Task ID"
+// @Success      200      {string}   string
+// @Failure      400      {string}   http.StatusBadRequest
+// @Failure      404      {string}   http.StatusNotFound
+// @Failure      409      {string}   http.StatusConflict
+// @Router       /build/{id}/task/{taskID}/skip [post]
+func HandleSkipTask(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+	buildID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	taskID, err := strconv.Atoi(chi.URLParam(r, "taskID"))
+	if err != nil {
+		logger.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+	err = GlobalQueue.SkipTask(buildID, taskID)
+	if err != nil {
+		logger.Println(err)
+		if errors.Is(err, errTaskStarted) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(err.Error()))
+		return
+	}
+}
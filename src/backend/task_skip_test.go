@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestSkipTask(t *testing.T) {
+	for _, parallel := range []int{0, 2} {
+		setupTestEnv(t)
+		job := &Job{
+			Name: "skip_task",
+			Tasks: []*Task{
+				{Name: "build", Command: "sleep 0.5", Kind: KindMain},
+				{Name: "test", Command: "sleep 0.5", Kind: KindMain},
+				{Name: "deploy", Command: "touch deployed", Kind: KindMain},
+			},
+			Parallel: parallel,
+		}
+		build := createTestBuild(t, job)
+
+		router := chi.NewRouter()
+		router.Post("/build/{id}/task/{taskID}/skip", HandleSkipTask)
+		skip := func(buildID, taskID int) int {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/build/%d/task/%d/skip", buildID, taskID), nil))
+			return w.Code
+		}
+
+		waitFor(t, 5*time.Second, "the task is running", func() bool {
+			return build.GenerateBuildUpdateData().Tasks[0].Status == StatusRunning
+		})
+		if code := skip(build.ID, 2); code != http.StatusOK {
+			t.Fatalf("parallel %d: unexpected response %d", parallel, code)
+		}
+		if status := build.GenerateBuildUpdateData().Tasks[2].Status; status != StatusSkipped {
+			t.Errorf("parallel %d: expected the task to be skipped at once, got %s", parallel, status)
+		}
+		for _, c := range []struct {
+			buildID int
+			taskID  int
+			code    int
+		}{
+			{build.ID, 0, http.StatusConflict},
+			{build.ID, 2, http.StatusConflict},
+			{build.ID, 5, http.StatusNotFound},
+			{build.ID + 1, 1, http.StatusNotFound},
+		} {
+			if code := skip(c.buildID, c.taskID); code != c.code {
+				t.Errorf("parallel %d: expected %d for task %d of build %d, got %d", parallel, c.code, c.taskID, c.buildID, code)
+			}
+		}
+
+		waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+		data, err := getBuildStatusData(build.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range []ItemStatus{StatusFinished, StatusFinished, StatusSkipped} {
+			if data.Tasks[i].Status != expected {
+				t.Errorf("parallel %d: expected task %d to be %s, got %s", parallel, i, expected, data.Tasks[i].Status)
+			}
+		}
+		if _, err := os.Stat(build.GetWorkspaceDir() + "deployed"); !os.IsNotExist(err) {
+			t.Errorf("parallel %d: expected the skipped task not to run", parallel)
+		}
+	}
+}
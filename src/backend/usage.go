@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sasha-s/go-deadlock"
+	bolt "go.etcd.io/bbolt"
+)
+
+// UsageFlushPeriod is a period to save collected usage counters in database
+const UsageFlushPeriod = 1 * time.Minute
+
+// BuildUsage contains information about how often artifacts of the build
+// were downloaded
+type BuildUsage struct {
+	Downloads    int       `json:"downloads"`
+	LastDownload time.Time `json:"last_download"`
+}
+
+// UsageTracker collects usage counters in memory and periodically saves them
+// in database to avoid a write transaction per request
+type UsageTracker struct {
+	downloads    map[int]int
+	lastDownload map[int]time.Time
+	views        map[string]int
+	mu           deadlock.Mutex
+}
+
+// RecordDownload increments number of artifact downloads of the build
+func (u *UsageTracker) RecordDownload(buildID int) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.downloads[buildID]++
+	u.lastDownload[buildID] = time.Now()
+}
+
+// RecordView increments number of build page views of the job
+func (u *UsageTracker) RecordView(jobName string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.views[jobName]++
+}
+
+// Flush saves collected counters in database
+func (u *UsageTracker) Flush() {
+	u.mu.Lock()
+	downloads := u.downloads
+	lastDownload := u.lastDownload
+	views := u.views
+	u.downloads = make(map[int]int)
+	u.lastDownload = make(map[int]time.Time)
+	u.views = make(map[string]int)
+	u.mu.Unlock()
+
+	if len(downloads) == 0 && len(views) == 0 {
+		return
+	}
+
+	err := DB.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(UsageBucket)
+		for buildID, count := range downloads {
+			usage := BuildUsage{}
+			data := ub.Get(Itob(buildID))
+			if data != nil {
+				err := json.Unmarshal(data, &usage)
+				if err != nil {
+					Logger.Println(err)
+				}
+			}
+			usage.Downloads += count
+			usage.LastDownload = lastDownload[buildID]
+			usageB, err := json.Marshal(usage)
+			if err != nil {
+				return err
+			}
+			err = ub.Put(Itob(buildID), usageB)
+			if err != nil {
+				return err
+			}
+		}
+
+		jb := tx.Bucket(JobsBucket)
+		for jobName, count := range views {
+			b := jb.Bucket([]byte(jobName))
+			if b == nil {
+				continue
+			}
+			current, err := ByteToInt(b.Get([]byte("views")))
+			if err != nil {
+				current = 0
+			}
+			err = b.Put([]byte("views"), IntToByte(current+count))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		Logger.Println(err)
+	}
+}
+
+// startFlushing runs Flush every d
+func (u *UsageTracker) startFlushing(d time.Duration) {
+	ticker := time.NewTicker(d)
+	go func() {
+		for range ticker.C {
+			u.Flush()
+		}
+	}()
+}
+
+// GetBuildUsage returns usage information of the build
+func GetBuildUsage(tx *bolt.Tx, buildID int) *BuildUsage {
+	usage := BuildUsage{}
+	data := tx.Bucket(UsageBucket).Get(Itob(buildID))
+	if data != nil {
+		err := json.Unmarshal(data, &usage)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+	return &usage
+}
+
+// CreateUsageTracker creates and returns new usage tracker. Returns nil if
+// usage tracking is disabled
+func CreateUsageTracker(d time.Duration) *UsageTracker {
+	if Config.DisableUsageStats {
+		Logger.Println("Usage tracking is disabled")
+		return nil
+	}
+	u := &UsageTracker{
+		downloads:    make(map[int]int),
+		lastDownload: make(map[int]time.Time),
+		views:        make(map[string]int),
+	}
+	u.startFlushing(d)
+	return u
+}
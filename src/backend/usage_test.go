@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// setupTestUsage enables usage tracking with the flush period d
+func setupTestUsage(t *testing.T, d time.Duration) {
+	GlobalUsage = CreateUsageTracker(d)
+	t.Cleanup(func() {
+		GlobalUsage = nil
+	})
+	err := DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("a"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getJobViews returns the number of build page views of the job saved in
+// database
+func getJobViews(name string) int {
+	views := 0
+	DB.View(func(tx *bolt.Tx) error {
+		views, _ = ByteToInt(tx.Bucket(JobsBucket).Bucket([]byte(name)).Get([]byte("views")))
+		return nil
+	})
+	return views
+}
+
+// getSavedBuildUsage returns usage of the build saved in database
+func getSavedBuildUsage(id int) *BuildUsage {
+	var usage *BuildUsage
+	DB.View(func(tx *bolt.Tx) error {
+		usage = GetBuildUsage(tx, id)
+		return nil
+	})
+	return usage
+}
+
+// usageTestRouter serves requests which are counted by the usage tracker
+func usageTestRouter() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/api/build/{id}", HandleGetBuild)
+	router.Get("/api/build/{id}/artifacts.zip", HandleDownloadArtifacts)
+	router.Get("/api/build/{id}/artifacts/*", HandleDownloadArtifact)
+	router.Get("/storage/build/*", HandleWakespaceResource(http.FileServer(http.Dir(Config.WorkDir+"wakespace/"))).ServeHTTP)
+	return router
+}
+
+// putTestBuildPlan saves the build config which is returned with the build
+func putTestBuildPlan(t *testing.T, id int) {
+	err := os.WriteFile((&Build{ID: id}).GetBuildConfigFilename(), []byte("desc: a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsageTracker_Flush(t *testing.T) {
+	setupTestEnv(t)
+	setupTestUsage(t, time.Hour)
+
+	GlobalUsage.RecordDownload(1)
+	GlobalUsage.RecordDownload(1)
+	GlobalUsage.RecordView("a")
+	// Views of removed jobs are ignored
+	GlobalUsage.RecordView("removed")
+	if usage := getSavedBuildUsage(1); usage.Downloads != 0 {
+		t.Fatalf("Expected counters to be saved only on flush, got %d downloads", usage.Downloads)
+	}
+	GlobalUsage.Flush()
+	usage := getSavedBuildUsage(1)
+	if usage.Downloads != 2 || time.Since(usage.LastDownload) > time.Minute {
+		t.Errorf("Expected 2 downloads, got %+v", usage)
+	}
+	if views := getJobViews("a"); views != 1 {
+		t.Errorf("Expected 1 view, got %d", views)
+	}
+
+	// Counters are added to the saved ones
+	GlobalUsage.RecordDownload(1)
+	GlobalUsage.RecordView("a")
+	GlobalUsage.Flush()
+	GlobalUsage.Flush()
+	if usage := getSavedBuildUsage(1); usage.Downloads != 3 {
+		t.Errorf("Expected 3 downloads, got %d", usage.Downloads)
+	}
+	if views := getJobViews("a"); views != 2 {
+		t.Errorf("Expected 2 views, got %d", views)
+	}
+}
+
+func TestUsageTracker_PeriodicFlush(t *testing.T) {
+	setupTestEnv(t)
+	setupTestUsage(t, 50*time.Millisecond)
+
+	GlobalUsage.RecordDownload(1)
+	GlobalUsage.RecordView("a")
+	waitFor(t, 5*time.Second, "counters are saved", func() bool {
+		return getSavedBuildUsage(1).Downloads == 1 && getJobViews("a") == 1
+	})
+}
+
+func TestUsageTracker_Handlers(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		setupTestEnv(t)
+		Config.DisableUsageStats = disabled
+		setupTestUsage(t, time.Hour)
+		if disabled && GlobalUsage != nil {
+			t.Fatal("Expected no usage tracker when usage stats are disabled")
+		}
+		putTestBuildWithArtifact(t, 1, "a", time.Now())
+		putTestBuildPlan(t, 1)
+
+		router := usageTestRouter()
+		for _, path := range []string{
+			"/api/build/1",
+			"/api/build/1/artifacts.zip",
+			"/api/build/1/artifacts/out.txt",
+			"/storage/build/1/artifacts/out.txt",
+			// Not an artifact
+			"/storage/build/1/build_plan.yaml",
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("disabled %t, %s: unexpected response %d %s", disabled, path, w.Code, w.Body.String())
+			}
+		}
+
+		w := httptest.NewRecorder()
+		HandleUsageStats(w, httptest.NewRequest(http.MethodGet, "/api/stats/usage", nil))
+		if disabled {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected 404 when usage stats are disabled, got %d", w.Code)
+			}
+			if usage := getSavedBuildUsage(1); usage.Downloads != 0 || getJobViews("a") != 0 {
+				t.Errorf("Expected nothing to be recorded, got %+v and %d views", usage, getJobViews("a"))
+			}
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+		}
+		var stats UsageStatsData
+		err := json.Unmarshal(w.Body.Bytes(), &stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats.Jobs) != 1 || stats.Jobs[0].Name != "a" || stats.Jobs[0].Views != 1 {
+			t.Errorf("Expected 1 view of job a, got %+v", stats.Jobs)
+		}
+		if len(stats.Builds) != 1 || stats.Builds[0].ID != 1 || stats.Builds[0].Downloads != 3 || stats.Builds[0].Artifacts != 1 {
+			t.Errorf("Expected 3 downloads of build 1, got %+v", stats.Builds)
+		}
+	}
+}
+
+func TestCleanUnusedArtifacts(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		setupTestEnv(t)
+		Config.UnusedArtifactsTTL = "24h"
+		Config.DisableUsageStats = disabled
+		setupTestUsage(t, time.Hour)
+		old := time.Now().Add(-48 * time.Hour)
+		// Never downloaded
+		putTestBuildWithArtifact(t, 1, "a", old)
+		// Downloaded, the counter isn't saved yet
+		putTestBuildWithArtifact(t, 2, "a", old)
+		GlobalUsage.RecordDownload(2)
+		// Completed recently
+		putTestBuildWithArtifact(t, 3, "a", time.Now())
+
+		cl := Cleaner{Logger: Logger}
+		cl.CleanUnusedArtifacts()
+		for id, removed := range map[int]bool{1: !disabled, 2: false, 3: false} {
+			_, err := os.Stat(Config.WorkDir + "wakespace/" + strconv.Itoa(id) + "/artifacts/out.txt")
+			if os.IsNotExist(err) != removed {
+				t.Errorf("disabled %t, build %d: expected artifacts removed %t", disabled, id, removed)
+			}
+			data, err := getBuildStatusData(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.ArtifactsPurged != removed {
+				t.Errorf("disabled %t, build %d: expected purged %t, got %t", disabled, id, removed, data.ArtifactsPurged)
+			}
+		}
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"math/rand"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -36,3 +41,64 @@ func NormalizeNewlines(d []byte) []byte {
 	d = bytes.Replace(d, []byte{13}, []byte{10}, -1)
 	return d
 }
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+	"G":  1 << 30,
+	"GB": 1 << 30,
+	"T":  1 << 40,
+	"TB": 1 << 40,
+}
+
+// ParseSize converts human readable size, e.g. 512MB or 2G, to bytes
+func ParseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	pos := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if pos == -1 {
+		pos = len(size)
+	}
+	number, unit := size[:pos], strings.TrimSpace(size[pos:])
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", size)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("size can't be negative: %q", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// copyFile copies content of src to dst and sets the mode of dst
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	// Create applies umask, the mode is set explicitly
+	return os.Chmod(dst, mode.Perm())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"1024":  1024,
+		"10B":   10,
+		"2K":    2048,
+		"1.5MB": 1572864,
+		"3 gb":  3221225472,
+		"1T":    1099511627776,
+	}
+	for input, expected := range cases {
+		result, err := ParseSize(input)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", input, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("Expected %d for %q, got %d", expected, input, result)
+		}
+	}
+}
+
+func TestParseSize_Invalid(t *testing.T) {
+	for _, input := range []string{"", "MB", "10XB", "-5M"} {
+		_, err := ParseSize(input)
+		if err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/run.sh", []byte("#!/bin/sh\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = copyFile(dir+"/run.sh", dir+"/copy.sh", 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir + "/copy.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("Expected mode 0750, got %o", info.Mode().Perm())
+	}
+	content, _ := os.ReadFile(dir + "/copy.sh")
+	if string(content) != "#!/bin/sh\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if err := copyFile(dir+"/missing", dir+"/out", 0644); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// WebhookSignatureHeader contains HMAC-SHA256 of the request body, the same
+// header is sent by GitHub
+const WebhookSignatureHeader = "X-Hub-Signature-256"
+
+// WebhookEventHeader contains the type of the GitHub event, e.g. push
+const WebhookEventHeader = "X-GitHub-Event"
+
+// Params set for builds started by a push, see Job.Branches
+const (
+	WebhookCommitParam = "GIT_COMMIT"
+	WebhookBranchParam = "GIT_BRANCH"
+)
+
+// PushEvent is the part of the GitHub push payload which identifies the pushed
+// commit
+type PushEvent struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// Branch returns the name of the pushed branch, empty if a tag is pushed
+func (e *PushEvent) Branch() string {
+	if !strings.HasPrefix(e.Ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/heads/")
+}
+
+// parsePushEvent returns nil if the webhook is not a push. Requests without
+// the event header are pushes if the body has a ref
+func parsePushEvent(header http.Header, body []byte) *PushEvent {
+	if event := header.Get(WebhookEventHeader); event != "" && event != "push" {
+		return nil
+	}
+	var push PushEvent
+	err := json.Unmarshal(body, &push)
+	if err != nil || push.Ref == "" {
+		return nil
+	}
+	return &push
+}
+
+// matchesBranches returns true if the job has to be started by the webhook.
+// Jobs without Branches are started by any webhook
+func (j *Job) matchesBranches(push *PushEvent) bool {
+	if len(j.Branches) == 0 {
+		return true
+	}
+	if push == nil || push.Branch() == "" {
+		return false
+	}
+	for _, pattern := range j.Branches {
+		matched, _ := doublestar.Match(pattern, push.Branch())
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Used to verify branches before saving after editing
+func (j *Job) verifyBranches() error {
+	for _, pattern := range j.Branches {
+		// Unlike doublestar, path.Match reports malformed patterns without
+		// a match
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid branch pattern %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// verifyWebhookSignature returns an error if the signature in the form
+// sha256=<hex> doesn't match the body
+func verifyWebhookSignature(secret string, body []byte, signature string) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return fmt.Errorf("%s header is missing or invalid", WebhookSignatureHeader)
+	}
+	received, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return fmt.Errorf("%s header is invalid: %w", WebhookSignatureHeader, err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return fmt.Errorf("signature of the webhook doesn't match")
+	}
+	return nil
+}
+
+// parseWebhookPath splits a path like $.commits[0].id into field names and
+// array indexes
+func parseWebhookPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$.") {
+		return nil, fmt.Errorf("path %s has to start with $.", path)
+	}
+	elements := make([]string, 0)
+	for _, part := range strings.Split(path[2:], ".") {
+		key, indexes := part, ""
+		if idx := strings.Index(part, "["); idx >= 0 {
+			key, indexes = part[:idx], part[idx:]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("path %s has an empty field name", path)
+		}
+		elements = append(elements, key)
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if !strings.HasPrefix(indexes, "[") || end < 0 {
+				return nil, fmt.Errorf("path %s has an invalid index", path)
+			}
+			if _, err := strconv.Atoi(indexes[1:end]); err != nil {
+				return nil, fmt.Errorf("path %s has an invalid index: %s", path, indexes[1:end])
+			}
+			elements = append(elements, indexes[:end+1])
+			indexes = indexes[end+1:]
+		}
+	}
+	return elements, nil
+}
+
+// extractWebhookValue returns the value at the path in the decoded JSON body.
+// Strings are returned as is, other values are encoded as JSON. False if the
+// body doesn't have the value
+func extractWebhookValue(body interface{}, path string) (string, bool) {
+	elements, err := parseWebhookPath(path)
+	if err != nil {
+		return "", false
+	}
+	value := body
+	for _, element := range elements {
+		if strings.HasPrefix(element, "[") {
+			items, ok := value.([]interface{})
+			idx, _ := strconv.Atoi(element[1 : len(element)-1])
+			if !ok || idx < 0 || idx >= len(items) {
+				return "", false
+			}
+			value = items[idx]
+			continue
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value, ok = fields[element]
+		if !ok {
+			return "", false
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	}
+	valueB, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(valueB), true
+}
+
+// webhookParams maps fields of the JSON body to params of the build according
+// to Job.WebhookParams. Fields missing in the body keep default values
+func (j *Job) webhookParams(body []byte) (url.Values, error) {
+	params := url.Values{}
+	if len(j.WebhookParams) == 0 {
+		return params, nil
+	}
+	var decoded interface{}
+	err := json.Unmarshal(body, &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse body of the webhook: %w", err)
+	}
+	for name, path := range j.WebhookParams {
+		value, ok := extractWebhookValue(decoded, path)
+		if ok {
+			params.Set(name, value)
+		}
+	}
+	return params, nil
+}
+
+// Used to verify webhook params before saving after editing
+func (j *Job) verifyWebhookParams() error {
+	for name, path := range j.WebhookParams {
+		declared := false
+		for _, param := range j.DefaultParams {
+			if _, ok := param[name]; ok {
+				declared = true
+			}
+		}
+		if !declared {
+			return fmt.Errorf("webhook param %s is not declared in params", name)
+		}
+		if _, err := parseWebhookPath(path); err != nil {
+			return fmt.Errorf("webhook param %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+const testWebhookBody = `{
+	"ref": "refs/heads/main",
+	"head_commit": {"id": "5f2c9a1"},
+	"commits": [{"author": {"name": "octocat"}, "added": ["a.go"]}]
+}`
+
+func signWebhookBody(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookParams(t *testing.T) {
+	job := &Job{
+		DefaultParams: []map[string]string{{"COMMIT_SHA": ""}, {"AUTHOR": ""}, {"ADDED": ""}, {"TAG": "none"}},
+		WebhookParams: map[string]string{
+			"COMMIT_SHA": "$.head_commit.id",
+			"AUTHOR":     "$.commits[0].author.name",
+			"ADDED":      "$.commits[0].added",
+			"TAG":        "$.release.tag_name",
+		},
+	}
+	if err := job.verifyWebhookParams(); err != nil {
+		t.Fatal(err)
+	}
+	params, err := job.webhookParams([]byte(testWebhookBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"COMMIT_SHA": "5f2c9a1", "AUTHOR": "octocat", "ADDED": `["a.go"]`, "TAG": ""}
+	for name, value := range expected {
+		if params.Get(name) != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, params.Get(name))
+		}
+	}
+
+	for _, path := range []string{"head_commit.id", "$.", "$.commits[x]", "$.commits[0", "$..id"} {
+		job.WebhookParams = map[string]string{"COMMIT_SHA": path}
+		if job.verifyWebhookParams() == nil {
+			t.Errorf("Expected error for path %q", path)
+		}
+	}
+	job.WebhookParams = map[string]string{"UNKNOWN": "$.ref"}
+	if job.verifyWebhookParams() == nil {
+		t.Error("Expected error for the param which is not declared")
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(testWebhookBody)
+	if err := verifyWebhookSignature("s3cr3t", body, signWebhookBody("s3cr3t", testWebhookBody)); err != nil {
+		t.Error(err)
+	}
+	for _, signature := range []string{"", "5f2c9a1", "sha256=zz", signWebhookBody("other", testWebhookBody)} {
+		if verifyWebhookSignature("s3cr3t", body, signature) == nil {
+			t.Errorf("Expected error for signature %q", signature)
+		}
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	setupTestEnv(t)
+	content := `
+desc: Started by GitHub
+params:
+  - COMMIT_SHA: unknown
+webhook_params:
+  COMMIT_SHA: $.head_commit.id
+webhook_secret: s3cr3t
+tasks:
+  - run: echo ${COMMIT_SHA}
+`
+	err := os.WriteFile(Config.JobDir+"push.yaml", []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("push"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Post("/webhook/{name}", HandleWebhook)
+	send := func(name, signature string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/webhook/"+name, strings.NewReader(testWebhookBody))
+		r.Header.Set(WebhookSignatureHeader, signature)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Unknown jobs can't be told from invalid signatures
+	unknown := send("unknown", signWebhookBody("s3cr3t", testWebhookBody))
+	invalid := send("push", signWebhookBody("other", testWebhookBody))
+	if unknown.Code != http.StatusForbidden || invalid.Code != http.StatusForbidden || unknown.Body.String() != invalid.Body.String() {
+		t.Errorf("Expected the same 403 responses, got %d %q and %d %q", unknown.Code, unknown.Body.String(), invalid.Code, invalid.Body.String())
+	}
+	Config.APIKeys = []string{HashAPIKey("k3y")}
+	r := httptest.NewRequest(http.MethodPost, "/webhook/unknown", nil)
+	r.Header.Set("Authorization", "Bearer k3y")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job of the authenticated request, got %d", w.Code)
+	}
+	large := strings.Repeat(" ", MaxWebhookBodySize) + testWebhookBody
+	r = httptest.NewRequest(http.MethodPost, "/webhook/push", strings.NewReader(large))
+	r.Header.Set(WebhookSignatureHeader, signWebhookBody("s3cr3t", large))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for the large body, got %d", w.Code)
+	}
+
+	w = send("push", signWebhookBody("s3cr3t", testWebhookBody))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	id, err := strconv.Atoi(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data BuildUpdateData
+	waitFor(t, 5*time.Second, "build is recorded", func() bool {
+		var dataB []byte
+		DB.View(func(tx *bolt.Tx) error {
+			dataB = tx.Bucket(HistoryBucket).Get(Itob(id))
+			return nil
+		})
+		return dataB != nil && json.Unmarshal(dataB, &data) == nil
+	})
+	if data.Params[0]["COMMIT_SHA"] != "5f2c9a1" || data.Trigger.Kind != TriggerWebhook {
+		t.Errorf("Unexpected params %v and trigger %+v", data.Params, data.Trigger)
+	}
+}
+
+func TestHandleWebhook_Branches(t *testing.T) {
+	setupTestEnv(t)
+	content := `
+params:
+  - GIT_COMMIT: ""
+  - GIT_BRANCH: ""
+webhook_secret: s3cr3t
+branches:
+  - main
+  - release/*
+tasks:
+  - run: git checkout ${GIT_COMMIT}
+`
+	err := os.WriteFile(Config.JobDir+"branches.yaml", []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		jb, err := tx.Bucket(JobsBucket).CreateBucketIfNotExists([]byte("branches"))
+		if err != nil {
+			return err
+		}
+		return jb.Put([]byte("active"), []byte("true"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Post("/webhook/{name}", HandleWebhook)
+	send := func(event, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/webhook/branches", strings.NewReader(body))
+		r.Header.Set(WebhookSignatureHeader, signWebhookBody("s3cr3t", body))
+		r.Header.Set(WebhookEventHeader, event)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	ignored := map[string]string{
+		"feature branch": `{"ref": "refs/heads/feature/login", "after": "1a2b3c4"}`,
+		"nested release": `{"ref": "refs/heads/release/1.2/hotfix", "after": "1a2b3c4"}`,
+		"tag":            `{"ref": "refs/tags/main", "after": "1a2b3c4"}`,
+	}
+	for name, body := range ignored {
+		if w := send("push", body); w.Code != http.StatusAccepted {
+			t.Errorf("%s: expected the push to be ignored, got %d", name, w.Code)
+		}
+	}
+	if w := send("ping", `{"zen": "Keep it logically awesome."}`); w.Code != http.StatusAccepted {
+		t.Errorf("Expected the ping to be ignored, got %d", w.Code)
+	}
+	if GlobalQueue.HasJob("branches") {
+		t.Fatal("Expected no builds for ignored webhooks")
+	}
+
+	w := send("push", `{"ref": "refs/heads/release/1.2", "after": "5f2c9a1"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	id, err := strconv.Atoi(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data BuildUpdateData
+	waitFor(t, 5*time.Second, "build is recorded", func() bool {
+		var dataB []byte
+		DB.View(func(tx *bolt.Tx) error {
+			dataB = tx.Bucket(HistoryBucket).Get(Itob(id))
+			return nil
+		})
+		// Builds of other tests might still be saved with the same ID
+		return dataB != nil && json.Unmarshal(dataB, &data) == nil && data.Name == "branches"
+	})
+	if data.Params[0]["GIT_COMMIT"] != "5f2c9a1" || data.Params[1]["GIT_BRANCH"] != "release/1.2" {
+		t.Errorf("Unexpected params %v", data.Params)
+	}
+}
+
+func TestVerifyBranches(t *testing.T) {
+	if err := (&Job{Branches: []string{"main", "release/**"}}).verifyBranches(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Job{Branches: []string{"release/[0-9"}}).verifyBranches(); err == nil {
+		t.Error("Expected error for an invalid pattern")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// DefaultWorkspaceAuditMaxSize is the size of the workspace above which the
+// audit is skipped
+const DefaultWorkspaceAuditMaxSize = "1GB"
+
+// MaxWorkspaceAuditViolations limits number of violations stored in the
+// build record
+const MaxWorkspaceAuditViolations = 100
+
+// WorkspaceAudit compares files of the workspace before the first main task
+// and after the last one. In the job file it is either `true` or an object
+type WorkspaceAudit struct {
+	// Globs of files which tasks are expected to create, modify or delete
+	Outputs []string `yaml:"outputs" json:"outputs"`
+	// The audit is skipped if the workspace is larger, 1GB by default
+	MaxSize string `yaml:"max_size" json:"max_size"`
+	// Report changes outside of Outputs as a build warning
+	Warn     bool `yaml:"warn" json:"warn"`
+	disabled bool
+}
+
+// WorkspaceAuditResult is the difference between the manifests of the
+// workspace
+type WorkspaceAuditResult struct {
+	Summary  string `json:"summary"`
+	Created  int    `json:"created"`
+	Modified int    `json:"modified"`
+	Deleted  int    `json:"deleted"`
+	// Changed files which don't match Outputs, at most
+	// MaxWorkspaceAuditViolations
+	Violations      []string `json:"violations,omitempty"`
+	ViolationsCount int      `json:"violations_count"`
+	// The reason why the audit was skipped
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// workspaceManifestEntry is the state of a file in the workspace
+type workspaceManifestEntry struct {
+	size  int64
+	mtime int64
+	mode  fs.FileMode
+}
+
+// UnmarshalYAML accepts a boolean which enables the audit with default
+// settings
+func (a *WorkspaceAudit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		a.disabled = !enabled
+		return nil
+	}
+	type plain WorkspaceAudit
+	return unmarshal((*plain)(a))
+}
+
+// isEnabled returns true if the workspace has to be audited
+func (a *WorkspaceAudit) isEnabled() bool {
+	return a != nil && !a.disabled
+}
+
+// maxSize returns the size limit of the audited workspace in bytes
+func (a *WorkspaceAudit) maxSize() (int64, error) {
+	if a.MaxSize == "" {
+		return ParseSize(DefaultWorkspaceAuditMaxSize)
+	}
+	return ParseSize(a.MaxSize)
+}
+
+// isOutput returns true if the file is expected to be changed by tasks
+func (a *WorkspaceAudit) isOutput(path string) bool {
+	// Used to pass environment variables between tasks
+	if path == "build.env" {
+		return true
+	}
+	for _, pattern := range a.Outputs {
+		matched, err := doublestar.Match(pattern, path)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Used to verify the workspace audit before saving after editing
+func (j *Job) verifyAuditWorkspace() error {
+	if !j.AuditWorkspace.isEnabled() {
+		return nil
+	}
+	if _, err := j.AuditWorkspace.maxSize(); err != nil {
+		return fmt.Errorf("invalid audit_workspace max_size: %w", err)
+	}
+	for _, pattern := range j.AuditWorkspace.Outputs {
+		if err := validateArtifactPattern(pattern); err != nil {
+			return fmt.Errorf("invalid audit_workspace outputs: %w", err)
+		}
+	}
+	return nil
+}
+
+// workspaceManifest returns the state of all files in the directory. Files
+// are not read, only their metadata. It stops as soon as the total size
+// exceeds limit and returns errSizeLimitReached
+func workspaceManifest(dir string, limit int64) (map[string]workspaceManifestEntry, error) {
+	manifest := make(map[string]workspaceManifestEntry)
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files might be removed by background processes while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		if size > limit {
+			return errSizeLimitReached
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		manifest[filepath.ToSlash(rel)] = workspaceManifestEntry{
+			size:  info.Size(),
+			mtime: info.ModTime().UnixNano(),
+			mode:  info.Mode(),
+		}
+		return nil
+	})
+	return manifest, err
+}
+
+// compare returns the difference between the manifests of the workspace
+func (a *WorkspaceAudit) compare(before, after map[string]workspaceManifestEntry) *WorkspaceAuditResult {
+	result := &WorkspaceAuditResult{}
+	violations := make([]string, 0)
+	for path, entry := range after {
+		previous, ok := before[path]
+		switch {
+		case !ok:
+			result.Created++
+		case previous != entry:
+			result.Modified++
+		default:
+			continue
+		}
+		if !a.isOutput(path) {
+			violations = append(violations, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			result.Deleted++
+			if !a.isOutput(path) {
+				violations = append(violations, path)
+			}
+		}
+	}
+	sort.Strings(violations)
+	result.ViolationsCount = len(violations)
+	if len(violations) > MaxWorkspaceAuditViolations {
+		violations = violations[:MaxWorkspaceAuditViolations]
+	}
+	result.Violations = violations
+	result.Summary = fmt.Sprintf(
+		"%s files created, %s modified, %s deleted, %s outside declared outputs",
+		formatCount(result.Created), formatCount(result.Modified),
+		formatCount(result.Deleted), formatCount(result.ViolationsCount),
+	)
+	return result
+}
+
+// formatCount formats the number with thousands separators, e.g. 1,240
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	var formatted strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			formatted.WriteByte(',')
+		}
+		formatted.WriteRune(digit)
+	}
+	return formatted.String()
+}
+
+// startWorkspaceAudit takes the manifest of the workspace before main tasks
+// run. Nil if the audit is disabled or skipped
+func (b *Build) startWorkspaceAudit() map[string]workspaceManifestEntry {
+	audit := b.Job.AuditWorkspace
+	if !audit.isEnabled() {
+		return nil
+	}
+	limit, err := audit.maxSize()
+	if err == nil {
+		var manifest map[string]workspaceManifestEntry
+		manifest, err = workspaceManifest(b.GetWorkspaceDir(), limit)
+		if err == nil {
+			return manifest
+		}
+	}
+	skipped := err.Error()
+	if errors.Is(err, errSizeLimitReached) {
+		maxSize := audit.MaxSize
+		if maxSize == "" {
+			maxSize = DefaultWorkspaceAuditMaxSize
+		}
+		skipped = fmt.Sprintf("workspace is larger than %s", maxSize)
+	}
+	b.Logger.Printf("Workspace audit is skipped: %s\n", skipped)
+	b.mutex.Lock()
+	b.WorkspaceAudit = &WorkspaceAuditResult{Skipped: skipped}
+	b.mutex.Unlock()
+	return nil
+}
+
+// finishWorkspaceAudit compares the workspace with the manifest taken by
+// startWorkspaceAudit and stores the result in the build
+func (b *Build) finishWorkspaceAudit(before map[string]workspaceManifestEntry) {
+	if before == nil {
+		return
+	}
+	audit := b.Job.AuditWorkspace
+	limit, _ := audit.maxSize()
+	after, err := workspaceManifest(b.GetWorkspaceDir(), limit)
+	var result *WorkspaceAuditResult
+	switch {
+	case errors.Is(err, errSizeLimitReached):
+		result = &WorkspaceAuditResult{Skipped: "workspace has grown larger than the limit"}
+	case err != nil:
+		result = &WorkspaceAuditResult{Skipped: err.Error()}
+	default:
+		result = audit.compare(before, after)
+	}
+	if result.Skipped != "" {
+		b.Logger.Printf("Workspace audit is skipped: %s\n", result.Skipped)
+	} else {
+		b.Logger.Printf("Workspace audit: %s\n", result.Summary)
+	}
+	b.mutex.Lock()
+	b.WorkspaceAudit = result
+	b.mutex.Unlock()
+	if audit.Warn && result.ViolationsCount > 0 {
+		b.addWarning(fmt.Sprintf("Workspace audit: %s", result.Summary))
+	}
+}
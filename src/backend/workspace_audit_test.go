@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestWorkspaceAuditCompare(t *testing.T) {
+	audit := &WorkspaceAudit{Outputs: []string{"dist/**"}}
+	before := map[string]workspaceManifestEntry{
+		"go.mod":    {size: 10, mtime: 1},
+		"main.go":   {size: 20, mtime: 1},
+		"old.txt":   {size: 5, mtime: 1},
+		"build.env": {size: 5, mtime: 1},
+	}
+	after := map[string]workspaceManifestEntry{
+		"go.mod":          {size: 10, mtime: 1},
+		"main.go":         {size: 20, mtime: 2},
+		"build.env":       {size: 8, mtime: 2},
+		"dist/app":        {size: 100, mtime: 2},
+		"dist/lib/util.a": {size: 100, mtime: 2},
+	}
+	result := audit.compare(before, after)
+	if result.Created != 2 || result.Modified != 2 || result.Deleted != 1 || result.ViolationsCount != 2 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(result.Violations) != 2 || result.Violations[0] != "main.go" || result.Violations[1] != "old.txt" {
+		t.Errorf("Unexpected violations %v", result.Violations)
+	}
+	if result.Summary != "2 files created, 2 modified, 1 deleted, 2 outside declared outputs" {
+		t.Errorf("Unexpected summary %q", result.Summary)
+	}
+
+	for n, expected := range map[int]string{0: "0", 999: "999", 1240: "1,240", 1234567: "1,234,567"} {
+		if formatCount(n) != expected {
+			t.Errorf("Expected %s, got %s", expected, formatCount(n))
+		}
+	}
+}
+
+func TestWorkspaceAuditYAML(t *testing.T) {
+	var job Job
+	err := yaml.Unmarshal([]byte("audit_workspace: true"), &job)
+	if err != nil || !job.AuditWorkspace.isEnabled() {
+		t.Errorf("Expected enabled audit, got %+v %v", job.AuditWorkspace, err)
+	}
+	job = Job{}
+	err = yaml.Unmarshal([]byte("audit_workspace: false"), &job)
+	if err != nil || job.AuditWorkspace.isEnabled() {
+		t.Errorf("Expected disabled audit, got %+v %v", job.AuditWorkspace, err)
+	}
+	job = Job{}
+	err = yaml.Unmarshal([]byte("audit_workspace:\n  outputs: [dist/**]\n  max_size: 5KB"), &job)
+	if err != nil || !job.AuditWorkspace.isEnabled() || job.AuditWorkspace.Outputs[0] != "dist/**" {
+		t.Errorf("Unexpected audit %+v %v", job.AuditWorkspace, err)
+	}
+	job.AuditWorkspace.MaxSize = "five"
+	if job.verifyAuditWorkspace() == nil {
+		t.Error("Expected error for invalid max_size")
+	}
+}
+
+func TestWorkspaceAuditBuild(t *testing.T) {
+	setupTestEnv(t)
+	job := &Job{
+		Name:           "audit_workspace",
+		AuditWorkspace: &WorkspaceAudit{Outputs: []string{"dist/**"}, Warn: true},
+		Tasks: []*Task{
+			{Name: "build", Command: "mkdir -p dist && echo app > dist/app && echo tmp > stray.txt", Kind: KindMain},
+		},
+	}
+	build := createTestBuild(t, job)
+
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	data := build.GenerateBuildUpdateData()
+	audit := data.WorkspaceAudit
+	if audit == nil || audit.Created != 2 || audit.ViolationsCount != 1 || audit.Violations[0] != "stray.txt" {
+		t.Fatalf("Unexpected audit %+v", audit)
+	}
+	if len(data.Warnings) != 1 || data.Warnings[0] != "Workspace audit: "+audit.Summary {
+		t.Errorf("Unexpected warnings %v", data.Warnings)
+	}
+
+	job.AuditWorkspace.MaxSize = "1B"
+	build = createTestBuild(t, job)
+	waitForTerminalState(t, build, 5*time.Second, StatusFinished)
+	if audit := build.GenerateBuildUpdateData().WorkspaceAudit; audit == nil || audit.Skipped == "" {
+		t.Errorf("Expected skipped audit, got %+v", audit)
+	}
+}
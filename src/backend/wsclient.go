@@ -177,6 +177,7 @@ func (c *Client) HandleIncomingMessage(msg *MsgIncoming) {
 		}
 		for _, item := range data.To {
 			c.Subscribe(item)
+			c.hub.subscribed <- &wsSubscription{client: c, prefix: item}
 		}
 	case MsgTypeInUnsubscribe:
 		var data InSubscribeData
@@ -1,6 +1,9 @@
 package main
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+)
 
 // Hub maintains the set of active clients and broadcasts messages to the
 // clients.
@@ -16,6 +19,19 @@ type Hub struct {
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Clients which have subscribed and are waiting for MsgTypeSequences
+	subscribed chan *wsSubscription
+
+	// The last sequence number of each message type
+	sequences map[string]uint64
+}
+
+// wsSubscription is a subscription of the client to message types with the
+// prefix
+type wsSubscription struct {
+	client *Client
+	prefix string
 }
 
 func newHub() *Hub {
@@ -24,7 +40,21 @@ func newHub() *Hub {
 		broadcast:  make(chan *MsgBroadcast),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		subscribed: make(chan *wsSubscription),
 		clients:    make(map[*Client]bool),
+		sequences:  make(map[string]uint64),
+	}
+}
+
+// send queues the message to the client or disconnects the client if its
+// buffer is full
+func (h *Hub) send(client *Client, msgB []byte) {
+	select {
+	case client.send <- msgB:
+	default:
+		client.Logger.Println("Buffer is full")
+		close(client.send)
+		delete(h.clients, client)
 	}
 }
 
@@ -40,7 +70,27 @@ func (h *Hub) run() {
 				delete(h.clients, client)
 				close(client.send)
 			}
+		case subscription := <-h.subscribed:
+			if _, ok := h.clients[subscription.client]; !ok {
+				continue
+			}
+			sequences := make(map[string]uint64)
+			for msgType, seq := range h.sequences {
+				if strings.HasPrefix(msgType, subscription.prefix) {
+					sequences[msgType] = seq
+				}
+			}
+			msgB, err := json.Marshal(&MsgBroadcast{Type: MsgTypeSequences, Data: sequences})
+			if err != nil {
+				Logger.Println(err)
+				continue
+			}
+			h.send(subscription.client, msgB)
 		case message := <-h.broadcast:
+			// Messages are handled one by one, so the sequence matches the
+			// order in which clients receive them
+			h.sequences[message.Type]++
+			message.Seq = h.sequences[message.Type]
 			msgB, err := json.Marshal(message)
 			if err != nil {
 				Logger.Println(err)
@@ -48,13 +98,7 @@ func (h *Hub) run() {
 				for client := range h.clients {
 					ok, _ := client.IsSubscribed(message.Type)
 					if ok {
-						select {
-						case client.send <- msgB:
-						default:
-							client.Logger.Println("Buffer is full")
-							close(client.send)
-							delete(h.clients, client)
-						}
+						h.send(client, msgB)
 					}
 				}
 			}
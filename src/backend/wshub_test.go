@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readTestMessage returns the next message queued to the client
+func readTestMessage(t *testing.T, client *Client) *MsgBroadcast {
+	select {
+	case msgB := <-client.send:
+		var msg MsgBroadcast
+		err := json.Unmarshal(msgB, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message wasn't received")
+	}
+	return nil
+}
+
+func TestHubSequences(t *testing.T) {
+	Logger = log.New(io.Discard, "", 0)
+	hub := newHub()
+	go hub.run()
+	client := &Client{
+		hub:          hub,
+		send:         make(chan []byte, 1024),
+		SubscribedTo: []string{"build:log:"},
+		Logger:       Logger,
+	}
+	hub.register <- client
+
+	const broadcasters = 8
+	const messages = 50
+	var wg sync.WaitGroup
+	for i := 0; i < broadcasters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				hub.broadcast <- &MsgBroadcast{Type: "build:log:" + strconv.Itoa(i%2), Data: j}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	last := make(map[string]uint64)
+	for i := 0; i < broadcasters*messages; i++ {
+		msg := readTestMessage(t, client)
+		if msg.Seq != last[msg.Type]+1 {
+			t.Fatalf("Expected sequence %d of %s, got %d", last[msg.Type]+1, msg.Type, msg.Seq)
+		}
+		last[msg.Type] = msg.Seq
+	}
+
+	hub.subscribed <- &wsSubscription{client: client, prefix: "build:log:1"}
+	msg := readTestMessage(t, client)
+	sequences, ok := msg.Data.(map[string]interface{})
+	if msg.Type != MsgTypeSequences || !ok || len(sequences) != 1 || sequences["build:log:1"] != float64(broadcasters/2*messages) {
+		t.Errorf("Unexpected snapshot %+v", msg)
+	}
+}
@@ -25,8 +25,13 @@ axios.interceptors.response.use(
     function (error) {
         // Exclude special request to check if user is logged in
         if (error.request.responseURL.indexOf("/_isLoggedIn") === -1) {
+            let text = (error.response && error.response.data) || error;
+            // Invalid params of a new build
+            if (text.fields) {
+                text = text.fields.map((field) => `${field.param} ${field.error}`).join(", ");
+            }
             notify({
-                text: (error.response && error.response.data) || error,
+                text: text,
                 type: "error",
             });
             if (error.response.status === 403) {
@@ -1,9 +1,29 @@
+// The last sequence number of each message type. Messages with a sequence
+// which is not higher are stale
+const lastSequences = new Map();
+
+// Sequences start from scratch on every connection
+export const resetSequences = function () {
+    lastSequences.clear();
+};
+
 const wsMessageHandler = function (app, data) {
     const messages = data.split("\n");
     for (let i = 0; i < messages.length; i++) {
         const msg = JSON.parse(messages[i]);
+        if (msg.type === "sequences") {
+            // Sent after subscribing, contains the current sequences
+            for (const [type, seq] of Object.entries(msg.data)) {
+                lastSequences.set(type, Math.max(lastSequences.get(type) || 0, seq));
+            }
+            continue;
+        }
+        if (msg.seq <= (lastSequences.get(msg.type) || 0)) {
+            continue;
+        }
+        lastSequences.set(msg.type, msg.seq);
         if (msg.type.startsWith("build:log:")) {
-            app.emitter.emit(`${msg.type}:task-${msg.data.taskID}`, msg.data);
+            app.emitter.emit(`${msg.type}:task-${msg.data.key || msg.data.taskID}`, msg.data);
             continue;
         } else if (msg.type.startsWith("build:update:")) {
             // For build view
@@ -11,6 +31,9 @@ const wsMessageHandler = function (app, data) {
             // For feed view
             app.emitter.emit("build:update:", msg.data);
             continue;
+        } else if (["queue:update", "server:shutdown", "builds:removed"].includes(msg.type)) {
+            app.emitter.emit(msg.type, msg.data);
+            continue;
         }
         console.warn("Unhandled message", msg);
     }